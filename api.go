@@ -0,0 +1,260 @@
+package mig
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arthurdotwork/mig/internal/executor"
+)
+
+// apiStatus is a migration in the response of GET /status
+type apiStatus struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name,omitempty"`
+	State      string     `json:"state"` // pending, applied, modified or missing
+	AppliedAt  *time.Time `json:"applied_at,omitempty"`
+	DurationMs int64      `json:"duration_ms,omitempty"`
+	AppliedBy  string     `json:"applied_by,omitempty"`
+	Checksum   string     `json:"checksum,omitempty"`
+}
+
+// apiPlanned is a migration in the response of GET /plan
+type apiPlanned struct {
+	ID            string `json:"id"`
+	Name          string `json:"name,omitempty"`
+	Filename      string `json:"filename"`
+	Checksum      string `json:"checksum"`
+	Transactional bool   `json:"transactional"`
+	Phase         string `json:"phase,omitempty"`
+}
+
+// apiHistory is an entry in the response of GET /history
+type apiHistory struct {
+	ID         int64     `json:"id"`
+	Version    string    `json:"version"`
+	Command    string    `json:"command"`
+	ExecutedAt time.Time `json:"executed_at"`
+}
+
+// apiEvent is a line of the progress streamed by POST /apply
+type apiEvent struct {
+	Type       string `json:"type"`
+	Migration  string `json:"migration,omitempty"`
+	Target     string `json:"target,omitempty"`
+	Tenant     string `json:"tenant,omitempty"`
+	DurationMs int64  `json:"duration_ms,omitempty"`
+	Pending    *int   `json:"pending,omitempty"`
+	Applied    *int   `json:"applied,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// apiResult is the type of the last line streamed by POST /apply
+const apiResult = "result"
+
+// apiDefaultHistoryLimit is the page size of GET /history without a limit
+const apiDefaultHistoryLimit = 100
+
+// observerFunc passes the events of the runs to a function
+type observerFunc func(Event)
+
+// Observe calls the function with the event
+func (f observerFunc) Observe(_ context.Context, event Event) {
+	f(event)
+}
+
+// APIHandler serves a REST API driving the migrator, for an admin panel or a
+// deployment orchestrator. Every request must carry the token as a bearer
+// token:
+//
+//   - GET /status lists the migrations with their state
+//   - GET /plan lists the pending migrations as they would be applied
+//   - GET /history?after=ID&limit=N pages through the executed statements
+//   - POST /apply applies the pending migrations, or the one of {"id": ...},
+//     streaming the events of its run as JSON lines and ending with a
+//     "result" line
+//
+// Applying takes the migration lock like MigrateUpAllContext, so runs from
+// other processes wait for each other, and a run already in progress on the
+// same Migrator fails with ErrAlreadyRunning.
+func APIHandler(m *Migrator, token string) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		statuses, err := m.StatusContext(r.Context())
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		entries := make([]apiStatus, 0, len(statuses))
+		for _, status := range statuses {
+			entry := apiStatus{
+				ID:         status.ID,
+				Name:       status.Name,
+				State:      status.State(),
+				DurationMs: status.Duration.Milliseconds(),
+				AppliedBy:  status.AppliedBy,
+				Checksum:   status.Checksum,
+			}
+			if status.Applied {
+				entry.AppliedAt = &status.AppliedAt
+			}
+			entries = append(entries, entry)
+		}
+
+		writeAPIJSON(w, http.StatusOK, entries)
+	})
+
+	mux.HandleFunc("GET /plan", func(w http.ResponseWriter, r *http.Request) {
+		plan, err := m.Plan(r.Context())
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		entries := make([]apiPlanned, 0, len(plan))
+		for _, planned := range plan {
+			entries = append(entries, apiPlanned{
+				ID:            planned.ID,
+				Name:          planned.Name,
+				Filename:      planned.Filename,
+				Checksum:      planned.Checksum,
+				Transactional: planned.Transactional,
+				Phase:         planned.Phase,
+			})
+		}
+
+		writeAPIJSON(w, http.StatusOK, entries)
+	})
+
+	mux.HandleFunc("GET /history", func(w http.ResponseWriter, r *http.Request) {
+		after, limit := int64(0), apiDefaultHistoryLimit
+		var err error
+		if value := r.URL.Query().Get("after"); value != "" {
+			if after, err = strconv.ParseInt(value, 10, 64); err != nil {
+				writeAPIError(w, http.StatusBadRequest, errors.New("invalid after, expected a history ID"))
+				return
+			}
+		}
+		if value := r.URL.Query().Get("limit"); value != "" {
+			if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+				writeAPIError(w, http.StatusBadRequest, errors.New("invalid limit, expected a positive number"))
+				return
+			}
+		}
+
+		page, err := m.HistoryPage(r.Context(), after, limit)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		entries := make([]apiHistory, 0, len(page))
+		for _, entry := range page {
+			entries = append(entries, apiHistory(entry))
+		}
+
+		writeAPIJSON(w, http.StatusOK, entries)
+	})
+
+	mux.HandleFunc("POST /apply", func(w http.ResponseWriter, r *http.Request) {
+		var req ApplyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+		if req.ID != "" && req.Phase != "" {
+			writeAPIError(w, http.StatusBadRequest, errors.New("id and phase are mutually exclusive"))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+
+		var mu sync.Mutex
+		encoder := json.NewEncoder(w)
+		flusher, _ := w.(http.Flusher)
+		stream := func(event apiEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			encoder.Encode(event) //nolint:errcheck
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+
+		// Only the events of the run of this request are streamed, a request
+		// failing with ErrAlreadyRunning gets its result alone
+		observer := observerFunc(func(event Event) {
+			line := apiEvent{
+				Type:       string(event.Type),
+				Migration:  event.Migration,
+				Target:     event.Target,
+				Tenant:     event.Tenant,
+				DurationMs: event.Duration.Milliseconds(),
+			}
+			if event.Type == EventRunStarted || event.Type == EventRunFinished {
+				line.Pending = &event.Pending
+			}
+			if event.Err != nil {
+				line.Error = event.Err.Error()
+			}
+			stream(line)
+		})
+
+		applied, err := apply(executor.ContextWithObserver(r.Context(), observer), m, req)
+		result := apiEvent{Type: apiResult, Applied: &applied}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		stream(result)
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="mig"`)
+			writeAPIError(w, http.StatusUnauthorized, errors.New("missing or invalid bearer token"))
+			return
+		}
+
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// apply runs the migrations selected by an apply request and returns how many
+// were applied
+func apply(ctx context.Context, m *Migrator, req ApplyRequest) (int, error) {
+	switch {
+	case req.ID != "":
+		if err := m.MigrateUpByID(ctx, req.ID, req.AllowOutOfOrder); err != nil {
+			return 0, err
+		}
+		return 1, nil
+	case req.Phase != "":
+		return m.MigrateUpPhase(ctx, req.Phase)
+	default:
+		return m.MigrateUpAllContext(ctx)
+	}
+}
+
+// writeAPIJSON writes a JSON response
+func writeAPIJSON(w http.ResponseWriter, code int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body) //nolint:errcheck
+}
+
+// writeAPIError writes an error as a JSON response
+func writeAPIError(w http.ResponseWriter, code int, err error) {
+	writeAPIJSON(w, code, map[string]string{"error": err.Error()})
+}
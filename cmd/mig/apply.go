@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/arthurdotwork/mig"
+)
+
+// cmdApply writes SQL generated by another tool as a new migration and
+// applies it
+func cmdApply(ctx context.Context, args []string) error {
+	// Parse command flags
+	cmdFlags := flag.NewFlagSet("apply", flag.ExitOnError)
+	cmdFlags.StringVar(&target, "target", target, "Name of the target defined in the configuration file")
+	name := cmdFlags.String("name", "", "Name of the migration, the name of the file by default")
+	yes := cmdFlags.Bool("yes", false, "Skip the confirmation of protected targets")
+	cmdFlags.Parse(args) //nolint:errcheck
+
+	if cmdFlags.NArg() == 0 {
+		return errors.New("the file of the SQL to apply is required, - for stdin")
+	}
+	source := cmdFlags.Arg(0)
+
+	// The flags may follow the file, as in `mig apply - -name generated_change`
+	cmdFlags.Parse(cmdFlags.Args()[1:]) //nolint:errcheck
+	if cmdFlags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(cmdFlags.Args(), " "))
+	}
+
+	// The configuration read from stdin leaves nothing of it for the SQL
+	if source == "-" && configPath == "-" {
+		return errors.New("apply cannot read both the configuration and the SQL from stdin, pass -config <path> or a file of SQL")
+	}
+
+	var data []byte
+	var err error
+	if source == "-" {
+		data, err = io.ReadAll(stdin)
+	} else {
+		data, err = os.ReadFile(source)
+		if *name == "" {
+			*name = strings.TrimSuffix(filepath.Base(source), filepath.Ext(source))
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read the SQL: %w", err)
+	}
+
+	if *name == "" {
+		return errors.New("-name is required to apply SQL from stdin")
+	}
+
+	affected := func() ([]string, error) { return []string{"new migration " + *name}, nil }
+	if err := confirmProtected(target, "apply", *yes, affected); err != nil {
+		return err
+	}
+
+	return withMigrator(target, "", func(_ string, m *mig.Migrator) error {
+		filename, err := m.ApplySQL(ctx, *name, string(data))
+		if filename != "" {
+			fmt.Println(filename)
+		}
+		if err != nil {
+			return err
+		}
+
+		slog.InfoContext(ctx, "migration applied", slog.String("name", *name), slog.String("filename", filename))
+		return nil
+	})
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"os/user"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arthurdotwork/mig"
+)
+
+// Outcomes of an audited command and of its migrations
+const (
+	auditSuccess = "success"
+	auditFailure = "failure"
+	auditApplied = "applied"
+	auditFailed  = "failed"
+)
+
+// auditRecord is a line of the audit file
+type auditRecord struct {
+	Time      time.Time       `json:"time"`
+	Command   string          `json:"command"`
+	Args      []string        `json:"args"`
+	User      string          `json:"user"`
+	Host      string          `json:"host"`
+	Databases []auditDatabase `json:"databases,omitempty"`
+	Versions  []auditVersion  `json:"versions,omitempty"`
+	Outcome   string          `json:"outcome"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// auditDatabase is a database the command connected to
+type auditDatabase struct {
+	Target   string `json:"target,omitempty"`
+	Tenant   string `json:"tenant,omitempty"`
+	Database string `json:"database,omitempty"`
+}
+
+// auditVersion is a migration the command applied, or failed to
+type auditVersion struct {
+	ID      string `json:"id"`
+	Target  string `json:"target,omitempty"`
+	Tenant  string `json:"tenant,omitempty"`
+	Outcome string `json:"outcome"`
+}
+
+// auditTrail collects the record of the running command and appends it to
+// the audit file once the command returns
+type auditTrail struct {
+	mu     sync.Mutex
+	file   *os.File
+	record auditRecord
+}
+
+// openAudit opens the audit file before the command runs, so a command that
+// cannot be audited does not run
+func openAudit(path, command string, args []string) (*auditTrail, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit file: %w", err)
+	}
+
+	record := auditRecord{
+		Time:    time.Now().UTC(),
+		Command: command,
+		Args:    redactArgs(args),
+		User:    os.Getenv("USER"),
+	}
+	if current, err := user.Current(); err == nil {
+		record.User = current.Username
+	}
+	record.Host, _ = os.Hostname()
+
+	return &auditTrail{file: f, record: record}, nil
+}
+
+// Observe records the migrations applied by the command
+func (a *auditTrail) Observe(_ context.Context, event mig.Event) {
+	if event.Type != mig.EventMigrationApplied && event.Type != mig.EventMigrationFailed {
+		return
+	}
+
+	outcome := auditApplied
+	if event.Type == mig.EventMigrationFailed {
+		outcome = auditFailed
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.record.Versions = append(a.record.Versions, auditVersion{
+		ID:      event.Migration,
+		Target:  event.Target,
+		Tenant:  event.Tenant,
+		Outcome: outcome,
+	})
+}
+
+// connected records a database the command connected to
+func (a *auditTrail) connected(target, tenant string, m *mig.Migrator) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.record.Databases = append(a.record.Databases, auditDatabase{
+		Target:   target,
+		Tenant:   tenant,
+		Database: m.Database(),
+	})
+}
+
+// close appends the record of the command with its outcome as a single line,
+// so concurrent commands do not interleave their records
+func (a *auditTrail) close(err error) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	defer a.file.Close() //nolint:errcheck
+
+	a.record.Outcome = auditSuccess
+	if err != nil {
+		a.record.Outcome = auditFailure
+		a.record.Error = err.Error()
+	}
+
+	line, err := json.Marshal(a.record)
+	if err != nil {
+		return err
+	}
+
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit record: %w", err)
+	}
+
+	return nil
+}
+
+// redactArgs hides the password of the -db-url flag in the arguments
+func redactArgs(args []string) []string {
+	redacted := slices.Clone(args)
+	for i, arg := range redacted {
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if name != "db-url" {
+			continue
+		}
+
+		if hasValue {
+			redacted[i] = strings.TrimSuffix(arg, value) + redactURL(value)
+		} else if i+1 < len(redacted) {
+			redacted[i+1] = redactURL(redacted[i+1])
+		}
+	}
+
+	return redacted
+}
+
+// redactURL replaces the password of a connection URL
+func redactURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil {
+		return "xxxxx"
+	}
+
+	return u.Redacted()
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/arthurdotwork/mig"
+)
+
+// outputCI is the -output value printing CI annotations
+const outputCI = "ci"
+
+// ciReportFile is the GitLab Code Quality report written under GitLab CI,
+// declared as an artifacts:reports:codequality of the job
+const ciReportFile = "gl-code-quality-report.json"
+
+// Levels of an annotation
+const (
+	ciError   = "error"
+	ciWarning = "warning"
+)
+
+// annotation is a problem reported on a line of a migration file
+type annotation struct {
+	Level   string
+	File    string // Path relative to the repository, empty when the file is gone
+	Line    int
+	Column  int
+	Title   string
+	Message string
+}
+
+// codeQualityIssue is an issue of a GitLab Code Quality report
+type codeQualityIssue struct {
+	Description string `json:"description"`
+	CheckName   string `json:"check_name"`
+	Fingerprint string `json:"fingerprint"`
+	Severity    string `json:"severity"`
+	Location    struct {
+		Path  string `json:"path"`
+		Lines struct {
+			Begin int `json:"begin"`
+		} `json:"lines"`
+	} `json:"location"`
+}
+
+// ciAnnotations reports lint findings, drifted and failed migrations inline
+// on the pull request: as workflow commands printed to stdout on GitHub
+// Actions, and as a Code Quality report on GitLab CI
+type ciAnnotations struct {
+	mu     sync.Mutex
+	gitlab bool
+	root   string
+	dirs   map[string][]string
+	issues []codeQualityIssue
+}
+
+// newCIAnnotations detects the CI platform, annotations are printed in the
+// GitHub Actions format outside of GitLab CI, which Gitea and Forgejo
+// Actions read too
+func newCIAnnotations() *ciAnnotations {
+	root, _ := os.Getwd()
+
+	return &ciAnnotations{
+		gitlab: os.Getenv("GITLAB_CI") == "true",
+		root:   cmp.Or(os.Getenv("GITHUB_WORKSPACE"), os.Getenv("CI_PROJECT_DIR"), root),
+		dirs:   make(map[string][]string),
+	}
+}
+
+// Observe annotates the failed migrations on their failed statement
+func (c *ciAnnotations) Observe(_ context.Context, event mig.Event) {
+	if event.Type != mig.EventMigrationFailed {
+		return
+	}
+
+	note := annotation{Level: ciError, Title: "mig: migration failed", Message: event.Err.Error()}
+
+	var stmtErr *mig.StatementError
+	if errors.As(event.Err, &stmtErr) {
+		note.File, note.Line = c.path(event.Target, stmtErr.Filename), stmtErr.Line
+	} else {
+		note.File = c.path(event.Target, event.Migration+".sql")
+	}
+
+	c.add(note)
+}
+
+// lint annotates a lint finding
+func (c *ciAnnotations) lint(target string, finding mig.LintFinding) {
+	level := ciWarning
+	if finding.Severity == mig.LintSeverityError {
+		level = ciError
+	}
+
+	c.add(annotation{
+		Level:   level,
+		File:    c.path(target, finding.Filename),
+		Line:    finding.Line,
+		Title:   "mig lint: " + finding.Rule,
+		Message: finding.Message,
+	})
+}
+
+// syntax annotates a syntax error
+func (c *ciAnnotations) syntax(target string, syntaxErr mig.SyntaxError) {
+	c.add(annotation{
+		Level:   ciError,
+		File:    c.path(target, syntaxErr.Filename),
+		Line:    syntaxErr.Line,
+		Column:  syntaxErr.Column,
+		Title:   "mig validate: syntax error",
+		Message: syntaxErr.Message,
+	})
+}
+
+// drift annotates the applied migrations whose file changed or is gone
+func (c *ciAnnotations) drift(target, tenant string, statuses []mig.MigrationStatus) {
+	where := strings.Trim(target+"/"+tenant, "/")
+	if where != "" {
+		where = " on " + where
+	}
+
+	for _, status := range statuses {
+		switch {
+		case status.Missing:
+			c.add(annotation{
+				Level:   ciError,
+				Title:   "mig: missing migration",
+				Message: fmt.Sprintf("Migration %s was applied%s but its file was renamed or deleted", status.ID, where),
+			})
+		case status.Modified:
+			c.add(annotation{
+				Level:   ciError,
+				File:    c.path(target, status.Filename),
+				Line:    1,
+				Title:   "mig: checksum drift",
+				Message: fmt.Sprintf("Migration %s changed since it was applied%s, write a new migration instead of editing it", status.ID, where),
+			})
+		}
+	}
+}
+
+// path returns the path of a migration file of the target relative to the
+// repository, empty when it cannot be found
+func (c *ciAnnotations) path(target, filename string) string {
+	if filename == "" {
+		return ""
+	}
+
+	c.mu.Lock()
+	dirs, ok := c.dirs[target]
+	c.mu.Unlock()
+	if !ok {
+		dirs, _ = mig.MigrationsDirs(configPath, migratorOptions(target)...)
+
+		c.mu.Lock()
+		c.dirs[target] = dirs
+		c.mu.Unlock()
+	}
+
+	for _, dir := range dirs {
+		path := filepath.Join(dir, filename)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+
+		if rel, err := filepath.Rel(c.root, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.ToSlash(rel)
+		}
+		return filepath.ToSlash(path)
+	}
+
+	return ""
+}
+
+// add prints an annotation, or adds it to the Code Quality report
+func (c *ciAnnotations) add(note annotation) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.gitlab {
+		fmt.Println(workflowCommand(note))
+		return
+	}
+
+	issue := codeQualityIssue{
+		Description: note.Message,
+		CheckName:   note.Title,
+		Severity:    "minor",
+	}
+	if note.Level == ciError {
+		issue.Severity = "critical"
+	}
+	issue.Location.Path = note.File
+	issue.Location.Lines.Begin = max(note.Line, 1)
+
+	sum := sha256.Sum256(fmt.Appendf(nil, "%s\x00%s\x00%d\x00%s", note.Title, note.File, note.Line, note.Message))
+	issue.Fingerprint = hex.EncodeToString(sum[:])
+
+	c.issues = append(c.issues, issue)
+}
+
+// close writes the Code Quality report on GitLab CI, an empty one when there
+// is nothing to report so a fixed problem is cleared from the merge request
+func (c *ciAnnotations) close() error {
+	if !c.gitlab {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	issues := c.issues
+	if issues == nil {
+		issues = []codeQualityIssue{}
+	}
+
+	report, err := json.MarshalIndent(issues, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(ciReportFile, append(report, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write the code quality report: %w", err)
+	}
+
+	return nil
+}
+
+// workflowCommand formats an annotation as a GitHub Actions workflow command
+func workflowCommand(note annotation) string {
+	var properties []string
+	if note.File != "" {
+		properties = append(properties, "file="+escapeProperty(note.File))
+		if note.Line > 0 {
+			properties = append(properties, fmt.Sprintf("line=%d", note.Line))
+		}
+		if note.Column > 0 {
+			properties = append(properties, fmt.Sprintf("col=%d", note.Column))
+		}
+	}
+	properties = append(properties, "title="+escapeProperty(note.Title))
+
+	return fmt.Sprintf("::%s %s::%s", note.Level, strings.Join(properties, ","), escapeData(note.Message))
+}
+
+// escapeData escapes the message of a workflow command
+func escapeData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// escapeProperty escapes a property of a workflow command
+func escapeProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
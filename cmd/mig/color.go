@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// ANSI colors of the status labels, all of the same length so colored
+// columns stay aligned by tabwriter
+const (
+	colorReset  = "\x1b[0m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorGray   = "\x1b[90m"
+
+	// colorDefault pads uncolored cells of a colored column
+	colorDefault = "\x1b[39m"
+)
+
+// labelColors are the colors of the status labels
+var labelColors = map[string]string{
+	"APPLIED": colorGreen,
+	"PENDING": colorYellow,
+	"DRIFTED": colorRed,
+	"MISSING": colorRed,
+}
+
+// colorEnabled reports whether output to f can be colored: f is a terminal,
+// NO_COLOR is not set (https://no-color.org) and TERM is not dumb
+func colorEnabled(f *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+
+	return isTerminal(f)
+}
+
+// colorize wraps text in the color of a status label, or gray for other
+// text, when enabled
+func colorize(label, text string, enabled bool) string {
+	if !enabled {
+		return text
+	}
+
+	color, ok := labelColors[label]
+	if !ok {
+		color = colorGray
+	}
+
+	return color + text + colorReset
+}
+
+// relativeTime formats how long ago t was, such as "2 days ago"
+func relativeTime(t, now time.Time) string {
+	d := now.Sub(t)
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return ago(int(d/time.Minute), "minute")
+	case d < 24*time.Hour:
+		return ago(int(d/time.Hour), "hour")
+	case d < 60*24*time.Hour:
+		return ago(int(d/(24*time.Hour)), "day")
+	case d < 365*24*time.Hour:
+		return ago(int(d/(30*24*time.Hour)), "month")
+	default:
+		return ago(int(d/(365*24*time.Hour)), "year")
+	}
+}
+
+// ago formats n units ago, in the singular for one
+func ago(n int, unit string) string {
+	if n == 1 {
+		return fmt.Sprintf("1 %s ago", unit)
+	}
+
+	return fmt.Sprintf("%d %ss ago", n, unit)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/arthurdotwork/mig"
+)
+
+// consoleMaxLine bounds the length of a line read by the console, for pasted
+// statements
+const consoleMaxLine = 1 << 20
+
+func cmdConsole(ctx context.Context, args []string) error {
+	// Parse command flags
+	cmdFlags := flag.NewFlagSet("console", flag.ExitOnError)
+	cmdFlags.StringVar(&target, "target", target, "Name of the target defined in the configuration file")
+	tenant := cmdFlags.String("tenant", "", "Tenant schema to connect to, for multi-tenant targets")
+	yes := cmdFlags.Bool("yes", false, "Skip the confirmation of protected targets")
+	cmdFlags.Parse(args) //nolint:errcheck
+
+	if err := confirmProtected(target, "console", *yes, nil); err != nil {
+		return err
+	}
+
+	return withMigrator(target, *tenant, func(_ string, m *mig.Migrator) error {
+		// A single connection keeps the session settings and the transactions
+		// opened by the statements
+		conn, err := m.DB().Conn(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to connect to database: %w", err)
+		}
+		defer conn.Close() //nolint:errcheck
+
+		return console(ctx, conn, stdin, consolePrompt(target, *tenant))
+	})
+}
+
+// consolePrompt names the database the statements run on
+func consolePrompt(name, tenant string) string {
+	if name == "" {
+		name = "default"
+	}
+	if tenant != "" {
+		name += "/" + tenant
+	}
+
+	return name
+}
+
+// console reads statements ending with a semicolon from r and prints their
+// rows, until \q or the end of the input. On a terminal, a failed statement
+// is reported and the next one is read, otherwise it ends the console.
+func console(ctx context.Context, conn *sql.Conn, r io.Reader, prompt string) error {
+	interactive := isTerminal(os.Stdin)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, consoleMaxLine)
+
+	var statement strings.Builder
+	for {
+		if interactive {
+			if statement.Len() == 0 {
+				fmt.Fprintf(os.Stderr, "%s=> ", prompt)
+			} else {
+				fmt.Fprintf(os.Stderr, "%s-> ", prompt)
+			}
+		}
+
+		if !scanner.Scan() {
+			if interactive {
+				fmt.Fprintln(os.Stderr)
+			}
+			return scanner.Err()
+		}
+		line := scanner.Text()
+
+		if statement.Len() == 0 {
+			switch strings.TrimSpace(line) {
+			case "":
+				continue
+			case `\q`, "quit", "exit":
+				return nil
+			}
+		}
+
+		statement.WriteString(line)
+		statement.WriteByte('\n')
+		if !statementComplete(statement.String()) {
+			continue
+		}
+
+		query := strings.TrimSpace(statement.String())
+		statement.Reset()
+
+		if err := runConsoleStatement(ctx, conn, query); err != nil {
+			if !interactive || ctx.Err() != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		}
+	}
+}
+
+// statementComplete reports whether the text read ends a statement: it ends
+// with a semicolon outside of a string or a dollar-quoted body
+func statementComplete(text string) bool {
+	text = strings.TrimSpace(text)
+
+	return strings.HasSuffix(text, ";") &&
+		strings.Count(text, "'")%2 == 0 &&
+		strings.Count(text, "$$")%2 == 0
+}
+
+// runConsoleStatement executes a statement and prints the rows it returns
+func runConsoleStatement(ctx context.Context, conn *sql.Conn, query string) error {
+	rows, err := conn.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close() //nolint:errcheck
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	if len(columns) == 0 {
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		fmt.Println("OK")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(columns, "\t"))
+
+	values := make([]any, len(columns))
+	pointers := make([]any, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+
+	count := 0
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return err
+		}
+
+		row := make([]string, len(values))
+		for i, value := range values {
+			row[i] = formatConsoleValue(value)
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	w.Flush() //nolint:errcheck
+	fmt.Printf("(%d rows)\n", count)
+	return nil
+}
+
+// formatConsoleValue formats a column value on a single line
+func formatConsoleValue(value any) string {
+	var s string
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		s = string(v)
+	case time.Time:
+		s = v.Format(time.RFC3339Nano)
+	default:
+		s = fmt.Sprint(v)
+	}
+
+	return strings.NewReplacer("\t", `\t`, "\n", `\n`, "\r", `\r`).Replace(s)
+}
@@ -0,0 +1,7 @@
+//go:build clickhouse
+
+package main
+
+import (
+	_ "github.com/ClickHouse/clickhouse-go/v2" // ClickHouse driver
+)
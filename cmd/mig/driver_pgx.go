@@ -0,0 +1,7 @@
+//go:build pgx
+
+package main
+
+import (
+	_ "github.com/jackc/pgx/v5/stdlib" // PostgreSQL driver (pgx)
+)
@@ -0,0 +1,7 @@
+//go:build sqlserver
+
+package main
+
+import (
+	_ "github.com/microsoft/go-mssqldb" // SQL Server driver
+)
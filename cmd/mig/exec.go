@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/arthurdotwork/mig"
+)
+
+// cmdExec runs ad-hoc SQL and records it in mig_history
+func cmdExec(ctx context.Context, args []string) error {
+	// Parse command flags
+	cmdFlags := flag.NewFlagSet("exec", flag.ExitOnError)
+	command := cmdFlags.String("c", "", "SQL to execute")
+	file := cmdFlags.String("f", "", "File of the SQL to execute, - for stdin")
+	name := cmdFlags.String("name", "", "Name recorded with the SQL, the name of the file by default")
+	noTx := cmdFlags.Bool("no-tx", false, "Run the SQL outside of a transaction, for CREATE INDEX CONCURRENTLY")
+	yes := cmdFlags.Bool("yes", false, "Skip the confirmation of protected targets")
+	targetFlags(cmdFlags)
+	cmdFlags.Parse(args) //nolint:errcheck
+
+	if (*command == "") == (*file == "") {
+		return errors.New("exactly one of -c and -f is required")
+	}
+
+	// The configuration read from stdin leaves nothing of it for the SQL
+	if *file == "-" && configPath == "-" {
+		return errors.New("exec cannot read both the configuration and the SQL from stdin, pass -config <path> or -f <file>")
+	}
+
+	content, label := *command, "sql"
+	if *file != "" {
+		var data []byte
+		var err error
+		if *file == "-" {
+			data, err = io.ReadAll(stdin)
+		} else {
+			data, err = os.ReadFile(*file)
+			label = strings.TrimSuffix(filepath.Base(*file), filepath.Ext(*file))
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read the SQL: %w", err)
+		}
+		content = string(data)
+	}
+
+	// The time keeps the names of the hotfixes apart and ordered, as the IDs of
+	// the migrations are
+	version := time.Now().UTC().Format("2006_01_02_15_04_05") + "_" + label
+	if *name != "" {
+		version = *name
+	}
+
+	return forEachTarget(ctx, func(name string) error {
+		affected := func() ([]string, error) { return []string{mig.AdHocPrefix + version}, nil }
+		if err := confirmProtected(name, "exec", *yes, affected); err != nil {
+			return err
+		}
+
+		return forEachTenant(ctx, name, func(tenant string, m *mig.Migrator) error {
+			if err := m.Exec(ctx, version, content, !*noTx); err != nil {
+				return err
+			}
+
+			slog.InfoContext(ctx, "ad-hoc SQL recorded", slog.String("target", name), slog.String("tenant", tenant), slog.String("version", mig.AdHocPrefix+version))
+			return nil
+		})
+	})
+}
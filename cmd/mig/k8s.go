@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/arthurdotwork/mig"
+)
+
+// Events printed by up-all -k8s besides those of the runs
+const (
+	jobSkipped  = "skipped"      // A target or tenant had no pending migration, the lock was not taken
+	jobFinished = "job_finished" // Every target was migrated, or the first failure, last line of the output
+)
+
+// jobEvent is a line printed to stdout by up-all -k8s
+type jobEvent struct {
+	Time       time.Time `json:"time"`
+	Event      string    `json:"event"`
+	Target     string    `json:"target,omitempty"`
+	Tenant     string    `json:"tenant,omitempty"`
+	Migration  string    `json:"migration,omitempty"`
+	DurationMs int64     `json:"duration_ms,omitempty"`
+	Pending    *int      `json:"pending,omitempty"`
+	Applied    []string  `json:"applied,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// jobEvents prints the events of up-all -k8s as JSON lines, for the log
+// collector of the cluster
+type jobEvents struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+// newJobEvents prints the events to stdout, logs stay on stderr
+func newJobEvents() *jobEvents {
+	return &jobEvents{encoder: json.NewEncoder(os.Stdout)}
+}
+
+// Observe prints an event of a run
+func (j *jobEvents) Observe(_ context.Context, event mig.Event) {
+	line := jobEvent{
+		Event:      string(event.Type),
+		Target:     event.Target,
+		Tenant:     event.Tenant,
+		Migration:  event.Migration,
+		DurationMs: event.Duration.Milliseconds(),
+		Applied:    event.Applied,
+	}
+	if event.Type == mig.EventRunStarted || event.Type == mig.EventRunFinished {
+		line.Pending = &event.Pending
+	}
+	if event.Err != nil {
+		line.Error = event.Err.Error()
+	}
+
+	j.emit(line)
+}
+
+// emit prints an event, stamped with the current time
+func (j *jobEvents) emit(line jobEvent) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	line.Time = time.Now().UTC()
+	j.encoder.Encode(line) //nolint:errcheck
+}
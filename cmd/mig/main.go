@@ -1,12 +1,24 @@
 package main
 
 import (
+	"bytes"
+	"cmp"
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
 	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+	"unicode"
 
 	"github.com/arthurdotwork/mig"
 )
@@ -20,9 +32,51 @@ type Command struct {
 
 var (
 	// Global flags
-	configPath  string
-	logLevel    string
-	showVersion bool
+	configPath     string
+	dbURL          string
+	dbHost         string
+	dbPort         int
+	dbName         string
+	dbUser         string
+	promptPassword bool
+	lenient        bool
+	ageKeyFile     string
+	target         string
+	allTargets     bool
+	logLevel       string
+	logFormat      string
+	logFile        string
+	output         string
+	quiet          bool
+	showVersion    bool
+	pushgateway    string
+	pushJob        string
+
+	// Directory of the configuration file found by discoverConfig, empty
+	// when -config or MIG_CONFIG names it
+	baseDir string
+
+	// Configuration files read by the command, decrypted once however many
+	// times the configuration is loaded
+	configCache = mig.NewConfigCache()
+
+	// Metrics of the migrations, pushed when -pushgateway is set
+	metrics = mig.NewMetrics()
+
+	// Trail of the command, nil without an audit file
+	audit *auditTrail
+
+	// Events printed by up-all -k8s, nil otherwise
+	jobLog *jobEvents
+
+	// Annotations of -output ci, nil otherwise
+	annotations *ciAnnotations
+
+	// errDeadline is the cause of the failure of an up-all exceeding -deadline
+	errDeadline = errors.New("up-all exceeded its deadline")
+
+	// Passwords prompted for, by target
+	passwords = make(map[string]string)
 
 	// Available commands
 	commands = map[string]*Command{
@@ -46,32 +100,140 @@ var (
 			Description: "Apply all pending migrations",
 			Execute:     cmdUpAll,
 		},
+		"apply": {
+			Name:        "apply",
+			Description: "Write SQL from a file or stdin as a new migration and apply it",
+			Execute:     cmdApply,
+		},
+		"exec": {
+			Name:        "exec",
+			Description: "Execute ad-hoc SQL and record it in the migration history",
+			Execute:     cmdExec,
+		},
+		"test": {
+			Name:        "test",
+			Description: "Rehearse pending migrations on a shadow database, then apply them",
+			Execute:     cmdTest,
+		},
+		"rebase": {
+			Name:        "rebase",
+			Description: "Move pending migrations older than the applied ones after them",
+			Execute:     cmdRebase,
+		},
+		"archive": {
+			Name:        "archive",
+			Description: "Move old applied migrations to the archive directory",
+			Execute:     cmdArchive,
+		},
+		"plan": {
+			Name:        "plan",
+			Description: "Show the pending migrations without applying them",
+			Execute:     cmdPlan,
+		},
+		"analyze": {
+			Name:        "analyze",
+			Description: "Report the table locks the pending migrations take",
+			Execute:     cmdAnalyze,
+		},
 		"status": {
 			Name:        "status",
 			Description: "Show the status of migrations",
 			Execute:     cmdStatus,
 		},
+		"console": {
+			Name:        "console",
+			Description: "Open an interactive SQL shell on the configured database",
+			Execute:     cmdConsole,
+		},
+		"tui": {
+			Name:        "tui",
+			Description: "Browse, inspect and apply migrations in a terminal UI",
+			Execute:     cmdTUI,
+		},
+		"serve": {
+			Name:        "serve",
+			Description: "Serve an authenticated JSON and gRPC API to check and apply migrations remotely",
+			Execute:     cmdServe,
+		},
+		"report": {
+			Name:        "report",
+			Description: "Write a Markdown or HTML changelog of the applied migrations",
+			Execute:     cmdReport,
+		},
+		"export": {
+			Name:        "export",
+			Description: "Export the mig_versions and mig_history tables to CSV or JSON",
+			Execute:     cmdExport,
+		},
+		"gen": {
+			Name:        "gen",
+			Description: "Generate a Go file declaring the migrations as constants",
+			Execute:     cmdGen,
+		},
+		"lint": {
+			Name:        "lint",
+			Description: "Check the migrations for risky statements",
+			Execute:     cmdLint,
+		},
+		"validate": {
+			Name:        "validate",
+			Description: "Check the syntax of the migrations without connecting",
+			Execute:     cmdValidate,
+		},
+		"dump-schema": {
+			Name:        "dump-schema",
+			Description: "Write a normalized snapshot of the database schema",
+			Execute:     cmdDumpSchema,
+		},
+		"drift": {
+			Name:        "drift",
+			Description: "Compare the database schema to a committed snapshot",
+			Execute:     cmdDrift,
+		},
+		"config": {
+			Name:        "config",
+			Description: "Check the configuration file (validate) without connecting",
+			Execute:     cmdConfig,
+		},
+		"auth": {
+			Name:        "auth",
+			Description: "Store (login) or remove (logout) a password in the OS keyring",
+			Execute:     cmdAuth,
+		},
 	}
 )
 
 func init() {
 	// Define global flags
-	flag.StringVar(&configPath, "config", mig.DefaultConfigFilename, "Path to the configuration file")
-	flag.StringVar(&logLevel, "log-level", "info", "Log level (debug, info, warn, error, fatal)")
+	flag.StringVar(&configPath, "config", envOr("MIG_CONFIG", mig.DefaultConfigFilename), "Path to the configuration file, or - to read it from stdin (env MIG_CONFIG)")
+	flag.StringVar(&dbURL, "db-url", "", "Database connection URL, overrides the configuration file and DATABASE_URL")
+	flag.StringVar(&dbHost, "host", "", "Database host, overrides the configuration file, DATABASE_HOST and the database URL")
+	flag.IntVar(&dbPort, "port", 0, "Database port, overrides the configuration file, DATABASE_PORT and the database URL")
+	flag.StringVar(&dbName, "dbname", "", "Database name, overrides the configuration file, DATABASE_NAME and the database URL")
+	flag.StringVar(&dbUser, "user", "", "Database user, overrides the configuration file, DATABASE_USER and the database URL")
+	flag.BoolVar(&promptPassword, "prompt-password", false, "Prompt for the database password when none is configured (automatic on a terminal)")
+	flag.StringVar(&ageKeyFile, "age-key-file", "", "Age identity decrypting a SOPS-encrypted configuration file (env MIG_AGE_KEY_FILE)")
+	flag.BoolVar(&lenient, "lenient", false, "Warn about unknown keys in the configuration file instead of failing")
+	targetFlags(flag.CommandLine)
+	flag.StringVar(&logLevel, "log-level", envOr("MIG_LOG_LEVEL", ""), "Log level (debug, info, warn, error), overrides logging.level (env MIG_LOG_LEVEL)")
+	flag.StringVar(&logFormat, "log-format", "", "Log format (text, json), overrides logging.format")
+	flag.StringVar(&output, "output", envOr("MIG_OUTPUT", ""), "Output format: ci annotates lint findings, drifted and failed migrations for GitHub Actions or GitLab CI (env MIG_OUTPUT)")
+	flag.StringVar(&logFile, "log-file", "", "File the logs are appended to instead of stderr, overrides logging.file")
+	flag.BoolVar(&quiet, "quiet", false, "Only log errors, overrides the log level, the results of the commands still go to stdout")
 	flag.BoolVar(&showVersion, "version", false, "Show version information")
+	flag.StringVar(&pushgateway, "pushgateway", "", "URL of a Prometheus Pushgateway to push the migration metrics to")
+	flag.StringVar(&pushJob, "push-job", "mig", "Job name of the metrics pushed to the Pushgateway")
 }
 
 func main() {
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
 	// Parse flags
 	flag.Parse()
 
-	// Configure logger based on log level
-	setupLogger(logLevel)
-
-	// Show version information if requested
+	// Show version information if requested, and the help without a command,
+	// before reading a configuration file that may be missing or invalid
 	if showVersion {
 		fmt.Printf("Migrator version %s\n", mig.Version)
 		os.Exit(0)
@@ -84,6 +246,17 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Run from any subdirectory of the project, init creates the file here
+	if args[0] != "init" {
+		discoverConfig()
+	}
+
+	// Configure the logger from the flags and the configuration file
+	if err := setupLogger(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to set up logging: %s\n", err)
+		os.Exit(1)
+	}
+
 	// Check if the command exists
 	cmd, ok := commands[args[0]]
 	if !ok {
@@ -92,8 +265,49 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Open the audit file first, a command that cannot be audited does not run.
+	// A configuration file that cannot be read is reported by the command.
+	if auditConfig, err := mig.Audit(configPath, migratorOptions("")...); err == nil && auditConfig.File != "" {
+		if audit, err = openAudit(auditConfig.File, args[0], os.Args[1:]); err != nil {
+			slog.ErrorContext(ctx, "failed to audit command", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+	}
+
+	switch output {
+	case "":
+	case outputCI:
+		annotations = newCIAnnotations()
+	default:
+		slog.ErrorContext(ctx, "invalid -output, expected ci", slog.String("output", output))
+		os.Exit(1)
+	}
+
 	// Execute the command
-	if err := cmd.Execute(ctx, args[1:]); err != nil {
+	err := cmd.Execute(ctx, args[1:])
+
+	if annotations != nil {
+		if ciErr := annotations.close(); ciErr != nil {
+			slog.ErrorContext(ctx, "failed to write annotations", slog.String("error", ciErr.Error()))
+			err = cmp.Or(err, ciErr)
+		}
+	}
+
+	if audit != nil {
+		if auditErr := audit.close(err); auditErr != nil {
+			slog.ErrorContext(ctx, "failed to audit command", slog.String("error", auditErr.Error()))
+			err = cmp.Or(err, auditErr)
+		}
+	}
+
+	// Push the metrics, of failed runs too
+	if pushgateway != "" {
+		if err := metrics.Push(ctx, pushgateway, pushJob); err != nil {
+			slog.ErrorContext(ctx, "failed to push metrics", slog.String("error", err.Error()))
+		}
+	}
+
+	if err != nil {
 		slog.ErrorContext(ctx, "failed to execute command",
 			slog.String("command", args[0]),
 			slog.String("error", err.Error()))
@@ -101,40 +315,356 @@ func main() {
 	}
 }
 
-// setupLogger configures the slog logger with appropriate level
-func setupLogger(level string) {
-	var logLevel slog.Level
-	switch strings.ToLower(level) {
+// envOr returns the value of the environment variable, or fallback when it is
+// not set
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+
+	return fallback
+}
+
+// setupLogger configures the slog logger from the logging settings of the
+// configuration file, overridden by the flags. A configuration file that
+// cannot be read leaves the defaults, the command reports the error.
+func setupLogger() error {
+	logging, err := mig.Logging(configPath, migratorOptions("")...)
+	if err != nil {
+		defer slog.Warn("ignoring the logging settings of the configuration file", slog.String("error", err.Error()))
+		logging = mig.LoggingConfig{}
+	}
+
+	if logLevel != "" {
+		logging.Level = logLevel
+	}
+	if quiet {
+		logging.Level = "error"
+	}
+	if logFormat != "" {
+		logging.Format = logFormat
+	}
+	if logFile != "" {
+		logging.File = logFile
+	}
+
+	var level slog.Level
+	switch strings.ToLower(logging.Level) {
 	case "debug":
-		logLevel = slog.LevelDebug
-	case "info":
-		logLevel = slog.LevelInfo
+		level = slog.LevelDebug
+	case "", "info":
+		level = slog.LevelInfo
 	case "warn":
-		logLevel = slog.LevelWarn
+		level = slog.LevelWarn
 	case "error":
-		logLevel = slog.LevelError
+		level = slog.LevelError
+	default:
+		return fmt.Errorf("invalid log level %q, expected debug, info, warn or error", logging.Level)
+	}
+
+	var w io.Writer = os.Stderr
+	if logging.File != "" {
+		// The file stays open until the process exits
+		f, err := os.OpenFile(logging.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %w", err)
+		}
+		w = f
+	}
+
+	handlerOptions := &slog.HandlerOptions{Level: level}
+	switch strings.ToLower(logging.Format) {
+	case "", mig.LogFormatText:
+		slog.SetDefault(slog.New(slog.NewTextHandler(w, handlerOptions)))
+	case mig.LogFormatJSON:
+		slog.SetDefault(slog.New(slog.NewJSONHandler(w, handlerOptions)))
 	default:
-		logLevel = slog.LevelInfo
+		return fmt.Errorf("invalid log format %q, expected text or json", logging.Format)
+	}
+
+	return nil
+}
+
+// targetFlags registers the target selection flags, so they can be given
+// before or after the command name
+func targetFlags(flags *flag.FlagSet) {
+	flags.StringVar(&target, "target", target, "Name of the target defined in the configuration file")
+	flags.BoolVar(&allTargets, "all-targets", allTargets, "Run the command against every target defined in the configuration file")
+}
+
+// discoverConfig looks for mig.yaml in the parent directories when neither
+// -config nor MIG_CONFIG name a file, the paths of the file found then resolve
+// next to it. Without one, the command runs from the environment or reports
+// the missing file.
+func discoverConfig() {
+	explicit := os.Getenv("MIG_CONFIG") != ""
+	flag.Visit(func(f *flag.Flag) {
+		explicit = explicit || f.Name == "config"
+	})
+	if explicit {
+		return
+	}
+
+	path, err := mig.FindConfig(mig.DefaultConfigFilename)
+	if err != nil {
+		return
+	}
+
+	configPath, baseDir = path, filepath.Dir(path)
+}
+
+// migratorOptions builds the migrator options for the named target from the
+// global flags
+func migratorOptions(name string) []mig.Option {
+	opts := []mig.Option{mig.WithLogger(slog.Default()), mig.WithConfigCache(configCache)}
+	if baseDir != "" {
+		opts = append(opts, mig.WithBaseDir(baseDir))
+	}
+
+	if name != "" {
+		opts = append(opts, mig.WithTarget(name))
+	}
+
+	if lenient {
+		opts = append(opts, mig.WithLenientConfig())
+	}
+
+	if ageKeyFile != "" {
+		opts = append(opts, mig.WithAgeKeyFile(ageKeyFile))
+	}
+
+	if dbURL != "" {
+		opts = append(opts, mig.WithDatabaseURL(dbURL))
+	}
+
+	// Individual settings apply on top of the URL
+	if dbHost != "" {
+		opts = append(opts, mig.WithHost(dbHost))
+	}
+
+	if dbPort != 0 {
+		opts = append(opts, mig.WithPort(dbPort))
+	}
+
+	if dbName != "" {
+		opts = append(opts, mig.WithDatabaseName(dbName))
+	}
+
+	if dbUser != "" {
+		opts = append(opts, mig.WithUser(dbUser))
+	}
+
+	if pushgateway != "" {
+		opts = append(opts, mig.WithMetrics(metrics))
+	}
+
+	if audit != nil {
+		opts = append(opts, mig.WithObserver(audit))
+	}
+
+	if jobLog != nil {
+		opts = append(opts, mig.WithObserver(jobLog))
+	}
+
+	if annotations != nil {
+		opts = append(opts, mig.WithObserver(annotations))
+	}
+
+	if promptPassword || isTerminal(os.Stdin) {
+		opts = append(opts, mig.WithPasswordPrompt(func() (string, error) {
+			// Ask once per target, even when migrating several tenants
+			if password, ok := passwords[name]; ok {
+				return password, nil
+			}
+
+			password, err := readPassword("Database password: ")
+			if err != nil {
+				return "", err
+			}
+			passwords[name] = password
+
+			return password, nil
+		}))
+	}
+
+	return opts
+}
+
+// forEachTarget runs fn with the selected target, or with every target
+// defined in the configuration file when -all-targets is set
+func forEachTarget(ctx context.Context, fn func(name string) error) error {
+	if !allTargets {
+		return fn(target)
+	}
+
+	if target != "" {
+		return fmt.Errorf("-target and -all-targets are mutually exclusive")
+	}
+
+	names, err := mig.Targets(configPath, migratorOptions("")...)
+	if err != nil {
+		return err
+	}
+
+	if len(names) == 0 {
+		return fmt.Errorf("no targets defined in %s", configPath)
+	}
+
+	for _, name := range names {
+		slog.DebugContext(ctx, "running against target", slog.String("target", name))
+		if err := fn(name); err != nil {
+			return fmt.Errorf("target %s: %w", name, err)
+		}
 	}
 
-	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
-		Level: logLevel,
-	}))
-	slog.SetDefault(logger)
+	return nil
+}
+
+// forEachTenant runs fn against every tenant schema of the named target, or
+// once with an empty tenant when the target is not multi-tenant
+func forEachTenant(ctx context.Context, name string, fn func(tenant string, m *mig.Migrator) error) error {
+	tenants, err := mig.Tenants(configPath, migratorOptions(name)...)
+	if err != nil {
+		return err
+	}
+
+	if tenants == nil {
+		return withMigrator(name, "", fn)
+	}
+
+	if len(tenants) == 0 {
+		slog.WarnContext(ctx, "no tenant schemas found", slog.String("target", name))
+	}
+
+	for _, tenant := range tenants {
+		if err := withMigrator(name, tenant, fn); err != nil {
+			return fmt.Errorf("tenant %s: %w", tenant, err)
+		}
+	}
+
+	return nil
+}
+
+// confirmProtected asks for a confirmation before command changes the named
+// target when it is protected, unless yes is set, printing what affected lists
+// first. Nothing is asked when affected lists nothing, as when no migration is
+// pending, and a nil affected asks without a list.
+func confirmProtected(name, command string, yes bool, affected func() ([]string, error)) error {
+	protected, err := mig.Protected(configPath, migratorOptions(name)...)
+	if err != nil {
+		return err
+	}
+
+	if !protected || yes {
+		return nil
+	}
+
+	label := name
+	if label == "" {
+		label = "default"
+	}
+
+	var items []string
+	if affected != nil {
+		if items, err = affected(); err != nil {
+			return err
+		}
+		if len(items) == 0 {
+			return nil
+		}
+	}
+
+	return confirmDestructive(fmt.Sprintf("%s on protected target %s", command, label), label, items, false)
+}
+
+// confirmDestructive prints what command is about to affect and asks for
+// answer to be typed back, unless yes is set. Without a terminal to ask on,
+// the command is refused.
+func confirmDestructive(command, answer string, affected []string, yes bool) error {
+	if yes {
+		return nil
+	}
+
+	if len(affected) > 0 {
+		fmt.Fprintf(os.Stderr, "%s will affect:\n", command)
+		for _, item := range affected {
+			fmt.Fprintf(os.Stderr, "  %s\n", item)
+		}
+	}
+
+	if !isTerminal(os.Stdin) {
+		return fmt.Errorf("%s needs a confirmation, pass -yes to run it without a terminal", command)
+	}
+
+	confirmed, err := confirm(fmt.Sprintf("Type %q to run %s: ", answer, command), answer)
+	if err != nil {
+		return err
+	}
+
+	if !confirmed {
+		return fmt.Errorf("%s was not confirmed", command)
+	}
+
+	return nil
+}
+
+// pendingMigrations lists the files of the pending migrations of every tenant
+// of the named target that selected keeps, prefixed by their tenant
+func pendingMigrations(ctx context.Context, name string, selected func([]mig.PlannedMigration) []mig.PlannedMigration) ([]string, error) {
+	var files []string
+	err := forEachTenant(ctx, name, func(tenant string, m *mig.Migrator) error {
+		plan, err := m.Plan(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range selected(plan) {
+			if tenant != "" {
+				files = append(files, tenant+": "+migration.Filename)
+			} else {
+				files = append(files, migration.Filename)
+			}
+		}
+		return nil
+	})
+
+	return files, err
+}
+
+// withMigrator opens a migrator for the named target and tenant and passes it to fn
+func withMigrator(name, tenant string, fn func(tenant string, m *mig.Migrator) error) error {
+	opts := migratorOptions(name)
+	if tenant != "" {
+		opts = append(opts, mig.WithTenant(tenant))
+	}
+
+	m, err := mig.New(configPath, opts...)
+	if err != nil {
+		return err
+	}
+	defer m.Close() //nolint:errcheck
+
+	if audit != nil {
+		audit.connected(name, tenant, m)
+	}
+
+	return fn(tenant, m)
 }
 
 // showHelp displays help information
 func showHelp() {
-	fmt.Printf("Migrator version %s\n\n", mig.Version)
-	fmt.Println("Usage:")
-	fmt.Println("  mig [options] <command> [arguments]")
-	fmt.Println()
-	fmt.Println("Options:")
+	// The help is shown on usage errors, keep stdout for the results
+	w := flag.CommandLine.Output()
+	fmt.Fprintf(w, "Migrator version %s\n\n", mig.Version)
+	fmt.Fprintln(w, "Usage:")
+	fmt.Fprintln(w, "  mig [options] <command> [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Options:")
 	flag.PrintDefaults()
-	fmt.Println()
-	fmt.Println("Commands:")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
 	for _, cmd := range commands {
-		fmt.Printf("  %-10s %s\n", cmd.Name, cmd.Description)
+		fmt.Fprintf(w, "  %-10s %s\n", cmd.Name, cmd.Description)
 	}
 }
 
@@ -145,6 +675,10 @@ func cmdInit(ctx context.Context, args []string) error {
 	migrationsDir := cmdFlags.String("dir", mig.DefaultMigrationsDir, "Path to the migrations directory")
 	cmdFlags.Parse(args) //nolint:errcheck
 
+	if configPath == "-" {
+		return fmt.Errorf("init cannot write the configuration to stdin, pass -config <path>")
+	}
+
 	// Initialize the environment
 	err := mig.Initialize(configPath, *migrationsDir)
 	if err != nil {
@@ -162,6 +696,7 @@ func cmdInit(ctx context.Context, args []string) error {
 func cmdCreate(ctx context.Context, args []string) error {
 	// Parse command flags
 	cmdFlags := flag.NewFlagSet("create", flag.ExitOnError)
+	cmdFlags.StringVar(&target, "target", target, "Name of the target defined in the configuration file")
 	cmdFlags.Parse(args) //nolint:errcheck
 
 	// Get the migration name
@@ -170,108 +705,940 @@ func cmdCreate(ctx context.Context, args []string) error {
 	}
 	name := strings.Join(cmdFlags.Args(), "_")
 
-	// Create a new migrator
-	m, err := mig.New(configPath)
-	if err != nil {
-		return err
+	return withMigrator(target, "", func(_ string, m *mig.Migrator) error {
+		// Create the migration
+		filename, err := m.CreateMigration(name)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(filename)
+		slog.InfoContext(ctx, "migration created", slog.String("name", name), slog.String("filename", filename))
+		return nil
+	})
+}
+
+// cmdGen generates a Go file declaring the migrations as constants
+func cmdGen(ctx context.Context, args []string) error {
+	// Parse command flags
+	cmdFlags := flag.NewFlagSet("gen", flag.ExitOnError)
+	migrationsDir := cmdFlags.String("dir", mig.DefaultMigrationsDir, "Path to the migrations directory")
+	out := cmdFlags.String("out", "", "Path of the generated file (default <dir>/migrations.go)")
+	packageName := cmdFlags.String("package", "", "Package of the generated file (default the name of its directory)")
+	check := cmdFlags.Bool("check", false, "Fail if the generated file is out of date instead of writing it")
+	cmdFlags.Parse(args) //nolint:errcheck
+
+	if *out == "" {
+		*out = filepath.Join(*migrationsDir, "migrations.go")
 	}
-	defer m.Close() //nolint:errcheck
 
-	// Create the migration
-	filename, err := m.CreateMigration(name)
-	if err != nil {
+	if *packageName == "" {
+		absOut, err := filepath.Abs(*out)
+		if err != nil {
+			return fmt.Errorf("failed to get absolute path for %s: %w", *out, err)
+		}
+		*packageName = packageFromDir(filepath.Base(filepath.Dir(absOut)))
+	}
+
+	var buf bytes.Buffer
+	if err := mig.Generate(&buf, *migrationsDir, *packageName); err != nil {
 		return err
 	}
 
-	slog.InfoContext(ctx, "migration created", slog.String("name", name), slog.String("filename", filename))
+	if *check {
+		current, err := os.ReadFile(*out)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to read %s: %w", *out, err)
+		}
+
+		if !bytes.Equal(current, buf.Bytes()) {
+			return fmt.Errorf("%s is out of date with %s, run mig gen", *out, *migrationsDir)
+		}
+
+		slog.InfoContext(ctx, "generated migrations are up to date", slog.String("file", *out))
+		return nil
+	}
+
+	if err := os.WriteFile(*out, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", *out, err)
+	}
+
+	slog.InfoContext(ctx, "migrations generated", slog.String("file", *out), slog.String("package", *packageName))
 	return nil
 }
 
+// packageFromDir derives a Go package name from a directory name
+func packageFromDir(dir string) string {
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, dir)
+
+	if name == "" || unicode.IsDigit([]rune(name)[0]) {
+		name = "migrations" + name
+	}
+
+	return name
+}
+
 // cmdUp applies the next pending migration
 func cmdUp(ctx context.Context, args []string) error {
 	// Parse command flags
 	cmdFlags := flag.NewFlagSet("up", flag.ExitOnError)
+	only := cmdFlags.String("only", "", "ID of the single pending migration to apply")
+	allowOutOfOrder := cmdFlags.Bool("allow-out-of-order", false, "Allow -only to apply a migration before earlier pending ones")
+	phase := cmdFlags.String("phase", "", "Apply the pending migrations of a deployment phase (expand, contract)")
+	yes := cmdFlags.Bool("yes", false, "Skip the confirmation of protected targets")
+	targetFlags(cmdFlags)
 	cmdFlags.Parse(args) //nolint:errcheck
 
-	// Create a new migrator
-	m, err := mig.New(configPath)
-	if err != nil {
-		return err
+	if *allowOutOfOrder && *only == "" {
+		return fmt.Errorf("-allow-out-of-order requires -only")
 	}
-	defer m.Close() //nolint:errcheck
 
-	// Apply the next migration
-	executed, err := m.MigrateUp()
-	if err != nil {
-		return err
+	if *phase != "" && *only != "" {
+		return fmt.Errorf("-phase and -only are mutually exclusive")
 	}
 
-	if executed {
-		slog.InfoContext(ctx, "migration up succeeded")
-	} else {
-		slog.WarnContext(ctx, "no migration to apply")
-	}
+	return forEachTarget(ctx, func(name string) error {
+		// The migrations the flags select, as the run applies them
+		affected := func() ([]string, error) {
+			return pendingMigrations(ctx, name, func(plan []mig.PlannedMigration) []mig.PlannedMigration {
+				switch {
+				case *only != "":
+					return slices.DeleteFunc(plan, func(m mig.PlannedMigration) bool { return m.ID != *only })
+				case *phase != "":
+					// The run stops at the first migration of another phase,
+					// untagged ones being part of the expand phase
+					i := slices.IndexFunc(plan, func(m mig.PlannedMigration) bool {
+						return cmp.Or(m.Phase, mig.PhaseExpand) != *phase
+					})
+					if i >= 0 {
+						plan = plan[:i]
+					}
+					return plan
+				default:
+					return plan[:min(len(plan), 1)]
+				}
+			})
+		}
+		if err := confirmProtected(name, "up", *yes, affected); err != nil {
+			return err
+		}
 
-	return nil
+		err := forEachTenant(ctx, name, func(tenant string, m *mig.Migrator) error {
+			// Apply the requested migration
+			if *only != "" {
+				if err := m.MigrateUpByID(ctx, *only, *allowOutOfOrder); err != nil {
+					return err
+				}
+
+				slog.InfoContext(ctx, "migration up succeeded", slog.String("target", name), slog.String("tenant", tenant), slog.String("migration", *only))
+				return nil
+			}
+
+			// Apply the migrations of the requested phase
+			if *phase != "" {
+				count, err := m.MigrateUpPhase(ctx, *phase)
+				if err != nil {
+					return err
+				}
+
+				slog.InfoContext(ctx, "migration up succeeded", slog.String("target", name), slog.String("tenant", tenant), slog.String("phase", *phase), slog.Int("count", count))
+				return nil
+			}
+
+			// Apply the next migration
+			executed, err := m.MigrateUpContext(ctx)
+			if err != nil {
+				return err
+			}
+
+			if executed {
+				slog.InfoContext(ctx, "migration up succeeded", slog.String("target", name), slog.String("tenant", tenant))
+			} else {
+				slog.WarnContext(ctx, "no migration to apply", slog.String("target", name), slog.String("tenant", tenant))
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return updateSchemaFile(ctx, name)
+	})
 }
 
 // cmdUpAll applies all pending migrations
 func cmdUpAll(ctx context.Context, args []string) error {
 	// Parse command flags
 	cmdFlags := flag.NewFlagSet("up-all", flag.ExitOnError)
+	yes := cmdFlags.Bool("yes", false, "Skip the confirmation of protected targets")
+	k8s := cmdFlags.Bool("k8s", false, "Run as a Kubernetes Job: skip the lock when nothing is pending and print the events as JSON lines to stdout")
+	deadline := cmdFlags.Duration("deadline", 0, "Fail when every target is not migrated within this duration, 0 for no deadline")
+	targetFlags(cmdFlags)
 	cmdFlags.Parse(args) //nolint:errcheck
 
-	// Create a new migrator
-	m, err := mig.New(configPath)
-	if err != nil {
-		return err
+	if *k8s {
+		jobLog = newJobEvents()
 	}
-	defer m.Close() //nolint:errcheck
 
-	// Apply all migrations
-	count, err := m.MigrateUpAll()
-	if err != nil {
-		return err
+	// The deadline bounds the whole command, from connecting to the last
+	// tenant, so a stuck Job fails instead of hanging
+	if *deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, *deadline, fmt.Errorf("%w of %s", errDeadline, *deadline))
+		defer cancel()
 	}
 
-	if count > 0 {
-		slog.InfoContext(ctx, "migrations up succeeded", slog.Int("count", count))
-	} else {
-		slog.WarnContext(ctx, "no migrations to apply")
+	err := forEachTarget(ctx, func(name string) error {
+		return upAll(ctx, name, "up-all", *yes)
+	})
+	if err != nil && errors.Is(context.Cause(ctx), errDeadline) {
+		err = fmt.Errorf("%w: %w", context.Cause(ctx), err)
 	}
 
-	return nil
+	if jobLog != nil {
+		line := jobEvent{Event: jobFinished}
+		if err != nil {
+			line.Error = err.Error()
+		}
+		jobLog.emit(line)
+	}
+
+	return err
 }
 
-// cmdStatus shows the status of migrations
-func cmdStatus(ctx context.Context, args []string) error {
+// upAll applies all pending migrations to every tenant of the named target,
+// once the protected target is confirmed for command
+func upAll(ctx context.Context, name, command string, yes bool) error {
+	affected := func() ([]string, error) {
+		return pendingMigrations(ctx, name, func(plan []mig.PlannedMigration) []mig.PlannedMigration { return plan })
+	}
+	if err := confirmProtected(name, command, yes, affected); err != nil {
+		return err
+	}
+
+	applied := 0
+	err := forEachTenant(ctx, name, func(tenant string, m *mig.Migrator) error {
+		// Jobs restarted or run by several replicas return without waiting for
+		// the lock once another one migrated
+		if jobLog != nil {
+			plan, err := m.Plan(ctx)
+			if err != nil {
+				return err
+			}
+
+			if len(plan) == 0 {
+				slog.InfoContext(ctx, "no pending migrations, skipping", slog.String("target", name), slog.String("tenant", tenant))
+				jobLog.emit(jobEvent{Event: jobSkipped, Target: name, Tenant: tenant})
+				return nil
+			}
+		}
+
+		// Apply all migrations
+		count, err := m.MigrateUpAllContext(ctx)
+		applied += count
+		if err != nil {
+			return err
+		}
+
+		if count > 0 {
+			slog.InfoContext(ctx, "migrations up succeeded", slog.String("target", name), slog.String("tenant", tenant), slog.Int("count", count))
+		} else {
+			slog.WarnContext(ctx, "no migrations to apply", slog.String("target", name), slog.String("tenant", tenant))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if jobLog != nil && applied == 0 {
+		return nil
+	}
+
+	return updateSchemaFile(ctx, name)
+}
+
+// updateSchemaFile dumps the schema of the named target to its
+// migrations.schema_file after migrating, when one is configured
+func updateSchemaFile(ctx context.Context, name string) error {
+	file, err := mig.SchemaFile(configPath, migratorOptions(name)...)
+	if err != nil || file == "" {
+		return err
+	}
+
+	return dumpSchema(ctx, name, file)
+}
+
+// dumpSchema writes the normalized schema of the named target to file, or to
+// the standard output when file is empty
+func dumpSchema(ctx context.Context, name, file string) error {
+	return withMigrator(name, "", func(_ string, m *mig.Migrator) error {
+		schema, err := m.DumpSchema(ctx)
+		if err != nil {
+			return err
+		}
+
+		if file == "" {
+			fmt.Print(schema)
+			return nil
+		}
+
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return fmt.Errorf("failed to create the schema file directory: %w", err)
+		}
+
+		if err := os.WriteFile(file, []byte(schema), 0644); err != nil {
+			return fmt.Errorf("failed to write the schema file: %w", err)
+		}
+
+		slog.InfoContext(ctx, "schema dumped", slog.String("target", name), slog.String("file", file))
+		return nil
+	})
+}
+
+// cmdDumpSchema writes a normalized snapshot of the schema to the given file,
+// migrations.schema_file or the standard output
+func cmdDumpSchema(ctx context.Context, args []string) error {
+	// Parse command flags
+	cmdFlags := flag.NewFlagSet("dump-schema", flag.ExitOnError)
+	out := cmdFlags.String("out", "", "Path of the snapshot, overrides migrations.schema_file, - for the standard output")
+	cmdFlags.StringVar(&target, "target", target, "Name of the target defined in the configuration file")
+	cmdFlags.Parse(args) //nolint:errcheck
+
+	file := *out
+	if file == "" {
+		var err error
+		if file, err = mig.SchemaFile(configPath, migratorOptions(target)...); err != nil {
+			return err
+		}
+	}
+	if file == "-" {
+		file = ""
+	}
+
+	return dumpSchema(ctx, target, file)
+}
+
+// cmdTest rehearses the pending migrations on a shadow database of every
+// tenant, and applies them to the real database only when they all succeed
+func cmdTest(ctx context.Context, args []string) error {
+	// Parse command flags
+	cmdFlags := flag.NewFlagSet("test", flag.ExitOnError)
+	shadow := cmdFlags.Bool("shadow", false, "Rehearse the migrations on a temporary shadow database first")
+	empty := cmdFlags.Bool("empty", false, "Create an empty shadow database instead of a copy of the target")
+	yes := cmdFlags.Bool("yes", false, "Skip the confirmation of protected targets")
+	targetFlags(cmdFlags)
+	cmdFlags.Parse(args) //nolint:errcheck
+
+	if !*shadow {
+		return fmt.Errorf("usage: mig test -shadow [-empty] [-yes] [-target name | -all-targets]")
+	}
+
+	return forEachTarget(ctx, func(name string) error {
+		tenants, err := mig.Tenants(configPath, migratorOptions(name)...)
+		if err != nil {
+			return err
+		}
+		if tenants == nil {
+			tenants = []string{""}
+		}
+
+		for _, tenant := range tenants {
+			opts := migratorOptions(name)
+			if tenant != "" {
+				opts = append(opts, mig.WithTenant(tenant))
+			}
+
+			count, err := mig.Shadow(ctx, configPath, !*empty, opts...)
+			if err != nil {
+				return fmt.Errorf("shadow run failed, nothing was applied: %w", err)
+			}
+
+			slog.InfoContext(ctx, "shadow run succeeded", slog.String("target", name), slog.String("tenant", tenant), slog.Int("count", count))
+		}
+
+		return upAll(ctx, name, "test", *yes)
+	})
+}
+
+// cmdRebase renames the pending migrations older than the last applied one
+func cmdRebase(ctx context.Context, args []string) error {
+	// Parse command flags
+	cmdFlags := flag.NewFlagSet("rebase", flag.ExitOnError)
+	cmdFlags.StringVar(&target, "target", target, "Name of the target defined in the configuration file")
+	cmdFlags.Parse(args) //nolint:errcheck
+
+	return withMigrator(target, "", func(_ string, m *mig.Migrator) error {
+		rebased, err := m.Rebase(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range rebased {
+			fmt.Printf("%s -> %s\n", migration.From, migration.To)
+			slog.InfoContext(ctx, "migration rebased", slog.String("from", migration.From), slog.String("to", migration.To))
+		}
+
+		if len(rebased) == 0 {
+			slog.InfoContext(ctx, "no migration to rebase")
+		}
+
+		return nil
+	})
+}
+
+// cmdArchive moves the migrations applied before a date or older than a
+// migration ID to the archive directory
+func cmdArchive(ctx context.Context, args []string) error {
+	// Parse command flags
+	cmdFlags := flag.NewFlagSet("archive", flag.ExitOnError)
+	cmdFlags.StringVar(&target, "target", target, "Name of the target defined in the configuration file")
+	before := cmdFlags.String("before", "", "Archive the migrations applied before this date (YYYY-MM-DD) or older than this migration ID")
+	yes := cmdFlags.Bool("yes", false, "Archive without asking for a confirmation")
+	cmdFlags.Parse(args) //nolint:errcheck
+
+	if *before == "" {
+		return fmt.Errorf("-before is required")
+	}
+
+	return withMigrator(target, "", func(_ string, m *mig.Migrator) error {
+		plan, err := m.ArchivePlan(ctx, *before)
+		if err != nil {
+			return err
+		}
+
+		if len(plan) == 0 {
+			slog.InfoContext(ctx, "no migration to archive")
+			return nil
+		}
+
+		if err := confirmDestructive("archive", "archive", plan, *yes); err != nil {
+			return err
+		}
+
+		archived, err := m.Archive(ctx, *before)
+		if err != nil {
+			return err
+		}
+
+		for _, filename := range archived {
+			fmt.Println(filename)
+			slog.InfoContext(ctx, "migration archived", slog.String("file", filename))
+		}
+
+		return nil
+	})
+}
+
+// cmdPlan prints the pending migrations in the order they would be applied,
+// with the estimated rows of their INSERT, UPDATE and DELETE statements when
+// -explain is set
+func cmdPlan(ctx context.Context, args []string) error {
+	// Parse command flags
+	cmdFlags := flag.NewFlagSet("plan", flag.ExitOnError)
+	targetFlags(cmdFlags)
+	explain := cmdFlags.Bool("explain", false, "Estimate the rows of the INSERT, UPDATE and DELETE statements with EXPLAIN")
+	cmdFlags.Parse(args) //nolint:errcheck
+
+	return forEachTarget(ctx, func(name string) error {
+		return forEachTenant(ctx, name, func(tenant string, m *mig.Migrator) error {
+			plan, err := m.Plan(ctx)
+			if err != nil {
+				return err
+			}
+
+			var estimates []mig.Estimate
+			if *explain {
+				if estimates, err = m.Explain(ctx); err != nil {
+					return err
+				}
+			}
+
+			if len(plan) == 0 {
+				slog.InfoContext(ctx, "no migration to apply", slog.String("target", name), slog.String("tenant", tenant))
+				return nil
+			}
+
+			for _, migration := range plan {
+				mode := "tx"
+				if !migration.Transactional {
+					mode = "no-tx"
+				}
+				fmt.Printf("%s (%s)\n", migration.Filename, mode)
+
+				for _, estimate := range estimates {
+					if estimate.Migration != migration.ID {
+						continue
+					}
+
+					statement, _, _ := strings.Cut(estimate.Statement, "\n")
+					if estimate.Err != nil {
+						fmt.Printf("  line %d: %s: %s\n", estimate.Line, statement, estimate.Err)
+						continue
+					}
+					fmt.Printf("  line %d: %s: ~%d rows\n", estimate.Line, statement, estimate.Rows)
+				}
+			}
+
+			return nil
+		})
+	})
+}
+
+// cmdAnalyze prints the table lock each statement of the pending migrations
+// takes with the size of the table, and the migrations needing a
+// maintenance window because they block reads
+func cmdAnalyze(ctx context.Context, args []string) error {
+	// Parse command flags
+	cmdFlags := flag.NewFlagSet("analyze", flag.ExitOnError)
+	targetFlags(cmdFlags)
+	cmdFlags.Parse(args) //nolint:errcheck
+
+	return forEachTarget(ctx, func(name string) error {
+		return forEachTenant(ctx, name, func(tenant string, m *mig.Migrator) error {
+			impacts, err := m.AnalyzeLocks(ctx)
+			if err != nil {
+				return err
+			}
+
+			if len(impacts) == 0 {
+				slog.InfoContext(ctx, "no pending statement takes a table lock", slog.String("target", name), slog.String("tenant", tenant))
+				return nil
+			}
+
+			risks := make(map[string]int)
+			var windows []string
+			for i, impact := range impacts {
+				if i == 0 || impacts[i-1].Migration != impact.Migration {
+					fmt.Println(impact.Migration)
+				}
+
+				size := "new table"
+				if impact.Exists {
+					size = fmt.Sprintf("~%d rows, %s", impact.Rows, formatBytes(impact.Bytes))
+				}
+
+				statement, _, _ := strings.Cut(impact.Statement, "\n")
+				fmt.Printf("  line %d: %s: %s on %s (%s) [%s]\n", impact.Line, statement, impact.Mode, impact.Table, size, impact.Risk)
+
+				risks[impact.Risk]++
+				if impact.Risk == mig.LockRiskHigh && !slices.Contains(windows, impact.Migration) {
+					windows = append(windows, impact.Migration)
+				}
+			}
+
+			fmt.Printf("\n%d high, %d medium and %d low risk statements\n", risks[mig.LockRiskHigh], risks[mig.LockRiskMedium], risks[mig.LockRiskLow])
+			if len(windows) > 0 {
+				fmt.Printf("Blocking reads, consider a maintenance window: %s\n", strings.Join(windows, ", "))
+			}
+
+			return nil
+		})
+	})
+}
+
+// formatBytes formats a size in bytes with a binary unit, such as 1.5 GiB
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// cmdStatus shows the status of migrations
+func cmdStatus(ctx context.Context, args []string) error {
 	// Parse command flags
 	cmdFlags := flag.NewFlagSet("status", flag.ExitOnError)
+	targetFlags(cmdFlags)
+	asJSON := cmdFlags.Bool("json", false, "Print the status of every migration as JSON")
+	columnList := cmdFlags.String("columns", "", "Comma-separated columns of the table ("+strings.Join(statusColumnNames(), ", ")+")")
+	output := cmdFlags.String("o", "", "Output format of the table: wide adds the name, duration and checksum")
+	pending := cmdFlags.Bool("pending", false, "Only show the pending migrations")
+	applied := cmdFlags.Bool("applied", false, "Only show the applied migrations")
+	since := cmdFlags.String("since", "", "Only show the migrations applied since this date (YYYY-MM-DD) or time (RFC 3339)")
+	until := cmdFlags.String("until", "", "Only show the migrations applied until this date (YYYY-MM-DD, included) or time (RFC 3339)")
+	grep := cmdFlags.String("grep", "", "Only show the migrations whose ID contains this text, ignoring case")
 	cmdFlags.Parse(args) //nolint:errcheck
 
-	// Create a new migrator
-	m, err := mig.New(configPath)
+	filter, err := newStatusFilter(*pending, *applied, *since, *until, *grep)
 	if err != nil {
 		return err
 	}
-	defer m.Close() //nolint:errcheck
 
-	// Get the status
-	statuses, err := m.Status()
+	columns, err := selectStatusColumns(*columnList, *output)
 	if err != nil {
 		return err
 	}
 
-	// Display the status
-	fmt.Println("Migration Status:")
-	fmt.Println("=================")
+	if *asJSON && (*columnList != "" || *output != "") {
+		return fmt.Errorf("-columns and -o do not apply to -json")
+	}
+
+	var entries []statusEntry
+	err = forEachTarget(ctx, func(name string) error {
+		// Get the status of every tenant
+		var tenants []string
+		statuses := make(map[string][]mig.MigrationStatus)
+		err := forEachTenant(ctx, name, func(tenant string, m *mig.Migrator) error {
+			tenantStatuses, err := m.StatusContext(ctx)
+			if err != nil {
+				return err
+			}
+			if annotations != nil {
+				annotations.drift(name, tenant, tenantStatuses)
+			}
+			tenants = append(tenants, tenant)
+			statuses[tenant] = slices.DeleteFunc(tenantStatuses, func(status mig.MigrationStatus) bool {
+				return !filter.match(status)
+			})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if *asJSON {
+			for _, tenant := range tenants {
+				for _, status := range statuses[tenant] {
+					entries = append(entries, newStatusEntry(name, tenant, status))
+				}
+			}
+			return nil
+		}
+
+		// Display the status
+		if name != "" {
+			fmt.Printf("Migration Status (%s):\n", name)
+		} else {
+			fmt.Println("Migration Status:")
+		}
+		fmt.Println("=================")
+
+		color := colorEnabled(os.Stdout)
+		if len(tenants) == 1 && tenants[0] == "" {
+			printStatus(statuses[""], columns, color)
+		} else {
+			printTenantStatus(tenants, statuses, color)
+		}
+
+		if allTargets {
+			fmt.Println()
+		}
+
+		return nil
+	})
+	if err != nil || !*asJSON {
+		return err
+	}
+
+	if entries == nil {
+		entries = []statusEntry{}
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(entries)
+}
+
+// cmdReport writes a changelog of the applied migrations for release notes
+// and audits
+func cmdReport(ctx context.Context, args []string) error {
+	// Parse command flags
+	cmdFlags := flag.NewFlagSet("report", flag.ExitOnError)
+	cmdFlags.StringVar(&target, "target", target, "Name of the target defined in the configuration file")
+	format := cmdFlags.String("format", mig.ReportMarkdown, "Format of the report (markdown, html)")
+	since := cmdFlags.String("since", "", "Only report the migrations applied since this date (YYYY-MM-DD) or time (RFC 3339)")
+	out := cmdFlags.String("out", "", "Path of the report, the standard output when not set")
+	cmdFlags.Parse(args) //nolint:errcheck
+
+	var from time.Time
+	if *since != "" {
+		var err error
+		if from, _, err = parseStatusTime(*since); err != nil {
+			return fmt.Errorf("invalid -since: %w", err)
+		}
+	}
+
+	return withMigrator(target, "", func(_ string, m *mig.Migrator) error {
+		report, err := m.Report(ctx, *format, from)
+		if err != nil {
+			return err
+		}
+
+		if *out == "" {
+			fmt.Print(report)
+			return nil
+		}
+
+		if err := os.WriteFile(*out, []byte(report), 0644); err != nil {
+			return fmt.Errorf("failed to write the report: %w", err)
+		}
+
+		slog.InfoContext(ctx, "report written", slog.String("file", *out))
+		return nil
+	})
+}
+
+func cmdExport(ctx context.Context, args []string) error {
+	// Parse command flags
+	cmdFlags := flag.NewFlagSet("export", flag.ExitOnError)
+	cmdFlags.StringVar(&target, "target", target, "Name of the target defined in the configuration file")
+	format := cmdFlags.String("format", mig.ExportJSON, "Format of the export (csv, json)")
+	out := cmdFlags.String("out", "", "Path of the export, the standard output when not set")
+	maxSQL := cmdFlags.Int("max-sql", 0, "Truncate the executed SQL to this many bytes, 0 to keep it whole")
+	cmdFlags.Parse(args) //nolint:errcheck
+
+	if *format != mig.ExportCSV && *format != mig.ExportJSON {
+		return fmt.Errorf("invalid -format %q, expected %s or %s", *format, mig.ExportCSV, mig.ExportJSON)
+	}
+
+	return withMigrator(target, "", func(_ string, m *mig.Migrator) error {
+		if *out == "" {
+			return m.Export(ctx, os.Stdout, *format, *maxSQL)
+		}
 
-	// Count applied migrations
-	appliedCount := 0
+		f, err := os.Create(*out)
+		if err != nil {
+			return fmt.Errorf("failed to create the export: %w", err)
+		}
+
+		if err := m.Export(ctx, f, *format, *maxSQL); err != nil {
+			f.Close()       //nolint:errcheck
+			os.Remove(*out) //nolint:errcheck
+			return err
+		}
+
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("failed to write the export: %w", err)
+		}
+
+		slog.InfoContext(ctx, "export written", slog.String("file", *out))
+		return nil
+	})
+}
+
+// statusFilter selects the migrations shown by "mig status"
+type statusFilter struct {
+	pending, applied bool
+	since, until     time.Time // Zero when not set
+	grep             string    // Lowercased
+}
+
+// newStatusFilter parses the filter flags of "mig status"
+func newStatusFilter(pending, applied bool, since, until, grep string) (statusFilter, error) {
+	if pending && applied {
+		return statusFilter{}, fmt.Errorf("-pending and -applied are mutually exclusive")
+	}
+
+	if pending && (since != "" || until != "") {
+		return statusFilter{}, fmt.Errorf("-since and -until only match applied migrations, they cannot be combined with -pending")
+	}
+
+	filter := statusFilter{pending: pending, applied: applied, grep: strings.ToLower(grep)}
+
+	var err error
+	if since != "" {
+		if filter.since, _, err = parseStatusTime(since); err != nil {
+			return statusFilter{}, fmt.Errorf("invalid -since: %w", err)
+		}
+	}
+
+	if until != "" {
+		var dateOnly bool
+		if filter.until, dateOnly, err = parseStatusTime(until); err != nil {
+			return statusFilter{}, fmt.Errorf("invalid -until: %w", err)
+		}
+		// A date includes its whole day
+		if dateOnly {
+			filter.until = filter.until.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		}
+	}
+
+	return filter, nil
+}
+
+// parseStatusTime parses a date in the local time zone or an RFC 3339 time,
+// reporting whether it was a date
+func parseStatusTime(value string) (time.Time, bool, error) {
+	if t, err := time.ParseInLocation(time.DateOnly, value, time.Local); err == nil {
+		return t, true, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("expected a date (YYYY-MM-DD) or an RFC 3339 time, got %q", value)
+	}
+
+	return t, false, nil
+}
+
+// match reports whether the filter selects the migration, pending migrations
+// have no applied time so the date range leaves them out
+func (f statusFilter) match(status mig.MigrationStatus) bool {
+	if f.pending && status.Applied || f.applied && !status.Applied {
+		return false
+	}
+
+	if !f.since.IsZero() || !f.until.IsZero() {
+		if !status.Applied {
+			return false
+		}
+		if !f.since.IsZero() && status.AppliedAt.Before(f.since) {
+			return false
+		}
+		if !f.until.IsZero() && status.AppliedAt.After(f.until) {
+			return false
+		}
+	}
+
+	return f.grep == "" || strings.Contains(strings.ToLower(status.ID), f.grep)
+}
+
+// statusEntry is the JSON form of a migration status printed by "mig status -json"
+type statusEntry struct {
+	Target    string     `json:"target,omitempty"`
+	Tenant    string     `json:"tenant,omitempty"`
+	ID        string     `json:"id"`
+	Name      string     `json:"name,omitempty"`
+	State     string     `json:"state"` // pending, applied, modified or missing
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+	Checksum  string     `json:"checksum,omitempty"`
+}
+
+// newStatusEntry converts the status of a migration of a target and tenant
+func newStatusEntry(target, tenant string, status mig.MigrationStatus) statusEntry {
+	entry := statusEntry{
+		Target:   target,
+		Tenant:   tenant,
+		ID:       status.ID,
+		Name:     status.Name,
+		State:    status.State(),
+		Checksum: status.Checksum,
+	}
+	if status.Applied {
+		entry.AppliedAt = &status.AppliedAt
+	}
+
+	return entry
+}
+
+// statusColumn is a column of the status table
+type statusColumn struct {
+	name   string
+	header string
+	value  func(status mig.MigrationStatus, now time.Time) string
+}
+
+// statusColumns are the columns the status table can show, in the order of
+// -columns help
+var statusColumns = []statusColumn{
+	{"state", "STATE", func(status mig.MigrationStatus, _ time.Time) string {
+		return statusLabel(status)
+	}},
+	{"id", "ID", func(status mig.MigrationStatus, _ time.Time) string {
+		return status.ID
+	}},
+	{"name", "NAME", func(status mig.MigrationStatus, _ time.Time) string {
+		return cmp.Or(status.Name, "-")
+	}},
+	{"filename", "FILENAME", func(status mig.MigrationStatus, _ time.Time) string {
+		return cmp.Or(status.Filename, "-")
+	}},
+	{"applied_at", "APPLIED AT", func(status mig.MigrationStatus, now time.Time) string {
+		if !status.Applied {
+			return "-"
+		}
+		return fmt.Sprintf("%s (%s)", status.AppliedAt.Format("2006-01-02 15:04:05"), relativeTime(status.AppliedAt, now))
+	}},
+	{"applied_by", "APPLIED BY", func(status mig.MigrationStatus, _ time.Time) string {
+		return cmp.Or(status.AppliedBy, "-")
+	}},
+	{"duration", "DURATION", func(status mig.MigrationStatus, _ time.Time) string {
+		if status.Duration == 0 {
+			return "-"
+		}
+		return status.Duration.String()
+	}},
+	{"checksum", "CHECKSUM", func(status mig.MigrationStatus, _ time.Time) string {
+		return cmp.Or(status.Checksum, "-")
+	}},
+}
+
+// Columns of the status table by default and with -o wide
+var (
+	defaultStatusColumns = []string{"state", "applied_at", "id"}
+	wideStatusColumns    = []string{"state", "id", "name", "applied_at", "duration", "checksum"}
+)
+
+// statusColumnNames returns the names of the columns of the status table
+func statusColumnNames() []string {
+	names := make([]string, len(statusColumns))
+	for i, column := range statusColumns {
+		names[i] = column.name
+	}
+
+	return names
+}
+
+// selectStatusColumns returns the columns of the status table chosen with
+// -columns or -o
+func selectStatusColumns(columnList, output string) ([]statusColumn, error) {
+	names := defaultStatusColumns
+	switch output {
+	case "":
+	case "wide":
+		names = wideStatusColumns
+	default:
+		return nil, fmt.Errorf("invalid output format %q, expected wide", output)
+	}
+
+	if columnList != "" {
+		if output != "" {
+			return nil, fmt.Errorf("-columns and -o are mutually exclusive")
+		}
+		names = strings.Split(columnList, ",")
+	}
+
+	columns := make([]statusColumn, len(names))
+	for i, name := range names {
+		j := slices.IndexFunc(statusColumns, func(column statusColumn) bool {
+			return column.name == strings.TrimSpace(name)
+		})
+		if j == -1 {
+			return nil, fmt.Errorf("unknown status column %q (available: %s)", name, strings.Join(statusColumnNames(), ", "))
+		}
+		columns[i] = statusColumns[j]
+	}
+
+	return columns, nil
+}
+
+// printStatus displays the status of the migrations of a single database in
+// the given columns, with colored states when color is set
+func printStatus(statuses []mig.MigrationStatus, columns []statusColumn, color bool) {
+	// Count applied and drifted migrations
+	appliedCount, driftedCount := 0, 0
 	for _, status := range statuses {
 		if status.Applied {
 			appliedCount++
 		}
+		if status.Modified && !status.Missing {
+			driftedCount++
+		}
 	}
 
 	fmt.Printf("Total: %d, Applied: %d, Pending: %d\n\n", len(statuses), appliedCount, len(statuses)-appliedCount)
@@ -279,18 +1646,284 @@ func cmdStatus(ctx context.Context, args []string) error {
 	// Display the list of migrations
 	if len(statuses) > 0 {
 		fmt.Println("Migrations:")
+		now := time.Now()
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+		header := make([]string, len(columns))
+		for i, column := range columns {
+			header[i] = column.header
+			if column.name == "state" && color {
+				header[i] = colorDefault + column.header + colorReset
+			}
+		}
+		fmt.Fprintf(w, "  %s\n", strings.Join(header, "\t"))
+
 		for _, status := range statuses {
-			statusText := "PENDING"
-			appliedAt := ""
-			if status.Applied {
-				statusText = "APPLIED"
-				appliedAt = status.AppliedAt
+			row := make([]string, len(columns))
+			for i, column := range columns {
+				row[i] = column.value(status, now)
+				if column.name == "state" {
+					row[i] = colorize(row[i], row[i], color)
+				}
+			}
+			drift := ""
+			if status.Modified && !status.Missing {
+				drift = "  " + colorize("DRIFTED", "DRIFTED", color)
 			}
-			fmt.Printf("  %-10s  %s  %s\n", statusText, appliedAt, status.ID)
+			fmt.Fprintf(w, "  %s%s\n", strings.Join(row, "\t"), drift)
 		}
+		w.Flush() //nolint:errcheck
 	} else {
 		fmt.Println("No migrations found")
 	}
 
+	if driftedCount > 0 {
+		fmt.Printf("\n%d applied migration(s) DRIFTED: their file changed since they were applied\n", driftedCount)
+	}
+}
+
+// statusLabel returns the state of a migration for the status output, a
+// drifted migration is still applied
+func statusLabel(status mig.MigrationStatus) string {
+	switch {
+	case status.Missing:
+		return "MISSING"
+	case status.Applied:
+		return "APPLIED"
+	default:
+		return "PENDING"
+	}
+}
+
+// printTenantStatus displays a matrix of the migrations applied to each
+// tenant schema, with colored states when color is set
+func printTenantStatus(tenants []string, statuses map[string][]mig.MigrationStatus, color bool) {
+	// Count the tenants with pending migrations
+	behind := 0
+	for _, tenant := range tenants {
+		for _, status := range statuses[tenant] {
+			if !status.Applied {
+				behind++
+				break
+			}
+		}
+	}
+
+	fmt.Printf("Tenants: %d, Up to date: %d, Behind: %d\n\n", len(tenants), len(tenants)-behind, behind)
+
+	if len(tenants) == 0 {
+		fmt.Println("No tenants found")
+		return
+	}
+
+	// Every tenant shares the migrations directory, but a tenant can have
+	// applied migrations whose file is missing, so the rows are merged
+	var ids []string
+	states := make(map[string]map[string]string)
+	for _, tenant := range tenants {
+		for _, status := range statuses[tenant] {
+			if states[status.ID] == nil {
+				ids = append(ids, status.ID)
+				states[status.ID] = make(map[string]string)
+			}
+			states[status.ID][tenant] = statusLabel(status)
+			if status.Modified && !status.Missing {
+				states[status.ID][tenant] = "DRIFTED"
+			}
+		}
+	}
+	slices.Sort(ids)
+
+	header := slices.Clone(tenants)
+	if color {
+		for j, tenant := range header {
+			header[j] = colorDefault + tenant + colorReset
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "  MIGRATION\t%s\n", strings.Join(header, "\t"))
+	for _, id := range ids {
+		row := make([]string, len(tenants))
+		for j, tenant := range tenants {
+			state := cmp.Or(states[id][tenant], "-")
+			row[j] = colorize(state, state, color)
+		}
+		fmt.Fprintf(w, "  %s\t%s\n", id, strings.Join(row, "\t"))
+	}
+	w.Flush() //nolint:errcheck
+}
+
+// cmdConfig checks the configuration file
+func cmdConfig(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] != "validate" {
+		return fmt.Errorf("usage: mig config validate [-target name | -all-targets]")
+	}
+
+	// Parse command flags
+	cmdFlags := flag.NewFlagSet("config validate", flag.ExitOnError)
+	targetFlags(cmdFlags)
+	cmdFlags.Parse(args[1:]) //nolint:errcheck
+
+	return forEachTarget(ctx, func(name string) error {
+		if err := mig.ValidateConfig(configPath, migratorOptions(name)...); err != nil {
+			return err
+		}
+
+		slog.InfoContext(ctx, "configuration is valid", slog.String("target", name))
+		return nil
+	})
+}
+
+// cmdLint checks the migrations against the safety rules, failing when one
+// of them is an error
+func cmdLint(ctx context.Context, args []string) error {
+	// Parse command flags
+	cmdFlags := flag.NewFlagSet("lint", flag.ExitOnError)
+	targetFlags(cmdFlags)
+	cmdFlags.Parse(args) //nolint:errcheck
+
+	return forEachTarget(ctx, func(name string) error {
+		findings, err := mig.Lint(configPath, migratorOptions(name)...)
+		if err != nil {
+			return err
+		}
+
+		errs := 0
+		for _, finding := range findings {
+			fmt.Println(finding)
+			if annotations != nil {
+				annotations.lint(name, finding)
+			}
+			if finding.Severity == mig.LintSeverityError {
+				errs++
+			}
+		}
+
+		if errs > 0 {
+			return fmt.Errorf("%d of %d lint findings are errors", errs, len(findings))
+		}
+
+		slog.InfoContext(ctx, "migrations linted", slog.String("target", name), slog.Int("warnings", len(findings)))
+		return nil
+	})
+}
+
+// cmdValidate checks the syntax of the migrations offline, failing when one
+// of them has an error
+func cmdValidate(ctx context.Context, args []string) error {
+	// Parse command flags
+	cmdFlags := flag.NewFlagSet("validate", flag.ExitOnError)
+	targetFlags(cmdFlags)
+	cmdFlags.Parse(args) //nolint:errcheck
+
+	return forEachTarget(ctx, func(name string) error {
+		errs, err := mig.Validate(configPath, migratorOptions(name)...)
+		if err != nil {
+			return err
+		}
+
+		for _, syntaxErr := range errs {
+			fmt.Println(syntaxErr)
+			if annotations != nil {
+				annotations.syntax(name, syntaxErr)
+			}
+		}
+
+		if len(errs) > 0 {
+			return fmt.Errorf("%d syntax errors in the migrations", len(errs))
+		}
+
+		slog.InfoContext(ctx, "migrations validated", slog.String("target", name))
+		return nil
+	})
+}
+
+// cmdDrift compares the live schema to the snapshot file, failing when they
+// differ, or updates the snapshot
+func cmdDrift(ctx context.Context, args []string) error {
+	// Parse command flags
+	cmdFlags := flag.NewFlagSet("drift", flag.ExitOnError)
+	file := cmdFlags.String("file", "", "Path to the schema snapshot (default: migrations.schema_file or schema.sql)")
+	update := cmdFlags.Bool("update", false, "Write the live schema to the snapshot instead of comparing them")
+	targetFlags(cmdFlags)
+	cmdFlags.Parse(args) //nolint:errcheck
+
+	return forEachTarget(ctx, func(name string) error {
+		file := *file
+		if file == "" {
+			schemaFile, err := mig.SchemaFile(configPath, migratorOptions(name)...)
+			if err != nil {
+				return err
+			}
+			file = cmp.Or(schemaFile, "schema.sql")
+		}
+
+		if *update {
+			return dumpSchema(ctx, name, file)
+		}
+
+		return withMigrator(name, "", func(_ string, m *mig.Migrator) error {
+			schema, err := m.DumpSchema(ctx)
+			if err != nil {
+				return err
+			}
+
+			snapshot, err := os.ReadFile(file)
+			if os.IsNotExist(err) {
+				return fmt.Errorf("schema snapshot %s does not exist, run mig drift -update to create it", file)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read the schema snapshot: %w", err)
+			}
+
+			changes := mig.DiffSchema(string(snapshot), schema)
+			for _, change := range changes {
+				fmt.Println(change)
+			}
+
+			if len(changes) > 0 {
+				return fmt.Errorf("schema drifted from %s: %d difference(s)", file, len(changes))
+			}
+
+			slog.InfoContext(ctx, "schema matches the snapshot", slog.String("target", name), slog.String("file", file))
+			return nil
+		})
+	})
+}
+
+// cmdAuth manages database passwords stored in the OS keyring
+func cmdAuth(ctx context.Context, args []string) error {
+	// Parse command flags
+	cmdFlags := flag.NewFlagSet("auth", flag.ExitOnError)
+	cmdFlags.Parse(args) //nolint:errcheck
+
+	if cmdFlags.NArg() != 2 {
+		return fmt.Errorf("usage: mig auth login|logout <target>")
+	}
+	action, target := cmdFlags.Arg(0), cmdFlags.Arg(1)
+
+	switch action {
+	case "login":
+		password, err := readPassword(fmt.Sprintf("Database password for %s: ", target))
+		if err != nil {
+			return err
+		}
+
+		if err := mig.SaveKeyringPassword(ctx, target, password); err != nil {
+			return fmt.Errorf("failed to store password: %w", err)
+		}
+
+		slog.InfoContext(ctx, "password stored in the OS keyring", slog.String("target", target))
+	case "logout":
+		if err := mig.DeleteKeyringPassword(ctx, target); err != nil {
+			return fmt.Errorf("failed to remove password: %w", err)
+		}
+
+		slog.InfoContext(ctx, "password removed from the OS keyring", slog.String("target", target))
+	default:
+		return fmt.Errorf("unknown auth action %q, expected login or logout", action)
+	}
+
 	return nil
 }
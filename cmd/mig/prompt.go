@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// stdin buffers the answers read from the standard input, shared by the
+// prompts so that a line buffered by one of them is not lost to the next
+var stdin = bufio.NewReader(os.Stdin)
+
+// isTerminal reports whether the file is attached to a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// readPassword prompts on stderr and reads a line from stdin, with echo
+// disabled when stdin is a terminal
+func readPassword(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+
+	if isTerminal(os.Stdin) {
+		if err := stty("-echo"); err != nil {
+			return "", fmt.Errorf("failed to disable terminal echo: %w", err)
+		}
+		defer func() {
+			stty("echo") //nolint:errcheck
+			fmt.Fprintln(os.Stderr)
+		}()
+	}
+
+	line, err := stdin.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// confirm prompts on stderr and reports whether the line read from stdin is
+// the expected answer
+func confirm(prompt, answer string) (bool, error) {
+	fmt.Fprint(os.Stderr, prompt)
+
+	line, err := stdin.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, fmt.Errorf("failed to read confirmation: %w", err)
+	}
+
+	return strings.TrimSpace(line) == answer, nil
+}
+
+// stty changes the settings of the terminal attached to stdin
+func stty(args ...string) error {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/arthurdotwork/mig"
+)
+
+// serveShutdownTimeout bounds the wait for the requests in flight, an apply
+// included, once the server is asked to stop
+const serveShutdownTimeout = 30 * time.Second
+
+func cmdServe(ctx context.Context, args []string) error {
+	// Parse command flags
+	cmdFlags := flag.NewFlagSet("serve", flag.ExitOnError)
+	cmdFlags.StringVar(&target, "target", target, "Name of the target defined in the configuration file")
+	tenant := cmdFlags.String("tenant", "", "Tenant schema to migrate, for multi-tenant targets")
+	addr := cmdFlags.String("addr", ":8080", "Address to listen on")
+	yes := cmdFlags.Bool("yes", false, "Allow serving a protected target, whose migrations can then be applied remotely")
+	cmdFlags.Parse(args) //nolint:errcheck
+
+	token := os.Getenv("MIG_SERVE_TOKEN")
+	if token == "" {
+		return errors.New("MIG_SERVE_TOKEN must be set to the bearer token of the API")
+	}
+
+	if err := confirmProtected(target, "serve", *yes, nil); err != nil {
+		return err
+	}
+
+	return withMigrator(target, *tenant, func(_ string, m *mig.Migrator) error {
+		// The gRPC service is served next to the JSON API, over unencrypted
+		// HTTP/2 as gRPC clients connect without TLS
+		api, grpc := mig.APIHandler(m, token), mig.GRPCServer(m, token)
+		var protocols http.Protocols
+		protocols.SetHTTP1(true)
+		protocols.SetUnencryptedHTTP2(true)
+
+		server := &http.Server{
+			Addr: *addr,
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+					grpc.ServeHTTP(w, r)
+					return
+				}
+				api.ServeHTTP(w, r)
+			}),
+			Protocols:         &protocols,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+
+		errs := make(chan error, 1)
+		go func() {
+			errs <- server.ListenAndServe()
+		}()
+
+		slog.InfoContext(ctx, "serving the migrations API", slog.String("addr", *addr))
+
+		select {
+		case err := <-errs:
+			return fmt.Errorf("failed to serve: %w", err)
+		case <-ctx.Done():
+		}
+
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), serveShutdownTimeout)
+		defer cancel()
+
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			return fmt.Errorf("failed to stop the server: %w", err)
+		}
+
+		slog.InfoContext(ctx, "server stopped")
+		return nil
+	})
+}
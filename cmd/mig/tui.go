@@ -0,0 +1,573 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/arthurdotwork/mig"
+)
+
+// Escape sequences of the terminal UI
+const (
+	tuiAltScreen  = "\x1b[?1049h\x1b[?25l"
+	tuiMainScreen = "\x1b[?25h\x1b[?1049l"
+	tuiClear      = "\x1b[H\x1b[2J"
+	tuiReverse    = "\x1b[7m"
+)
+
+// Views of the terminal UI
+const (
+	tuiList = iota
+	tuiSQL
+)
+
+// tuiLogLines is the height of the execution output below the migrations
+const tuiLogLines = 6
+
+// logPane keeps the last lines logged by the migrator for the terminal UI,
+// signaling updated so the screen follows the execution
+type logPane struct {
+	mu      sync.Mutex
+	lines   []string
+	updated chan struct{}
+}
+
+// Write appends the logged lines
+func (p *logPane) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	p.lines = append(p.lines, strings.Split(strings.TrimRight(string(b), "\n"), "\n")...)
+	if len(p.lines) > 100 {
+		p.lines = p.lines[len(p.lines)-100:]
+	}
+	p.mu.Unlock()
+
+	select {
+	case p.updated <- struct{}{}:
+	default:
+	}
+
+	return len(b), nil
+}
+
+// tail returns the last n lines
+func (p *logPane) tail(n int) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]string(nil), p.lines[max(0, len(p.lines)-n):]...)
+}
+
+// tui is the state of "mig tui"
+type tui struct {
+	ctx   context.Context
+	m     *mig.Migrator
+	label string // Target and tenant shown in the title
+	log   *logPane
+	color bool
+
+	// protected is the name to type before applying migrations to a
+	// protected target, empty otherwise
+	protected string
+
+	statuses []mig.MigrationStatus
+	cursor   int
+	view     int
+
+	// sql holds the lines of the migration shown in the SQL view
+	sql       []string
+	sqlTitle  string
+	sqlOffset int
+
+	// prompt asks for answer before running action, input collects the keys
+	prompt string
+	answer string
+	input  string
+	action func()
+
+	message string
+	running bool
+	done    chan error
+	cancel  context.CancelFunc
+}
+
+func cmdTUI(ctx context.Context, args []string) error {
+	// Parse command flags
+	cmdFlags := flag.NewFlagSet("tui", flag.ExitOnError)
+	cmdFlags.StringVar(&target, "target", target, "Name of the target defined in the configuration file")
+	tenant := cmdFlags.String("tenant", "", "Tenant schema to migrate, for multi-tenant targets")
+	cmdFlags.Parse(args) //nolint:errcheck
+
+	if !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+		return errors.New("mig tui requires a terminal")
+	}
+
+	protected, err := mig.Protected(configPath, migratorOptions(target)...)
+	if err != nil {
+		return err
+	}
+
+	// The migrator logs to the output pane instead of stderr
+	pane := &logPane{updated: make(chan struct{}, 1)}
+	opts := append(migratorOptions(target), mig.WithLogger(slog.New(slog.NewTextHandler(pane, nil))))
+	if *tenant != "" {
+		opts = append(opts, mig.WithTenant(*tenant))
+	}
+
+	m, err := mig.New(configPath, opts...)
+	if err != nil {
+		return err
+	}
+	defer m.Close() //nolint:errcheck
+
+	if audit != nil {
+		audit.connected(target, *tenant, m)
+	}
+
+	t := &tui{
+		ctx:   ctx,
+		m:     m,
+		label: strings.Trim(cmp.Or(target, "default")+"/"+*tenant, "/"),
+		log:   pane,
+		color: colorEnabled(os.Stdout),
+		done:  make(chan error, 1),
+	}
+	if protected {
+		t.protected = cmp.Or(target, "default")
+	}
+
+	if err := t.refresh(); err != nil {
+		return err
+	}
+
+	return t.run()
+}
+
+// run switches the terminal to raw mode and handles the keys until the user quits
+func (t *tui) run() error {
+	saved, err := sttyOutput("-g")
+	if err != nil {
+		return fmt.Errorf("failed to read the terminal settings: %w", err)
+	}
+	if err := stty("raw", "-echo"); err != nil {
+		return fmt.Errorf("failed to set up the terminal: %w", err)
+	}
+	fmt.Print(tuiAltScreen)
+	defer func() {
+		fmt.Print(tuiMainScreen)
+		stty(saved) //nolint:errcheck
+	}()
+
+	keys := make(chan string)
+	go readKeys(keys)
+
+	for {
+		t.draw()
+
+		select {
+		case key, ok := <-keys:
+			if !ok || t.handle(key) {
+				if t.cancel != nil {
+					t.cancel()
+					<-t.done
+				}
+				return nil
+			}
+		case <-t.log.updated:
+		case err := <-t.done:
+			t.finish(err)
+		}
+	}
+}
+
+// readKeys sends the keys pressed on stdin, naming the special ones
+func readKeys(keys chan<- string) {
+	defer close(keys)
+
+	names := map[string]string{
+		"\x1b[A": "up", "\x1bOA": "up", "\x1b[B": "down", "\x1bOB": "down",
+		"\x1b[5~": "pgup", "\x1b[6~": "pgdown", "\x1b[H": "home", "\x1b[F": "end",
+		"\r": "enter", "\n": "enter", "\x1b": "esc", "\x03": "ctrl-c", "\x7f": "backspace",
+	}
+
+	buf := make([]byte, 64)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return
+		}
+
+		input := string(buf[:n])
+		if name, ok := names[input]; ok {
+			keys <- name
+			continue
+		}
+
+		// Unknown escape sequences are dropped, typed text is split in keys
+		if strings.HasPrefix(input, "\x1b") {
+			continue
+		}
+		for _, r := range input {
+			if name, ok := names[string(r)]; ok {
+				keys <- name
+			} else {
+				keys <- string(r)
+			}
+		}
+	}
+}
+
+// handle applies a key, reporting whether the user quits
+func (t *tui) handle(key string) bool {
+	if key == "ctrl-c" {
+		return true
+	}
+
+	if t.prompt != "" {
+		t.handlePrompt(key)
+		return false
+	}
+
+	if t.view == tuiSQL {
+		t.handleSQL(key)
+		return false
+	}
+
+	switch key {
+	case "q":
+		return !t.running
+	case "up", "k":
+		t.cursor = max(0, t.cursor-1)
+	case "down", "j":
+		t.cursor = min(len(t.statuses)-1, t.cursor+1)
+	case "pgup":
+		t.cursor = max(0, t.cursor-listHeight(t.rows()))
+	case "pgdown":
+		t.cursor = min(len(t.statuses)-1, t.cursor+listHeight(t.rows()))
+	case "home", "g":
+		t.cursor = 0
+	case "end", "G":
+		t.cursor = len(t.statuses) - 1
+	case "enter", "v":
+		t.showSQL()
+	case "r":
+		if !t.running {
+			if err := t.refresh(); err != nil {
+				t.message = err.Error()
+			}
+		}
+	case "a":
+		t.applySelected()
+	case "A":
+		t.applyAll()
+	}
+
+	return false
+}
+
+// handlePrompt collects the answer to the prompt, running its action once
+// the expected answer is entered
+func (t *tui) handlePrompt(key string) {
+	switch key {
+	case "esc":
+		t.prompt, t.message = "", "Canceled"
+	case "backspace":
+		if t.input != "" {
+			_, size := utf8.DecodeLastRuneInString(t.input)
+			t.input = t.input[:len(t.input)-size]
+		}
+	case "enter":
+		t.prompt = ""
+		if t.input != t.answer {
+			t.message = "Canceled"
+			return
+		}
+		t.action()
+	default:
+		if utf8.RuneCountInString(key) == 1 {
+			t.input += key
+		}
+	}
+}
+
+// handleSQL scrolls the SQL view
+func (t *tui) handleSQL(key string) {
+	height := t.rows() - 3
+	last := max(0, len(t.sql)-height)
+
+	switch key {
+	case "q", "esc", "enter":
+		t.view = tuiList
+	case "up", "k":
+		t.sqlOffset = max(0, t.sqlOffset-1)
+	case "down", "j":
+		t.sqlOffset = min(last, t.sqlOffset+1)
+	case "pgup":
+		t.sqlOffset = max(0, t.sqlOffset-height)
+	case "pgdown", " ":
+		t.sqlOffset = min(last, t.sqlOffset+height)
+	case "home", "g":
+		t.sqlOffset = 0
+	case "end", "G":
+		t.sqlOffset = last
+	}
+}
+
+// refresh reloads the status of the migrations
+func (t *tui) refresh() error {
+	statuses, err := t.m.StatusContext(t.ctx)
+	if err != nil {
+		return err
+	}
+
+	t.statuses = statuses
+	t.cursor = max(0, min(t.cursor, len(statuses)-1))
+
+	return nil
+}
+
+// showSQL opens the selected migration in the SQL view
+func (t *tui) showSQL() {
+	if len(t.statuses) == 0 {
+		return
+	}
+
+	status := t.statuses[t.cursor]
+	if status.Missing {
+		t.message = status.ID + " is applied but its file is missing"
+		return
+	}
+
+	migration, err := t.m.Migration(status.ID)
+	if err != nil {
+		t.message = err.Error()
+		return
+	}
+
+	t.sql = strings.Split(strings.ReplaceAll(migration.Content, "\t", "    "), "\n")
+	t.sqlTitle, t.sqlOffset, t.view = migration.Filename, 0, tuiSQL
+}
+
+// applySelected asks to apply the selected pending migration
+func (t *tui) applySelected() {
+	if t.running || len(t.statuses) == 0 {
+		return
+	}
+
+	status := t.statuses[t.cursor]
+	if status.Applied {
+		t.message = status.ID + " is already applied, migrations are forward-only"
+		return
+	}
+
+	t.ask(fmt.Sprintf("Apply %s?", status.ID), func(ctx context.Context) error {
+		return t.m.MigrateUpByID(ctx, status.ID, false)
+	})
+}
+
+// applyAll asks to apply every pending migration
+func (t *tui) applyAll() {
+	if t.running {
+		return
+	}
+
+	pending := 0
+	for _, status := range t.statuses {
+		if !status.Applied {
+			pending++
+		}
+	}
+	if pending == 0 {
+		t.message = "No pending migrations"
+		return
+	}
+
+	t.ask(fmt.Sprintf("Apply the %d pending migration(s)?", pending), func(ctx context.Context) error {
+		_, err := t.m.MigrateUpAllContext(ctx)
+		return err
+	})
+}
+
+// ask prompts for a confirmation, the target name for protected targets,
+// before running apply in the background
+func (t *tui) ask(question string, apply func(context.Context) error) {
+	t.prompt, t.answer, t.input = question+" Type y to confirm: ", "y", ""
+	if t.protected != "" {
+		t.prompt = fmt.Sprintf("%s Target %s is protected, type %q to confirm: ", question, t.protected, t.protected)
+		t.answer = t.protected
+	}
+
+	t.action = func() {
+		ctx, cancel := context.WithCancel(t.ctx)
+		t.running, t.cancel, t.message = true, cancel, "Running... ctrl-c cancels and quits"
+
+		go func() {
+			t.done <- apply(ctx)
+		}()
+	}
+}
+
+// finish reports the outcome of a run and reloads the status
+func (t *tui) finish(err error) {
+	t.cancel()
+	t.running, t.cancel = false, nil
+
+	t.message = "Done"
+	if err != nil {
+		t.message = "Failed: " + err.Error()
+	}
+
+	if err := t.refresh(); err != nil {
+		t.message = err.Error()
+	}
+}
+
+// rows returns the height of the terminal
+func (t *tui) rows() int {
+	rows, _ := terminalSize()
+	return rows
+}
+
+// listHeight returns the number of migrations shown at once on a terminal
+// of the given height, below the title and above the output and keys
+func listHeight(rows int) int {
+	return max(1, rows-tuiLogLines-5)
+}
+
+// draw renders the current view
+func (t *tui) draw() {
+	rows, cols := terminalSize()
+
+	var lines []string
+	if t.view == tuiSQL {
+		lines = append(lines, t.reverse(fit(t.sqlTitle, cols), cols))
+		end := min(len(t.sql), t.sqlOffset+rows-3)
+		for _, line := range t.sql[t.sqlOffset:end] {
+			lines = append(lines, fit(line, cols))
+		}
+		for len(lines) < rows-1 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, fit("↑/↓ scroll  pgup/pgdn page  q back", cols))
+	} else {
+		lines = t.drawList(rows, cols)
+	}
+
+	var b strings.Builder
+	b.WriteString(tuiClear)
+	b.WriteString(strings.Join(lines, "\r\n"))
+	fmt.Print(b.String())
+}
+
+// drawList renders the migrations, the execution output and the keys
+func (t *tui) drawList(rows, cols int) []string {
+	pending := 0
+	for _, status := range t.statuses {
+		if !status.Applied {
+			pending++
+		}
+	}
+
+	lines := []string{
+		t.reverse(fit(fmt.Sprintf("mig %s - %s: %d migration(s), %d pending", mig.Version, t.label, len(t.statuses), pending), cols), cols),
+	}
+
+	// Keep the cursor on screen
+	height := listHeight(rows)
+	offset := max(0, min(t.cursor-height/2, len(t.statuses)-height))
+	for i := offset; i < min(len(t.statuses), offset+height); i++ {
+		status := t.statuses[i]
+		label := statusLabel(status)
+		if status.Modified {
+			label = "DRIFTED"
+		}
+		applied := "-"
+		if status.Applied {
+			applied = status.AppliedAt.Local().Format(time.DateTime)
+		}
+
+		line := fit(fmt.Sprintf("%-8s %-19s %s", label, applied, status.ID), cols)
+		switch {
+		case i == t.cursor:
+			line = t.reverse(line, cols)
+		case t.color:
+			line = colorize(label, line, true)
+		}
+		lines = append(lines, line)
+	}
+	for len(lines) < height+1 {
+		lines = append(lines, "")
+	}
+
+	lines = append(lines, strings.Repeat("─", cols))
+	logged := t.log.tail(tuiLogLines)
+	for i := range tuiLogLines {
+		if i < len(logged) {
+			lines = append(lines, fit(logged[i], cols))
+		} else {
+			lines = append(lines, "")
+		}
+	}
+	lines = append(lines, strings.Repeat("─", cols))
+
+	switch {
+	case t.prompt != "":
+		lines = append(lines, fit(t.prompt+t.input, cols))
+	default:
+		lines = append(lines, fit(t.message, cols))
+	}
+	lines = append(lines, fit("↑/↓ move  enter view SQL  a apply  A apply all  r refresh  q quit", cols))
+
+	return lines[:min(len(lines), rows)]
+}
+
+// reverse highlights a line over the whole width of the terminal
+func (t *tui) reverse(line string, cols int) string {
+	return tuiReverse + line + strings.Repeat(" ", max(0, cols-utf8.RuneCountInString(line))) + colorReset
+}
+
+// fit truncates a line to the width of the terminal
+func fit(line string, cols int) string {
+	if utf8.RuneCountInString(line) <= cols {
+		return line
+	}
+
+	runes := []rune(line)
+	return string(runes[:max(0, cols-1)]) + "…"
+}
+
+// terminalSize returns the rows and columns of the terminal, 24x80 when
+// they cannot be read
+func terminalSize() (int, int) {
+	out, err := sttyOutput("size")
+	if err != nil {
+		return 24, 80
+	}
+
+	rows, cols, ok := strings.Cut(strings.TrimSpace(out), " ")
+	r, rowsErr := strconv.Atoi(rows)
+	c, colsErr := strconv.Atoi(cols)
+	if !ok || rowsErr != nil || colsErr != nil || r == 0 || c == 0 {
+		return 24, 80
+	}
+
+	return r, c
+}
+
+// sttyOutput runs stty on the terminal attached to stdin and returns its output
+func sttyOutput(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
@@ -0,0 +1,19 @@
+package mig
+
+import (
+	"context"
+
+	"github.com/arthurdotwork/mig/internal/credentials"
+)
+
+// SaveKeyringPassword stores the database password for the target in the OS
+// keyring, read back when connecting to the target without a configured
+// password, or through `password_from: keyring:<target>`
+func SaveKeyringPassword(ctx context.Context, target, password string) error {
+	return credentials.Keyring{}.Store(ctx, target, password)
+}
+
+// DeleteKeyringPassword removes the database password stored for the target
+func DeleteKeyringPassword(ctx context.Context, target string) error {
+	return credentials.Keyring{}.Delete(ctx, target)
+}
@@ -0,0 +1,29 @@
+package mig
+
+import (
+	"github.com/arthurdotwork/mig/internal/config"
+	"github.com/arthurdotwork/mig/internal/database"
+)
+
+// Dialect describes how mig talks to a database engine: connection strings,
+// quoting, placeholders, tracking tables, transactional DDL and locking.
+// Implement it and call RegisterDialect to support an engine mig doesn't ship.
+type Dialect = database.Dialect
+
+// DatabaseConfig is the database section of the configuration
+type DatabaseConfig = config.DatabaseConfig
+
+// RegisterDialect registers a dialect under its name, making it selectable
+// with the `driver` configuration setting. Registering a name that already
+// exists replaces the previous dialect.
+//
+// The database/sql driver returned by Dialect.DriverName must be registered
+// separately, usually by importing the driver package.
+func RegisterDialect(d Dialect) {
+	database.RegisterDialect(d)
+}
+
+// GetDialect returns the dialect registered under the given name
+func GetDialect(name string) (Dialect, error) {
+	return database.GetDialect(name)
+}
@@ -0,0 +1,20 @@
+// Package mig applies SQL migrations to a database and records them in the
+// mig_versions and mig_history tables.
+//
+// This package is the only public API of the module: the cmd/mig CLI is built
+// on top of it, and the packages under internal are implementation details
+// that cannot be imported. A Migrator is created either from a configuration
+// file with New, or from a connection pool owned by the application with
+// NewWithDB:
+//
+//	m, err := mig.New("mig.yaml", mig.WithTarget("analytics"))
+//	if err != nil {
+//		return err
+//	}
+//	defer m.Close()
+//
+//	count, err := m.MigrateUpAll()
+//
+// Behaviour is configured with Option values, and additional database
+// engines are supported by registering a Dialect.
+package mig
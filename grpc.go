@@ -0,0 +1,209 @@
+package mig
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/arthurdotwork/mig/internal/executor"
+	"github.com/arthurdotwork/mig/internal/rpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCServer returns a gRPC server of the mig.v1.Migrator service of
+// proto/mig/v1/migrator.proto, for a deployment controller driving the
+// migrations. Every call must carry the token as an "authorization: Bearer"
+// metadata.
+//
+// Serve it on a listener, or next to other handlers through its ServeHTTP
+// method from an http.Server accepting HTTP/2, which needs
+// http.Server.Protocols to allow unencrypted HTTP/2 without TLS. Apply takes
+// the migration lock like MigrateUpAllContext and streams the events of the
+// run it started.
+func GRPCServer(m *Migrator, token string) *grpc.Server {
+	server := rpc.NewServer(token)
+	rpc.RegisterMigratorServer(server, &grpcService{m: m})
+
+	return server
+}
+
+// grpcService implements the mig.v1.Migrator service on a Migrator
+type grpcService struct {
+	rpc.UnimplementedMigratorServer
+
+	m *Migrator
+}
+
+// Plan lists the pending migrations in the order they would be applied
+func (s *grpcService) Plan(ctx context.Context, _ *rpc.PlanRequest) (*rpc.PlanResponse, error) {
+	plan, err := s.m.Plan(ctx)
+	if err != nil {
+		return nil, rpc.Status(err)
+	}
+
+	return rpc.NewPlanResponse(plan), nil
+}
+
+// Status lists the migrations with their state
+func (s *grpcService) Status(ctx context.Context, _ *rpc.StatusRequest) (*rpc.StatusResponse, error) {
+	statuses, err := s.m.StatusContext(ctx)
+	if err != nil {
+		return nil, rpc.Status(err)
+	}
+
+	return rpc.NewStatusResponse(statuses), nil
+}
+
+// Verify fails with FAILED_PRECONDITION when the files of applied migrations
+// changed or are missing
+func (s *grpcService) Verify(ctx context.Context, _ *rpc.VerifyRequest) (*rpc.VerifyResponse, error) {
+	if err := s.m.Verify(ctx); err != nil {
+		return nil, rpc.Status(err)
+	}
+
+	return &rpc.VerifyResponse{}, nil
+}
+
+// Apply applies the migrations selected by the request, streaming the events
+// of its run and ending with the result event
+//
+// The run goes on in another goroutine whose events are passed back, as only
+// the goroutine of the call may send on the stream. It is canceled once the
+// client is gone.
+func (s *grpcService) Apply(msg *rpc.ApplyRequest, stream grpc.ServerStreamingServer[rpc.ApplyEvent]) error {
+	req := ApplyRequest{ID: msg.GetId(), AllowOutOfOrder: msg.GetAllowOutOfOrder(), Phase: msg.GetPhase()}
+	if req.ID != "" && req.Phase != "" {
+		return status.Error(codes.InvalidArgument, "id and phase are mutually exclusive")
+	}
+
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+
+	events := make(chan Event)
+	observer := observerFunc(func(event Event) {
+		select {
+		case events <- event:
+		case <-ctx.Done():
+		}
+	})
+
+	type result struct {
+		applied int
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		applied, err := apply(executor.ContextWithObserver(ctx, observer), s.m, req)
+		done <- result{applied: applied, err: err}
+	}()
+
+	var sendErr error
+	for {
+		select {
+		case event := <-events:
+			if sendErr != nil {
+				continue
+			}
+			if sendErr = stream.Send(rpc.NewApplyEvent(event)); sendErr != nil {
+				cancel()
+			}
+		case res := <-done:
+			if sendErr != nil {
+				return sendErr
+			}
+
+			// The result is sent before the error so the client knows how
+			// many migrations a failed run applied
+			if err := stream.Send(rpc.NewResultEvent(res.applied)); err != nil && res.err == nil {
+				return err
+			}
+			return rpc.Status(res.err)
+		}
+	}
+}
+
+// GRPCClient calls the gRPC service of GRPCServer, served by mig serve
+//
+// Its errors are gRPC status errors, whose status.Code is codes.Aborted for a
+// run already in progress, codes.NotFound for an unknown migration,
+// codes.FailedPrecondition when Verify finds changed or missing files and
+// codes.Unauthenticated for a wrong token.
+type GRPCClient struct {
+	conn   *grpc.ClientConn
+	client rpc.MigratorClient
+}
+
+// NewGRPCClient creates a client of the service at a base URL such as
+// http://mig.internal:8080, over unencrypted HTTP/2 for http URLs and TLS for
+// https ones. It connects on the first call, Close releases the connection.
+func NewGRPCClient(target, token string) (*GRPCClient, error) {
+	conn, err := rpc.Dial(target, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GRPCClient{conn: conn, client: rpc.NewMigratorClient(conn)}, nil
+}
+
+// Close closes the connection of the client
+func (c *GRPCClient) Close() error {
+	return c.conn.Close()
+}
+
+// Plan returns the pending migrations in the order they would be applied
+func (c *GRPCClient) Plan(ctx context.Context) ([]PlannedMigration, error) {
+	resp, err := c.client.Plan(ctx, &rpc.PlanRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	return rpc.PlanOf(resp), nil
+}
+
+// Status returns the status of all migrations
+func (c *GRPCClient) Status(ctx context.Context) ([]MigrationStatus, error) {
+	resp, err := c.client.Status(ctx, &rpc.StatusRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	return rpc.StatusOf(resp), nil
+}
+
+// Verify checks that the files of the applied migrations did not change
+func (c *GRPCClient) Verify(ctx context.Context) error {
+	_, err := c.client.Verify(ctx, &rpc.VerifyRequest{})
+	return err
+}
+
+// Apply applies the migrations selected by req, every pending one when it is
+// empty, passing the events of the run to fn as they are streamed, and
+// returns the number of migrations applied
+func (c *GRPCClient) Apply(ctx context.Context, req ApplyRequest, fn func(Event)) (int, error) {
+	stream, err := c.client.Apply(ctx, &rpc.ApplyRequest{Id: req.ID, AllowOutOfOrder: req.AllowOutOfOrder, Phase: req.Phase})
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	for {
+		msg, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return applied, nil
+		}
+		if err != nil {
+			return applied, err
+		}
+
+		if msg.GetType() == string(rpc.EventResult) {
+			applied = int(msg.GetApplied())
+			continue
+		}
+
+		if fn != nil {
+			fn(rpc.EventOf(msg))
+		}
+	}
+}
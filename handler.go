@@ -0,0 +1,67 @@
+package mig
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// StatusReport is the body served by StatusHandler
+type StatusReport struct {
+	// Current is the ID of the latest applied migration, empty when none is
+	Current string `json:"current"`
+
+	// Pending is the number of migrations not applied yet
+	Pending int `json:"pending"`
+
+	// Dirty is set when the file of an applied migration changed since it was
+	// applied or is missing, so the schema may not match the migrations
+	Dirty bool `json:"dirty"`
+
+	// Missing lists the applied migrations whose file is gone
+	Missing []string `json:"missing,omitempty"`
+
+	// Ready is set when no migration is pending and the state is not dirty
+	Ready bool `json:"ready"`
+
+	// Error is the reason the status could not be read
+	Error string `json:"error,omitempty"`
+}
+
+// StatusHandler serves the migration state as JSON, for readiness probes. It
+// responds 200 when the schema is current and 503 when migrations are
+// pending, the state is dirty or the status cannot be read.
+func StatusHandler(m *Migrator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := StatusReport{}
+
+		status, err := m.StatusContext(r.Context())
+		if err != nil {
+			report.Error = err.Error()
+		}
+
+		for _, migration := range status {
+			switch {
+			case !migration.Applied:
+				report.Pending++
+			case migration.Missing:
+				report.Dirty = true
+				report.Missing = append(report.Missing, migration.ID)
+			case migration.Modified:
+				report.Dirty = true
+				report.Current = migration.ID
+			default:
+				report.Current = migration.ID
+			}
+		}
+		report.Ready = err == nil && report.Pending == 0 && !report.Dirty
+
+		code := http.StatusOK
+		if !report.Ready {
+			code = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(report) //nolint:errcheck
+	})
+}
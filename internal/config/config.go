@@ -1,94 +1,982 @@
 package config
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
 
+	"github.com/arthurdotwork/mig/internal/version"
 	"gopkg.in/yaml.v3"
 )
 
 const (
 	// DefaultMigrationsDir is the default name of the migrations directory
 	DefaultMigrationsDir = "migrations"
+
+	// DefaultDriver is the database driver used when none is configured
+	DefaultDriver = "postgres"
+
+	// DefaultApplicationName identifies mig sessions on the database server
+	DefaultApplicationName = "mig/" + version.Version
+)
+
+// driverAliases maps alternative driver spellings to their canonical name
+var driverAliases = map[string]string{
+	"postgresql": "postgres",
+	"mssql":      "sqlserver",
+}
+
+// schemeDrivers maps connection URL schemes to the driver handling them
+var schemeDrivers = map[string]string{
+	"postgres":   "postgres",
+	"postgresql": "postgres",
+	"sqlserver":  "sqlserver",
+	"clickhouse": "clickhouse",
+}
+
+// defaultPorts maps each known driver to the default port of its server
+var defaultPorts = map[string]int{
+	"postgres":   5432,
+	"pgx":        5432,
+	"sqlserver":  1433,
+	"clickhouse": 9000,
+}
+
+var (
+	driversMu sync.RWMutex
+	drivers   = make(map[string]bool)
+)
+
+// RegisterDriver makes a driver name valid in the configuration besides the
+// built-in ones, it is called for the dialects registered by the caller
+func RegisterDriver(name string) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+
+	drivers[strings.ToLower(name)] = true
+}
+
+// validateDriver checks that a dialect is registered under the driver name,
+// so a typo is not taken for a database without a default port
+func validateDriver(driver string) error {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+
+	if _, ok := defaultPorts[driver]; ok || drivers[driver] {
+		return nil
+	}
+
+	names := make([]string, 0, len(defaultPorts)+len(drivers))
+	for name := range defaultPorts {
+		names = append(names, name)
+	}
+	for name := range drivers {
+		if _, ok := defaultPorts[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return fmt.Errorf("unsupported database driver %q (available: %s)", driver, strings.Join(names, ", "))
+}
+
+// Policies for pending migrations older than the last applied one
+const (
+	OutOfOrderAllow = "allow"
+	OutOfOrderWarn  = "warn"
+	OutOfOrderFail  = "fail"
 )
 
 // DatabaseConfig represents the configuration for the database connection
 type DatabaseConfig struct {
+	Driver   string `yaml:"driver"`
+	URL      string `yaml:"url,omitempty"`
 	Host     string `yaml:"host"`
 	Port     int    `yaml:"port"`
 	Name     string `yaml:"name"`
 	User     string `yaml:"user"`
 	Password string `yaml:"password"`
 	SSLMode  string `yaml:"sslmode"`
+
+	// PasswordFrom references a secret holding the password, e.g. "vault:secret/data/db#password"
+	PasswordFrom string `yaml:"password_from,omitempty"`
+
+	// PasswordFile is a file holding the password, e.g. a mounted Docker or Kubernetes secret
+	PasswordFile string `yaml:"password_file,omitempty"`
+
+	// TLS client certificate, private key (and its passphrase) and CA bundle
+	SSLCert     string `yaml:"sslcert,omitempty"`
+	SSLKey      string `yaml:"sslkey,omitempty"`
+	SSLPassword string `yaml:"sslpassword,omitempty"`
+	SSLRootCert string `yaml:"sslrootcert,omitempty"`
+
+	// ConnectTimeout is the maximum wait for a connection, in seconds (0 waits forever)
+	ConnectTimeout int `yaml:"connect_timeout,omitempty"`
+
+	// ApplicationName identifies mig sessions on the server, e.g. in pg_stat_activity
+	ApplicationName string `yaml:"application_name,omitempty"`
+
+	// Connection pool tuning, zero values keep the database/sql defaults
+	MaxOpenConns    int           `yaml:"max_open_conns,omitempty"`
+	MaxIdleConns    int           `yaml:"max_idle_conns,omitempty"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime,omitempty"`
+
+	// UsePGEnv falls back to the PG* environment variables and ~/.pgpass for
+	// settings that are not configured, like libpq does
+	UsePGEnv bool `yaml:"use_pg_env,omitempty"`
+
+	// Schema is the search_path of the migration sessions, holding the mig
+	// tables and the unqualified objects of the migrations, "public" when empty
+	Schema string `yaml:"schema,omitempty"`
+
+	// PgBouncer avoids prepared statements and session state, which do not
+	// survive PgBouncer's transaction pooling
+	PgBouncer bool `yaml:"pgbouncer,omitempty"`
+
+	// Params are extra driver connection parameters passed through as-is
+	Params map[string]string `yaml:"params,omitempty"`
+
+	// CreateIfMissing creates the database when it does not exist yet, from
+	// a connection to the maintenance database of the server
+	CreateIfMissing bool `yaml:"create_if_missing,omitempty"`
+
+	// Create holds the settings of the database created by CreateIfMissing
+	Create CreateDatabaseConfig `yaml:"create,omitempty"`
+}
+
+// CreateDatabaseConfig holds the settings of a database created when missing,
+// the server defaults apply to those left empty
+type CreateDatabaseConfig struct {
+	Owner    string `yaml:"owner,omitempty"`
+	Encoding string `yaml:"encoding,omitempty"`
+
+	// MaintenanceDatabase is connected to for creating the database, e.g.
+	// "postgres" for PostgreSQL
+	MaintenanceDatabase string `yaml:"maintenance_database,omitempty"`
+}
+
+// IsSocket reports whether the host is a Unix domain socket directory
+func (d DatabaseConfig) IsSocket() bool {
+	return strings.HasPrefix(d.Host, "/")
+}
+
+// HasPassword reports whether any password source is configured
+func (d DatabaseConfig) HasPassword() bool {
+	if d.Password != "" || d.PasswordFrom != "" || d.PasswordFile != "" {
+		return true
+	}
+
+	if d.URL != "" {
+		if u, err := url.Parse(d.URL); err == nil && u.User != nil {
+			_, ok := u.User.Password()
+			return ok
+		}
+	}
+
+	return false
 }
 
 // MigrationsConfig represents the configuration for migrations
 type MigrationsConfig struct {
 	Directory string `yaml:"directory"`
+
+	// ExtraDirectories hold migrations merged with those of Directory, e.g.
+	// seeds that only a staging target applies
+	ExtraDirectories []string `yaml:"extra_directories,omitempty"`
+
+	// Timeout bounds the execution of each migration (0 waits forever)
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+
+	// OutOfOrder is the policy for pending migrations older than the last
+	// applied one: OutOfOrderAllow (the default), OutOfOrderWarn or OutOfOrderFail
+	OutOfOrder string `yaml:"out_of_order,omitempty"`
+
+	// DefaultTx runs the migrations inside a transaction unless they opt out
+	// with "-- disable-tx", when false they opt in with "-- mig:tx" instead
+	DefaultTx *bool `yaml:"default_tx,omitempty"`
+
+	// AutoDisableTx runs the migrations holding statements that cannot run
+	// inside a transaction, such as CREATE INDEX CONCURRENTLY or VACUUM,
+	// outside of one without "-- disable-tx"
+	AutoDisableTx bool `yaml:"auto_disable_tx,omitempty"`
+
+	// Analyze runs ANALYZE on the tables each migration writes to once it is
+	// applied, so the planner does not wait for the next scheduled run to see
+	// the backfilled rows
+	Analyze bool `yaml:"analyze,omitempty"`
+
+	// After holds maintenance statements run after each migration is applied,
+	// outside of its transaction
+	After []string `yaml:"after,omitempty"`
+
+	// SchemaFile receives a normalized dump of the schema after the CLI
+	// applies migrations, committed so reviews show their net effect
+	SchemaFile string `yaml:"schema_file,omitempty"`
+
+	// BaselineVersion adopts an existing database: when it holds tables but
+	// no mig_versions table yet, the migrations up to this ID are recorded as
+	// applied without running them
+	BaselineVersion string `yaml:"baseline_version,omitempty"`
+
+	// FS holds the migrations instead of Directory when set, such as an
+	// embed.FS compiled into the application
+	FS fs.FS `yaml:"-"`
+}
+
+// TransactionsByDefault reports whether migrations run inside a transaction
+// unless they opt out, which is the default
+func (m MigrationsConfig) TransactionsByDefault() bool {
+	return m.DefaultTx == nil || *m.DefaultTx
+}
+
+// TenantsConfig lists the schemas migrated one after the other when each
+// tenant has its own schema in a single database
+type TenantsConfig struct {
+	// Schemas is a static list of tenant schemas
+	Schemas []string `yaml:"schemas,omitempty"`
+
+	// Pattern is a LIKE pattern matched against the existing schema names
+	Pattern string `yaml:"pattern,omitempty"`
+
+	// Query is a SQL query returning the tenant schemas in a single column
+	Query string `yaml:"query,omitempty"`
+}
+
+// Enabled reports whether any tenant source is configured
+func (t TenantsConfig) Enabled() bool {
+	return len(t.Schemas) > 0 || t.Pattern != "" || t.Query != ""
+}
+
+// Severities of the lint rules
+const (
+	SeverityError = "error"
+	SeverityWarn  = "warn"
+	SeverityOff   = "off"
+)
+
+// LintConfig selects the severity of the safety rules checked on migrations,
+// targets can tighten or relax them, e.g. forbid dropping columns in production
+type LintConfig struct {
+	// Rules maps rule names to their severity: error, warn or off
+	Rules map[string]string `yaml:"rules,omitempty"`
+}
+
+// EnvironmentConfig describes the environment a target migrates
+type EnvironmentConfig struct {
+	// Protected asks for a confirmation before the CLI changes the database,
+	// such as a production one
+	Protected bool `yaml:"protected,omitempty"`
+}
+
+// Defaults of the backup taken before migrations run
+const (
+	DefaultBackupPath     = "backups/{{.Database}}_{{.Timestamp}}.dump"
+	DefaultBackupCommand  = "pg_dump"
+	DefaultRestoreCommand = "pg_restore"
+)
+
+// BackupConfig dumps the database with pg_dump before migrations are
+// applied, for teams without managed snapshots
+type BackupConfig struct {
+	// Enabled takes a backup before applying pending migrations, a failed
+	// backup aborts the run
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// Path is the file the dump is written to in the custom format, as a
+	// template of .Database, .Target, .Tenant and .Timestamp
+	Path string `yaml:"path,omitempty"`
+
+	// Command is the pg_dump executable, looked up in the PATH by default
+	Command string `yaml:"command,omitempty"`
+
+	// RestoreOnFailure restores the backup when a migration running outside
+	// of a transaction fails, since its partial changes cannot be rolled back
+	RestoreOnFailure bool `yaml:"restore_on_failure,omitempty"`
+
+	// RestoreCommand is the pg_restore executable, looked up in the PATH by
+	// default
+	RestoreCommand string `yaml:"restore_command,omitempty"`
+}
+
+// AccessConfig lists the checks run after each migration, before it is
+// recorded, to fail the run when the application roles lost access to the
+// objects they use
+type AccessConfig struct {
+	// Grants are the privileges the roles must keep
+	Grants []GrantConfig `yaml:"grants,omitempty"`
+
+	// Queries return a row per problem, described by the first column, and
+	// nothing when access is intact
+	Queries []string `yaml:"queries,omitempty"`
+}
+
+// GrantConfig is a set of privileges a role must hold on the tables of a schema
+type GrantConfig struct {
+	Role string `yaml:"role"`
+
+	// Privileges are table privileges such as SELECT, INSERT, UPDATE or DELETE
+	Privileges []string `yaml:"privileges"`
+
+	// Schema holds the tables, the schema migrations run in by default
+	Schema string `yaml:"schema,omitempty"`
+
+	// Tables restricts the check to these tables, every table of the schema
+	// by default
+	Tables []string `yaml:"tables,omitempty"`
+}
+
+// DefaultStatsDPrefix is prepended to the names of the StatsD metrics
+const DefaultStatsDPrefix = "mig."
+
+// StatsDConfig sends metrics of the runs to a StatsD or DogStatsD agent, for
+// short-lived jobs no Prometheus scrapes
+type StatsDConfig struct {
+	// Address is the host:port of the agent, e.g. localhost:8125, metrics
+	// are only sent when it is set
+	Address string `yaml:"address,omitempty"`
+
+	// Prefix is prepended to the metric names, "mig." by default
+	Prefix string `yaml:"prefix,omitempty"`
+
+	// Tags are added to every metric in the DogStatsD format, e.g. the
+	// environment of the target
+	Tags map[string]string `yaml:"tags,omitempty"`
+}
+
+// Events of a migration run sent to the notification endpoints
+const (
+	NotifyStarted   = "started"
+	NotifySucceeded = "succeeded"
+	NotifyFailed    = "failed"
+)
+
+// notifyEvents are the events a NotificationConfig can select
+var notifyEvents = []string{NotifyStarted, NotifySucceeded, NotifyFailed}
+
+// NotificationConfig posts the runs applying migrations to a Slack channel
+// or an HTTP endpoint, such as an on-call channel for production
+type NotificationConfig struct {
+	// Slack is the URL of a Slack incoming webhook
+	Slack string `yaml:"slack,omitempty"`
+
+	// URL is an HTTP endpoint receiving a POST of the run
+	URL string `yaml:"url,omitempty"`
+
+	// Template renders the body posted to URL as a text/template of the
+	// run, the run as JSON by default
+	Template string `yaml:"template,omitempty"`
+
+	// Headers are added to the requests to URL, e.g. an Authorization header
+	Headers map[string]string `yaml:"headers,omitempty"`
+
+	// Events restricts the notifications to started, succeeded or failed
+	// runs, all of them by default
+	Events []string `yaml:"events,omitempty"`
+}
+
+// Notifies reports whether the endpoint is notified of an event
+func (n NotificationConfig) Notifies(event string) bool {
+	return len(n.Events) == 0 || slices.Contains(n.Events, event)
+}
+
+// notificationFuncs are the functions of the notification templates, json
+// encodes a value so it can be embedded in a JSON body
+var notificationFuncs = template.FuncMap{
+	"json": func(value any) (string, error) {
+		encoded, err := json.Marshal(value)
+		return string(encoded), err
+	},
+}
+
+// Body renders the template of the notification for a run, or encodes the
+// run as JSON without a template
+func (n NotificationConfig) Body(run any) ([]byte, error) {
+	if n.Template == "" {
+		return json.Marshal(run)
+	}
+
+	tmpl, err := template.New("notification").Funcs(notificationFuncs).Option("missingkey=error").Parse(n.Template)
+	if err != nil {
+		return nil, fmt.Errorf("invalid notification template: %w", err)
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, run); err != nil {
+		return nil, fmt.Errorf("failed to render the notification template: %w", err)
+	}
+
+	return []byte(b.String()), nil
+}
+
+// tablePrivileges are the privileges a GrantConfig can require
+var tablePrivileges = []string{"SELECT", "INSERT", "UPDATE", "DELETE", "TRUNCATE", "REFERENCES", "TRIGGER"}
+
+// Formats of the logs
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// LoggingConfig selects how the CLI logs, it is read from the top-level
+// settings only since the logger is set up before a target is selected
+type LoggingConfig struct {
+	// Format is LogFormatText (the default) or LogFormatJSON
+	Format string `yaml:"format,omitempty"`
+
+	// Level is the minimum level logged: debug, info (the default), warn or error
+	Level string `yaml:"level,omitempty"`
+
+	// File receives the logs instead of the standard error when set, they are
+	// appended to it
+	File string `yaml:"file,omitempty"`
+}
+
+// AuditConfig keeps a trail of the CLI commands independent of the database,
+// it is read from the top-level settings only like LoggingConfig
+type AuditConfig struct {
+	// File receives a JSON record of every command, appended to it
+	File string `yaml:"file,omitempty"`
+}
+
+// TimeoutsConfig bounds the phases of a run, 0 waits forever
+type TimeoutsConfig struct {
+	// Connect bounds the wait for a connection, database.connect_timeout takes
+	// precedence
+	Connect time.Duration `yaml:"connect,omitempty"`
+
+	// Statement bounds the execution of each migration, migrations.timeout
+	// takes precedence
+	Statement time.Duration `yaml:"statement,omitempty"`
+
+	// Lock bounds the wait for the migration lock held by another runner
+	Lock time.Duration `yaml:"lock,omitempty"`
+
+	// Run bounds a whole run, from waiting for the lock to applying the last
+	// migration
+	Run time.Duration `yaml:"run,omitempty"`
 }
 
 // Config represents the configuration for the migrator
 type Config struct {
-	Database   DatabaseConfig   `yaml:"database"`
-	Migrations MigrationsConfig `yaml:"migrations"`
+	Database    DatabaseConfig    `yaml:"database"`
+	Migrations  MigrationsConfig  `yaml:"migrations"`
+	Tenants     TenantsConfig     `yaml:"tenants,omitempty"`
+	Lint        LintConfig        `yaml:"lint,omitempty"`
+	Timeouts    TimeoutsConfig    `yaml:"timeouts,omitempty"`
+	Logging     LoggingConfig     `yaml:"logging,omitempty"`
+	Audit       AuditConfig       `yaml:"audit,omitempty"`
+	Environment EnvironmentConfig `yaml:"environment,omitempty"`
+	Backup      BackupConfig      `yaml:"backup,omitempty"`
+	Access      AccessConfig      `yaml:"access,omitempty"`
+
+	// Notifications are the endpoints told about the runs applying migrations
+	Notifications []NotificationConfig `yaml:"notifications,omitempty"`
+
+	StatsD StatsDConfig `yaml:"statsd,omitempty"`
+
+	// DefaultTarget is the target used when none is selected
+	DefaultTarget string `yaml:"default_target,omitempty"`
+
+	// Targets are named databases, each with database and migrations sections
+	// overriding the top-level settings
+	Targets map[string]yaml.Node `yaml:"targets,omitempty"`
+
+	// Target is the name of the selected target, empty for the top-level settings
+	Target string `yaml:"-"`
+
+	// Tenant is the schema migrations run in, empty for the default search path
+	Tenant string `yaml:"-"`
+
+	// AgeKeyFile is the age identity decrypting SOPS-encrypted configuration
+	// files, it is read from the overrides before the files are
+	AgeKeyFile string `yaml:"-"`
+
+	// Cache shares the documents read between loads, it is read from the
+	// overrides before the files are
+	Cache *Cache `yaml:"-"`
+
+	// BaseDir is the directory the relative migrations directories and schema
+	// file resolve in, the working directory when empty
+	BaseDir string `yaml:"-"`
+
+	// UnknownKeys lists the keys of the configuration files that match no
+	// setting, as "file:line: key", which fail loading unless Lenient is set
+	UnknownKeys []string `yaml:"-"`
+
+	// Lenient tolerates unknown keys, leaving the caller to warn about them
+	Lenient bool `yaml:"-"`
 }
 
+// Override adjusts a loaded configuration before it is validated, it is used
+// to apply command-line flags on top of the file and environment
+type Override func(*Config)
+
 // Load loads the configuration from the specified file
-func Load(path string) (*Config, error) {
-	data, err := os.ReadFile(path)
+func Load(path string, overrides ...Override) (*Config, error) {
+	return LoadTarget(path, "", overrides...)
+}
+
+// LoadTarget loads the configuration from the specified file with the
+// settings of the named target applied, or of the default target when the
+// name is empty
+func LoadTarget(path, target string, overrides ...Override) (*Config, error) {
+	config, err := parse(path, overrides)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
+		return nil, err
 	}
 
-	var config Config
-	if err := yaml.Unmarshal(data, &config); err != nil {
-		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	if envDefaultTarget := lookupEnv("DEFAULT_TARGET"); envDefaultTarget != "" {
+		config.DefaultTarget = envDefaultTarget
 	}
 
-	// Apply environment variable overrides for sensitive fields
-	if envHost := os.Getenv("DATABASE_HOST"); envHost != "" {
+	if target == "" {
+		target = config.DefaultTarget
+	}
+
+	if target != "" {
+		node, ok := config.Targets[target]
+		if !ok {
+			return nil, fmt.Errorf("unknown target %q (available: %s)", target, strings.Join(config.TargetNames(), ", "))
+		}
+
+		// Decoding on top of the parsed file only replaces the keys the target sets
+		if err := node.Decode(config); err != nil {
+			return nil, fmt.Errorf("failed to parse target %q: %w", target, err)
+		}
+		config.Target = target
+	}
+
+	// Apply the environment variable overrides
+	if envDriver := lookupEnv("DATABASE_DRIVER"); envDriver != "" {
+		config.Database.Driver = envDriver
+	}
+
+	if envURL := lookupEnv("DATABASE_URL"); envURL != "" {
+		config.Database.URL = envURL
+	}
+
+	if envHost := lookupEnv("DATABASE_HOST"); envHost != "" {
 		config.Database.Host = envHost
 	}
 
-	if envPort := os.Getenv("DATABASE_PORT"); envPort != "" {
+	if envPort := lookupEnv("DATABASE_PORT"); envPort != "" {
 		var port int
 		if _, err := fmt.Sscanf(envPort, "%d", &port); err == nil {
 			config.Database.Port = port
 		}
 	}
 
-	if envName := os.Getenv("DATABASE_NAME"); envName != "" {
+	if envName := lookupEnv("DATABASE_NAME"); envName != "" {
 		config.Database.Name = envName
 	}
 
-	if envUser := os.Getenv("DATABASE_USER"); envUser != "" {
+	if envUser := lookupEnv("DATABASE_USER"); envUser != "" {
 		config.Database.User = envUser
 	}
 
-	if envPassword := os.Getenv("DATABASE_PASSWORD"); envPassword != "" {
+	if envPassword := lookupEnv("DATABASE_PASSWORD"); envPassword != "" {
 		config.Database.Password = envPassword
 	}
 
-	if envSSLMode := os.Getenv("DATABASE_SSLMODE"); envSSLMode != "" {
+	if envPasswordFrom := lookupEnv("DATABASE_PASSWORD_FROM"); envPasswordFrom != "" {
+		config.Database.PasswordFrom = envPasswordFrom
+	}
+
+	if envPasswordFile := lookupEnv("DATABASE_PASSWORD_FILE"); envPasswordFile != "" {
+		config.Database.PasswordFile = envPasswordFile
+	}
+
+	if envSchema := lookupEnv("DATABASE_SCHEMA"); envSchema != "" {
+		config.Database.Schema = envSchema
+	}
+
+	if envSSLMode := lookupEnv("DATABASE_SSLMODE"); envSSLMode != "" {
 		config.Database.SSLMode = envSSLMode
 	}
 
+	if envConnectTimeout := lookupEnv("DATABASE_CONNECT_TIMEOUT"); envConnectTimeout != "" {
+		var timeout int
+		if _, err := fmt.Sscanf(envConnectTimeout, "%d", &timeout); err == nil {
+			config.Database.ConnectTimeout = timeout
+		}
+	}
+
+	if envApplicationName := lookupEnv("DATABASE_APPLICATION_NAME"); envApplicationName != "" {
+		config.Database.ApplicationName = envApplicationName
+	}
+
+	if envMaxOpenConns := lookupEnv("DATABASE_MAX_OPEN_CONNS"); envMaxOpenConns != "" {
+		var maxOpenConns int
+		if _, err := fmt.Sscanf(envMaxOpenConns, "%d", &maxOpenConns); err == nil {
+			config.Database.MaxOpenConns = maxOpenConns
+		}
+	}
+
+	if envMaxIdleConns := lookupEnv("DATABASE_MAX_IDLE_CONNS"); envMaxIdleConns != "" {
+		var maxIdleConns int
+		if _, err := fmt.Sscanf(envMaxIdleConns, "%d", &maxIdleConns); err == nil {
+			config.Database.MaxIdleConns = maxIdleConns
+		}
+	}
+
+	if envConnMaxLifetime := lookupEnv("DATABASE_CONN_MAX_LIFETIME"); envConnMaxLifetime != "" {
+		if lifetime, err := time.ParseDuration(envConnMaxLifetime); err == nil {
+			config.Database.ConnMaxLifetime = lifetime
+		}
+	}
+
+	if envSSLCert := lookupEnv("DATABASE_SSLCERT"); envSSLCert != "" {
+		config.Database.SSLCert = envSSLCert
+	}
+
+	if envSSLKey := lookupEnv("DATABASE_SSLKEY"); envSSLKey != "" {
+		config.Database.SSLKey = envSSLKey
+	}
+
+	if envSSLPassword := lookupEnv("DATABASE_SSLPASSWORD"); envSSLPassword != "" {
+		config.Database.SSLPassword = envSSLPassword
+	}
+
+	if envSSLRootCert := lookupEnv("DATABASE_SSLROOTCERT"); envSSLRootCert != "" {
+		config.Database.SSLRootCert = envSSLRootCert
+	}
+
+	if envUsePGEnv := lookupEnv("DATABASE_USE_PG_ENV"); envUsePGEnv != "" {
+		if usePGEnv, err := strconv.ParseBool(envUsePGEnv); err == nil {
+			config.Database.UsePGEnv = usePGEnv
+		}
+	}
+
+	if envCreateIfMissing := lookupEnv("DATABASE_CREATE_IF_MISSING"); envCreateIfMissing != "" {
+		if createIfMissing, err := strconv.ParseBool(envCreateIfMissing); err == nil {
+			config.Database.CreateIfMissing = createIfMissing
+		}
+	}
+
+	if envPgBouncer := lookupEnv("DATABASE_PGBOUNCER"); envPgBouncer != "" {
+		if pgBouncer, err := strconv.ParseBool(envPgBouncer); err == nil {
+			config.Database.PgBouncer = pgBouncer
+		}
+	}
+
+	if envParams := lookupEnv("DATABASE_PARAMS"); envParams != "" {
+		config.Database.Params = make(map[string]string)
+		for _, param := range strings.Split(envParams, ",") {
+			if key, value, ok := strings.Cut(param, "="); ok {
+				config.Database.Params[strings.TrimSpace(key)] = strings.TrimSpace(value)
+			}
+		}
+	}
+
+	if envDirectory := lookupEnv("MIGRATIONS_DIRECTORY"); envDirectory != "" {
+		config.Migrations.Directory = envDirectory
+	}
+
+	if envExtraDirectories := lookupEnv("MIGRATIONS_EXTRA_DIRECTORIES"); envExtraDirectories != "" {
+		config.Migrations.ExtraDirectories = nil
+		for _, directory := range strings.Split(envExtraDirectories, ",") {
+			if directory = strings.TrimSpace(directory); directory != "" {
+				config.Migrations.ExtraDirectories = append(config.Migrations.ExtraDirectories, directory)
+			}
+		}
+	}
+
+	if envTimeout := lookupEnv("MIGRATIONS_TIMEOUT"); envTimeout != "" {
+		if timeout, err := time.ParseDuration(envTimeout); err == nil {
+			config.Migrations.Timeout = timeout
+		}
+	}
+
+	applyLoggingEnv(config)
+
+	for name, timeout := range map[string]*time.Duration{
+		"TIMEOUTS_CONNECT":   &config.Timeouts.Connect,
+		"TIMEOUTS_STATEMENT": &config.Timeouts.Statement,
+		"TIMEOUTS_LOCK":      &config.Timeouts.Lock,
+		"TIMEOUTS_RUN":       &config.Timeouts.Run,
+	} {
+		if envTimeout := lookupEnv(name); envTimeout != "" {
+			if d, err := time.ParseDuration(envTimeout); err == nil {
+				*timeout = d
+			}
+		}
+	}
+
+	if envOutOfOrder := lookupEnv("MIGRATIONS_OUT_OF_ORDER"); envOutOfOrder != "" {
+		config.Migrations.OutOfOrder = envOutOfOrder
+	}
+
+	if envDefaultTx := lookupEnv("MIGRATIONS_DEFAULT_TX"); envDefaultTx != "" {
+		if defaultTx, err := strconv.ParseBool(envDefaultTx); err == nil {
+			config.Migrations.DefaultTx = &defaultTx
+		}
+	}
+
+	if envAutoDisableTx := lookupEnv("MIGRATIONS_AUTO_DISABLE_TX"); envAutoDisableTx != "" {
+		if autoDisableTx, err := strconv.ParseBool(envAutoDisableTx); err == nil {
+			config.Migrations.AutoDisableTx = autoDisableTx
+		}
+	}
+
+	if envAnalyze := lookupEnv("MIGRATIONS_ANALYZE"); envAnalyze != "" {
+		if analyze, err := strconv.ParseBool(envAnalyze); err == nil {
+			config.Migrations.Analyze = analyze
+		}
+	}
+
+	if envSchemaFile := lookupEnv("MIGRATIONS_SCHEMA_FILE"); envSchemaFile != "" {
+		config.Migrations.SchemaFile = envSchemaFile
+	}
+
+	if envBaseline := lookupEnv("MIGRATIONS_BASELINE_VERSION"); envBaseline != "" {
+		config.Migrations.BaselineVersion = envBaseline
+	}
+
+	if envProtected := lookupEnv("ENVIRONMENT_PROTECTED"); envProtected != "" {
+		if protected, err := strconv.ParseBool(envProtected); err == nil {
+			config.Environment.Protected = protected
+		}
+	}
+
+	if envBackup := lookupEnv("BACKUP_ENABLED"); envBackup != "" {
+		if enabled, err := strconv.ParseBool(envBackup); err == nil {
+			config.Backup.Enabled = enabled
+		}
+	}
+
+	if envBackupPath := lookupEnv("BACKUP_PATH"); envBackupPath != "" {
+		config.Backup.Path = envBackupPath
+	}
+
+	if envBackupCommand := lookupEnv("BACKUP_COMMAND"); envBackupCommand != "" {
+		config.Backup.Command = envBackupCommand
+	}
+
+	if envRestore := lookupEnv("BACKUP_RESTORE_ON_FAILURE"); envRestore != "" {
+		if restore, err := strconv.ParseBool(envRestore); err == nil {
+			config.Backup.RestoreOnFailure = restore
+		}
+	}
+
+	if envRestoreCommand := lookupEnv("BACKUP_RESTORE_COMMAND"); envRestoreCommand != "" {
+		config.Backup.RestoreCommand = envRestoreCommand
+	}
+
+	if envStatsD := lookupEnv("STATSD_ADDRESS"); envStatsD != "" {
+		config.StatsD.Address = envStatsD
+	}
+
+	if envSchemas := lookupEnv("TENANTS_SCHEMAS"); envSchemas != "" {
+		config.Tenants.Schemas = nil
+		for _, schema := range strings.Split(envSchemas, ",") {
+			if schema = strings.TrimSpace(schema); schema != "" {
+				config.Tenants.Schemas = append(config.Tenants.Schemas, schema)
+			}
+		}
+	}
+
+	if envPattern := lookupEnv("TENANTS_PATTERN"); envPattern != "" {
+		config.Tenants.Pattern = envPattern
+	}
+
+	if envQuery := lookupEnv("TENANTS_QUERY"); envQuery != "" {
+		config.Tenants.Query = envQuery
+	}
+
+	// Apply the caller overrides, which take precedence over the environment
+	for _, override := range overrides {
+		override(config)
+	}
+
+	if len(config.UnknownKeys) > 0 && !config.Lenient {
+		return nil, fmt.Errorf("unknown config keys: %s", strings.Join(config.UnknownKeys, ", "))
+	}
+
+	// Fall back to the libpq conventions for anything still unset
+	if config.Database.UsePGEnv && config.Database.URL == "" {
+		applyPGEnv(&config.Database)
+	}
+
 	// Validate the configuration
-	if err := Validate(&config); err != nil {
+	if err := Validate(config); err != nil {
+		return nil, err
+	}
+
+	if config.Database.UsePGEnv {
+		if err := applyPgpass(&config.Database); err != nil {
+			return nil, err
+		}
+	}
+
+	return config, nil
+}
+
+// Targets returns the sorted names of the targets defined in the specified file
+func Targets(path string, overrides ...Override) ([]string, error) {
+	config, err := parse(path, overrides)
+	if err != nil {
 		return nil, err
 	}
 
+	return config.TargetNames(), nil
+}
+
+// LoadLogging loads the logging settings from the specified file and the
+// environment, a missing file leaves the defaults
+func LoadLogging(path string, overrides ...Override) (LoggingConfig, error) {
+	config, err := parse(path, overrides)
+	if errors.Is(err, fs.ErrNotExist) {
+		config, err = &Config{}, nil
+	}
+	if err != nil {
+		return LoggingConfig{}, err
+	}
+
+	applyLoggingEnv(config)
+	for _, override := range overrides {
+		override(config)
+	}
+
+	if err := validateLogging(config); err != nil {
+		return LoggingConfig{}, err
+	}
+
+	return config.Logging, nil
+}
+
+// LoadAudit loads the audit settings from the specified file and the
+// environment, a missing file leaves the defaults
+func LoadAudit(path string, overrides ...Override) (AuditConfig, error) {
+	config, err := parse(path, overrides)
+	if errors.Is(err, fs.ErrNotExist) {
+		config, err = &Config{}, nil
+	}
+	if err != nil {
+		return AuditConfig{}, err
+	}
+
+	if envFile := lookupEnv("AUDIT_FILE"); envFile != "" {
+		config.Audit.File = envFile
+	}
+
+	return config.Audit, nil
+}
+
+// applyLoggingEnv applies the environment variables of the logging settings
+func applyLoggingEnv(config *Config) {
+	if envFormat := lookupEnv("LOGGING_FORMAT"); envFormat != "" {
+		config.Logging.Format = envFormat
+	}
+
+	if envLevel := lookupEnv("LOGGING_LEVEL"); envLevel != "" {
+		config.Logging.Level = envLevel
+	}
+
+	if envFile := lookupEnv("LOGGING_FILE"); envFile != "" {
+		config.Logging.File = envFile
+	}
+}
+
+// TargetNames returns the sorted names of the configured targets
+func (c *Config) TargetNames() []string {
+	names := make([]string, 0, len(c.Targets))
+	for name := range c.Targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// parse reads and decodes the configuration file and its includes without
+// applying anything, a missing file is an empty configuration when the
+// environment configures the database. Only the settings needed to read the
+// files are taken from the overrides.
+func parse(path string, overrides []Override) (*Config, error) {
+	var reading Config
+	for _, override := range overrides {
+		override(&reading)
+	}
+
+	if reading.AgeKeyFile == "" {
+		reading.AgeKeyFile = lookupEnv("AGE_KEY_FILE")
+	}
+
+	l := &loader{seen: make(map[string]bool), ageKeyFile: reading.AgeKeyFile}
+	var document *yaml.Node
+	var unknown []string
+	var err error
+	if reading.Cache != nil {
+		document, unknown, err = reading.Cache.read(l, path)
+	} else {
+		document, err = l.read(path)
+		unknown = l.unknown
+	}
+	if errors.Is(err, fs.ErrNotExist) && hasDatabaseEnv() {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	if document != nil {
+		if err := document.Decode(&config); err != nil {
+			return nil, fmt.Errorf("failed to parse config file: %w", err)
+		}
+	}
+	config.UnknownKeys = unknown
+
 	return &config, nil
 }
 
+// lookupEnv returns the value of the MIG_ prefixed environment variable
+// setting a configuration key, falling back to the unprefixed name for the
+// historical DATABASE_* variables
+func lookupEnv(name string) string {
+	if value := os.Getenv("MIG_" + name); value != "" {
+		return value
+	}
+
+	if strings.HasPrefix(name, "DATABASE_") {
+		return os.Getenv(name)
+	}
+
+	return ""
+}
+
+// hasDatabaseEnv reports whether any MIG_DATABASE_* or DATABASE_* environment
+// variable is set
+func hasDatabaseEnv() bool {
+	for _, env := range os.Environ() {
+		name, value, _ := strings.Cut(env, "=")
+		if value != "" && (strings.HasPrefix(name, "DATABASE_") || strings.HasPrefix(name, "MIG_DATABASE_")) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // CreateDefault creates a default configuration file
 func CreateDefault(path string) error {
 	// Create a default configuration
 	config := Config{}
 
 	// Set default database settings
+	config.Database.Driver = DefaultDriver
 	config.Database.Host = "localhost"
 	config.Database.Port = 5432
 	config.Database.Name = "postgres"
@@ -115,38 +1003,445 @@ func CreateDefault(path string) error {
 
 // Validate validates the configuration
 func Validate(config *Config) error {
-	if config.Database.Host == "" {
-		return errors.New("database host is required")
+	config.Database.Driver = normalizeDriver(config.Database.Driver)
+
+	if config.Database.URL != "" {
+		if err := validateURL(&config.Database); err != nil {
+			return err
+		}
 	}
 
-	if config.Database.Port == 0 {
-		config.Database.Port = 5432 // Default PostgreSQL port
+	if config.Database.Driver == "" {
+		config.Database.Driver = DefaultDriver
 	}
 
-	if config.Database.Name == "" {
-		return errors.New("database name is required")
+	if err := validateDriver(config.Database.Driver); err != nil {
+		return err
 	}
 
-	if config.Database.User == "" {
-		return errors.New("database user is required")
+	// A connection URL carries its own host, credentials and database name
+	if config.Database.URL == "" {
+		if config.Database.Host == "" {
+			return errors.New("database host is required")
+		}
+
+		if config.Database.Port == 0 {
+			config.Database.Port = defaultPorts[config.Database.Driver]
+		}
+
+		if config.Database.Name == "" {
+			return errors.New("database name is required")
+		}
+
+		// Peer authentication over a Unix socket uses the operating system user
+		if config.Database.User == "" && !config.Database.IsSocket() {
+			return errors.New("database user is required")
+		}
 	}
 
 	if config.Database.SSLMode == "" {
 		config.Database.SSLMode = "disable" // Default SSL mode
 	}
 
+	if config.Database.ApplicationName == "" {
+		config.Database.ApplicationName = DefaultApplicationName
+	}
+
+	if config.Database.ConnectTimeout < 0 {
+		return errors.New("database connect_timeout must not be negative")
+	}
+
+	if config.Database.PasswordFrom != "" && config.Database.PasswordFile != "" {
+		return errors.New("database password_from and password_file are mutually exclusive")
+	}
+
+	// The migration lock is held on its own connection while migrations run on another
+	if config.Database.MaxOpenConns == 1 {
+		return errors.New("database max_open_conns must be at least 2")
+	}
+
+	// lib/pq cannot decrypt private keys, pgx can
+	if config.Database.SSLPassword != "" && config.Database.Driver == "postgres" {
+		return errors.New("database sslpassword is not supported by the postgres driver, use driver: pgx")
+	}
+
+	// Tenants are selected through the PostgreSQL search_path
+	if (config.Tenants.Enabled() || config.Tenant != "") && config.Database.Driver != "postgres" && config.Database.Driver != "pgx" {
+		return fmt.Errorf("tenants are not supported by the %s driver", config.Database.Driver)
+	}
+
+	if config.Database.Schema != "" && config.Database.Driver != "postgres" && config.Database.Driver != "pgx" {
+		return fmt.Errorf("database schema is not supported by the %s driver", config.Database.Driver)
+	}
+
+	if config.Database.PgBouncer {
+		if config.Database.Driver != "postgres" && config.Database.Driver != "pgx" {
+			return fmt.Errorf("database pgbouncer is not supported by the %s driver", config.Database.Driver)
+		}
+
+		// PgBouncer rejects the search_path startup parameter tenants rely on
+		if config.Tenants.Enabled() || config.Tenant != "" {
+			return errors.New("tenants are not supported in pgbouncer mode")
+		}
+
+		if config.Database.Schema != "" {
+			return errors.New("database schema is not supported in pgbouncer mode")
+		}
+
+		// Only the transaction of a migration holds the lock in pgbouncer mode
+		if !config.Migrations.TransactionsByDefault() {
+			return errors.New("migrations default_tx false is not supported in pgbouncer mode")
+		}
+	}
+
+	if err := validateLint(config); err != nil {
+		return err
+	}
+
+	if err := validateTimeouts(config); err != nil {
+		return err
+	}
+
+	if err := validateLogging(config); err != nil {
+		return err
+	}
+
+	if err := validateBackup(config); err != nil {
+		return err
+	}
+
+	if err := validateAccess(config); err != nil {
+		return err
+	}
+
+	if err := validateNotifications(config); err != nil {
+		return err
+	}
+
+	if err := validateStatsD(config); err != nil {
+		return err
+	}
+
+	return validateMigrations(config)
+}
+
+// ForDB builds the configuration of a migrator running on a connection opened
+// by the caller, so only the settings unrelated to connecting apply
+func ForDB(overrides ...Override) (*Config, error) {
+	config := &Config{}
+	for _, override := range overrides {
+		override(config)
+	}
+
+	config.Database.Driver = normalizeDriver(config.Database.Driver)
+	if config.Database.Driver == "" {
+		config.Database.Driver = DefaultDriver
+	}
+
+	if err := validateLint(config); err != nil {
+		return nil, err
+	}
+
+	if err := validateTimeouts(config); err != nil {
+		return nil, err
+	}
+
+	if err := validateMigrations(config); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// normalizeDriver lowercases a driver name and resolves its aliases
+func normalizeDriver(driver string) string {
+	driver = strings.ToLower(driver)
+	if alias, ok := driverAliases[driver]; ok {
+		return alias
+	}
+
+	return driver
+}
+
+// validateLint checks the severities of the lint rules
+func validateLint(config *Config) error {
+	for rule, severity := range config.Lint.Rules {
+		switch severity {
+		case SeverityError, SeverityWarn, SeverityOff:
+		default:
+			return fmt.Errorf("invalid severity %q for lint rule %s, expected error, warn or off", severity, rule)
+		}
+	}
+
+	return nil
+}
+
+// validateLogging checks the log format and level, and defaults them
+func validateLogging(config *Config) error {
+	config.Logging.Format = strings.ToLower(config.Logging.Format)
+	switch config.Logging.Format {
+	case "":
+		config.Logging.Format = LogFormatText
+	case LogFormatText, LogFormatJSON:
+	default:
+		return fmt.Errorf("invalid logging format %q, expected text or json", config.Logging.Format)
+	}
+
+	config.Logging.Level = strings.ToLower(config.Logging.Level)
+	switch config.Logging.Level {
+	case "":
+		config.Logging.Level = "info"
+	case "debug", "info", "warn", "error":
+	default:
+		return fmt.Errorf("invalid logging level %q, expected debug, info, warn or error", config.Logging.Level)
+	}
+
+	return nil
+}
+
+// validateAccess checks the privileges of the access grants and normalizes
+// them to upper case
+func validateAccess(config *Config) error {
+	if len(config.Access.Grants) > 0 && config.Database.Driver != "postgres" && config.Database.Driver != "pgx" {
+		return fmt.Errorf("access grants are not supported by the %s driver", config.Database.Driver)
+	}
+
+	for i := range config.Access.Grants {
+		grant := &config.Access.Grants[i]
+		if grant.Role == "" {
+			return errors.New("access grants require a role")
+		}
+
+		if len(grant.Privileges) == 0 {
+			return fmt.Errorf("access grants of role %s require privileges", grant.Role)
+		}
+
+		for j, privilege := range grant.Privileges {
+			grant.Privileges[j] = strings.ToUpper(privilege)
+			if !slices.Contains(tablePrivileges, grant.Privileges[j]) {
+				return fmt.Errorf("invalid access privilege %q of role %s, expected one of %s", privilege, grant.Role, strings.Join(tablePrivileges, ", "))
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateNotifications checks that each notification has a single endpoint,
+// a valid template and known events
+func validateNotifications(config *Config) error {
+	for i, notification := range config.Notifications {
+		if (notification.Slack == "") == (notification.URL == "") {
+			return fmt.Errorf("notification %d requires either a slack webhook or a url", i+1)
+		}
+
+		if notification.Template != "" {
+			if notification.Slack != "" {
+				return fmt.Errorf("notification %d: template only applies to url notifications", i+1)
+			}
+
+			if _, err := template.New("notification").Funcs(notificationFuncs).Parse(notification.Template); err != nil {
+				return fmt.Errorf("invalid template of notification %d: %w", i+1, err)
+			}
+		}
+
+		for _, event := range notification.Events {
+			if !slices.Contains(notifyEvents, event) {
+				return fmt.Errorf("invalid event %q of notification %d, expected one of %s", event, i+1, strings.Join(notifyEvents, ", "))
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateStatsD checks the address of the StatsD agent and defaults the
+// prefix of the metrics
+func validateStatsD(config *Config) error {
+	if config.StatsD.Address == "" {
+		return nil
+	}
+
+	if _, _, err := net.SplitHostPort(config.StatsD.Address); err != nil {
+		return fmt.Errorf("invalid statsd address %q, expected host:port: %w", config.StatsD.Address, err)
+	}
+
+	if config.StatsD.Prefix == "" {
+		config.StatsD.Prefix = DefaultStatsDPrefix
+	}
+
+	return nil
+}
+
+// validateBackup checks the backup path template and defaults the backup
+// settings when backups are enabled
+func validateBackup(config *Config) error {
+	if !config.Backup.Enabled {
+		if config.Backup.RestoreOnFailure {
+			return errors.New("backup restore_on_failure requires backup enabled")
+		}
+		return nil
+	}
+
+	if config.Database.Driver != "postgres" && config.Database.Driver != "pgx" {
+		return fmt.Errorf("backup is not supported by the %s driver", config.Database.Driver)
+	}
+
+	if config.Backup.Path == "" {
+		config.Backup.Path = DefaultBackupPath
+	}
+
+	if config.Backup.Command == "" {
+		config.Backup.Command = DefaultBackupCommand
+	}
+
+	if config.Backup.RestoreCommand == "" {
+		config.Backup.RestoreCommand = DefaultRestoreCommand
+	}
+
+	// Render the template once so unknown fields fail early
+	if _, err := config.BackupFile(time.Time{}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// BackupFile renders the backup path for a backup taken at the given time
+func (c *Config) BackupFile(at time.Time) (string, error) {
+	tmpl, err := template.New("backup").Option("missingkey=error").Parse(c.Backup.Path)
+	if err != nil {
+		return "", fmt.Errorf("invalid backup path: %w", err)
+	}
+
+	name := c.Database.Name
+	if c.Database.URL != "" {
+		if u, err := url.Parse(c.Database.URL); err == nil {
+			name = strings.TrimPrefix(u.Path, "/")
+		}
+	}
+
+	var b strings.Builder
+	err = tmpl.Execute(&b, map[string]string{
+		"Database":  name,
+		"Target":    c.Target,
+		"Tenant":    c.Tenant,
+		"Timestamp": at.UTC().Format("2006_01_02_15_04_05"),
+	})
+	if err != nil {
+		return "", fmt.Errorf("invalid backup path: %w", err)
+	}
+
+	return b.String(), nil
+}
+
+// validateTimeouts checks the timeouts and defaults the connect and
+// per-migration timeouts to them
+func validateTimeouts(config *Config) error {
+	timeouts := config.Timeouts
+	if timeouts.Connect < 0 || timeouts.Statement < 0 || timeouts.Lock < 0 || timeouts.Run < 0 {
+		return errors.New("timeouts must not be negative")
+	}
+
+	// connect_timeout is in seconds, round up so a sub-second timeout still applies
+	if config.Database.ConnectTimeout == 0 && timeouts.Connect > 0 {
+		config.Database.ConnectTimeout = int((timeouts.Connect + time.Second - 1) / time.Second)
+	}
+
+	if config.Migrations.Timeout == 0 {
+		config.Migrations.Timeout = timeouts.Statement
+	}
+
+	return nil
+}
+
+// validateMigrations defaults the migrations directory and makes it absolute
+func validateMigrations(config *Config) error {
+	if config.Migrations.Timeout < 0 {
+		return errors.New("migrations timeout must not be negative")
+	}
+
+	switch config.Migrations.OutOfOrder {
+	case "":
+		config.Migrations.OutOfOrder = OutOfOrderAllow
+	case OutOfOrderAllow, OutOfOrderWarn, OutOfOrderFail:
+	default:
+		return fmt.Errorf("invalid migrations out_of_order %q, expected allow, warn or fail", config.Migrations.OutOfOrder)
+	}
+
+	if config.Migrations.SchemaFile != "" && config.BaseDir != "" && !filepath.IsAbs(config.Migrations.SchemaFile) {
+		config.Migrations.SchemaFile = filepath.Join(config.BaseDir, config.Migrations.SchemaFile)
+	}
+
+	if config.Migrations.FS != nil {
+		return nil
+	}
+
 	if config.Migrations.Directory == "" {
 		config.Migrations.Directory = DefaultMigrationsDir
 	}
 
 	// Ensure the migrations directory path is absolute
 	if !filepath.IsAbs(config.Migrations.Directory) {
-		absPath, err := filepath.Abs(config.Migrations.Directory)
+		absPath, err := filepath.Abs(filepath.Join(config.BaseDir, config.Migrations.Directory))
 		if err != nil {
 			return fmt.Errorf("failed to get absolute path for migrations directory: %w", err)
 		}
 		config.Migrations.Directory = absPath
 	}
 
+	for i, directory := range config.Migrations.ExtraDirectories {
+		if !filepath.IsAbs(directory) {
+			absPath, err := filepath.Abs(filepath.Join(config.BaseDir, directory))
+			if err != nil {
+				return fmt.Errorf("failed to get absolute path for migrations directory: %w", err)
+			}
+			config.Migrations.ExtraDirectories[i] = absPath
+		}
+	}
+
+	return nil
+}
+
+// Find looks for the configuration file name in dir, then in its parent
+// directories up to the root of the repository holding dir, marked by a .git
+// entry, or of the filesystem
+func Find(dir, name string) (string, error) {
+	start, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	dir = start
+	for {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+
+		parent := filepath.Dir(dir)
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil || parent == dir {
+			return "", fmt.Errorf("%s not found in %s or its parent directories: %w", name, start, fs.ErrNotExist)
+		}
+		dir = parent
+	}
+}
+
+// validateURL checks the connection URL and infers the driver from its scheme
+func validateURL(db *DatabaseConfig) error {
+	u, err := url.Parse(db.URL)
+	if err != nil {
+		return fmt.Errorf("invalid database url: %w", err)
+	}
+
+	driver, ok := schemeDrivers[strings.ToLower(u.Scheme)]
+	if !ok {
+		return fmt.Errorf("invalid database url: unsupported scheme %q", u.Scheme)
+	}
+
+	if db.Driver == "" {
+		db.Driver = driver
+	}
+
 	return nil
 }
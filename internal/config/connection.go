@@ -0,0 +1,90 @@
+package config
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// SetHost sets the database host, in the connection URL as well when one is
+// configured so the host takes precedence over it
+func (d *DatabaseConfig) SetHost(host string) {
+	d.Host = host
+	d.editURL(func(u *url.URL) {
+		u.Host = joinHostPort(host, u.Port())
+	})
+}
+
+// SetPort sets the database port, in the connection URL as well when one is
+// configured
+func (d *DatabaseConfig) SetPort(port int) {
+	d.Port = port
+	d.editURL(func(u *url.URL) {
+		u.Host = joinHostPort(u.Hostname(), strconv.Itoa(port))
+	})
+}
+
+// SetName sets the database name, in the connection URL as well when one is
+// configured
+func (d *DatabaseConfig) SetName(name string) {
+	d.Name = name
+	d.editURL(func(u *url.URL) {
+		// SQL Server takes the database as a parameter, the others as the path
+		if schemeDrivers[strings.ToLower(u.Scheme)] == "sqlserver" {
+			query := u.Query()
+			query.Set("database", name)
+			u.RawQuery = query.Encode()
+			return
+		}
+
+		u.Path = "/" + name
+		u.RawPath = ""
+	})
+}
+
+// SetUser sets the database user, in the connection URL as well when one is
+// configured, keeping the password of the URL
+func (d *DatabaseConfig) SetUser(user string) {
+	d.User = user
+	d.editURL(func(u *url.URL) {
+		if u.User != nil {
+			if password, ok := u.User.Password(); ok {
+				u.User = url.UserPassword(user, password)
+				return
+			}
+		}
+
+		u.User = url.User(user)
+	})
+}
+
+// editURL applies edit to the connection URL, an invalid URL is left as-is
+// for Validate to report
+func (d *DatabaseConfig) editURL(edit func(u *url.URL)) {
+	if d.URL == "" {
+		return
+	}
+
+	u, err := url.Parse(d.URL)
+	if err != nil {
+		return
+	}
+
+	edit(u)
+	d.URL = u.String()
+}
+
+// joinHostPort joins a host and an optional port into a URL host
+func joinHostPort(host, port string) string {
+	if port != "" {
+		return net.JoinHostPort(host, port)
+	}
+
+	// IPv6 addresses are bracketed even without a port
+	if strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+
+	return host
+}
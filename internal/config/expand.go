@@ -0,0 +1,50 @@
+package config
+
+import (
+	"os"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envReference matches ${VAR} and ${VAR:-default} references, and the $${
+// escape of a literal ${
+var envReference = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// expandEnv replaces the environment variable references in the scalar
+// values of the document, a reference to a variable that is unset or empty
+// is replaced with its default, or removed
+func expandEnv(node *yaml.Node) {
+	if node.Kind == yaml.ScalarNode {
+		expanded := expandString(node.Value)
+		if expanded != node.Value {
+			node.Value = expanded
+
+			// Resolve the type from the expanded value, so "port: ${DB_PORT}" is an int
+			if node.Style == 0 {
+				node.Tag = ""
+			}
+		}
+		return
+	}
+
+	for _, child := range node.Content {
+		expandEnv(child)
+	}
+}
+
+// expandString replaces the environment variable references in s
+func expandString(s string) string {
+	return envReference.ReplaceAllStringFunc(s, func(reference string) string {
+		if reference == "$${" {
+			return "${"
+		}
+
+		match := envReference.FindStringSubmatch(reference)
+		if value := os.Getenv(match[1]); value != "" {
+			return value
+		}
+
+		return match[2]
+	})
+}
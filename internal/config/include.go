@@ -0,0 +1,240 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// includeKey lists the files layered on top of the file declaring it
+const includeKey = "include"
+
+// StdinPath is the configuration path reading the configuration from the
+// standard input, e.g. generated by an orchestration system
+const StdinPath = "-"
+
+// readStdin reads the standard input once, since the configuration is loaded
+// for every target and tenant
+var readStdin = sync.OnceValues(func() ([]byte, error) {
+	return io.ReadAll(os.Stdin)
+})
+
+// Cache holds the configuration documents read by the loads sharing it, so a
+// program loading the configuration for several purposes, as the CLI does
+// for one command, reads and decrypts each file once. Files changed after
+// they were read are not seen by the loads sharing the cache.
+type Cache struct {
+	mu        sync.Mutex
+	documents map[cacheKey]cachedDocument
+}
+
+// cacheKey identifies a document by its path and the identity decrypting it
+type cacheKey struct {
+	path       string
+	ageKeyFile string
+}
+
+// cachedDocument is a document read by the loader with its unknown keys
+type cachedDocument struct {
+	root    *yaml.Node
+	unknown []string
+}
+
+// NewCache returns an empty configuration cache
+func NewCache() *Cache {
+	return &Cache{documents: make(map[cacheKey]cachedDocument)}
+}
+
+// read returns the cached document of path, reading it with l on the first
+// call. Failed reads are not cached.
+func (c *Cache) read(l *loader, path string) (*yaml.Node, []string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := cacheKey{path: path, ageKeyFile: l.ageKeyFile}
+	if cached, ok := c.documents[key]; ok {
+		return cached.root, cached.unknown, nil
+	}
+
+	root, err := l.read(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.documents[key] = cachedDocument{root: root, unknown: l.unknown}
+
+	return root, l.unknown, nil
+}
+
+// loader reads a configuration file and its includes
+type loader struct {
+	// seen holds the files being read, to detect include cycles
+	seen map[string]bool
+
+	// unknown lists the keys that match no setting, in every file read
+	unknown []string
+
+	// ageKeyFile is the age identity decrypting SOPS-encrypted files
+	ageKeyFile string
+}
+
+// read reads the configuration file at path with its environment variable
+// references expanded and its includes merged, it returns nil for an empty
+// file. Includes are relative to the including file, and each one overrides
+// the including file and the includes listed before it. Includes that do not
+// exist are skipped, so a local layer can be left uncommitted.
+func (l *loader) read(path string) (*yaml.Node, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	if l.seen[absPath] {
+		return nil, fmt.Errorf("include cycle through config file %s", path)
+	}
+	l.seen[absPath] = true
+	defer delete(l.seen, absPath)
+
+	data, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	if len(document.Content) == 0 {
+		return nil, nil
+	}
+
+	if isEncrypted(document.Content[0]) {
+		if data, err = decrypt(path, l.ageKeyFile); err != nil {
+			return nil, err
+		}
+
+		document = yaml.Node{}
+		if err := yaml.Unmarshal(data, &document); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+
+		if len(document.Content) == 0 {
+			return nil, nil
+		}
+	}
+
+	root := document.Content[0]
+	expandEnv(root)
+
+	includes, err := takeIncludes(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	for _, key := range unknownKeys(root, configType, "") {
+		l.unknown = append(l.unknown, fmt.Sprintf("%s:%s", path, key))
+	}
+
+	for _, include := range includes {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(path), include)
+		}
+
+		layer, err := l.read(include)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if layer != nil {
+			mergeNodes(root, layer)
+		}
+	}
+
+	return root, nil
+}
+
+// readFile reads a configuration file, or the standard input for StdinPath
+func readFile(path string) ([]byte, error) {
+	if path == StdinPath {
+		data, err := readStdin()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config from stdin: %w", err)
+		}
+		return data, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	return data, nil
+}
+
+// takeIncludes removes the include key from a mapping and returns the files
+// it lists, either a single file or a sequence of them
+func takeIncludes(node *yaml.Node) ([]string, error) {
+	if node.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value != includeKey {
+			continue
+		}
+
+		value := node.Content[i+1]
+		node.Content = append(node.Content[:i], node.Content[i+2:]...)
+
+		var includes []string
+		if value.Kind == yaml.ScalarNode {
+			includes = []string{value.Value}
+		} else if err := value.Decode(&includes); err != nil {
+			return nil, fmt.Errorf("invalid include: %w", err)
+		}
+
+		return includes, nil
+	}
+
+	return nil, nil
+}
+
+// mergeNodes merges src into dst: mappings are merged key by key, anything
+// else in src replaces dst
+func mergeNodes(dst, src *yaml.Node) {
+	if dst.Kind != yaml.MappingNode || src.Kind != yaml.MappingNode {
+		*dst = *src
+		return
+	}
+
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i], src.Content[i+1]
+
+		if existing := mappingValue(dst, key.Value); existing != nil {
+			mergeNodes(existing, value)
+			continue
+		}
+
+		dst.Content = append(dst.Content, key, value)
+	}
+}
+
+// mappingValue returns the value of the key in a mapping, or nil
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,155 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// applyPGEnv fills the database settings left empty with the libpq PG*
+// environment variables and libpq defaults
+func applyPGEnv(db *DatabaseConfig) {
+	fill := func(field *string, env string) {
+		if *field == "" {
+			*field = os.Getenv(env)
+		}
+	}
+
+	fill(&db.Host, "PGHOST")
+	fill(&db.Name, "PGDATABASE")
+	fill(&db.User, "PGUSER")
+	fill(&db.Password, "PGPASSWORD")
+	fill(&db.SSLMode, "PGSSLMODE")
+	fill(&db.SSLCert, "PGSSLCERT")
+	fill(&db.SSLKey, "PGSSLKEY")
+	fill(&db.SSLRootCert, "PGSSLROOTCERT")
+	fill(&db.ApplicationName, "PGAPPNAME")
+
+	if db.Port == 0 {
+		if port, err := strconv.Atoi(os.Getenv("PGPORT")); err == nil {
+			db.Port = port
+		}
+	}
+
+	if db.ConnectTimeout == 0 {
+		if timeout, err := strconv.Atoi(os.Getenv("PGCONNECT_TIMEOUT")); err == nil {
+			db.ConnectTimeout = timeout
+		}
+	}
+
+	// libpq defaults: local server, operating system user, database named after the user
+	if db.Host == "" {
+		db.Host = "localhost"
+	}
+
+	if db.User == "" {
+		if u, err := user.Current(); err == nil {
+			db.User = u.Username
+		}
+	}
+
+	if db.Name == "" {
+		db.Name = db.User
+	}
+}
+
+// applyPgpass looks up the password in the libpq password file, either
+// PGPASSFILE or ~/.pgpass, when no other password source is configured
+func applyPgpass(db *DatabaseConfig) error {
+	if db.Password != "" || db.PasswordFrom != "" || db.PasswordFile != "" || db.URL != "" {
+		return nil
+	}
+
+	path := os.Getenv("PGPASSFILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		path = filepath.Join(home, ".pgpass")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+
+	// Like libpq, ignore password files readable by other users
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("password file %s has group or world access, permissions should be 0600 or less", path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open password file: %w", err)
+	}
+	defer file.Close() //nolint:errcheck
+
+	host := db.Host
+	if db.IsSocket() {
+		host = "localhost"
+	}
+	wanted := []string{host, strconv.Itoa(db.Port), db.Name, db.User}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := splitPgpassLine(line)
+		if len(fields) != 5 {
+			continue
+		}
+
+		if pgpassMatches(fields[:4], wanted) {
+			db.Password = fields[4]
+			return nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read password file: %w", err)
+	}
+
+	return nil
+}
+
+// splitPgpassLine splits a hostname:port:database:username:password line,
+// honouring backslash-escaped colons and backslashes
+func splitPgpassLine(line string) []string {
+	var fields []string
+	var current strings.Builder
+
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '\\' && i+1 < len(line):
+			i++
+			current.WriteByte(line[i])
+		case c == ':' && len(fields) < 4:
+			fields = append(fields, current.String())
+			current.Reset()
+		default:
+			current.WriteByte(c)
+		}
+	}
+
+	return append(fields, current.String())
+}
+
+// pgpassMatches reports whether each pattern is "*" or equal to the wanted value
+func pgpassMatches(patterns, wanted []string) bool {
+	for i, pattern := range patterns {
+		if pattern != "*" && pattern != wanted[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// sopsKey is the top-level key holding the metadata of SOPS-encrypted files
+const sopsKey = "sops"
+
+// isEncrypted reports whether the document was encrypted with SOPS, entirely
+// or only for some values
+func isEncrypted(root *yaml.Node) bool {
+	return root.Kind == yaml.MappingNode && mappingValue(root, sopsKey) != nil
+}
+
+// decrypt decrypts a SOPS-encrypted file with the sops CLI, using the age
+// identity at ageKeyFile when set, and the sops defaults otherwise
+func decrypt(path, ageKeyFile string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command("sops", "--decrypt", "--input-type", "yaml", "--output-type", "yaml", path)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if ageKeyFile != "" {
+		cmd.Env = append(os.Environ(), "SOPS_AGE_KEY_FILE="+ageKeyFile)
+	}
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("failed to decrypt config file %s: %w: %s", path, err, msg)
+		}
+		return nil, fmt.Errorf("failed to decrypt config file %s: %w", path, err)
+	}
+
+	return stdout.Bytes(), nil
+}
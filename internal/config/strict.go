@@ -0,0 +1,66 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configType is the type the configuration files are decoded into
+var configType = reflect.TypeOf(Config{})
+
+// nodeType is the type of the settings decoded later, such as targets
+var nodeType = reflect.TypeOf(yaml.Node{})
+
+// unknownKeys returns the keys of the mapping that match no field of t, as
+// "line: key" with the path of nested keys, recursing into nested settings
+func unknownKeys(node *yaml.Node, t reflect.Type, prefix string) []string {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	var unknown []string
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+
+		switch t.Kind() {
+		case reflect.Struct:
+			field, ok := yamlField(t, key.Value)
+			if !ok {
+				unknown = append(unknown, fmt.Sprintf("%d: %s%s", key.Line, prefix, key.Value))
+				continue
+			}
+			unknown = append(unknown, unknownKeys(value, field.Type, prefix+key.Value+".")...)
+		case reflect.Map:
+			// Targets are configurations of their own
+			if t.Elem() == nodeType {
+				unknown = append(unknown, unknownKeys(value, configType, prefix+key.Value+".")...)
+			}
+		}
+	}
+
+	return unknown
+}
+
+// yamlField returns the field of the struct decoded from the key
+func yamlField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+
+		if name == key {
+			return field, true
+		}
+	}
+
+	return reflect.StructField{}, false
+}
@@ -0,0 +1,92 @@
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// runCommand runs a command and returns its trimmed standard output
+func runCommand(ctx context.Context, name string, args ...string) (string, error) {
+	return runCommandInput(ctx, "", name, args...)
+}
+
+// runCommandInput runs a command with the given standard input and returns
+// its trimmed standard output
+func runCommandInput(ctx context.Context, input string, name string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s: %w: %s", name, err, msg)
+		}
+		return "", fmt.Errorf("%s: %w", name, err)
+	}
+
+	return strings.TrimRight(stdout.String(), "\r\n"), nil
+}
+
+// AWSSecretsManager reads secrets from AWS Secrets Manager through the aws CLI
+//
+// Credentials, profile and region follow the usual AWS CLI configuration.
+// References look like "prod/db#password", where the key is looked up in a
+// JSON secret string, or just "prod/db-password" for plain text secrets.
+type AWSSecretsManager struct{}
+
+// Resolve fetches the current version of the secret
+func (AWSSecretsManager) Resolve(ctx context.Context, ref string) (string, error) {
+	id, key := splitKey(ref)
+
+	secret, err := runCommand(ctx, "aws", "secretsmanager", "get-secret-value",
+		"--secret-id", id,
+		"--query", "SecretString",
+		"--output", "text")
+	if err != nil {
+		return "", err
+	}
+
+	return extractKey(secret, key)
+}
+
+// GCPSecretManager reads secrets from Google Cloud Secret Manager through the gcloud CLI
+//
+// References are either a secret name using the active project and the latest
+// version ("db-password") or a full resource name
+// ("projects/my-project/secrets/db/versions/3"), optionally followed by
+// "#key" for JSON secrets.
+type GCPSecretManager struct{}
+
+// Resolve fetches the requested version of the secret
+func (GCPSecretManager) Resolve(ctx context.Context, ref string) (string, error) {
+	name, key := splitKey(ref)
+
+	args := []string{"secrets", "versions", "access"}
+
+	parts := strings.Split(name, "/")
+	switch {
+	case len(parts) == 1:
+		args = append(args, "latest", "--secret="+name)
+	case len(parts) >= 4 && parts[0] == "projects" && parts[2] == "secrets":
+		version := "latest"
+		if len(parts) == 6 && parts[4] == "versions" {
+			version = parts[5]
+		}
+		args = append(args, version, "--secret="+parts[3], "--project="+parts[1])
+	default:
+		return "", fmt.Errorf("invalid secret name %q, expected <secret> or projects/<project>/secrets/<secret>[/versions/<version>]", name)
+	}
+
+	secret, err := runCommand(ctx, "gcloud", args...)
+	if err != nil {
+		return "", err
+	}
+
+	return extractKey(secret, key)
+}
@@ -0,0 +1,110 @@
+package credentials
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Provider resolves a secret reference into the secret value
+type Provider interface {
+	// Resolve returns the secret identified by ref, the part of the
+	// reference following the "<scheme>:" prefix
+	Resolve(ctx context.Context, ref string) (string, error)
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = make(map[string]Provider)
+)
+
+// Register makes a provider available under the given reference scheme
+func Register(scheme string, p Provider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+
+	providers[scheme] = p
+}
+
+// Resolve resolves a "<scheme>:<ref>" reference, e.g. "vault:secret/data/db#password"
+func Resolve(ctx context.Context, reference string) (string, error) {
+	scheme, ref, ok := strings.Cut(reference, ":")
+	if !ok || ref == "" {
+		return "", fmt.Errorf("invalid secret reference %q, expected <provider>:<reference>", reference)
+	}
+
+	providersMu.RLock()
+	p, ok := providers[scheme]
+	providersMu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("unknown secret provider %q (available: %s)", scheme, strings.Join(schemes(), ", "))
+	}
+
+	secret, err := p.Resolve(ctx, ref)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve secret %q: %w", reference, err)
+	}
+
+	return secret, nil
+}
+
+// schemes returns the sorted names of the registered providers
+func schemes() []string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// splitKey splits "path#key" into the secret path and the optional JSON key
+func splitKey(ref string) (string, string) {
+	path, key, _ := strings.Cut(ref, "#")
+	return path, key
+}
+
+// extractKey returns the value of key in a JSON object secret, or the raw
+// secret when no key is requested
+func extractKey(secret, key string) (string, error) {
+	if key == "" {
+		return secret, nil
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(secret), &fields); err != nil {
+		return "", fmt.Errorf("secret is not a JSON object, cannot extract %q: %w", key, err)
+	}
+
+	return lookupField(fields, key)
+}
+
+// lookupField returns the string value of a field of a decoded JSON object
+func lookupField(fields map[string]interface{}, key string) (string, error) {
+	value, ok := fields[key]
+	if !ok {
+		return "", fmt.Errorf("key %q not found in secret", key)
+	}
+
+	str, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("key %q is not a string", key)
+	}
+
+	return str, nil
+}
+
+func init() {
+	Register("file", File{})
+	Register("keyring", Keyring{})
+	Register("vault", Vault{})
+	Register("aws-sm", AWSSecretsManager{})
+	Register("gcp-sm", GCPSecretManager{})
+}
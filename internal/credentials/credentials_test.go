@@ -0,0 +1,146 @@
+package credentials_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/arthurdotwork/mig/internal/credentials"
+	"github.com/stretchr/testify/require"
+)
+
+type staticProvider map[string]string
+
+func (p staticProvider) Resolve(_ context.Context, ref string) (string, error) {
+	return p[ref], nil
+}
+
+func TestResolve(t *testing.T) {
+	t.Run("it should return an error for a malformed reference", func(t *testing.T) {
+		_, err := credentials.Resolve(context.Background(), "secret/data/db#password")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "invalid secret reference")
+	})
+
+	t.Run("it should return an error for an unknown provider", func(t *testing.T) {
+		_, err := credentials.Resolve(context.Background(), "keepass:db")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unknown secret provider")
+	})
+
+	t.Run("it should resolve through a registered provider", func(t *testing.T) {
+		credentials.Register("static", staticProvider{"db": "s3cret"})
+
+		secret, err := credentials.Resolve(context.Background(), "static:db")
+		require.NoError(t, err)
+		require.Equal(t, "s3cret", secret)
+	})
+}
+
+func TestVault(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "test-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1/secret/data/db":
+			_, _ = w.Write([]byte(`{"data": {"data": {"password": "kv2-pass"}, "metadata": {"version": 3}}}`))
+		case "/v1/kv/db":
+			_, _ = w.Write([]byte(`{"data": {"password": "kv1-pass"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv("VAULT_ADDR", server.URL)
+	t.Setenv("VAULT_TOKEN", "test-token")
+
+	t.Run("it should read a key from a KV v2 secret", func(t *testing.T) {
+		secret, err := credentials.Resolve(context.Background(), "vault:secret/data/db#password")
+		require.NoError(t, err)
+		require.Equal(t, "kv2-pass", secret)
+	})
+
+	t.Run("it should read a key from a KV v1 secret", func(t *testing.T) {
+		secret, err := credentials.Resolve(context.Background(), "vault:kv/db#password")
+		require.NoError(t, err)
+		require.Equal(t, "kv1-pass", secret)
+	})
+
+	t.Run("it should return an error for a missing key", func(t *testing.T) {
+		_, err := credentials.Resolve(context.Background(), "vault:secret/data/db#username")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "not found")
+	})
+
+	t.Run("it should return an error for a missing secret", func(t *testing.T) {
+		_, err := credentials.Resolve(context.Background(), "vault:secret/data/other#password")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "404")
+	})
+
+	t.Run("it should require a key", func(t *testing.T) {
+		_, err := credentials.Resolve(context.Background(), "vault:secret/data/db")
+		require.Error(t, err)
+	})
+}
+
+func TestFile(t *testing.T) {
+	t.Run("it should read the secret without its trailing newline", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "db_password")
+		err := os.WriteFile(path, []byte("s3cret with spaces \n"), 0600)
+		require.NoError(t, err)
+
+		secret, err := credentials.Resolve(context.Background(), "file:"+path)
+		require.NoError(t, err)
+		require.Equal(t, "s3cret with spaces ", secret)
+	})
+
+	t.Run("it should return an error for a missing file", func(t *testing.T) {
+		_, err := credentials.Resolve(context.Background(), "file:/non/existent/secret")
+		require.Error(t, err)
+	})
+}
+
+func TestKeyring(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the fake secret-tool only stands in for the Linux keyring")
+	}
+
+	// secretTool puts a secret-tool running script first in the PATH
+	secretTool := func(t *testing.T, script string) {
+		dir := t.TempDir()
+		err := os.WriteFile(filepath.Join(dir, "secret-tool"), []byte("#!/bin/sh\n"+script+"\n"), 0755)
+		require.NoError(t, err)
+		t.Setenv("PATH", dir)
+	}
+
+	t.Run("it should read the password stored for the target", func(t *testing.T) {
+		secretTool(t, `echo "s3cret-$5"`)
+
+		secret, err := credentials.Keyring{}.Resolve(context.Background(), "prod")
+		require.NoError(t, err)
+		require.Equal(t, "s3cret-prod", secret)
+	})
+
+	t.Run("it should report a target without password", func(t *testing.T) {
+		secretTool(t, "exit 1")
+
+		_, err := credentials.Keyring{}.Resolve(context.Background(), "prod")
+		require.ErrorIs(t, err, credentials.ErrNoKeyringPassword)
+	})
+
+	t.Run("it should report a missing secret-tool as unsupported", func(t *testing.T) {
+		t.Setenv("PATH", t.TempDir())
+
+		_, err := credentials.Keyring{}.Resolve(context.Background(), "prod")
+		require.ErrorIs(t, err, credentials.ErrKeyringUnsupported)
+	})
+}
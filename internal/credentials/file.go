@@ -0,0 +1,23 @@
+package credentials
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// File reads a secret from a file, such as a Docker or Kubernetes secret
+// mounted under /run/secrets. A single trailing newline is ignored.
+type File struct{}
+
+// Resolve reads the secret file at the given path
+func (File) Resolve(_ context.Context, path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %w", err)
+	}
+
+	secret := strings.TrimSuffix(string(data), "\n")
+	return strings.TrimSuffix(secret, "\r"), nil
+}
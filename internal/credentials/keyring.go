@@ -0,0 +1,107 @@
+package credentials
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// KeyringService is the service name mig entries are stored under in the OS keyring
+const KeyringService = "mig"
+
+// Keyring stores and reads passwords in the operating system keyring: the
+// login keychain on macOS (through `security`) and the Secret Service on
+// Linux (through `secret-tool`). References are target names.
+type Keyring struct{}
+
+// Resolve reads the password stored for the target. The error wraps
+// ErrNoKeyringPassword when there is none, and ErrKeyringUnsupported when the
+// OS has no keyring mig can use.
+func (Keyring) Resolve(ctx context.Context, target string) (string, error) {
+	var (
+		secret string
+		err    error
+		// notFound is the exit code of the lookup of a missing entry
+		notFound int
+	)
+	switch runtime.GOOS {
+	case "darwin":
+		secret, err = runCommand(ctx, "security", "find-generic-password", "-s", KeyringService, "-a", target, "-w")
+		notFound = 44 // errSecItemNotFound
+	case "linux", "freebsd", "openbsd", "netbsd":
+		secret, err = runCommand(ctx, "secret-tool", "lookup", "service", KeyringService, "target", target)
+		notFound = 1
+	default:
+		return "", ErrKeyringUnsupported
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(err, exec.ErrNotFound):
+		return "", fmt.Errorf("%w: %w", ErrKeyringUnsupported, err)
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == notFound, err == nil && secret == "":
+		return "", fmt.Errorf("%w for target %q, run `mig auth login %s`", ErrNoKeyringPassword, target, target)
+	case err != nil:
+		return "", err
+	}
+
+	return secret, nil
+}
+
+// Store saves the password for the target, replacing any previous one
+func (Keyring) Store(ctx context.Context, target, password string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		// Pass the password through `security -i` on stdin rather than as an
+		// argument, so it never shows up in the process list
+		command := fmt.Sprintf("add-generic-password -U -s %s -a %s -l %s -w %s\n",
+			securityQuote(KeyringService),
+			securityQuote(target),
+			securityQuote(KeyringService+" "+target),
+			securityQuote(password))
+		_, err := runCommandInput(ctx, command, "security", "-i")
+		return err
+	case "linux", "freebsd", "openbsd", "netbsd":
+		_, err := runCommandInput(ctx, password, "secret-tool", "store",
+			"--label", KeyringService+" "+target,
+			"service", KeyringService,
+			"target", target)
+		return err
+	default:
+		return ErrKeyringUnsupported
+	}
+}
+
+// Delete removes the password stored for the target
+func (Keyring) Delete(ctx context.Context, target string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		_, err := runCommand(ctx, "security", "delete-generic-password", "-s", KeyringService, "-a", target)
+		return err
+	case "linux", "freebsd", "openbsd", "netbsd":
+		_, err := runCommand(ctx, "secret-tool", "clear", "service", KeyringService, "target", target)
+		return err
+	default:
+		return ErrKeyringUnsupported
+	}
+}
+
+var (
+	// ErrNoKeyringPassword is wrapped by the errors of Keyring.Resolve when no
+	// password is stored for the target
+	ErrNoKeyringPassword = errors.New("no password stored in the OS keyring")
+
+	// ErrKeyringUnsupported is wrapped by the errors of Keyring when the OS
+	// keyring or its command line tool is not available
+	ErrKeyringUnsupported = errors.New("the OS keyring is not supported on " + runtime.GOOS)
+)
+
+// securityQuote quotes an argument for the `security -i` command parser
+func securityQuote(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	return `"` + value + `"`
+}
@@ -0,0 +1,126 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/arthurdotwork/mig/internal/config"
+)
+
+// Querier runs queries on a connection pool, a connection or a transaction
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// CheckAccess returns the problems found by the access checks: the
+// privileges of the grants the roles lack, when the dialect can tell, and
+// the rows returned by the queries
+func CheckAccess(ctx context.Context, q Querier, dialect Dialect, access config.AccessConfig) ([]string, error) {
+	var problems []string
+
+	if len(access.Grants) > 0 {
+		checker, ok := dialect.(GrantChecker)
+		if !ok {
+			return nil, fmt.Errorf("access grants are not supported by the %s dialect", dialect.Name())
+		}
+
+		for _, grant := range access.Grants {
+			missing, err := checker.MissingGrants(ctx, q, grant)
+			if err != nil {
+				return nil, err
+			}
+			problems = append(problems, missing...)
+		}
+	}
+
+	for _, query := range access.Queries {
+		found, err := queryProblems(ctx, q, query)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, found...)
+	}
+
+	return problems, nil
+}
+
+// queryProblems returns the first column of the rows of an access query
+func queryProblems(ctx context.Context, q Querier, query string) ([]string, error) {
+	rows, err := q.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run access query: %w", err)
+	}
+	defer rows.Close() //nolint:errcheck
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run access query: %w", err)
+	}
+
+	var problems []string
+	for rows.Next() {
+		values := make([]any, len(columns))
+		for i := range values {
+			values[i] = new(any)
+		}
+		if err := rows.Scan(values...); err != nil {
+			return nil, fmt.Errorf("failed to scan access query row: %w", err)
+		}
+
+		problem := *values[0].(*any)
+		if b, ok := problem.([]byte); ok {
+			problem = string(b)
+		}
+		problems = append(problems, fmt.Sprint(problem))
+	}
+
+	return problems, rows.Err()
+}
+
+// MissingGrants returns "role lacks PRIVILEGE on schema.table" for each
+// privilege of the grant the role lacks on the tables of its schema
+func (Postgres) MissingGrants(ctx context.Context, q Querier, grant config.GrantConfig) ([]string, error) {
+	privileges := make([]string, len(grant.Privileges))
+	for i, privilege := range grant.Privileges {
+		privileges[i] = "(" + QuoteLiteral(privilege) + ")"
+	}
+
+	query := `
+	SELECT n.nspname || '.' || c.relname, p.privilege
+	FROM pg_class c
+	JOIN pg_namespace n ON n.oid = c.relnamespace
+	CROSS JOIN (VALUES ` + strings.Join(privileges, ", ") + `) AS p(privilege)
+	WHERE n.nspname = COALESCE(NULLIF($2, ''), current_schema())
+	AND c.relkind IN ('r', 'p')
+	AND c.relname NOT IN ('mig_versions', 'mig_history')
+	AND NOT has_table_privilege($1, c.oid, p.privilege)`
+
+	if len(grant.Tables) > 0 {
+		tables := make([]string, len(grant.Tables))
+		for i, table := range grant.Tables {
+			tables[i] = QuoteLiteral(table)
+		}
+		query += `
+	AND c.relname IN (` + strings.Join(tables, ", ") + `)`
+	}
+
+	rows, err := q.QueryContext(ctx, query+`
+	ORDER BY 1, 2`, grant.Role, grant.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check the grants of %s: %w", grant.Role, err)
+	}
+	defer rows.Close() //nolint:errcheck
+
+	var missing []string
+	for rows.Next() {
+		var table, privilege string
+		if err := rows.Scan(&table, &privilege); err != nil {
+			return nil, fmt.Errorf("failed to scan missing grant: %w", err)
+		}
+		missing = append(missing, fmt.Sprintf("%s lacks %s on %s", grant.Role, privilege, table))
+	}
+
+	return missing, rows.Err()
+}
@@ -0,0 +1,105 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/arthurdotwork/mig/internal/config"
+)
+
+// Backup dumps the database of the target to path with the backup command,
+// when the dialect supports it
+func Backup(ctx context.Context, dialect Dialect, target string, dbCfg config.DatabaseConfig, command, path string) error {
+	backuper, connStr, password, err := backupConnection(ctx, dialect, target, dbCfg)
+	if err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create backup directory: %w", err)
+		}
+	}
+
+	return backuper.Backup(ctx, command, connStr, password, path)
+}
+
+// Restore restores the database of the target from the backup at path with
+// the restore command, when the dialect supports it
+func Restore(ctx context.Context, dialect Dialect, target string, dbCfg config.DatabaseConfig, command, path string) error {
+	backuper, connStr, password, err := backupConnection(ctx, dialect, target, dbCfg)
+	if err != nil {
+		return err
+	}
+
+	return backuper.Restore(ctx, command, connStr, password, path)
+}
+
+// backupConnection returns the connection string of the backup commands, with
+// the password left out of it
+func backupConnection(ctx context.Context, dialect Dialect, target string, dbCfg config.DatabaseConfig) (Backuper, string, string, error) {
+	backuper, ok := dialect.(Backuper)
+	if !ok {
+		return nil, "", "", fmt.Errorf("backups are not supported by the %s dialect", dialect.Name())
+	}
+
+	dbCfg, err := resolveCredentials(ctx, target, dbCfg)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	password := dbCfg.Password
+	dbCfg.Password = ""
+
+	connStr, err := connectionString(dialect, dbCfg)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	return backuper, connStr, password, nil
+}
+
+// Backup dumps the database with pg_dump in the custom format, which
+// pg_restore reads. A partial dump is removed when pg_dump fails.
+func (Postgres) Backup(ctx context.Context, command, connStr, password, path string) error {
+	if err := runPgCommand(ctx, command, password, "--format=custom", "--file="+path, "--dbname="+connStr); err != nil {
+		os.Remove(path) //nolint:errcheck
+		return err
+	}
+
+	return nil
+}
+
+// Restore restores a custom format dump with pg_restore in a single
+// transaction, dropping the objects of the dump before recreating them.
+// Objects missing from the dump are left alone.
+func (Postgres) Restore(ctx context.Context, command, connStr, password, path string) error {
+	return runPgCommand(ctx, command, password, "--clean", "--if-exists", "--single-transaction", "--exit-on-error", "--dbname="+connStr, path)
+}
+
+// runPgCommand runs a PostgreSQL client command with the password in the
+// environment
+func runPgCommand(ctx context.Context, command, password string, args ...string) error {
+	var stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Env = os.Environ()
+	if password != "" {
+		cmd.Env = append(cmd.Env, "PGPASSWORD="+password)
+	}
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%s: %w: %s", command, err, msg)
+		}
+		return fmt.Errorf("%s: %w", command, err)
+	}
+
+	return nil
+}
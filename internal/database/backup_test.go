@@ -0,0 +1,66 @@
+package database_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/arthurdotwork/mig/internal/config"
+	"github.com/arthurdotwork/mig/internal/database"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCommand writes an executable shell script standing in for pg_dump
+func fakeCommand(t *testing.T, script string) string {
+	path := filepath.Join(t.TempDir(), "pg_dump")
+	require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755))
+	return path
+}
+
+func TestBackup(t *testing.T) {
+	dbCfg := config.DatabaseConfig{Host: "localhost", Port: 5432, Name: "app", User: "mig", Password: "secret", SSLMode: "disable"}
+
+	t.Run("it should dump the database in the custom format", func(t *testing.T) {
+		command := fakeCommand(t, `for arg; do case "$arg" in --file=*) echo "$@ $PGPASSWORD" > "${arg#--file=}";; esac; done`)
+		path := filepath.Join(t.TempDir(), "backups", "app.dump")
+
+		err := database.Backup(context.Background(), database.Postgres{}, "", dbCfg, command, path)
+		require.NoError(t, err)
+
+		dump, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Contains(t, string(dump), "--format=custom")
+		require.Contains(t, string(dump), "--dbname=host=localhost port=5432 dbname=app user=mig sslmode=disable")
+		require.NotContains(t, string(dump), "password=")
+		require.Contains(t, string(dump), " secret")
+	})
+
+	t.Run("it should remove the partial dump when the command fails", func(t *testing.T) {
+		command := fakeCommand(t, `for arg; do case "$arg" in --file=*) echo partial > "${arg#--file=}";; esac; done; echo "connection refused" >&2; exit 1`)
+		path := filepath.Join(t.TempDir(), "app.dump")
+
+		err := database.Backup(context.Background(), database.Postgres{}, "", dbCfg, command, path)
+		require.ErrorContains(t, err, "connection refused")
+		require.NoFileExists(t, path)
+	})
+
+	t.Run("it should restore the dump in a single transaction", func(t *testing.T) {
+		out := filepath.Join(t.TempDir(), "args")
+		command := fakeCommand(t, `echo "$@ $PGPASSWORD" > "`+out+`"`)
+
+		err := database.Restore(context.Background(), database.Postgres{}, "", dbCfg, command, "app.dump")
+		require.NoError(t, err)
+
+		args, err := os.ReadFile(out)
+		require.NoError(t, err)
+		require.Contains(t, string(args), "--clean --if-exists --single-transaction --exit-on-error")
+		require.Contains(t, string(args), "app.dump secret")
+		require.NotContains(t, string(args), "password=")
+	})
+
+	t.Run("it should reject a dialect without backups", func(t *testing.T) {
+		err := database.Backup(context.Background(), database.ClickHouse{}, "", dbCfg, "pg_dump", "app.dump")
+		require.ErrorContains(t, err, "backups are not supported by the clickhouse dialect")
+	})
+}
@@ -0,0 +1,218 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/arthurdotwork/mig/internal/config"
+)
+
+// ClickHouse is the ClickHouse dialect
+//
+// The driver is not linked by default: build mig with `-tags clickhouse`
+// or import github.com/ClickHouse/clickhouse-go/v2 in your own program.
+type ClickHouse struct{}
+
+// Name returns the name of the dialect
+func (ClickHouse) Name() string {
+	return "clickhouse"
+}
+
+// DriverName returns the database/sql driver name
+func (ClickHouse) DriverName() string {
+	return "clickhouse"
+}
+
+// ConnectionString builds a clickhouse:// URL for the native protocol
+func (ClickHouse) ConnectionString(cfg config.DatabaseConfig) string {
+	query := url.Values{}
+	if cfg.SSLMode != "" && cfg.SSLMode != "disable" {
+		query.Set("secure", "true")
+		if cfg.SSLMode == "require" {
+			query.Set("skip_verify", "true")
+		}
+	}
+
+	if cfg.ConnectTimeout > 0 {
+		query.Set("dial_timeout", strconv.Itoa(cfg.ConnectTimeout)+"s")
+	}
+
+	if cfg.ApplicationName != "" {
+		query.Set("client_info_product", cfg.ApplicationName)
+	}
+
+	for key, value := range cfg.Params {
+		query.Set(key, value)
+	}
+
+	u := url.URL{
+		Scheme:   "clickhouse",
+		User:     url.UserPassword(cfg.User, cfg.Password),
+		Host:     net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)),
+		Path:     "/" + cfg.Name,
+		RawQuery: query.Encode(),
+	}
+
+	return u.String()
+}
+
+// QuoteIdentifier quotes an identifier with backticks
+func (ClickHouse) QuoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// Placeholder returns a positional ? parameter
+func (ClickHouse) Placeholder(int) string {
+	return "?"
+}
+
+// CreateVersionTableSQL returns the statement creating the mig_versions table
+//
+// ClickHouse has no auto-increment, so the id is derived from the insertion
+// time, and ReplacingMergeTree collapses accidental duplicate versions.
+func (ClickHouse) CreateVersionTableSQL() string {
+	return `
+	CREATE TABLE IF NOT EXISTS mig_versions (
+		id UInt64 DEFAULT toUInt64(toUnixTimestamp64Nano(now64(9))),
+		version String,
+		applied_at DateTime64(3, 'UTC') DEFAULT now64(3),
+		checksum String DEFAULT '',
+		duration_ms UInt64 DEFAULT 0,
+		applied_by String DEFAULT ''
+	) ENGINE = ReplacingMergeTree
+	ORDER BY version`
+}
+
+// CreateHistoryTableSQL returns the statement creating the mig_history table
+func (ClickHouse) CreateHistoryTableSQL() string {
+	return `
+	CREATE TABLE IF NOT EXISTS mig_history (
+		id UInt64 DEFAULT toUInt64(toUnixTimestamp64Nano(now64(9))),
+		version String,
+		command String,
+		executed_at DateTime64(3, 'UTC') DEFAULT now64(3)
+	) ENGINE = MergeTree
+	ORDER BY (executed_at, version)`
+}
+
+// UpgradeVersionTableSQL returns the statements adding the checksum,
+// duration and applied_by columns. applied_by stays empty: ClickHouse
+// computes the default of a column added later when reading the rows
+// predating it, which would attribute them to the reader.
+func (ClickHouse) UpgradeVersionTableSQL() []string {
+	return []string{
+		"ALTER TABLE mig_versions ADD COLUMN IF NOT EXISTS checksum String DEFAULT ''",
+		"ALTER TABLE mig_versions ADD COLUMN IF NOT EXISTS duration_ms UInt64 DEFAULT 0",
+		"ALTER TABLE mig_versions ADD COLUMN IF NOT EXISTS applied_by String DEFAULT ''",
+	}
+}
+
+// RecordMigrationSQL returns the INSERT recording a migration, without its
+// duration: ClickHouse has no session state to time a script with
+func (ClickHouse) RecordMigrationSQL(version, checksum string) string {
+	return fmt.Sprintf("INSERT INTO mig_versions (version, checksum) VALUES (%s, %s)", clickHouseLiteral(version), clickHouseLiteral(checksum))
+}
+
+// RecordHistorySQL returns the INSERT recording a history entry
+func (ClickHouse) RecordHistorySQL(version, command string) string {
+	return fmt.Sprintf("INSERT INTO mig_history (version, command) VALUES (%s, %s)", clickHouseLiteral(version), clickHouseLiteral(command))
+}
+
+// clickHouseLiteral quotes a string literal, in which ClickHouse treats
+// backslashes as escapes
+func clickHouseLiteral(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	return "'" + strings.ReplaceAll(value, "'", `\'`) + "'"
+}
+
+// SupportsTransactionalDDL reports that ClickHouse has no transactional DDL,
+// so every migration runs without a transaction
+func (ClickHouse) SupportsTransactionalDDL() bool {
+	return false
+}
+
+// SplitStatements splits the migration on semicolons, since the native
+// protocol only accepts a single statement per query
+func (ClickHouse) SplitStatements(content string) []string {
+	return splitOnSemicolons(content)
+}
+
+// Lock is a no-op: ClickHouse has no session locks, so concurrent runs must
+// be serialized by the caller
+func (ClickHouse) Lock(context.Context, *sql.Conn) error {
+	return nil
+}
+
+// Unlock is a no-op, see Lock
+func (ClickHouse) Unlock(context.Context, *sql.Conn) error {
+	return nil
+}
+
+// splitOnSemicolons splits SQL on top-level semicolons, ignoring those inside
+// quoted strings, quoted identifiers and comments
+func splitOnSemicolons(content string) []string {
+	var statements []string
+
+	flush := func(statement string) {
+		if strings.TrimSpace(stripComments(statement)) != "" {
+			statements = append(statements, strings.TrimSpace(statement))
+		}
+	}
+
+	start := 0
+	for i := 0; i < len(content); i++ {
+		switch c := content[i]; {
+		case c == '\'' || c == '"' || c == '`':
+			// Skip to the closing quote, honouring backslash and doubled-quote escapes
+			for i++; i < len(content); i++ {
+				if content[i] == '\\' {
+					i++
+				} else if content[i] == c {
+					if i+1 < len(content) && content[i+1] == c {
+						i++
+						continue
+					}
+					break
+				}
+			}
+		case c == '-' && strings.HasPrefix(content[i:], "--"):
+			if end := strings.IndexByte(content[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				i = len(content)
+			}
+		case c == '/' && strings.HasPrefix(content[i:], "/*"):
+			if end := strings.Index(content[i+2:], "*/"); end >= 0 {
+				i += end + 3
+			} else {
+				i = len(content)
+			}
+		case c == ';':
+			flush(content[start:i])
+			start = i + 1
+		}
+	}
+
+	if start < len(content) {
+		flush(content[start:])
+	}
+
+	return statements
+}
+
+// stripComments removes line comments so comment-only chunks can be skipped
+func stripComments(statement string) string {
+	var lines []string
+	for _, line := range strings.Split(statement, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+			lines = append(lines, line)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -1,21 +1,33 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"iter"
+	"maps"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/arthurdotwork/mig/internal/config"
+	"github.com/arthurdotwork/mig/internal/credentials"
 	_ "github.com/lib/pq" // PostgreSQL driver
 )
 
-// Constants for the SQL statements to create the migration tables
+// Constants for the SQL statements to create the PostgreSQL migration tables
 const (
 	CreateVersionTableSQL = `
 	CREATE TABLE IF NOT EXISTS mig_versions (
 		id SERIAL PRIMARY KEY,
 		version VARCHAR(255) NOT NULL UNIQUE,
-		applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+		checksum VARCHAR(64),
+		duration_ms BIGINT,
+		applied_by VARCHAR(255) DEFAULT current_user
 	);`
 
 	CreateHistoryTableSQL = `
@@ -32,48 +44,315 @@ type MigrationVersion struct {
 	ID        int
 	Version   string
 	AppliedAt time.Time
+	Checksum  string        // SHA-256 of the applied file, empty for records predating checksums
+	Duration  time.Duration // Execution time, zero for records predating durations
+	AppliedBy string        // Database user that applied it, empty for records predating it
 }
 
-// Connect establishes a connection to the PostgreSQL database
-func Connect(cfg *config.Config) (*sql.DB, error) {
-	connStr := fmt.Sprintf(
-		"host=%s port=%d dbname=%s user=%s password=%s sslmode=%s",
-		cfg.Database.Host,
-		cfg.Database.Port,
-		cfg.Database.Name,
-		cfg.Database.User,
-		cfg.Database.Password,
-		cfg.Database.SSLMode,
-	)
+// HistoryEntry represents a record in the mig_history table
+type HistoryEntry struct {
+	ID         int64
+	Version    string
+	Command    string
+	ExecutedAt time.Time
+}
+
+// AdHocPrefix starts the version of the history entries of ad-hoc SQL, run
+// outside of the migrations by Exec
+const AdHocPrefix = "adhoc:"
+
+// AdHoc reports whether the entry records ad-hoc SQL rather than a migration
+func (h HistoryEntry) AdHoc() bool {
+	return strings.HasPrefix(h.Version, AdHocPrefix)
+}
+
+// Connect establishes a connection to the configured database
+func Connect(ctx context.Context, cfg *config.Config) (*sql.DB, error) {
+	dialect, err := GetDialect(cfg.Database.Driver)
+	if err != nil {
+		return nil, err
+	}
+
+	if !slices.Contains(sql.Drivers(), dialect.DriverName()) {
+		return nil, fmt.Errorf("database driver %q is not registered (import its package or rebuild mig with -tags %s)", dialect.DriverName(), dialect.Name())
+	}
 
-	db, err := sql.Open("postgres", connStr)
+	dbCfg, err := resolveCredentials(ctx, cfg.Target, cfg.Database)
+	if err != nil {
+		return nil, err
+	}
+
+	if dbCfg.CreateIfMissing {
+		if err := createIfMissing(ctx, dialect, dbCfg); err != nil {
+			return nil, err
+		}
+	}
+
+	// Unqualified names, including the tracking tables, resolve in the tenant
+	// schema, or else in the configured one
+	schema := cfg.Tenant
+	if schema == "" {
+		schema = cfg.Database.Schema
+	}
+
+	if schema != "" {
+		dbCfg.Params = withParams(dbCfg.Params, map[string]string{"search_path": dialect.QuoteIdentifier(schema)})
+	}
+
+	if cfg.Database.PgBouncer {
+		dbCfg.Params = withParams(dbCfg.Params, pgBouncerParams[dialect.DriverName()])
+	}
+
+	connStr, err := connectionString(dialect, dbCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open(dialect.DriverName(), connStr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database connection: %w", err)
 	}
 
-	if err := db.Ping(); err != nil {
+	db.SetMaxOpenConns(cfg.Database.MaxOpenConns)
+	if cfg.Database.MaxIdleConns != 0 {
+		db.SetMaxIdleConns(cfg.Database.MaxIdleConns)
+	}
+	db.SetConnMaxLifetime(cfg.Database.ConnMaxLifetime)
+
+	// Bound the initial ping too, in case the driver ignores its own timeout
+	if cfg.Database.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(cfg.Database.ConnectTimeout)*time.Second)
+		defer cancel()
+	}
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close() //nolint:errcheck
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
 	return db, nil
 }
 
+// connectionString returns the driver connection string of the database
+func connectionString(dialect Dialect, dbCfg config.DatabaseConfig) (string, error) {
+	// A full connection URL bypasses the individual connection settings
+	if dbCfg.URL == "" {
+		return dialect.ConnectionString(dbCfg), nil
+	}
+
+	return withURLParams(dbCfg.URL, dbCfg.Params)
+}
+
+// createIfMissing creates the configured database when it does not exist,
+// connected to the maintenance database of the server
+func createIfMissing(ctx context.Context, dialect Dialect, dbCfg config.DatabaseConfig) error {
+	creator, ok := dialect.(DatabaseCreator)
+	if !ok {
+		return fmt.Errorf("create_if_missing is not supported by the %s dialect", dialect.Name())
+	}
+
+	name, err := DatabaseName(dbCfg)
+	if err != nil {
+		return err
+	}
+
+	if name == "" {
+		return fmt.Errorf("create_if_missing requires a database name")
+	}
+
+	db, err := openMaintenance(dialect, creator, dbCfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close() //nolint:errcheck
+
+	if _, err := creator.CreateDatabase(ctx, db, name, dbCfg.Create); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DatabaseName returns the name of the configured database, from the
+// connection URL when there is one
+func DatabaseName(dbCfg config.DatabaseConfig) (string, error) {
+	if dbCfg.URL == "" {
+		return dbCfg.Name, nil
+	}
+
+	u, err := url.Parse(dbCfg.URL)
+	if err != nil {
+		return "", fmt.Errorf("invalid database url: %w", err)
+	}
+
+	return strings.TrimPrefix(u.Path, "/"), nil
+}
+
+// openMaintenance opens a connection to the maintenance database of the
+// server, which always exists and is used to create or drop others
+func openMaintenance(dialect Dialect, creator DatabaseCreator, dbCfg config.DatabaseConfig) (*sql.DB, error) {
+	maintenance := dbCfg.Create.MaintenanceDatabase
+	if maintenance == "" {
+		maintenance = creator.MaintenanceDatabase()
+	}
+	dbCfg.SetName(maintenance)
+
+	connStr, err := connectionString(dialect, dbCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open(dialect.DriverName(), connStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open maintenance database connection: %w", err)
+	}
+
+	return db, nil
+}
+
+// resolveCredentials returns a copy of the database configuration with the
+// password fetched from its configured source. Without any, the password
+// stored by `mig auth login <target>` in the OS keyring is used, if there is
+// one.
+func resolveCredentials(ctx context.Context, target string, dbCfg config.DatabaseConfig) (config.DatabaseConfig, error) {
+	reference := dbCfg.PasswordFrom
+	if dbCfg.PasswordFile != "" {
+		reference = "file:" + dbCfg.PasswordFile
+	}
+
+	var (
+		password string
+		err      error
+	)
+	switch {
+	case reference != "":
+		if password, err = credentials.Resolve(ctx, reference); err != nil {
+			return dbCfg, err
+		}
+	case dbCfg.Password == "" && target != "" && !urlHasPassword(dbCfg.URL):
+		password, err = credentials.Keyring{}.Resolve(ctx, target)
+		if errors.Is(err, credentials.ErrNoKeyringPassword) || errors.Is(err, credentials.ErrKeyringUnsupported) {
+			return dbCfg, nil
+		}
+		if err != nil {
+			return dbCfg, fmt.Errorf("failed to read the password of target %q from the OS keyring: %w", target, err)
+		}
+	default:
+		return dbCfg, nil
+	}
+	dbCfg.Password = password
+
+	// The password also has to reach the driver when connecting with a URL
+	if dbCfg.URL != "" {
+		u, err := url.Parse(dbCfg.URL)
+		if err != nil {
+			return dbCfg, fmt.Errorf("invalid database url: %w", err)
+		}
+		username := dbCfg.User
+		if u.User != nil {
+			username = u.User.Username()
+		}
+		u.User = url.UserPassword(username, password)
+		dbCfg.URL = u.String()
+	}
+
+	return dbCfg, nil
+}
+
+// urlHasPassword reports whether a connection URL carries a password
+func urlHasPassword(connURL string) bool {
+	u, err := url.Parse(connURL)
+	if err != nil || u.User == nil {
+		return false
+	}
+
+	_, ok := u.User.Password()
+	return ok
+}
+
+// withParams returns a copy of the connection parameters with extra ones set
+func withParams(params, extra map[string]string) map[string]string {
+	merged := maps.Clone(params)
+	if merged == nil {
+		merged = make(map[string]string, len(extra))
+	}
+	maps.Copy(merged, extra)
+
+	return merged
+}
+
+// withURLParams adds the configured connection parameters to a connection URL
+func withURLParams(connURL string, params map[string]string) (string, error) {
+	if len(params) == 0 {
+		return connURL, nil
+	}
+
+	u, err := url.Parse(connURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid database url: %w", err)
+	}
+
+	query := u.Query()
+	for key, value := range params {
+		query.Set(key, value)
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+// sortedKeys returns the keys of a map in a deterministic order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
 // InitializeTables creates the necessary migration tables if they don't exist
-func InitializeTables(db *sql.DB) error {
-	if _, err := db.Exec(CreateVersionTableSQL); err != nil {
+func InitializeTables(ctx context.Context, db *sql.DB, dialect Dialect) error {
+	if _, err := db.ExecContext(ctx, dialect.CreateVersionTableSQL()); err != nil {
 		return fmt.Errorf("failed to create mig_versions table: %w", err)
 	}
 
-	if _, err := db.Exec(CreateHistoryTableSQL); err != nil {
+	if _, err := db.ExecContext(ctx, dialect.CreateHistoryTableSQL()); err != nil {
 		return fmt.Errorf("failed to create mig_history table: %w", err)
 	}
 
+	// Add the columns introduced since the tables were first created
+	for _, statement := range dialect.UpgradeVersionTableSQL() {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("failed to upgrade mig_versions table: %w", err)
+		}
+	}
+
 	return nil
 }
 
+// IsUnmanaged reports whether the database holds tables but no mig_versions
+// table, which is an existing database mig has not migrated yet. It must run
+// before InitializeTables creates the mig tables.
+func IsUnmanaged(ctx context.Context, db *sql.DB, dialect Dialect) (bool, error) {
+	lister, ok := dialect.(TableLister)
+	if !ok {
+		return false, fmt.Errorf("baselines are not supported by the %s dialect", dialect.Name())
+	}
+
+	tables, err := lister.ListTables(ctx, db)
+	if err != nil {
+		return false, err
+	}
+
+	return len(tables) > 0 && !slices.Contains(tables, "mig_versions"), nil
+}
+
 // GetAppliedMigrations retrieves all applied migrations
-func GetAppliedMigrations(db *sql.DB) ([]MigrationVersion, error) {
-	rows, err := db.Query("SELECT id, version, applied_at FROM mig_versions ORDER BY id")
+func GetAppliedMigrations(ctx context.Context, db *sql.DB) ([]MigrationVersion, error) {
+	rows, err := db.QueryContext(ctx, "SELECT id, version, applied_at, COALESCE(checksum, ''), COALESCE(duration_ms, 0), COALESCE(applied_by, '') FROM mig_versions ORDER BY id")
 	if err != nil {
 		return nil, fmt.Errorf("failed to query applied migrations: %w", err)
 	}
@@ -82,9 +361,11 @@ func GetAppliedMigrations(db *sql.DB) ([]MigrationVersion, error) {
 	var migrations []MigrationVersion
 	for rows.Next() {
 		var m MigrationVersion
-		if err := rows.Scan(&m.ID, &m.Version, &m.AppliedAt); err != nil {
+		var durationMs int64
+		if err := rows.Scan(&m.ID, &m.Version, &m.AppliedAt, &m.Checksum, &durationMs, &m.AppliedBy); err != nil {
 			return nil, fmt.Errorf("failed to scan migration row: %w", err)
 		}
+		m.Duration = time.Duration(durationMs) * time.Millisecond
 		migrations = append(migrations, m)
 	}
 
@@ -95,15 +376,59 @@ func GetAppliedMigrations(db *sql.DB) ([]MigrationVersion, error) {
 	return migrations, nil
 }
 
-// RecordMigration records a successfully applied migration
-func RecordMigration(db *sql.DB, version string, tx *sql.Tx) error {
-	query := "INSERT INTO mig_versions (version) VALUES ($1)"
+// IterHistory streams the history entries with an ID greater than afterID, in
+// order, without loading the whole table in memory
+func IterHistory(ctx context.Context, db *sql.DB, dialect Dialect, afterID int64) iter.Seq2[HistoryEntry, error] {
+	return func(yield func(HistoryEntry, error) bool) {
+		query := fmt.Sprintf("SELECT id, version, command, executed_at FROM mig_history WHERE id > %s ORDER BY id", dialect.Placeholder(1))
+		rows, err := db.QueryContext(ctx, query, afterID)
+		if err != nil {
+			yield(HistoryEntry{}, fmt.Errorf("failed to query migration history: %w", err))
+			return
+		}
+		defer rows.Close() //nolint:errcheck
+
+		for rows.Next() {
+			var entry HistoryEntry
+			if err := rows.Scan(&entry.ID, &entry.Version, &entry.Command, &entry.ExecutedAt); err != nil {
+				yield(HistoryEntry{}, fmt.Errorf("failed to scan history row: %w", err))
+				return
+			}
+
+			if !yield(entry, nil) {
+				return
+			}
+		}
+
+		if err := rows.Err(); err != nil {
+			yield(HistoryEntry{}, fmt.Errorf("error iterating over history: %w", err))
+		}
+	}
+}
+
+// IsApplied reports whether a migration version has been recorded, as seen by the transaction
+func IsApplied(ctx context.Context, tx *sql.Tx, dialect Dialect, version string) (bool, error) {
+	query := fmt.Sprintf("SELECT COUNT(*) FROM mig_versions WHERE version = %s", dialect.Placeholder(1))
+
+	var count int
+	if err := tx.QueryRowContext(ctx, query, version).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to check migration version: %w", err)
+	}
+
+	return count > 0, nil
+}
+
+// RecordMigration records a successfully applied migration with the checksum
+// of its file and its execution time, applied at the given time or at the
+// database's current time when it is zero
+func RecordMigration(ctx context.Context, db *sql.DB, dialect Dialect, version, checksum string, duration time.Duration, appliedAt time.Time, tx *sql.Tx) error {
+	query, args := insertSQL(dialect, "mig_versions", "applied_at", appliedAt, []string{"version", "checksum", "duration_ms"}, version, checksum, duration.Milliseconds())
 
 	var err error
 	if tx != nil {
-		_, err = tx.Exec(query, version)
+		_, err = tx.ExecContext(ctx, query, args...)
 	} else {
-		_, err = db.Exec(query, version)
+		_, err = db.ExecContext(ctx, query, args...)
 	}
 
 	if err != nil {
@@ -113,15 +438,49 @@ func RecordMigration(db *sql.DB, version string, tx *sql.Tx) error {
 	return nil
 }
 
-// RecordHistory records an entry in the migration history with the SQL content
-func RecordHistory(db *sql.DB, version string, sqlContent string, tx *sql.Tx) error {
-	query := "INSERT INTO mig_history (version, command) VALUES ($1, $2)"
+// insertSQL builds an INSERT statement for the given columns, setting the
+// timestamp column only when at is not zero so the database default applies
+func insertSQL(dialect Dialect, table, timestampColumn string, at time.Time, columns []string, args ...any) (string, []any) {
+	if !at.IsZero() {
+		columns = append(slices.Clone(columns), timestampColumn)
+		args = append(args, at)
+	}
+
+	placeholders := make([]string, len(columns))
+	for i := range columns {
+		placeholders[i] = dialect.Placeholder(i + 1)
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(columns, ", "), strings.Join(placeholders, ", "))
+	return query, args
+}
+
+// DumpSchema returns the schema of the database as normalized DDL, when the
+// dialect supports it
+func DumpSchema(ctx context.Context, db *sql.DB, dialect Dialect) (string, error) {
+	dumper, ok := dialect.(SchemaDumper)
+	if !ok {
+		return "", fmt.Errorf("schema dumps are not supported by the %s dialect", dialect.Name())
+	}
+
+	return dumper.DumpSchema(ctx, db)
+}
+
+// QuoteLiteral quotes a string literal the standard SQL way, doubling quotes
+func QuoteLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// RecordHistory records an entry in the migration history with the SQL content,
+// executed at the given time or at the database's current time when it is zero
+func RecordHistory(ctx context.Context, db *sql.DB, dialect Dialect, version string, sqlContent string, executedAt time.Time, tx *sql.Tx) error {
+	query, args := insertSQL(dialect, "mig_history", "executed_at", executedAt, []string{"version", "command"}, version, sqlContent)
 
 	var err error
 	if tx != nil {
-		_, err = tx.Exec(query, version, sqlContent)
+		_, err = tx.ExecContext(ctx, query, args...)
 	} else {
-		_, err = db.Exec(query, version, sqlContent)
+		_, err = db.ExecContext(ctx, query, args...)
 	}
 
 	if err != nil {
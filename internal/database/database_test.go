@@ -1,6 +1,7 @@
 package database_test
 
 import (
+	"context"
 	"database/sql"
 	"os"
 	"testing"
@@ -32,7 +33,7 @@ func getEnvOrDefault(key, defaultValue string) string {
 
 // setupTest prepares the database for testing
 func setupTest(t *testing.T) *sql.DB {
-	db, err := database.Connect(testDBConfig)
+	db, err := database.Connect(context.Background(), testDBConfig)
 	require.NoError(t, err)
 
 	// Drop the tables if they exist to ensure clean state
@@ -47,7 +48,7 @@ func setupTest(t *testing.T) *sql.DB {
 
 func TestConnect(t *testing.T) {
 	t.Run("it should connect to a valid database", func(t *testing.T) {
-		db, err := database.Connect(testDBConfig)
+		db, err := database.Connect(context.Background(), testDBConfig)
 		require.NoError(t, err)
 		require.NotNil(t, db)
 		defer db.Close() //nolint:errcheck
@@ -68,10 +69,59 @@ func TestConnect(t *testing.T) {
 			},
 		}
 
-		db, err := database.Connect(invalidConfig)
+		db, err := database.Connect(context.Background(), invalidConfig)
 		require.Error(t, err)
 		require.Nil(t, db)
 	})
+
+	t.Run("it should create the tracking tables in the configured schema", func(t *testing.T) {
+		admin, err := database.Connect(context.Background(), testDBConfig)
+		require.NoError(t, err)
+		defer admin.Close() //nolint:errcheck
+
+		_, err = admin.Exec("CREATE SCHEMA IF NOT EXISTS mig_app")
+		require.NoError(t, err)
+		defer admin.Exec("DROP SCHEMA IF EXISTS mig_app CASCADE") //nolint:errcheck
+
+		cfg := *testDBConfig
+		cfg.Database.Schema = "mig_app"
+
+		db, err := database.Connect(context.Background(), &cfg)
+		require.NoError(t, err)
+		defer db.Close() //nolint:errcheck
+
+		err = database.InitializeTables(context.Background(), db, database.Postgres{})
+		require.NoError(t, err)
+
+		var exists bool
+		err = admin.QueryRow("SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_schema = 'mig_app' AND table_name = 'mig_versions')").Scan(&exists)
+		require.NoError(t, err)
+		require.True(t, exists)
+	})
+
+	t.Run("it should create a missing database", func(t *testing.T) {
+		admin, err := database.Connect(context.Background(), testDBConfig)
+		require.NoError(t, err)
+		defer admin.Close() //nolint:errcheck
+
+		_, err = admin.Exec("DROP DATABASE IF EXISTS mig_create_test")
+		require.NoError(t, err)
+		defer admin.Exec("DROP DATABASE IF EXISTS mig_create_test") //nolint:errcheck
+
+		cfg := *testDBConfig
+		cfg.Database.Name = "mig_create_test"
+		cfg.Database.CreateIfMissing = true
+		cfg.Database.Create.Encoding = "UTF8"
+
+		db, err := database.Connect(context.Background(), &cfg)
+		require.NoError(t, err)
+		require.NoError(t, db.Close())
+
+		// Connecting again finds the database created
+		db, err = database.Connect(context.Background(), &cfg)
+		require.NoError(t, err)
+		require.NoError(t, db.Close())
+	})
 }
 
 func TestInitializeTables(t *testing.T) {
@@ -79,7 +129,7 @@ func TestInitializeTables(t *testing.T) {
 	defer db.Close() //nolint:errcheck
 
 	t.Run("it should create migration tables if they don't exist", func(t *testing.T) {
-		err := database.InitializeTables(db)
+		err := database.InitializeTables(context.Background(), db, database.Postgres{})
 		require.NoError(t, err)
 
 		// Verify tables were created
@@ -95,8 +145,30 @@ func TestInitializeTables(t *testing.T) {
 
 	t.Run("it should not fail if tables already exist", func(t *testing.T) {
 		// First initialization should already be done
-		err := database.InitializeTables(db)
+		err := database.InitializeTables(context.Background(), db, database.Postgres{})
+		require.NoError(t, err)
+	})
+}
+
+func TestUpgradeTables(t *testing.T) {
+	db := setupTest(t)
+	defer db.Close() //nolint:errcheck
+
+	t.Run("it should add the new columns to a table created by an older version", func(t *testing.T) {
+		_, err := db.Exec("CREATE TABLE mig_versions (id SERIAL PRIMARY KEY, version VARCHAR(255) NOT NULL UNIQUE, applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW())")
+		require.NoError(t, err)
+
+		_, err = db.Exec("INSERT INTO mig_versions (version) VALUES ('001')")
+		require.NoError(t, err)
+
+		err = database.InitializeTables(context.Background(), db, database.Postgres{})
+		require.NoError(t, err)
+
+		migrations, err := database.GetAppliedMigrations(context.Background(), db)
 		require.NoError(t, err)
+		require.Len(t, migrations, 1)
+		require.Empty(t, migrations[0].Checksum)
+		require.Zero(t, migrations[0].Duration)
 	})
 }
 
@@ -105,11 +177,11 @@ func TestGetAppliedMigrations(t *testing.T) {
 	defer db.Close() //nolint:errcheck
 
 	// Initialize tables for the test
-	err := database.InitializeTables(db)
+	err := database.InitializeTables(context.Background(), db, database.Postgres{})
 	require.NoError(t, err)
 
 	t.Run("it should return empty slice when no migrations are applied", func(t *testing.T) {
-		migrations, err := database.GetAppliedMigrations(db)
+		migrations, err := database.GetAppliedMigrations(context.Background(), db)
 		require.NoError(t, err)
 		require.Empty(t, migrations)
 	})
@@ -122,7 +194,7 @@ func TestGetAppliedMigrations(t *testing.T) {
 		_, err = db.Exec("INSERT INTO mig_versions (version, applied_at) VALUES ('002', $1)", time.Now().Add(-1*time.Hour))
 		require.NoError(t, err)
 
-		migrations, err := database.GetAppliedMigrations(db)
+		migrations, err := database.GetAppliedMigrations(context.Background(), db)
 		require.NoError(t, err)
 		require.Len(t, migrations, 2)
 		require.Equal(t, "001", migrations[0].Version)
@@ -135,11 +207,11 @@ func TestRecordMigration(t *testing.T) {
 	defer db.Close() //nolint:errcheck
 
 	// Initialize tables for the test
-	err := database.InitializeTables(db)
+	err := database.InitializeTables(context.Background(), db, database.Postgres{})
 	require.NoError(t, err)
 
 	t.Run("it should record migration without transaction", func(t *testing.T) {
-		err := database.RecordMigration(db, "001", nil)
+		err := database.RecordMigration(context.Background(), db, database.Postgres{}, "001", "", 0, time.Time{}, nil)
 		require.NoError(t, err)
 
 		// Verify the migration was recorded
@@ -149,11 +221,37 @@ func TestRecordMigration(t *testing.T) {
 		require.Equal(t, "001", version)
 	})
 
+	t.Run("it should record the checksum and duration", func(t *testing.T) {
+		err := database.RecordMigration(context.Background(), db, database.Postgres{}, "004", "abc123", 1500*time.Millisecond, time.Time{}, nil)
+		require.NoError(t, err)
+
+		migrations, err := database.GetAppliedMigrations(context.Background(), db)
+		require.NoError(t, err)
+
+		recorded := migrations[len(migrations)-1]
+		require.Equal(t, "004", recorded.Version)
+		require.Equal(t, "abc123", recorded.Checksum)
+		require.Equal(t, 1500*time.Millisecond, recorded.Duration)
+	})
+
+	t.Run("it should record the given applied time", func(t *testing.T) {
+		appliedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		err := database.RecordMigration(context.Background(), db, database.Postgres{}, "005", "", 0, appliedAt, nil)
+		require.NoError(t, err)
+
+		migrations, err := database.GetAppliedMigrations(context.Background(), db)
+		require.NoError(t, err)
+
+		recorded := migrations[len(migrations)-1]
+		require.Equal(t, "005", recorded.Version)
+		require.True(t, appliedAt.Equal(recorded.AppliedAt))
+	})
+
 	t.Run("it should record migration with transaction", func(t *testing.T) {
 		tx, err := db.Begin()
 		require.NoError(t, err)
 
-		err = database.RecordMigration(db, "002", tx)
+		err = database.RecordMigration(context.Background(), db, database.Postgres{}, "002", "", 0, time.Time{}, tx)
 		require.NoError(t, err)
 
 		err = tx.Commit()
@@ -170,7 +268,7 @@ func TestRecordMigration(t *testing.T) {
 		tx, err := db.Begin()
 		require.NoError(t, err)
 
-		err = database.RecordMigration(db, "003", tx)
+		err = database.RecordMigration(context.Background(), db, database.Postgres{}, "003", "", 0, time.Time{}, tx)
 		require.NoError(t, err)
 
 		err = tx.Rollback()
@@ -188,11 +286,11 @@ func TestRecordHistory(t *testing.T) {
 	defer db.Close() //nolint:errcheck
 
 	// Initialize tables for the test
-	err := database.InitializeTables(db)
+	err := database.InitializeTables(context.Background(), db, database.Postgres{})
 	require.NoError(t, err)
 
 	t.Run("it should record migration history without transaction", func(t *testing.T) {
-		err := database.RecordHistory(db, "001", "CREATE TABLE test (id INT)", nil)
+		err := database.RecordHistory(context.Background(), db, database.Postgres{}, "001", "CREATE TABLE test (id INT)", time.Time{}, nil)
 		require.NoError(t, err)
 
 		// Verify the history was recorded
@@ -207,7 +305,7 @@ func TestRecordHistory(t *testing.T) {
 		tx, err := db.Begin()
 		require.NoError(t, err)
 
-		err = database.RecordHistory(db, "002", "ALTER TABLE test ADD COLUMN name TEXT", tx)
+		err = database.RecordHistory(context.Background(), db, database.Postgres{}, "002", "ALTER TABLE test ADD COLUMN name TEXT", time.Time{}, tx)
 		require.NoError(t, err)
 
 		err = tx.Commit()
@@ -225,7 +323,7 @@ func TestRecordHistory(t *testing.T) {
 		tx, err := db.Begin()
 		require.NoError(t, err)
 
-		err = database.RecordHistory(db, "003", "DROP TABLE test", tx)
+		err = database.RecordHistory(context.Background(), db, database.Postgres{}, "003", "DROP TABLE test", time.Time{}, tx)
 		require.NoError(t, err)
 
 		err = tx.Rollback()
@@ -237,3 +335,33 @@ func TestRecordHistory(t *testing.T) {
 		require.Error(t, err, "Query should fail because history should not exist after rollback")
 	})
 }
+
+func TestIterHistory(t *testing.T) {
+	db := setupTest(t)
+	defer db.Close() //nolint:errcheck
+
+	err := database.InitializeTables(context.Background(), db, database.Postgres{})
+	require.NoError(t, err)
+
+	for _, version := range []string{"001", "002", "003"} {
+		err := database.RecordHistory(context.Background(), db, database.Postgres{}, version, "SELECT 1;", time.Time{}, nil)
+		require.NoError(t, err)
+	}
+
+	t.Run("it should stream the entries after the given ID", func(t *testing.T) {
+		var entries []database.HistoryEntry
+		for entry, err := range database.IterHistory(context.Background(), db, database.Postgres{}, 0) {
+			require.NoError(t, err)
+			entries = append(entries, entry)
+		}
+		require.Len(t, entries, 3)
+		require.Equal(t, "001", entries[0].Version)
+
+		var versions []string
+		for entry, err := range database.IterHistory(context.Background(), db, database.Postgres{}, entries[0].ID) {
+			require.NoError(t, err)
+			versions = append(versions, entry.Version)
+		}
+		require.Equal(t, []string{"002", "003"}, versions)
+	})
+}
@@ -0,0 +1,270 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arthurdotwork/mig/internal/config"
+)
+
+// Dialect describes how mig talks to a specific database engine
+type Dialect interface {
+	// Name returns the name used to select the dialect in the configuration
+	Name() string
+
+	// DriverName returns the database/sql driver name used to open connections
+	DriverName() string
+
+	// ConnectionString builds the driver connection string from the configuration
+	ConnectionString(cfg config.DatabaseConfig) string
+
+	// QuoteIdentifier quotes a table or column name
+	QuoteIdentifier(name string) string
+
+	// Placeholder returns the bind parameter for the n-th (1-based) argument
+	Placeholder(n int) string
+
+	// CreateVersionTableSQL returns the statement creating the mig_versions table
+	CreateVersionTableSQL() string
+
+	// CreateHistoryTableSQL returns the statement creating the mig_history table
+	CreateHistoryTableSQL() string
+
+	// UpgradeVersionTableSQL returns the idempotent statements adding the
+	// columns introduced since mig_versions was first created
+	UpgradeVersionTableSQL() []string
+
+	// RecordMigrationSQL returns the statement of scripts recording an applied
+	// migration in mig_versions with its values inlined, filling in the
+	// columns a run records
+	RecordMigrationSQL(version, checksum string) string
+
+	// RecordHistorySQL returns the statement of scripts recording an entry of
+	// mig_history with its values inlined
+	RecordHistorySQL(version, command string) string
+
+	// SupportsTransactionalDDL reports whether schema changes can be rolled back
+	SupportsTransactionalDDL() bool
+
+	// SplitStatements splits a migration into the batches sent to the server
+	SplitStatements(content string) []string
+
+	// Lock takes the migration lock on the given connection, blocking until it
+	// is available, so that concurrent runners apply migrations one at a time
+	Lock(ctx context.Context, conn *sql.Conn) error
+
+	// Unlock releases the migration lock held by the given connection
+	Unlock(ctx context.Context, conn *sql.Conn) error
+}
+
+// TxLocker is implemented by dialects that can hold the migration lock for the
+// duration of a transaction, which is used instead of the session lock when
+// connections are pooled per transaction (see the pgbouncer setting)
+type TxLocker interface {
+	// LockTx takes the migration lock until the transaction ends
+	LockTx(ctx context.Context, tx *sql.Tx) error
+}
+
+// StatementTimeouter is implemented by dialects that can bound the duration of
+// the statements of a transaction, which scripts use to mirror the
+// per-migration timeout
+type StatementTimeouter interface {
+	// StatementTimeoutSQL returns the statement limiting the duration of the
+	// following statements of the current transaction
+	StatementTimeoutSQL(timeout time.Duration) string
+}
+
+// ScriptTimer is implemented by dialects that can measure the duration of a
+// migration run from a script, which RecordMigrationSQL then records
+type ScriptTimer interface {
+	// StartTimerSQL returns the statement starting the timer of the session
+	StartTimerSQL() string
+}
+
+// DatabaseCreator is implemented by dialects that can create the configured
+// database when it does not exist yet, see the create_if_missing setting
+type DatabaseCreator interface {
+	// MaintenanceDatabase returns the database connected to for creating
+	// others, which always exists on the server
+	MaintenanceDatabase() string
+
+	// CreateDatabase creates the named database unless it exists, and reports
+	// whether it did
+	CreateDatabase(ctx context.Context, db *sql.DB, name string, create config.CreateDatabaseConfig) (bool, error)
+}
+
+// Shadower is implemented by dialects that can create a throwaway database
+// to rehearse migrations on before applying them to the real one
+type Shadower interface {
+	DatabaseCreator
+
+	// CreateShadow creates the named database as a copy of template, or
+	// empty when template is ""
+	CreateShadow(ctx context.Context, db *sql.DB, name, template string) error
+
+	// DropShadow drops the named database
+	DropShadow(ctx context.Context, db *sql.DB, name string) error
+}
+
+// SchemaDumper is implemented by dialects that can dump the schema of a
+// database as normalized DDL, for snapshots compared across runs
+type SchemaDumper interface {
+	// DumpSchema returns the schema of the database, excluding the mig tables,
+	// in a deterministic order
+	DumpSchema(ctx context.Context, db *sql.DB) (string, error)
+}
+
+// ServerVersioner is implemented by dialects that can report the major
+// version of the server, checked against the "-- mig:min-pg=" directive of
+// migrations before they are applied
+type ServerVersioner interface {
+	// ServerVersion returns the major version of the server, such as 16
+	ServerVersion(ctx context.Context, db *sql.DB) (int, error)
+}
+
+// RoleSetter is implemented by dialects that can run the statements of a
+// session as another role, see the "-- mig:role=" directive
+type RoleSetter interface {
+	// SetRoleSQL returns the statement switching the session to role
+	SetRoleSQL(role string) string
+
+	// ResetRoleSQL returns the statement switching the session back to the
+	// connecting user
+	ResetRoleSQL() string
+}
+
+// SearchPathSetter is implemented by dialects that can change the schemas
+// unqualified names resolve in, see the "-- mig:search_path=" directive
+type SearchPathSetter interface {
+	// SetSearchPathSQL returns the statement setting the search path to
+	// schemas, for the current transaction only when local is set
+	SetSearchPathSQL(schemas []string, local bool) string
+
+	// ResetSearchPathSQL returns the statement restoring the search path of
+	// the connection
+	ResetSearchPathSQL() string
+}
+
+// GrantChecker is implemented by dialects that can tell which privileges a
+// role lacks, see the access setting
+type GrantChecker interface {
+	// MissingGrants describes each privilege of grant the role lacks
+	MissingGrants(ctx context.Context, q Querier, grant config.GrantConfig) ([]string, error)
+}
+
+// Analyzer is implemented by dialects that can refresh the planner
+// statistics of a table, see the analyze setting
+type Analyzer interface {
+	// AnalyzeSQL returns the statement refreshing the statistics of table
+	AnalyzeSQL(table string) string
+}
+
+// Explainer is implemented by dialects that can estimate the rows a
+// statement would touch without executing it, see Migrator.Explain
+type Explainer interface {
+	// EstimateRows returns the number of rows the planner expects statement
+	// to insert, update or delete
+	EstimateRows(ctx context.Context, q Querier, statement string) (int64, error)
+}
+
+// TableSize is the estimated size of a table
+type TableSize struct {
+	Rows  int64 // Rows estimated from the planner statistics
+	Bytes int64 // Size on disk, with its indexes and TOAST data
+}
+
+// TableSizer is implemented by dialects that can estimate the size of
+// tables without scanning them, see Migrator.AnalyzeLocks
+type TableSizer interface {
+	// TableSizes returns the size of the tables, by name, leaving out those
+	// that do not exist
+	TableSizes(ctx context.Context, db *sql.DB, tables []string) (map[string]TableSize, error)
+}
+
+// TableLister is implemented by dialects that can list the tables of a
+// database, to tell an existing database adopted by mig from a new one, see
+// the baseline_version setting
+type TableLister interface {
+	// ListTables returns the names of the tables of the schema unqualified
+	// names resolve in
+	ListTables(ctx context.Context, db *sql.DB) ([]string, error)
+}
+
+// Backuper is implemented by dialects that can dump a whole database to a
+// file and restore it, see the backup setting. The password is passed to the
+// commands through the environment so it does not show in the process list.
+type Backuper interface {
+	// Backup runs the backup command to write a dump of the database at
+	// connStr to path
+	Backup(ctx context.Context, command, connStr, password, path string) error
+
+	// Restore runs the restore command to restore the dump at path into the
+	// database at connStr
+	Restore(ctx context.Context, command, connStr, password, path string) error
+}
+
+// ErrorPositioner is implemented by dialects whose errors locate the failure
+// in the statement, so a failed migration is reported on the line at fault
+// rather than where its statement starts
+type ErrorPositioner interface {
+	// ErrorPosition returns the 1-based character offset of the failure in
+	// the statement, 0 when err does not tell
+	ErrorPosition(err error) int
+}
+
+// LockName identifies the migration lock for dialects that use named locks
+const LockName = "mig"
+
+var (
+	dialectsMu sync.RWMutex
+	dialects   = make(map[string]Dialect)
+)
+
+// RegisterDialect makes a dialect available by name, replacing any previous registration
+func RegisterDialect(d Dialect) {
+	dialectsMu.Lock()
+	defer dialectsMu.Unlock()
+
+	dialects[strings.ToLower(d.Name())] = d
+	config.RegisterDriver(d.Name())
+}
+
+// GetDialect returns the dialect registered under the given name
+func GetDialect(name string) (Dialect, error) {
+	if name == "" {
+		name = config.DefaultDriver
+	}
+
+	dialectsMu.RLock()
+	defer dialectsMu.RUnlock()
+
+	d, ok := dialects[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported database driver %q (available: %s)", name, strings.Join(dialectNames(), ", "))
+	}
+
+	return d, nil
+}
+
+// dialectNames returns the sorted names of the registered dialects
+func dialectNames() []string {
+	names := make([]string, 0, len(dialects))
+	for name := range dialects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func init() {
+	RegisterDialect(Postgres{})
+	RegisterDialect(Pgx{})
+	RegisterDialect(SQLServer{})
+	RegisterDialect(ClickHouse{})
+}
@@ -0,0 +1,150 @@
+package database
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Changes of a schema object between a snapshot and the live database
+const (
+	ChangeMissing    = "missing"    // In the snapshot but not in the database
+	ChangeUnexpected = "unexpected" // In the database but not in the snapshot
+	ChangeChanged    = "changed"    // In both with a different definition
+)
+
+// SchemaChange is a difference between a schema snapshot and the live schema
+type SchemaChange struct {
+	Kind   string // type, table, column, constraint, index, view or statement
+	Object string // Qualified name of the object, such as public.users.email
+	Change string // ChangeMissing, ChangeUnexpected or ChangeChanged
+	Want   string // Definition in the snapshot, empty when missing from it
+	Got    string // Definition in the database, empty when missing from it
+}
+
+// String formats the change as "- kind name" for missing objects, "+ kind
+// name" for unexpected ones and "~ kind name" for changed ones, followed by
+// the definitions when they fit on a line
+func (c SchemaChange) String() string {
+	prefix := map[string]string{ChangeMissing: "-", ChangeUnexpected: "+", ChangeChanged: "~"}[c.Change]
+	line := fmt.Sprintf("%s %s %s", prefix, c.Kind, c.Object)
+
+	if strings.Contains(c.Want, "\n") || strings.Contains(c.Got, "\n") {
+		return line
+	}
+
+	switch c.Change {
+	case ChangeMissing:
+		return line + ": " + c.Want
+	case ChangeUnexpected:
+		return line + ": " + c.Got
+	default:
+		return line + ": " + c.Want + " -> " + c.Got
+	}
+}
+
+var (
+	typeStatement       = regexp.MustCompile(`^CREATE TYPE (\S+) AS`)
+	tableStatement      = regexp.MustCompile(`^CREATE TABLE (\S+) \(`)
+	constraintStatement = regexp.MustCompile(`^ALTER TABLE (\S+) ADD CONSTRAINT (\S+) `)
+	indexStatement      = regexp.MustCompile(`^CREATE (?:UNIQUE )?INDEX (\S+) ON (?:ONLY )?(\S+) `)
+	viewStatement       = regexp.MustCompile(`^CREATE (?:MATERIALIZED )?VIEW (\S+) AS`)
+)
+
+// schemaObject is a statement of a schema dump
+type schemaObject struct {
+	kind    string
+	name    string
+	ddl     string
+	columns []schemaObject // Columns of a table
+}
+
+// key identifies the object across dumps
+func (o schemaObject) key() string {
+	return o.kind + " " + o.name
+}
+
+// parseSchema splits a dump of DumpSchema into its objects, in order
+func parseSchema(dump string) []schemaObject {
+	var objects []schemaObject
+	for _, ddl := range strings.Split(strings.TrimSpace(dump), "\n\n") {
+		if ddl = strings.TrimSpace(ddl); ddl != "" {
+			objects = append(objects, parseObject(ddl))
+		}
+	}
+
+	return objects
+}
+
+// parseObject identifies the object a statement defines
+func parseObject(ddl string) schemaObject {
+	if match := tableStatement.FindStringSubmatch(ddl); match != nil {
+		table := schemaObject{kind: "table", name: match[1], ddl: ddl}
+		lines := strings.Split(ddl, "\n")
+		for _, line := range lines[1 : len(lines)-1] {
+			column := strings.TrimSuffix(strings.TrimSpace(line), ",")
+			name, _, _ := strings.Cut(column, " ")
+			table.columns = append(table.columns, schemaObject{kind: "column", name: match[1] + "." + name, ddl: column})
+		}
+		return table
+	}
+
+	if match := constraintStatement.FindStringSubmatch(ddl); match != nil {
+		return schemaObject{kind: "constraint", name: match[1] + "." + match[2], ddl: ddl}
+	}
+
+	if match := indexStatement.FindStringSubmatch(ddl); match != nil {
+		schema, _, _ := strings.Cut(match[2], ".")
+		return schemaObject{kind: "index", name: schema + "." + match[1], ddl: ddl}
+	}
+
+	if match := typeStatement.FindStringSubmatch(ddl); match != nil {
+		return schemaObject{kind: "type", name: match[1], ddl: ddl}
+	}
+
+	if match := viewStatement.FindStringSubmatch(ddl); match != nil {
+		return schemaObject{kind: "view", name: match[1], ddl: ddl}
+	}
+
+	return schemaObject{kind: "statement", name: strings.SplitN(ddl, "\n", 2)[0], ddl: ddl}
+}
+
+// DiffSchema compares a schema snapshot to the live schema, both dumped by
+// DumpSchema, and returns the objects that differ. The columns of a table
+// present in both are compared one by one.
+func DiffSchema(want, got string) []SchemaChange {
+	return diffObjects(parseSchema(want), parseSchema(got))
+}
+
+// diffObjects returns the changes between two lists of objects, in the order
+// of want followed by the unexpected objects of got
+func diffObjects(want, got []schemaObject) []SchemaChange {
+	gotByKey := make(map[string]schemaObject, len(got))
+	for _, object := range got {
+		gotByKey[object.key()] = object
+	}
+
+	var changes []SchemaChange
+	wanted := make(map[string]bool, len(want))
+	for _, w := range want {
+		wanted[w.key()] = true
+
+		g, ok := gotByKey[w.key()]
+		switch {
+		case !ok:
+			changes = append(changes, SchemaChange{Kind: w.kind, Object: w.name, Change: ChangeMissing, Want: w.ddl})
+		case w.kind == "table":
+			changes = append(changes, diffObjects(w.columns, g.columns)...)
+		case w.ddl != g.ddl:
+			changes = append(changes, SchemaChange{Kind: w.kind, Object: w.name, Change: ChangeChanged, Want: w.ddl, Got: g.ddl})
+		}
+	}
+
+	for _, g := range got {
+		if !wanted[g.key()] {
+			changes = append(changes, SchemaChange{Kind: g.kind, Object: g.name, Change: ChangeUnexpected, Got: g.ddl})
+		}
+	}
+
+	return changes
+}
@@ -0,0 +1,53 @@
+package database_test
+
+import (
+	"testing"
+
+	"github.com/arthurdotwork/mig/internal/database"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffSchema(t *testing.T) {
+	snapshot := `CREATE TABLE public.users (
+    id integer NOT NULL,
+    email text NOT NULL,
+    name text
+);
+
+ALTER TABLE public.users ADD CONSTRAINT users_pkey PRIMARY KEY (id);
+
+CREATE INDEX users_email_idx ON public.users USING btree (email);
+`
+
+	t.Run("it should find no change in an identical schema", func(t *testing.T) {
+		require.Empty(t, database.DiffSchema(snapshot, snapshot))
+	})
+
+	t.Run("it should report the changed objects", func(t *testing.T) {
+		live := `CREATE TABLE public.users (
+    id integer NOT NULL,
+    email character varying(255) NOT NULL,
+    nickname text
+);
+
+ALTER TABLE public.users ADD CONSTRAINT users_pkey PRIMARY KEY (id);
+
+CREATE TABLE public.audit (
+    id integer
+);
+`
+
+		var lines []string
+		for _, change := range database.DiffSchema(snapshot, live) {
+			lines = append(lines, change.String())
+		}
+
+		require.Equal(t, []string{
+			"~ column public.users.email: email text NOT NULL -> email character varying(255) NOT NULL",
+			"- column public.users.name: name text",
+			"+ column public.users.nickname: nickname text",
+			"- index public.users_email_idx: CREATE INDEX users_email_idx ON public.users USING btree (email);",
+			"+ table public.audit",
+		}, lines)
+	})
+}
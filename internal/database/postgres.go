@@ -0,0 +1,400 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/arthurdotwork/mig/internal/config"
+	"github.com/lib/pq"
+)
+
+// Postgres is the PostgreSQL dialect
+type Postgres struct{}
+
+// pgBouncerParams are the driver parameters avoiding named prepared
+// statements and multi round trip queries, which PgBouncer cannot route to a
+// single server connection in transaction pooling mode
+var pgBouncerParams = map[string]map[string]string{
+	"postgres": {"binary_parameters": "yes"},
+	"pgx":      {"default_query_exec_mode": "simple_protocol"},
+}
+
+// advisoryLockKey is the pg_advisory_lock key guarding migration runs ("mig" in ASCII)
+const advisoryLockKey int64 = 0x6d6967
+
+// Name returns the name of the dialect
+func (Postgres) Name() string {
+	return "postgres"
+}
+
+// DriverName returns the database/sql driver name
+func (Postgres) DriverName() string {
+	return "postgres"
+}
+
+// ConnectionString builds a libpq key/value connection string
+//
+// A host starting with "/" is a Unix socket directory. The user and password
+// are left out when empty so the driver falls back to the operating system
+// user, which is what peer authentication over sockets expects.
+func (Postgres) ConnectionString(cfg config.DatabaseConfig) string {
+	params := []string{
+		"host=" + quoteConnValue(cfg.Host),
+		fmt.Sprintf("port=%d", cfg.Port),
+		"dbname=" + quoteConnValue(cfg.Name),
+	}
+
+	if cfg.User != "" {
+		params = append(params, "user="+quoteConnValue(cfg.User))
+	}
+
+	if cfg.Password != "" {
+		params = append(params, "password="+quoteConnValue(cfg.Password))
+	}
+
+	params = append(params, "sslmode="+quoteConnValue(cfg.SSLMode))
+
+	if cfg.ConnectTimeout > 0 {
+		params = append(params, fmt.Sprintf("connect_timeout=%d", cfg.ConnectTimeout))
+	}
+
+	if cfg.ApplicationName != "" {
+		params = append(params, "application_name="+quoteConnValue(cfg.ApplicationName))
+	}
+
+	for _, tls := range [][2]string{
+		{"sslcert", cfg.SSLCert},
+		{"sslkey", cfg.SSLKey},
+		{"sslpassword", cfg.SSLPassword},
+		{"sslrootcert", cfg.SSLRootCert},
+	} {
+		if tls[1] != "" {
+			params = append(params, tls[0]+"="+quoteConnValue(tls[1]))
+		}
+	}
+
+	for _, key := range sortedKeys(cfg.Params) {
+		params = append(params, key+"="+quoteConnValue(cfg.Params[key]))
+	}
+
+	return strings.Join(params, " ")
+}
+
+// quoteConnValue quotes a libpq connection string value when it is empty or
+// contains spaces, quotes or backslashes
+func quoteConnValue(value string) string {
+	if value != "" && !strings.ContainsAny(value, ` '\`) {
+		return value
+	}
+
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `'`, `\'`)
+
+	return "'" + value + "'"
+}
+
+// QuoteIdentifier quotes an identifier with double quotes
+func (Postgres) QuoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// Placeholder returns a positional $n parameter
+func (Postgres) Placeholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+// CreateVersionTableSQL returns the statement creating the mig_versions table
+func (Postgres) CreateVersionTableSQL() string {
+	return CreateVersionTableSQL
+}
+
+// CreateHistoryTableSQL returns the statement creating the mig_history table
+func (Postgres) CreateHistoryTableSQL() string {
+	return CreateHistoryTableSQL
+}
+
+// UpgradeVersionTableSQL returns the statements adding the checksum,
+// duration and applied_by columns. The default of applied_by is set apart
+// so the existing records are not attributed to the current user.
+func (Postgres) UpgradeVersionTableSQL() []string {
+	return []string{
+		"ALTER TABLE mig_versions ADD COLUMN IF NOT EXISTS checksum VARCHAR(64)",
+		"ALTER TABLE mig_versions ADD COLUMN IF NOT EXISTS duration_ms BIGINT",
+		"ALTER TABLE mig_versions ADD COLUMN IF NOT EXISTS applied_by VARCHAR(255)",
+		"ALTER TABLE mig_versions ALTER COLUMN applied_by SET DEFAULT current_user",
+	}
+}
+
+// RecordMigrationSQL returns the INSERT recording a migration as the current
+// user, with the time elapsed since StartTimerSQL as its duration
+func (Postgres) RecordMigrationSQL(version, checksum string) string {
+	return fmt.Sprintf("INSERT INTO mig_versions (version, checksum, duration_ms, applied_by) VALUES (%s, %s, %s, current_user)",
+		QuoteLiteral(version), QuoteLiteral(checksum),
+		"(EXTRACT(EPOCH FROM clock_timestamp() - current_setting('mig.started_at')::timestamptz) * 1000)::bigint")
+}
+
+// RecordHistorySQL returns the INSERT recording a history entry
+func (Postgres) RecordHistorySQL(version, command string) string {
+	return fmt.Sprintf("INSERT INTO mig_history (version, command) VALUES (%s, %s)", QuoteLiteral(version), QuoteLiteral(command))
+}
+
+// StartTimerSQL returns the statement keeping the current time in the
+// mig.started_at setting of the session, outside of any transaction
+func (Postgres) StartTimerSQL() string {
+	return "SELECT set_config('mig.started_at', clock_timestamp()::text, false)"
+}
+
+// SupportsTransactionalDDL reports that PostgreSQL DDL is transactional
+func (Postgres) SupportsTransactionalDDL() bool {
+	return true
+}
+
+// SplitStatements returns the migration as a single batch
+func (Postgres) SplitStatements(content string) []string {
+	return []string{content}
+}
+
+// StatementTimeoutSQL returns a SET LOCAL statement_timeout statement
+func (Postgres) StatementTimeoutSQL(timeout time.Duration) string {
+	return fmt.Sprintf("SET LOCAL statement_timeout = %d", timeout.Milliseconds())
+}
+
+// Lock takes a session-level advisory lock
+func (Postgres) Lock(ctx context.Context, conn *sql.Conn) error {
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", advisoryLockKey); err != nil {
+		return fmt.Errorf("failed to acquire advisory lock: %w", err)
+	}
+
+	return nil
+}
+
+// LockTx takes a transaction-level advisory lock, released on commit or rollback
+func (Postgres) LockTx(ctx context.Context, tx *sql.Tx) error {
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", advisoryLockKey); err != nil {
+		return fmt.Errorf("failed to acquire advisory lock: %w", err)
+	}
+
+	return nil
+}
+
+// Unlock releases the session-level advisory lock
+func (Postgres) Unlock(ctx context.Context, conn *sql.Conn) error {
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", advisoryLockKey); err != nil {
+		return fmt.Errorf("failed to release advisory lock: %w", err)
+	}
+
+	return nil
+}
+
+// MaintenanceDatabase returns the default PostgreSQL database
+func (Postgres) MaintenanceDatabase() string {
+	return "postgres"
+}
+
+// CreateDatabase creates the named database unless it exists
+func (p Postgres) CreateDatabase(ctx context.Context, db *sql.DB, name string, create config.CreateDatabaseConfig) (bool, error) {
+	exists := func() (bool, error) {
+		var exists bool
+		if err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname = $1)", name).Scan(&exists); err != nil {
+			return false, fmt.Errorf("failed to check whether database %s exists: %w", name, err)
+		}
+		return exists, nil
+	}
+
+	if ok, err := exists(); err != nil || ok {
+		return false, err
+	}
+
+	statement := "CREATE DATABASE " + p.QuoteIdentifier(name)
+	if create.Owner != "" {
+		statement += " OWNER " + p.QuoteIdentifier(create.Owner)
+	}
+	if create.Encoding != "" {
+		statement += " ENCODING " + QuoteLiteral(create.Encoding)
+	}
+
+	if _, err := db.ExecContext(ctx, statement); err != nil {
+		// Another runner may have created it in the meantime
+		if ok, existsErr := exists(); existsErr == nil && ok {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to create database %s: %w", name, err)
+	}
+
+	return true, nil
+}
+
+// ServerVersion returns the major version from server_version_num, such as
+// 16 for 160002
+func (Postgres) ServerVersion(ctx context.Context, db *sql.DB) (int, error) {
+	var num int
+	if err := db.QueryRowContext(ctx, "SELECT current_setting('server_version_num')::int").Scan(&num); err != nil {
+		return 0, fmt.Errorf("failed to query the server version: %w", err)
+	}
+
+	return num / 10000, nil
+}
+
+// SetRoleSQL returns the SET ROLE statement of role
+func (p Postgres) SetRoleSQL(role string) string {
+	return "SET ROLE " + p.QuoteIdentifier(role)
+}
+
+// ResetRoleSQL returns the RESET ROLE statement
+func (Postgres) ResetRoleSQL() string {
+	return "RESET ROLE"
+}
+
+// SetSearchPathSQL returns the SET statement of the search path, SET LOCAL
+// when local is set
+func (p Postgres) SetSearchPathSQL(schemas []string, local bool) string {
+	quoted := make([]string, len(schemas))
+	for i, schema := range schemas {
+		quoted[i] = p.QuoteIdentifier(schema)
+	}
+
+	scope := ""
+	if local {
+		scope = "LOCAL "
+	}
+
+	return "SET " + scope + "search_path TO " + strings.Join(quoted, ", ")
+}
+
+// ResetSearchPathSQL returns the RESET statement of the search path
+func (Postgres) ResetSearchPathSQL() string {
+	return "RESET search_path"
+}
+
+// AnalyzeSQL returns the ANALYZE statement of table
+func (Postgres) AnalyzeSQL(table string) string {
+	return "ANALYZE " + table
+}
+
+// ErrorPosition returns the position reported by the server with a lib/pq
+// error, the errors of pgx do not tell
+func (Postgres) ErrorPosition(err error) int {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return 0
+	}
+
+	position, _ := strconv.Atoi(pqErr.Position)
+	return position
+}
+
+// explainPlan is a node of the plan returned by EXPLAIN (FORMAT JSON)
+type explainPlan struct {
+	NodeType string        `json:"Node Type"`
+	PlanRows float64       `json:"Plan Rows"`
+	Plans    []explainPlan `json:"Plans"`
+}
+
+// EstimateRows returns the rows estimated by EXPLAIN, without ANALYZE so the
+// statement is planned but not executed. The ModifyTable node of INSERT,
+// UPDATE and DELETE estimates the rows it returns, so the rows of the node
+// feeding it are reported instead.
+func (Postgres) EstimateRows(ctx context.Context, q Querier, statement string) (int64, error) {
+	rows, err := q.QueryContext(ctx, "EXPLAIN (FORMAT JSON) "+statement)
+	if err != nil {
+		return 0, fmt.Errorf("failed to explain statement: %w", err)
+	}
+	defer rows.Close() //nolint:errcheck
+
+	var output []byte
+	if rows.Next() {
+		if err := rows.Scan(&output); err != nil {
+			return 0, fmt.Errorf("failed to scan explain output: %w", err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to explain statement: %w", err)
+	}
+
+	var explained []struct {
+		Plan explainPlan `json:"Plan"`
+	}
+	if err := json.Unmarshal(output, &explained); err != nil {
+		return 0, fmt.Errorf("failed to parse explain output: %w", err)
+	}
+	if len(explained) == 0 {
+		return 0, fmt.Errorf("failed to parse explain output: no plan")
+	}
+
+	plan := explained[0].Plan
+	if plan.NodeType == "ModifyTable" && len(plan.Plans) > 0 {
+		plan = plan.Plans[0]
+	}
+
+	return int64(plan.PlanRows), nil
+}
+
+// TableSizes returns the sizes from pg_class, names resolving through the
+// search path like in the statements. reltuples is -1 for a table that was
+// never analyzed, counted as 0 rows.
+func (Postgres) TableSizes(ctx context.Context, db *sql.DB, tables []string) (map[string]TableSize, error) {
+	sizes := make(map[string]TableSize)
+	for _, table := range tables {
+		var size TableSize
+		err := db.QueryRowContext(ctx, `
+		SELECT GREATEST(c.reltuples, 0)::bigint, pg_total_relation_size(c.oid)
+		FROM pg_class c
+		WHERE c.oid = to_regclass($1)`, table).Scan(&size.Rows, &size.Bytes)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to query the size of %s: %w", table, err)
+		}
+		sizes[table] = size
+	}
+
+	return sizes, nil
+}
+
+// ListTables returns the tables of the first schema of the search path
+func (Postgres) ListTables(ctx context.Context, db *sql.DB) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `
+	SELECT table_name FROM information_schema.tables
+	WHERE table_schema = current_schema() AND table_type = 'BASE TABLE'
+	ORDER BY table_name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+	defer rows.Close() //nolint:errcheck
+
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			return nil, fmt.Errorf("failed to scan table name: %w", err)
+		}
+		tables = append(tables, table)
+	}
+
+	return tables, rows.Err()
+}
+
+// Pgx is the PostgreSQL dialect backed by the pgx stdlib driver instead of lib/pq
+//
+// The driver is not linked by default: build mig with `-tags pgx` or import
+// github.com/jackc/pgx/v5/stdlib in your own program.
+type Pgx struct {
+	Postgres
+}
+
+// Name returns the name of the dialect
+func (Pgx) Name() string {
+	return "pgx"
+}
+
+// DriverName returns the database/sql driver name
+func (Pgx) DriverName() string {
+	return "pgx"
+}
@@ -0,0 +1,204 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// schemaFilter excludes the system schemas from dumps
+const schemaFilter = `n.nspname NOT IN ('pg_catalog', 'information_schema')
+	AND n.nspname NOT LIKE 'pg_toast%'
+	AND n.nspname NOT LIKE 'pg_temp%'`
+
+// tableFilter excludes the mig tracking tables from dumps
+const tableFilter = `c.relname NOT IN ('mig_versions', 'mig_history')`
+
+// DumpSchema returns the user-defined types, tables, constraints, indexes and
+// views as normalized DDL, ordered by schema and name so the dump only
+// changes when the schema does. The mig tables are left out.
+func (Postgres) DumpSchema(ctx context.Context, db *sql.DB) (string, error) {
+	var b strings.Builder
+
+	steps := []func(context.Context, *sql.DB, *strings.Builder) error{
+		dumpEnums,
+		dumpTables,
+		dumpConstraints,
+		dumpIndexes,
+		dumpViews,
+	}
+	for _, step := range steps {
+		if err := step(ctx, db, &b); err != nil {
+			return "", fmt.Errorf("failed to dump schema: %w", err)
+		}
+	}
+
+	if b.Len() == 0 {
+		return "", nil
+	}
+
+	return strings.TrimRight(b.String(), "\n") + "\n", nil
+}
+
+// dumpEnums writes the enum types
+func dumpEnums(ctx context.Context, db *sql.DB, b *strings.Builder) error {
+	rows, err := db.QueryContext(ctx, `
+	SELECT n.nspname, t.typname, string_agg(quote_literal(e.enumlabel), ', ' ORDER BY e.enumsortorder)
+	FROM pg_type t
+	JOIN pg_namespace n ON n.oid = t.typnamespace
+	JOIN pg_enum e ON e.enumtypid = t.oid
+	WHERE `+schemaFilter+`
+	GROUP BY n.nspname, t.typname
+	ORDER BY n.nspname, t.typname`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close() //nolint:errcheck
+
+	for rows.Next() {
+		var schema, name, labels string
+		if err := rows.Scan(&schema, &name, &labels); err != nil {
+			return err
+		}
+		fmt.Fprintf(b, "CREATE TYPE %s.%s AS ENUM (%s);\n\n", schema, name, labels)
+	}
+
+	return rows.Err()
+}
+
+// dumpTables writes the tables with their columns in declaration order
+func dumpTables(ctx context.Context, db *sql.DB, b *strings.Builder) error {
+	rows, err := db.QueryContext(ctx, `
+	SELECT n.nspname, c.relname, a.attname, format_type(a.atttypid, a.atttypmod), a.attnotnull, COALESCE(pg_get_expr(d.adbin, d.adrelid), '')
+	FROM pg_attribute a
+	JOIN pg_class c ON c.oid = a.attrelid
+	JOIN pg_namespace n ON n.oid = c.relnamespace
+	LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+	WHERE c.relkind IN ('r', 'p') AND a.attnum > 0 AND NOT a.attisdropped
+	AND `+schemaFilter+`
+	AND `+tableFilter+`
+	ORDER BY n.nspname, c.relname, a.attnum`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close() //nolint:errcheck
+
+	var current string
+	for rows.Next() {
+		var schema, table, column, dataType, def string
+		var notNull bool
+		if err := rows.Scan(&schema, &table, &column, &dataType, &notNull, &def); err != nil {
+			return err
+		}
+
+		name := schema + "." + table
+		if name != current {
+			if current != "" {
+				b.WriteString("\n);\n\n")
+			}
+			fmt.Fprintf(b, "CREATE TABLE %s (\n", name)
+			current = name
+		} else {
+			b.WriteString(",\n")
+		}
+
+		fmt.Fprintf(b, "    %s %s", column, dataType)
+		if notNull {
+			b.WriteString(" NOT NULL")
+		}
+		if def != "" {
+			fmt.Fprintf(b, " DEFAULT %s", def)
+		}
+	}
+	if current != "" {
+		b.WriteString("\n);\n\n")
+	}
+
+	return rows.Err()
+}
+
+// dumpConstraints writes the table constraints
+func dumpConstraints(ctx context.Context, db *sql.DB, b *strings.Builder) error {
+	rows, err := db.QueryContext(ctx, `
+	SELECT n.nspname, c.relname, con.conname, pg_get_constraintdef(con.oid)
+	FROM pg_constraint con
+	JOIN pg_class c ON c.oid = con.conrelid
+	JOIN pg_namespace n ON n.oid = c.relnamespace
+	WHERE `+schemaFilter+`
+	AND `+tableFilter+`
+	ORDER BY n.nspname, c.relname, con.conname`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close() //nolint:errcheck
+
+	for rows.Next() {
+		var schema, table, name, def string
+		if err := rows.Scan(&schema, &table, &name, &def); err != nil {
+			return err
+		}
+		fmt.Fprintf(b, "ALTER TABLE %s.%s ADD CONSTRAINT %s %s;\n\n", schema, table, name, def)
+	}
+
+	return rows.Err()
+}
+
+// dumpIndexes writes the indexes that do not back a constraint
+func dumpIndexes(ctx context.Context, db *sql.DB, b *strings.Builder) error {
+	rows, err := db.QueryContext(ctx, `
+	SELECT pg_get_indexdef(i.indexrelid)
+	FROM pg_index i
+	JOIN pg_class ic ON ic.oid = i.indexrelid
+	JOIN pg_class c ON c.oid = i.indrelid
+	JOIN pg_namespace n ON n.oid = c.relnamespace
+	WHERE NOT EXISTS (SELECT 1 FROM pg_constraint con WHERE con.conindid = i.indexrelid)
+	AND `+schemaFilter+`
+	AND `+tableFilter+`
+	ORDER BY n.nspname, c.relname, ic.relname`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close() //nolint:errcheck
+
+	for rows.Next() {
+		var def string
+		if err := rows.Scan(&def); err != nil {
+			return err
+		}
+		fmt.Fprintf(b, "%s;\n\n", def)
+	}
+
+	return rows.Err()
+}
+
+// dumpViews writes the views and materialized views
+func dumpViews(ctx context.Context, db *sql.DB, b *strings.Builder) error {
+	rows, err := db.QueryContext(ctx, `
+	SELECT n.nspname, c.relname, c.relkind = 'm', pg_get_viewdef(c.oid, true)
+	FROM pg_class c
+	JOIN pg_namespace n ON n.oid = c.relnamespace
+	WHERE c.relkind IN ('v', 'm')
+	AND `+schemaFilter+`
+	ORDER BY n.nspname, c.relname`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close() //nolint:errcheck
+
+	for rows.Next() {
+		var schema, name, def string
+		var materialized bool
+		if err := rows.Scan(&schema, &name, &materialized, &def); err != nil {
+			return err
+		}
+
+		kind := "VIEW"
+		if materialized {
+			kind = "MATERIALIZED VIEW"
+		}
+		fmt.Fprintf(b, "CREATE %s %s.%s AS\n%s\n\n", kind, schema, name, strings.TrimSpace(def))
+	}
+
+	return rows.Err()
+}
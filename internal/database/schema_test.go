@@ -0,0 +1,47 @@
+package database_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/arthurdotwork/mig/internal/database"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDumpSchema(t *testing.T) {
+	db := setupTest(t)
+	defer db.Close() //nolint:errcheck
+
+	err := database.InitializeTables(context.Background(), db, database.Postgres{})
+	require.NoError(t, err)
+
+	_, err = db.Exec("DROP TABLE IF EXISTS schema_dump_test")
+	require.NoError(t, err)
+
+	_, err = db.Exec("CREATE TABLE schema_dump_test (id SERIAL PRIMARY KEY, email TEXT NOT NULL UNIQUE, name TEXT DEFAULT 'anonymous')")
+	require.NoError(t, err)
+	defer db.Exec("DROP TABLE IF EXISTS schema_dump_test") //nolint:errcheck
+
+	_, err = db.Exec("CREATE INDEX schema_dump_test_name_idx ON schema_dump_test (name)")
+	require.NoError(t, err)
+
+	t.Run("it should dump the tables, constraints and indexes in a stable order", func(t *testing.T) {
+		schema, err := database.DumpSchema(context.Background(), db, database.Postgres{})
+		require.NoError(t, err)
+
+		require.Contains(t, schema, "CREATE TABLE public.schema_dump_test (\n    id integer NOT NULL DEFAULT nextval('schema_dump_test_id_seq'::regclass),\n    email text NOT NULL,\n    name text DEFAULT 'anonymous'::text\n);")
+		require.Contains(t, schema, "ALTER TABLE public.schema_dump_test ADD CONSTRAINT schema_dump_test_pkey PRIMARY KEY (id);")
+		require.Contains(t, schema, "ALTER TABLE public.schema_dump_test ADD CONSTRAINT schema_dump_test_email_key UNIQUE (email);")
+		require.Contains(t, schema, "CREATE INDEX schema_dump_test_name_idx ON public.schema_dump_test USING btree (name);")
+		require.NotContains(t, schema, "mig_versions")
+
+		again, err := database.DumpSchema(context.Background(), db, database.Postgres{})
+		require.NoError(t, err)
+		require.Equal(t, schema, again)
+	})
+
+	t.Run("it should return an error for dialects without schema dumps", func(t *testing.T) {
+		_, err := database.DumpSchema(context.Background(), db, database.ClickHouse{})
+		require.ErrorContains(t, err, "schema dumps are not supported by the clickhouse dialect")
+	})
+}
@@ -0,0 +1,82 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/arthurdotwork/mig/internal/config"
+)
+
+// CreateShadow creates a shadow database on the server of the database of the
+// target, as a copy of it when fromTemplate is set or empty otherwise. It
+// returns the configuration connecting to the shadow and a function dropping
+// it.
+func CreateShadow(ctx context.Context, dialect Dialect, target string, dbCfg config.DatabaseConfig, fromTemplate bool) (config.DatabaseConfig, func(context.Context) error, error) {
+	shadower, ok := dialect.(Shadower)
+	if !ok {
+		return config.DatabaseConfig{}, nil, fmt.Errorf("shadow databases are not supported by the %s dialect", dialect.Name())
+	}
+
+	dbCfg, err := resolveCredentials(ctx, target, dbCfg)
+	if err != nil {
+		return config.DatabaseConfig{}, nil, err
+	}
+
+	template := ""
+	if fromTemplate {
+		if template, err = DatabaseName(dbCfg); err != nil {
+			return config.DatabaseConfig{}, nil, err
+		}
+		if template == "" {
+			return config.DatabaseConfig{}, nil, fmt.Errorf("a shadow copy requires a database name")
+		}
+	}
+
+	db, err := openMaintenance(dialect, shadower, dbCfg)
+	if err != nil {
+		return config.DatabaseConfig{}, nil, err
+	}
+
+	// Unique enough for concurrent rehearsals, and short of the identifier limits
+	name := fmt.Sprintf("mig_shadow_%d", time.Now().UnixNano())
+	if err := shadower.CreateShadow(ctx, db, name, template); err != nil {
+		db.Close() //nolint:errcheck
+		return config.DatabaseConfig{}, nil, err
+	}
+
+	drop := func(ctx context.Context) error {
+		defer db.Close() //nolint:errcheck
+		return shadower.DropShadow(ctx, db, name)
+	}
+
+	dbCfg.SetName(name)
+	dbCfg.CreateIfMissing = false
+
+	return dbCfg, drop, nil
+}
+
+// CreateShadow creates the named database, copied from template when it is
+// set. PostgreSQL refuses to copy a database other sessions are connected to.
+func (p Postgres) CreateShadow(ctx context.Context, db *sql.DB, name, template string) error {
+	statement := "CREATE DATABASE " + p.QuoteIdentifier(name)
+	if template != "" {
+		statement += " TEMPLATE " + p.QuoteIdentifier(template)
+	}
+
+	if _, err := db.ExecContext(ctx, statement); err != nil {
+		return fmt.Errorf("failed to create shadow database %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// DropShadow drops the named database
+func (p Postgres) DropShadow(ctx context.Context, db *sql.DB, name string) error {
+	if _, err := db.ExecContext(ctx, "DROP DATABASE IF EXISTS "+p.QuoteIdentifier(name)); err != nil {
+		return fmt.Errorf("failed to drop shadow database %s: %w", name, err)
+	}
+
+	return nil
+}
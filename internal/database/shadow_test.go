@@ -0,0 +1,37 @@
+package database_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/arthurdotwork/mig/internal/config"
+	"github.com/arthurdotwork/mig/internal/database"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateShadow(t *testing.T) {
+	db := setupTest(t)
+	defer db.Close() //nolint:errcheck
+
+	t.Run("it should create an empty shadow database and drop it", func(t *testing.T) {
+		shadowDB, drop, err := database.CreateShadow(context.Background(), database.Postgres{}, "", testDBConfig.Database, false)
+		require.NoError(t, err)
+		require.Regexp(t, `^mig_shadow_\d+$`, shadowDB.Name)
+
+		shadow, err := database.Connect(context.Background(), &config.Config{Database: shadowDB})
+		require.NoError(t, err)
+		require.NoError(t, shadow.Close())
+
+		require.NoError(t, drop(context.Background()))
+
+		var exists bool
+		err = db.QueryRow("SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname = $1)", shadowDB.Name).Scan(&exists)
+		require.NoError(t, err)
+		require.False(t, exists)
+	})
+
+	t.Run("it should reject a dialect without shadow databases", func(t *testing.T) {
+		_, _, err := database.CreateShadow(context.Background(), database.ClickHouse{}, "", testDBConfig.Database, false)
+		require.ErrorContains(t, err, "shadow databases are not supported by the clickhouse dialect")
+	})
+}
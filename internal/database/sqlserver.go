@@ -0,0 +1,194 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/arthurdotwork/mig/internal/config"
+)
+
+// SQLServer is the Microsoft SQL Server (and Azure SQL) dialect
+//
+// The driver is not linked by default: build mig with `-tags sqlserver`
+// or import github.com/microsoft/go-mssqldb in your own program.
+type SQLServer struct{}
+
+// batchSeparator matches the sqlcmd "GO" batch separator on its own line
+var batchSeparator = regexp.MustCompile(`(?im)^[ \t]*GO[ \t]*;?[ \t]*$`)
+
+// Name returns the name of the dialect
+func (SQLServer) Name() string {
+	return "sqlserver"
+}
+
+// DriverName returns the database/sql driver name
+func (SQLServer) DriverName() string {
+	return "sqlserver"
+}
+
+// ConnectionString builds a sqlserver:// URL
+func (SQLServer) ConnectionString(cfg config.DatabaseConfig) string {
+	query := url.Values{}
+	query.Set("database", cfg.Name)
+
+	switch cfg.SSLMode {
+	case "", "disable":
+		query.Set("encrypt", "disable")
+	case "require":
+		query.Set("encrypt", "true")
+		query.Set("TrustServerCertificate", "true")
+	default:
+		query.Set("encrypt", "true")
+	}
+
+	if cfg.ConnectTimeout > 0 {
+		query.Set("dial timeout", strconv.Itoa(cfg.ConnectTimeout))
+	}
+
+	if cfg.ApplicationName != "" {
+		query.Set("app name", cfg.ApplicationName)
+	}
+
+	// go-mssqldb only supports pinning the server CA, not client certificates
+	if cfg.SSLRootCert != "" {
+		query.Set("certificate", cfg.SSLRootCert)
+	}
+
+	for key, value := range cfg.Params {
+		query.Set(key, value)
+	}
+
+	u := url.URL{
+		Scheme:   "sqlserver",
+		User:     url.UserPassword(cfg.User, cfg.Password),
+		Host:     net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)),
+		RawQuery: query.Encode(),
+	}
+
+	return u.String()
+}
+
+// QuoteIdentifier quotes an identifier with square brackets
+func (SQLServer) QuoteIdentifier(name string) string {
+	return "[" + strings.ReplaceAll(name, "]", "]]") + "]"
+}
+
+// Placeholder returns a named @pn parameter
+func (SQLServer) Placeholder(n int) string {
+	return fmt.Sprintf("@p%d", n)
+}
+
+// CreateVersionTableSQL returns the statement creating the mig_versions table
+func (SQLServer) CreateVersionTableSQL() string {
+	return `
+	IF OBJECT_ID(N'mig_versions', N'U') IS NULL
+	CREATE TABLE mig_versions (
+		id INT IDENTITY(1,1) PRIMARY KEY,
+		version NVARCHAR(255) NOT NULL UNIQUE,
+		applied_at DATETIMEOFFSET NOT NULL DEFAULT SYSDATETIMEOFFSET(),
+		checksum NVARCHAR(64) NULL,
+		duration_ms BIGINT NULL,
+		applied_by NVARCHAR(255) NULL CONSTRAINT DF_mig_versions_applied_by DEFAULT SUSER_SNAME()
+	);`
+}
+
+// CreateHistoryTableSQL returns the statement creating the mig_history table
+func (SQLServer) CreateHistoryTableSQL() string {
+	return `
+	IF OBJECT_ID(N'mig_history', N'U') IS NULL
+	CREATE TABLE mig_history (
+		id INT IDENTITY(1,1) PRIMARY KEY,
+		version NVARCHAR(255) NOT NULL,
+		command NVARCHAR(MAX) NOT NULL,
+		executed_at DATETIMEOFFSET NOT NULL DEFAULT SYSDATETIMEOFFSET()
+	);`
+}
+
+// UpgradeVersionTableSQL returns the statements adding the checksum,
+// duration and applied_by columns. A nullable column added with a default
+// leaves the existing records NULL.
+func (SQLServer) UpgradeVersionTableSQL() []string {
+	return []string{
+		"IF COL_LENGTH(N'mig_versions', N'checksum') IS NULL ALTER TABLE mig_versions ADD checksum NVARCHAR(64) NULL",
+		"IF COL_LENGTH(N'mig_versions', N'duration_ms') IS NULL ALTER TABLE mig_versions ADD duration_ms BIGINT NULL",
+		"IF COL_LENGTH(N'mig_versions', N'applied_by') IS NULL ALTER TABLE mig_versions ADD applied_by NVARCHAR(255) NULL CONSTRAINT DF_mig_versions_applied_by DEFAULT SUSER_SNAME()",
+	}
+}
+
+// RecordMigrationSQL returns the INSERT recording a migration as the current
+// login, with the time elapsed since StartTimerSQL as its duration
+func (SQLServer) RecordMigrationSQL(version, checksum string) string {
+	return fmt.Sprintf("INSERT INTO mig_versions (version, checksum, duration_ms, applied_by) VALUES (%s, %s, %s, SUSER_SNAME())",
+		sqlServerLiteral(version), sqlServerLiteral(checksum),
+		"DATEDIFF_BIG(millisecond, CAST(SESSION_CONTEXT(N'mig.started_at') AS DATETIME2), SYSDATETIME())")
+}
+
+// RecordHistorySQL returns the INSERT recording a history entry
+func (SQLServer) RecordHistorySQL(version, command string) string {
+	return fmt.Sprintf("INSERT INTO mig_history (version, command) VALUES (%s, %s)", sqlServerLiteral(version), sqlServerLiteral(command))
+}
+
+// StartTimerSQL returns the statements keeping the current time in the
+// mig.started_at key of the session context, which outlives the batch
+func (SQLServer) StartTimerSQL() string {
+	return "DECLARE @mig_started_at DATETIME2 = SYSDATETIME(); EXEC sp_set_session_context N'mig.started_at', @mig_started_at"
+}
+
+// sqlServerLiteral quotes a Unicode string literal
+func sqlServerLiteral(value string) string {
+	return "N" + QuoteLiteral(value)
+}
+
+// SupportsTransactionalDDL reports that SQL Server DDL is transactional
+//
+// A few statements (CREATE/ALTER DATABASE, full-text indexes) still refuse to
+// run inside a transaction; those migrations need the "-- disable-tx" marker.
+func (SQLServer) SupportsTransactionalDDL() bool {
+	return true
+}
+
+// SplitStatements splits the migration on "GO" batch separators
+//
+// GO is a sqlcmd/SSMS convention rather than T-SQL, so statements such as
+// CREATE PROCEDURE that must start a batch are sent one batch at a time.
+func (SQLServer) SplitStatements(content string) []string {
+	var batches []string
+	for _, batch := range batchSeparator.Split(content, -1) {
+		if strings.TrimSpace(batch) != "" {
+			batches = append(batches, batch)
+		}
+	}
+
+	return batches
+}
+
+// Lock takes a session-owned application lock with sp_getapplock
+func (SQLServer) Lock(ctx context.Context, conn *sql.Conn) error {
+	var result int
+	query := "DECLARE @result INT; EXEC @result = sp_getapplock @Resource = @p1, @LockMode = 'Exclusive', @LockOwner = 'Session', @LockTimeout = -1; SELECT @result"
+	if err := conn.QueryRowContext(ctx, query, LockName).Scan(&result); err != nil {
+		return fmt.Errorf("failed to acquire application lock: %w", err)
+	}
+
+	// sp_getapplock returns 0 or 1 on success and a negative code on failure
+	if result < 0 {
+		return fmt.Errorf("failed to acquire application lock: sp_getapplock returned %d", result)
+	}
+
+	return nil
+}
+
+// Unlock releases the application lock with sp_releaseapplock
+func (SQLServer) Unlock(ctx context.Context, conn *sql.Conn) error {
+	if _, err := conn.ExecContext(ctx, "EXEC sp_releaseapplock @Resource = @p1, @LockOwner = 'Session'", LockName); err != nil {
+		return fmt.Errorf("failed to release application lock: %w", err)
+	}
+
+	return nil
+}
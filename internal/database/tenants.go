@@ -0,0 +1,93 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+
+	"github.com/arthurdotwork/mig/internal/config"
+)
+
+// ListTenants returns the configured tenant schemas followed by the existing
+// schemas matching the configured pattern and those returned by the
+// configured query, without duplicates
+func ListTenants(ctx context.Context, cfg *config.Config) ([]string, error) {
+	tenants := slices.Clone(cfg.Tenants.Schemas)
+
+	if cfg.Tenants.Pattern != "" || cfg.Tenants.Query != "" {
+		db, err := Connect(ctx, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to database: %w", err)
+		}
+		defer db.Close() //nolint:errcheck
+
+		if cfg.Tenants.Pattern != "" {
+			schemas, err := DiscoverSchemas(ctx, db, cfg.Tenants.Pattern)
+			if err != nil {
+				return nil, err
+			}
+			tenants = append(tenants, schemas...)
+		}
+
+		if cfg.Tenants.Query != "" {
+			schemas, err := QuerySchemas(ctx, db, cfg.Tenants.Query)
+			if err != nil {
+				return nil, err
+			}
+			tenants = append(tenants, schemas...)
+		}
+	}
+
+	seen := make(map[string]bool, len(tenants))
+	unique := make([]string, 0, len(tenants))
+	for _, tenant := range tenants {
+		if !seen[tenant] {
+			seen[tenant] = true
+			unique = append(unique, tenant)
+		}
+	}
+
+	return unique, nil
+}
+
+// DiscoverSchemas returns the sorted names of the schemas matching a LIKE pattern
+func DiscoverSchemas(ctx context.Context, db *sql.DB, pattern string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT schema_name FROM information_schema.schemata WHERE schema_name LIKE $1 ORDER BY schema_name", pattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover tenant schemas: %w", err)
+	}
+
+	return scanSchemas(rows)
+}
+
+// QuerySchemas returns the schema names selected by a single-column query, in
+// the order the query returns them
+func QuerySchemas(ctx context.Context, db *sql.DB, query string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tenant schemas: %w", err)
+	}
+
+	return scanSchemas(rows)
+}
+
+// scanSchemas reads the schema names of a single-column result set and closes it
+func scanSchemas(rows *sql.Rows) ([]string, error) {
+	defer rows.Close() //nolint:errcheck
+
+	var schemas []string
+	for rows.Next() {
+		var schema string
+		if err := rows.Scan(&schema); err != nil {
+			return nil, fmt.Errorf("failed to scan tenant schema: %w", err)
+		}
+		schemas = append(schemas, schema)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read tenant schemas: %w", err)
+	}
+
+	return schemas, nil
+}
@@ -0,0 +1,67 @@
+package database_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/arthurdotwork/mig/internal/config"
+	"github.com/arthurdotwork/mig/internal/database"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListTenants(t *testing.T) {
+	db := setupTest(t)
+	defer db.Close() //nolint:errcheck
+
+	for _, schema := range []string{"mig_tenant_a", "mig_tenant_b"} {
+		_, err := db.Exec("CREATE SCHEMA IF NOT EXISTS " + schema)
+		require.NoError(t, err)
+		defer db.Exec("DROP SCHEMA IF EXISTS " + schema + " CASCADE") //nolint:errcheck
+	}
+
+	t.Run("it should discover schemas matching the pattern", func(t *testing.T) {
+		schemas, err := database.DiscoverSchemas(context.Background(), db, "mig\\_tenant\\_%")
+		require.NoError(t, err)
+		require.Equal(t, []string{"mig_tenant_a", "mig_tenant_b"}, schemas)
+	})
+
+	t.Run("it should read the schemas returned by a query", func(t *testing.T) {
+		schemas, err := database.QuerySchemas(context.Background(), db, "SELECT schema_name FROM (VALUES ('mig_tenant_b'), ('mig_tenant_a')) AS tenants(schema_name)")
+		require.NoError(t, err)
+		require.Equal(t, []string{"mig_tenant_b", "mig_tenant_a"}, schemas)
+	})
+
+	t.Run("it should return an error when the query fails", func(t *testing.T) {
+		_, err := database.QuerySchemas(context.Background(), db, "SELECT schema_name FROM mig_missing_tenants")
+		require.Error(t, err)
+	})
+
+	t.Run("it should merge the static list and the discovered schemas", func(t *testing.T) {
+		cfg := *testDBConfig
+		cfg.Tenants = config.TenantsConfig{
+			Schemas: []string{"mig_tenant_b", "mig_tenant_c"},
+			Pattern: "mig\\_tenant\\_%",
+		}
+
+		tenants, err := database.ListTenants(context.Background(), &cfg)
+		require.NoError(t, err)
+		require.Equal(t, []string{"mig_tenant_b", "mig_tenant_c", "mig_tenant_a"}, tenants)
+	})
+
+	t.Run("it should create the tracking tables in the tenant schema", func(t *testing.T) {
+		cfg := *testDBConfig
+		cfg.Tenant = "mig_tenant_a"
+
+		tenantDB, err := database.Connect(context.Background(), &cfg)
+		require.NoError(t, err)
+		defer tenantDB.Close() //nolint:errcheck
+
+		err = database.InitializeTables(context.Background(), tenantDB, database.Postgres{})
+		require.NoError(t, err)
+
+		var exists bool
+		err = db.QueryRow("SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_schema = 'mig_tenant_a' AND table_name = 'mig_versions')").Scan(&exists)
+		require.NoError(t, err)
+		require.True(t, exists)
+	})
+}
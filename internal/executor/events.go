@@ -0,0 +1,120 @@
+package executor
+
+import (
+	"context"
+	"time"
+)
+
+// EventType identifies what an Event reports
+type EventType string
+
+// Event types, in the order they occur during a run
+const (
+	// EventRunStarted is sent once the migration lock is held, before the
+	// first migration of a run is applied
+	EventRunStarted EventType = "run_started"
+
+	// EventMigrationApplied is sent after a migration is applied
+	EventMigrationApplied EventType = "migration_applied"
+
+	// EventMigrationFailed is sent after a migration fails
+	EventMigrationFailed EventType = "migration_failed"
+
+	// EventRunFinished is sent at the end of a run, successful or not
+	EventRunFinished EventType = "run_finished"
+)
+
+// Event reports the progress of a migration run to the observers
+type Event struct {
+	Type   EventType
+	Target string // Name of the target, empty for the default one
+	Tenant string // Tenant schema, empty without tenants
+
+	// Migration is the ID of the migration of migration events
+	Migration string
+
+	// Duration is the time spent on the migration, or on the whole run for
+	// EventRunFinished
+	Duration time.Duration
+
+	// Err is the reason of the failure of EventMigrationFailed and of a
+	// failed EventRunFinished
+	Err error
+
+	// Applied lists the migrations applied by the run, for EventRunFinished
+	Applied []string
+
+	// Pending is the number of pending migrations, for run events
+	Pending int
+
+	// Selected lists the IDs of the pending migrations the run is about to
+	// apply, for EventRunStarted: the next one for ExecuteNextMigration, those
+	// of the phase for ExecutePhase
+	Selected []string
+}
+
+// Observer is notified of the progress of migration runs
+//
+// Observers are called synchronously from the run, so they should return
+// quickly.
+type Observer interface {
+	Observe(ctx context.Context, event Event)
+}
+
+// AddObserver registers an observer notified of the progress of the runs
+func (e *Executor) AddObserver(observer Observer) {
+	e.observers = append(e.observers, observer)
+}
+
+// observerKey is the context key of the observer of ContextWithObserver
+type observerKey struct{}
+
+// ContextWithObserver returns a context whose runs also notify observer,
+// after the observers of the executor. Unlike AddObserver, it only follows
+// the runs started with the context, not those of other callers sharing the
+// executor.
+func ContextWithObserver(ctx context.Context, observer Observer) context.Context {
+	return context.WithValue(ctx, observerKey{}, observer)
+}
+
+// notify sends the event to the observers, filling in the target and tenant
+func (e *Executor) notify(ctx context.Context, event Event) {
+	event.Target = e.cfg.Target
+	event.Tenant = e.cfg.Tenant
+
+	for _, observer := range e.observers {
+		observer.Observe(ctx, event)
+	}
+
+	if observer, ok := ctx.Value(observerKey{}).(Observer); ok {
+		observer.Observe(ctx, event)
+	}
+}
+
+// observeRun runs fn as a migration run, notifying the observers of its start
+// with the pending migrations selected for it, and of its outcome. The caller
+// must hold the lock.
+func (e *Executor) observeRun(ctx context.Context, selection selector, fn func(context.Context) error) error {
+	e.batch = nil
+	e.backupPath = ""
+
+	pending := e.GetPendingMigrations()
+	var selected []string
+	for _, migration := range selection(pending) {
+		selected = append(selected, migration.ID)
+	}
+	e.notify(ctx, Event{Type: EventRunStarted, Pending: len(pending), Selected: selected})
+
+	start := time.Now()
+	err := fn(ctx)
+
+	e.notify(ctx, Event{
+		Type:     EventRunFinished,
+		Duration: time.Since(start),
+		Err:      err,
+		Applied:  e.batch,
+		Pending:  len(e.GetPendingMigrations()),
+	})
+
+	return err
+}
@@ -0,0 +1,250 @@
+package executor_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arthurdotwork/mig/internal/config"
+	"github.com/arthurdotwork/mig/internal/executor"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingObserver records the events it is notified of
+type recordingObserver struct {
+	mu     sync.Mutex
+	events []executor.Event
+}
+
+func (r *recordingObserver) Observe(_ context.Context, event executor.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events = append(r.events, event)
+}
+
+func TestObserver(t *testing.T) {
+	// Setup
+	db := setupTestDB(t)
+	defer db.Close() //nolint:errcheck
+
+	tempDir, err := os.MkdirTemp("", "mig_executor_observer_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir) //nolint:errcheck
+
+	createMigrationFile(t, tempDir, "2023_01_01_10_00_00_first.sql", "SELECT 1;")
+	createMigrationFile(t, tempDir, "2023_01_02_10_00_00_broken.sql", "SELECT * FROM missing_table;")
+
+	cfg := testDBConfig(t, tempDir)
+
+	t.Run("it should notify the progress of a run", func(t *testing.T) {
+		exec, err := executor.New(context.Background(), cfg)
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		observer := &recordingObserver{}
+		exec.AddObserver(observer)
+
+		_, err = exec.ExecuteAllMigrations(context.Background())
+		require.Error(t, err)
+
+		require.Len(t, observer.events, 4)
+
+		require.Equal(t, executor.EventRunStarted, observer.events[0].Type)
+		require.Equal(t, 2, observer.events[0].Pending)
+
+		require.Equal(t, executor.EventMigrationApplied, observer.events[1].Type)
+		require.Equal(t, "2023_01_01_10_00_00_first", observer.events[1].Migration)
+
+		require.Equal(t, executor.EventMigrationFailed, observer.events[2].Type)
+		require.Equal(t, "2023_01_02_10_00_00_broken", observer.events[2].Migration)
+		require.Error(t, observer.events[2].Err)
+
+		require.Equal(t, executor.EventRunFinished, observer.events[3].Type)
+		require.Error(t, observer.events[3].Err)
+		require.Equal(t, []string{"2023_01_01_10_00_00_first"}, observer.events[3].Applied)
+		require.Equal(t, 1, observer.events[3].Pending)
+	})
+
+	t.Run("it should notify the observer of a context of its runs only", func(t *testing.T) {
+		// Start over with both migrations pending
+		_, err := db.Exec("DROP TABLE IF EXISTS mig_history, mig_versions")
+		require.NoError(t, err)
+
+		exec, err := executor.New(context.Background(), cfg)
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		observer := &recordingObserver{}
+		_, err = exec.ExecuteNextMigration(executor.ContextWithObserver(context.Background(), observer))
+		require.NoError(t, err)
+
+		// Another run of the executor
+		_, err = exec.ExecuteNextMigration(context.Background())
+		require.Error(t, err)
+
+		require.Len(t, observer.events, 3)
+		require.Equal(t, executor.EventMigrationApplied, observer.events[1].Type)
+		require.Equal(t, "2023_01_01_10_00_00_first", observer.events[1].Migration)
+		require.NoError(t, observer.events[2].Err)
+	})
+}
+
+func TestNotifications(t *testing.T) {
+	// Setup
+	db := setupTestDB(t)
+	defer db.Close() //nolint:errcheck
+
+	dir := t.TempDir()
+	createMigrationFile(t, dir, "2023_01_01_10_00_00_first.sql", "SELECT 1;")
+	createMigrationFile(t, dir, "2023_01_02_10_00_00_broken.sql", "SELECT * FROM missing_table;")
+
+	var mu sync.Mutex
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		mu.Lock()
+		defer mu.Unlock()
+		bodies = append(bodies, r.URL.Path+" "+string(body))
+	}))
+	defer server.Close()
+
+	cfg := testDBConfig(t, dir)
+	cfg.Notifications = []config.NotificationConfig{
+		{Slack: server.URL + "/slack", Events: []string{config.NotifyFailed}},
+		{URL: server.URL + "/hook", Template: `{"event": {{json .Event}}, "count": {{len .Migrations}}}`},
+	}
+
+	t.Run("it should post the start and outcome of a run", func(t *testing.T) {
+		exec, err := executor.New(context.Background(), cfg)
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		_, err = exec.ExecuteAllMigrations(context.Background())
+		require.Error(t, err)
+
+		require.Len(t, bodies, 3)
+		require.Equal(t, `/hook {"event": "started", "count": 0}`, bodies[0])
+
+		require.Contains(t, bodies[1], "/slack ")
+		var message map[string]string
+		require.NoError(t, json.Unmarshal([]byte(bodies[1][len("/slack "):]), &message))
+		require.Contains(t, message["text"], "failed")
+		require.Contains(t, message["text"], "2023_01_02_10_00_00_broken")
+
+		require.Equal(t, `/hook {"event": "failed", "count": 2}`, bodies[2])
+	})
+
+	t.Run("it should pass the runs to the registered notifiers", func(t *testing.T) {
+		_, err := db.Exec("DELETE FROM mig_versions")
+		require.NoError(t, err)
+
+		exec, err := executor.New(context.Background(), cfg)
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		var notifications []executor.Notification
+		exec.AddNotifier(executor.NotifierFunc(func(_ context.Context, notification executor.Notification) error {
+			notifications = append(notifications, notification)
+			return errors.New("pager unavailable")
+		}))
+
+		_, err = exec.ExecuteAllMigrations(context.Background())
+		require.ErrorContains(t, err, "missing_table")
+
+		require.Len(t, notifications, 2)
+		require.Equal(t, config.NotifyStarted, notifications[0].Event)
+		require.Equal(t, []string{"2023_01_01_10_00_00_first", "2023_01_02_10_00_00_broken"}, notifications[0].Pending)
+		require.Equal(t, config.NotifyFailed, notifications[1].Event)
+		require.Len(t, notifications[1].Migrations, 2)
+		require.Equal(t, "2023_01_01_10_00_00_first", notifications[1].Migrations[0].ID)
+		require.NotEmpty(t, notifications[1].Migrations[1].Error)
+	})
+
+	t.Run("it should only list the migrations selected for the run", func(t *testing.T) {
+		_, err := db.Exec("DELETE FROM mig_versions")
+		require.NoError(t, err)
+
+		exec, err := executor.New(context.Background(), cfg)
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		var notifications []executor.Notification
+		exec.AddNotifier(executor.NotifierFunc(func(_ context.Context, notification executor.Notification) error {
+			notifications = append(notifications, notification)
+			return nil
+		}))
+
+		executed, err := exec.ExecuteNextMigration(context.Background())
+		require.NoError(t, err)
+		require.True(t, executed)
+
+		require.Len(t, notifications, 2)
+		require.Equal(t, []string{"2023_01_01_10_00_00_first"}, notifications[0].Pending)
+		require.Equal(t, config.NotifySucceeded, notifications[1].Event)
+	})
+
+	t.Run("it should not post runs without pending migrations", func(t *testing.T) {
+		require.NoError(t, os.Remove(dir+"/2023_01_02_10_00_00_broken.sql"))
+		bodies = nil
+
+		exec, err := executor.New(context.Background(), cfg)
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		_, err = exec.ExecuteAllMigrations(context.Background())
+		require.NoError(t, err)
+		require.Empty(t, bodies)
+	})
+}
+
+func TestStatsD(t *testing.T) {
+	// Setup
+	db := setupTestDB(t)
+	defer db.Close() //nolint:errcheck
+
+	dir := t.TempDir()
+	createMigrationFile(t, dir, "2023_01_01_10_00_00_first.sql", "SELECT 1;")
+
+	agent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer agent.Close() //nolint:errcheck
+
+	cfg := testDBConfig(t, dir)
+	cfg.StatsD = config.StatsDConfig{
+		Address: agent.LocalAddr().String(),
+		Prefix:  config.DefaultStatsDPrefix,
+		Tags:    map[string]string{"env": "test"},
+	}
+
+	t.Run("it should send the metrics of a run once it finished", func(t *testing.T) {
+		exec, err := executor.New(context.Background(), cfg)
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		_, err = exec.ExecuteAllMigrations(context.Background())
+		require.NoError(t, err)
+
+		require.NoError(t, agent.SetReadDeadline(time.Now().Add(5*time.Second)))
+		buf := make([]byte, 2048)
+		n, _, err := agent.ReadFrom(buf)
+		require.NoError(t, err)
+
+		metrics := strings.Split(string(buf[:n]), "\n")
+		require.Len(t, metrics, 4)
+		require.Equal(t, "mig.migrations.applied:1|c|#env:test", metrics[0])
+		require.Regexp(t, `^mig\.migration\.duration:\d+\|ms\|#env:test,outcome:applied$`, metrics[1])
+		require.Regexp(t, `^mig\.run\.duration:\d+\|ms\|#env:test,outcome:success$`, metrics[2])
+		require.Equal(t, "mig.migrations.pending:0|g|#env:test", metrics[3])
+	})
+}
@@ -0,0 +1,99 @@
+package executor
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/arthurdotwork/mig/internal/database"
+)
+
+// Exec runs ad-hoc SQL, such as an emergency hotfix, while holding the
+// migration lock, and records it in mig_history under the version
+// database.AdHocPrefix + name, so it leaves the same trail as the migrations.
+//
+// The SQL runs in a transaction recording it, unless transactional is false
+// for statements such as CREATE INDEX CONCURRENTLY. Without a transaction, the
+// statements executed before a failure are still recorded.
+func (e *Executor) Exec(ctx context.Context, name, content string, transactional bool) error {
+	if strings.TrimSpace(content) == "" {
+		return fmt.Errorf("no statement to execute")
+	}
+
+	// Behind PgBouncer only a transaction can hold the lock serializing runners
+	if !transactional && e.cfg.Database.PgBouncer {
+		return fmt.Errorf("%w: ad-hoc SQL %s, run it over a direct connection to the database", ErrNoTxInPgBouncer, name)
+	}
+
+	version := database.AdHocPrefix + name
+	statements := e.dialect.SplitStatements(content)
+
+	logger := e.logger.With(slog.String("version", version))
+
+	return e.holdLock(ctx, func(ctx context.Context) error {
+		start := time.Now()
+
+		if !transactional {
+			for i, statement := range statements {
+				if _, err := e.db.ExecContext(ctx, statement); err != nil {
+					if i > 0 {
+						executed := strings.Join(statements[:i], ";\n") + ";"
+						if recordErr := database.RecordHistory(ctx, e.db, e.dialect, version, executed, e.recordedAt(), nil); recordErr != nil {
+							logger.ErrorContext(ctx, "failed to record the executed statements", slog.String("error", recordErr.Error()))
+						}
+					}
+					return fmt.Errorf("failed to execute ad-hoc SQL %s: %w", name, err)
+				}
+			}
+
+			if err := database.RecordHistory(ctx, e.db, e.dialect, version, content, e.recordedAt(), nil); err != nil {
+				return err
+			}
+
+			logger.InfoContext(ctx, "ad-hoc SQL executed", slog.Int("statements", len(statements)), slog.Duration("duration", time.Since(start)))
+			return nil
+		}
+
+		tx, err := e.db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction for ad-hoc SQL %s: %w", name, err)
+		}
+		defer tx.Rollback() //nolint:errcheck
+
+		// Without a session lock, serialize with the runners on the transaction
+		if locker, ok := e.dialect.(database.TxLocker); ok && e.cfg.Database.PgBouncer {
+			if err := locker.LockTx(ctx, tx); err != nil {
+				return err
+			}
+		}
+
+		if err := execAll(ctx, tx, statements); err != nil {
+			return fmt.Errorf("failed to execute ad-hoc SQL %s: %w", name, err)
+		}
+
+		if err := database.RecordHistory(ctx, e.db, e.dialect, version, content, e.recordedAt(), tx); err != nil {
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit ad-hoc SQL %s: %w", name, err)
+		}
+
+		logger.InfoContext(ctx, "ad-hoc SQL executed", slog.Int("statements", len(statements)), slog.Duration("duration", time.Since(start)))
+		return nil
+	})
+}
+
+// execAll executes the statements in the transaction, in order
+func execAll(ctx context.Context, tx *sql.Tx, statements []string) error {
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -1,56 +1,406 @@
 package executor
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"io"
+	"iter"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/arthurdotwork/mig/internal/config"
 	"github.com/arthurdotwork/mig/internal/database"
+	"github.com/arthurdotwork/mig/internal/lint"
 	"github.com/arthurdotwork/mig/internal/migrations"
+	"github.com/arthurdotwork/mig/internal/version"
 )
 
-// Executor handles the execution of migrations
+// MigrationStatus represents a migration's current status
+type MigrationStatus struct {
+	ID          string        // Migration ID
+	Name        string        // Migration Name
+	Filename    string        // Migration Filename
+	Applied     bool          // Whether the migration has been applied
+	AppliedAt   time.Time     // When the migration was applied (zero if not applied)
+	Checksum    string        // SHA-256 of the migration file
+	Duration    time.Duration // How long the migration took to apply (zero if not applied or unknown)
+	AppliedBy   string        // Database user that applied the migration (empty if not applied or unknown)
+	Description string        // Description of the migration from "-- mig:description", empty when missing
+	Modified    bool          // Whether the file changed since the migration was applied
+	Missing     bool          // Whether the migration is applied but its file is gone
+}
+
+// State summarizes the status as "pending", "applied", "modified" or "missing"
+func (s MigrationStatus) State() string {
+	switch {
+	case s.Missing:
+		return "missing"
+	case s.Modified:
+		return "modified"
+	case s.Applied:
+		return "applied"
+	default:
+		return "pending"
+	}
+}
+
+// Outcomes of a migration, logged in the outcome attribute once it finishes
+const (
+	OutcomeApplied = "applied" // The migration was applied
+	OutcomeFailed  = "failed"  // The migration failed
+	OutcomeSkipped = "skipped" // Another runner applied the migration first
+)
+
+// PlannedMigration is a pending migration as it would be applied
+type PlannedMigration struct {
+	ID            string // Migration ID
+	Name          string // Migration Name
+	Filename      string // Migration Filename
+	Checksum      string // SHA-256 of the migration file
+	Transactional bool   // Whether the migration runs inside a transaction
+	Phase         string // Deployment phase the migration is tagged with, empty when untagged
+}
+
+// Estimate is the number of rows the planner expects an INSERT, UPDATE or
+// DELETE statement of a pending migration to touch
+type Estimate struct {
+	Migration string // ID of the migration
+	Line      int    // Line of the statement in the migration file
+	Statement string // Statement as written in the migration file
+	Rows      int64  // Estimated rows, 0 when Err is set
+	Err       error  // Why the statement could not be explained, such as a table created by an earlier migration
+}
+
+var (
+	// ErrAlreadyRunning is returned when migrations are applied while another
+	// call on the same executor is still applying them
+	ErrAlreadyRunning = errors.New("migrations are already being applied")
+
+	// ErrMigrationNotFound is returned when no migration file has the given ID
+	ErrMigrationNotFound = errors.New("migration not found")
+
+	// ErrAlreadyApplied is returned when applying a migration that is already
+	// applied
+	ErrAlreadyApplied = errors.New("migration is already applied")
+
+	// ErrMigrationTimeout is returned when a migration runs longer than the
+	// configured per-migration timeout
+	ErrMigrationTimeout = errors.New("migration exceeded its timeout")
+
+	// ErrLockTimeout is returned when the context or the lock timeout expires
+	// while waiting for the migration lock
+	ErrLockTimeout = errors.New("timed out waiting for the migration lock")
+
+	// ErrRunTimeout is returned when a run, from waiting for the lock to
+	// applying the last migration, exceeds the configured run timeout
+	ErrRunTimeout = errors.New("run exceeded its timeout")
+
+	// ErrUnsafeMigration is returned when a migration about to be applied
+	// breaks a lint rule with the error severity
+	ErrUnsafeMigration = errors.New("migration breaks a lint rule")
+
+	// ErrConcurrentInTx is returned when a migration running inside a
+	// transaction uses CONCURRENTLY, which PostgreSQL refuses there
+	ErrConcurrentInTx = errors.New("CONCURRENTLY cannot run inside a transaction")
+
+	// ErrNoTxInPgBouncer is returned in pgbouncer mode when a migration runs
+	// outside of a transaction, where the transaction lock cannot protect it
+	ErrNoTxInPgBouncer = errors.New("migrations cannot run outside of a transaction in pgbouncer mode")
+
+	// ErrServerTooOld is returned when a migration about to be applied needs a
+	// newer server than the database runs, see "-- mig:min-pg="
+	ErrServerTooOld = errors.New("migration needs a newer database server")
+
+	// ErrAccessLost is returned when the access checks fail after the
+	// statements of a migration, which is then not recorded
+	ErrAccessLost = errors.New("application roles lost access")
+
+	// ErrBackupFailed is returned when the backup taken before applying
+	// migrations fails, nothing is applied then
+	ErrBackupFailed = errors.New("backup before migrating failed")
+
+	// ErrRestored is returned alongside the error of a migration that failed
+	// outside of a transaction when the database was restored from the backup
+	// taken before the run
+	ErrRestored = errors.New("database restored from the backup taken before the run")
+
+	// ErrOutOfOrder is returned when a pending migration is older than the
+	// last applied one and the out-of-order policy is "fail"
+	ErrOutOfOrder = errors.New("pending migration is older than the last applied one")
+
+	// ErrDirtyState is returned when the applied migrations no longer match
+	// the migration files
+	ErrDirtyState = errors.New("migration state is dirty")
+
+	// ErrChecksumMismatch is returned when the file of an applied migration
+	// changed since it was applied, it wraps ErrDirtyState
+	ErrChecksumMismatch = fmt.Errorf("%w, applied migration files changed", ErrDirtyState)
+
+	// ErrMissingMigration is returned when the file of an applied migration
+	// was renamed or deleted, it wraps ErrDirtyState
+	ErrMissingMigration = fmt.Errorf("%w, applied migration files are missing", ErrDirtyState)
+)
+
+// StatementError is returned when a statement of a migration fails, locating
+// it in the migration file
+type StatementError struct {
+	Migration string // ID of the migration
+	Filename  string // Filename of the migration
+	Line      int    // Line of the statement in the migration file, from 1, 0 when not found
+	Err       error  // Error of the database
+}
+
+// Error formats the error as the failure of the migration
+func (e *StatementError) Error() string {
+	return fmt.Sprintf("failed to execute migration %s: %v", e.Migration, e.Err)
+}
+
+// Unwrap returns the error of the database
+func (e *StatementError) Unwrap() error {
+	return e.Err
+}
+
+// statementError locates a failed statement in its migration file, down to
+// the line at fault when the dialect reports the position of the failure
+func (e *Executor) statementError(migration migrations.Migration, statement string, err error) error {
+	line := 0
+	trimmed := strings.TrimSpace(statement)
+	if i := strings.Index(migration.Content, trimmed); i >= 0 {
+		line = strings.Count(migration.Content[:i], "\n") + 1
+
+		if positioner, ok := e.dialect.(database.ErrorPositioner); ok {
+			if position := positioner.ErrorPosition(err); position > 0 {
+				// The position counts from the start of the statement as sent,
+				// leading whitespace included
+				runes := []rune(statement)
+				before := string(runes[:min(position-1, len(runes))])
+				lead := statement[:strings.Index(statement, trimmed)]
+				line += max(strings.Count(before, "\n")-strings.Count(lead, "\n"), 0)
+			}
+		}
+	}
+
+	return &StatementError{Migration: migration.ID, Filename: migration.Filename, Line: line, Err: err}
+}
+
+// Executor handles the execution of migrations, it is safe for concurrent use
 type Executor struct {
 	cfg        *config.Config
 	db         *sql.DB
+	dialect    database.Dialect
+	logger     *slog.Logger
+	linter     *lint.Linter
 	migrations []migrations.Migration
-	applied    []database.MigrationVersion
+
+	// archived holds the IDs of the migrations moved to the archive
+	// directory, which are applied but no longer loaded
+	archived map[string]bool
+
+	// mu guards applied, which Status and Plan refresh while migrations run
+	mu      sync.Mutex
+	applied []database.MigrationVersion
+
+	// running is set while a call holds the migration lock
+	running atomic.Bool
+
+	// ownsDB is false when the connection was opened by the caller
+	ownsDB bool
+
+	// now returns the time migrations are recorded at, nil to use the
+	// database's current time
+	now func() time.Time
+
+	// tracer receives a span per step, nil when tracing is disabled
+	tracer Tracer
+
+	// observers are notified of the progress of the runs, and batch lists
+	// the migrations applied by the current run
+	observers []Observer
+	batch     []string
+
+	// backupPath is the backup taken before the current run, empty without one
+	backupPath string
+
+	// notifier passes the runs to the notifiers, nil without any
+	notifier *notifier
 }
 
 // New creates a new migration executor
-func New(cfg *config.Config) (*Executor, error) {
+func New(ctx context.Context, cfg *config.Config) (*Executor, error) {
 	// Connect to the database
-	db, err := database.Connect(cfg)
+	db, err := database.Connect(ctx, cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
-	// Initialize the migration tables
-	if err := database.InitializeTables(db); err != nil {
+	exec, err := newExecutor(ctx, cfg, db)
+	if err != nil {
 		db.Close() //nolint:errcheck
+		return nil, err
+	}
+	exec.ownsDB = true
+
+	return exec, nil
+}
+
+// NewWithDB creates a new migration executor on a connection opened by the
+// caller, which stays open when the executor is closed
+func NewWithDB(ctx context.Context, cfg *config.Config, db *sql.DB) (*Executor, error) {
+	return newExecutor(ctx, cfg, db)
+}
+
+// newExecutor initializes the migration tables and loads the migrations
+func newExecutor(ctx context.Context, cfg *config.Config, db *sql.DB) (*Executor, error) {
+	// Resolve the dialect for the configured driver
+	dialect, err := database.GetDialect(cfg.Database.Driver)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tell an existing database to adopt before the tables are created
+	var adopt bool
+	if cfg.Migrations.BaselineVersion != "" {
+		if adopt, err = database.IsUnmanaged(ctx, db, dialect); err != nil {
+			return nil, err
+		}
+	}
+
+	// Initialize the migration tables
+	if err := database.InitializeTables(ctx, db, dialect); err != nil {
 		return nil, fmt.Errorf("failed to initialize tables: %w", err)
 	}
 
+	linter, err := lint.New(cfg.Lint)
+	if err != nil {
+		return nil, err
+	}
+
+	// Load the migrations, with those of the extra directories
+	migrationFiles, err := migrations.Load(cfg.Migrations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load migrations: %w", err)
+	}
+
+	if adopt {
+		if err := baseline(ctx, db, dialect, migrationFiles, cfg.Migrations.BaselineVersion); err != nil {
+			return nil, err
+		}
+	}
+
 	// Load the applied migrations
-	applied, err := database.GetAppliedMigrations(db)
+	applied, err := database.GetAppliedMigrations(ctx, db)
 	if err != nil {
-		db.Close() //nolint:errcheck
 		return nil, fmt.Errorf("failed to get applied migrations: %w", err)
 	}
 
-	// Load migrations from directory
-	migrationFiles, err := migrations.LoadMigrations(cfg.Migrations.Directory)
+	archived, err := migrations.LoadArchived(cfg.Migrations)
 	if err != nil {
-		db.Close() //nolint:errcheck
-		return nil, fmt.Errorf("failed to load migrations: %w", err)
+		return nil, fmt.Errorf("failed to load archived migrations: %w", err)
 	}
 
-	return &Executor{
+	exec := &Executor{
 		cfg:        cfg,
 		db:         db,
+		dialect:    dialect,
+		logger:     slog.New(slog.DiscardHandler),
+		linter:     linter,
 		migrations: migrationFiles,
+		archived:   archived,
 		applied:    applied,
-	}, nil
+	}
+
+	if cfg.StatsD.Address != "" {
+		exec.AddObserver(newStatsD(exec, cfg.StatsD))
+	}
+
+	for _, notification := range cfg.Notifications {
+		exec.AddNotifier(endpoint{cfg: notification})
+	}
+
+	return exec, nil
+}
+
+// baseline records the migrations up to version as applied without running
+// them, since the adopted database already holds their changes
+func baseline(ctx context.Context, db *sql.DB, dialect database.Dialect, files []migrations.Migration, version string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin baseline transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	for _, migration := range files {
+		if migration.ID > version {
+			continue
+		}
+
+		if err := database.RecordMigration(ctx, db, dialect, migration.ID, migration.Checksum, 0, time.Time{}, tx); err != nil {
+			return fmt.Errorf("failed to baseline %s: %w", migration.ID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit baseline: %w", err)
+	}
+
+	return nil
+}
+
+// setApplied replaces the cached list of applied migrations
+func (e *Executor) setApplied(applied []database.MigrationVersion) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.applied = applied
+}
+
+// loaded returns the migration files of the executor, Rebase, Archive and
+// AddMigration replace the slice rather than modifying it, so the result can
+// be read without holding the lock
+func (e *Executor) loaded() []migrations.Migration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.migrations
+}
+
+// archivedIDs returns a copy of the IDs of the archived migrations, which
+// Archive replaces under the lock
+func (e *Executor) archivedIDs() map[string]bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return maps.Clone(e.archived)
+}
+
+// SetLogger sets the logger receiving the migration progress, nothing is
+// logged by default
+func (e *Executor) SetLogger(logger *slog.Logger) {
+	e.logger = logger
+}
+
+// SetClock records applied migrations at the times returned by now instead of
+// the database's current time, for deterministic timestamps
+func (e *Executor) SetClock(now func() time.Time) {
+	e.now = now
+}
+
+// recordedAt returns the time migrations are recorded at, zero when the
+// database's current time applies
+func (e *Executor) recordedAt() time.Time {
+	if e.now == nil {
+		return time.Time{}
+	}
+
+	return e.now()
 }
 
 // Config returns the configuration
@@ -58,117 +408,1429 @@ func (e *Executor) Config() *config.Config {
 	return e.cfg
 }
 
-// Close closes the database connection
+// Database returns the name of the database, from the connection URL when
+// there is one, empty when it is not configured
+func (e *Executor) Database() string {
+	name, _ := database.DatabaseName(e.cfg.Database)
+	return name
+}
+
+// DB returns the connection pool the migrations are applied with
+func (e *Executor) DB() *sql.DB {
+	return e.db
+}
+
+// Close closes the database connection, unless it was opened by the caller
 func (e *Executor) Close() error {
+	if !e.ownsDB {
+		return nil
+	}
+
 	return e.db.Close()
 }
 
 // GetPendingMigrations returns migrations that have not been applied yet
 func (e *Executor) GetPendingMigrations() []migrations.Migration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
 	return migrations.GetPendingMigrations(e.migrations, e.applied)
 }
 
 // ExecuteMigration executes a single migration
-func (e *Executor) ExecuteMigration(migration migrations.Migration) error {
+func (e *Executor) ExecuteMigration(ctx context.Context, migration migrations.Migration) error {
+	_, err := e.executeMigration(ctx, migration)
+	return err
+}
+
+// executeMigration executes a single migration and logs its outcome, it
+// reports false when another runner applied it first
+func (e *Executor) executeMigration(ctx context.Context, migration migrations.Migration) (bool, error) {
+	attrs := []any{slog.String("migration", migration.ID)}
+	if e.cfg.Target != "" {
+		attrs = append(attrs, slog.String("target", e.cfg.Target))
+	}
+	if e.cfg.Tenant != "" {
+		attrs = append(attrs, slog.String("tenant", e.cfg.Tenant))
+	}
+	logger := e.logger.With(attrs...)
+
+	ctx, end := e.startSpan(ctx, SpanMigration, slog.String("migration", migration.ID), slog.Bool("transaction", e.transactional(migration)))
+	logger.InfoContext(ctx, "applying migration", slog.Bool("transaction", e.transactional(migration)))
+	start := time.Now()
+
+	executed, err := e.apply(ctx, migration)
+	end(err)
+	duration := time.Since(start)
+	if err != nil {
+		logger.ErrorContext(ctx, "migration failed", slog.String("outcome", OutcomeFailed), slog.Duration("duration", duration), slog.String("error", err.Error()))
+		e.notify(ctx, Event{Type: EventMigrationFailed, Migration: migration.ID, Duration: duration, Err: err})
+		return false, err
+	}
+
+	if !executed {
+		logger.InfoContext(ctx, "migration already applied by another runner", slog.String("outcome", OutcomeSkipped))
+		return false, nil
+	}
+
+	logger.InfoContext(ctx, "migration applied", slog.String("outcome", OutcomeApplied), slog.Duration("duration", duration))
+	e.batch = append(e.batch, migration.ID)
+	e.notify(ctx, Event{Type: EventMigrationApplied, Migration: migration.ID, Duration: duration})
+	e.maintain(ctx, logger, migration)
+	return true, nil
+}
+
+// maintain runs the maintenance statements of an applied migration: ANALYZE
+// of the tables it writes to and the configured and declared statements. The
+// migration is committed already, so failures are logged and the run goes on.
+func (e *Executor) maintain(ctx context.Context, logger *slog.Logger, migration migrations.Migration) {
+	var statements []string
+	if e.cfg.Migrations.Analyze || migration.Analyze {
+		if analyzer, ok := e.dialect.(database.Analyzer); ok {
+			for _, table := range lint.WrittenTables(migration.Content) {
+				statements = append(statements, analyzer.AnalyzeSQL(table))
+			}
+		} else {
+			logger.WarnContext(ctx, "skipping analyze", slog.String("error", fmt.Sprintf("ANALYZE is not supported by the %s dialect", e.dialect.Name())))
+		}
+	}
+	statements = append(statements, e.cfg.Migrations.After...)
+	statements = append(statements, migration.After...)
+
+	for _, statement := range statements {
+		start := time.Now()
+		if _, err := e.db.ExecContext(ctx, statement); err != nil {
+			logger.WarnContext(ctx, "maintenance statement failed", slog.String("statement", statement), slog.String("error", err.Error()))
+			continue
+		}
+		logger.InfoContext(ctx, "maintenance statement executed", slog.String("statement", statement), slog.Duration("duration", time.Since(start)))
+	}
+}
+
+// apply runs a migration within the configured timeout, it reports false when
+// another runner applied it first
+func (e *Executor) apply(ctx context.Context, migration migrations.Migration) (bool, error) {
+	timeout := e.cfg.Migrations.Timeout
+	if timeout <= 0 {
+		return e.run(ctx, migration)
+	}
+
+	// Cancelling the context cancels the running query on the server too
+	ctx, cancel := context.WithTimeoutCause(ctx, timeout, fmt.Errorf("%w of %s", ErrMigrationTimeout, timeout))
+	defer cancel()
+
+	executed, err := e.run(ctx, migration)
+	if err != nil && errors.Is(context.Cause(ctx), ErrMigrationTimeout) {
+		return false, fmt.Errorf("%w: %w", context.Cause(ctx), err)
+	}
+
+	return executed, err
+}
+
+// run executes the statements of a migration and records it, it reports false
+// when another runner applied it first
+func (e *Executor) run(ctx context.Context, migration migrations.Migration) (bool, error) {
+	statements := e.dialect.SplitStatements(migration.Content)
+	start := time.Now()
+
 	// Check if the migration uses transactions
-	if migration.DisableTx {
-		// Execute without a transaction
-		if _, err := e.db.Exec(migration.Content); err != nil {
-			return fmt.Errorf("failed to execute migration %s: %w", migration.ID, err)
+	if !e.transactional(migration) {
+		if line := e.autoDisableTx(migration); line > 0 && !migration.DisableTx {
+			e.logger.InfoContext(ctx, "running migration outside of a transaction",
+				slog.String("migration", migration.ID),
+				slog.Int("line", line),
+				slog.String("reason", "statement cannot run inside a transaction"))
 		}
 
-		// Record the migration
-		if err := database.RecordMigration(e.db, migration.ID, nil); err != nil {
-			return err
+		set, reset, err := e.sessionSQL(migration, false)
+		if err != nil {
+			return false, err
 		}
 
-		// Record the history with the SQL content
-		if err := database.RecordHistory(e.db, migration.ID, migration.Content, nil); err != nil {
-			return err
+		// Execute without a transaction, on a single connection when the
+		// migration changes session settings so that every statement sees them
+		var conn execer = e.db
+		if len(set) > 0 {
+			session, err := e.db.Conn(ctx)
+			if err != nil {
+				return false, fmt.Errorf("failed to get a connection for migration %s: %w", migration.ID, err)
+			}
+			defer session.Close() //nolint:errcheck
+			defer e.resetSession(session, reset)
+
+			for _, statement := range set {
+				if _, err := session.ExecContext(ctx, statement); err != nil {
+					return false, fmt.Errorf("failed to set up the session of migration %s: %w", migration.ID, err)
+				}
+			}
+			conn = session
+		}
+
+		for _, statement := range statements {
+			if _, err := conn.ExecContext(ctx, statement); err != nil {
+				return false, e.statementError(migration, statement, err)
+			}
+		}
+
+		if err := e.checkAccess(ctx, e.db, migration); err != nil {
+			return false, err
+		}
+
+		if err := e.record(ctx, migration, time.Since(start), nil); err != nil {
+			return false, err
 		}
 	} else {
 		// Begin a transaction
-		tx, err := e.db.Begin()
+		tx, err := e.db.BeginTx(ctx, nil)
 		if err != nil {
-			return fmt.Errorf("failed to begin transaction for migration %s: %w", migration.ID, err)
+			return false, fmt.Errorf("failed to begin transaction for migration %s: %w", migration.ID, err)
 		}
 
-		// Execute the migration
-		if _, err := tx.Exec(migration.Content); err != nil {
+		// Without a session lock, serialize runners on the transaction instead
+		if e.cfg.Database.PgBouncer {
+			if applied, err := e.lockTx(ctx, tx, migration); err != nil || applied {
+				tx.Rollback() //nolint:errcheck
+				return false, err
+			}
+		}
+
+		// Bound the statements on the server too, as the scripts do
+		if statement := e.statementTimeoutSQL(); statement != "" {
+			if _, err := tx.ExecContext(ctx, statement); err != nil {
+				tx.Rollback() //nolint:errcheck
+				return false, fmt.Errorf("failed to set the statement timeout of migration %s: %w", migration.ID, err)
+			}
+		}
+
+		// Rolling back the transaction restores the session settings too
+		set, reset, err := e.sessionSQL(migration, true)
+		if err != nil {
 			tx.Rollback() //nolint:errcheck
-			return fmt.Errorf("failed to execute migration %s: %w", migration.ID, err)
+			return false, err
+		}
+
+		for _, statement := range set {
+			if _, err := tx.ExecContext(ctx, statement); err != nil {
+				tx.Rollback() //nolint:errcheck
+				return false, fmt.Errorf("failed to set up the session of migration %s: %w", migration.ID, err)
+			}
+		}
+
+		// Execute the migration
+		for _, statement := range statements {
+			if _, err := tx.ExecContext(ctx, statement); err != nil {
+				tx.Rollback() //nolint:errcheck
+				return false, e.statementError(migration, statement, err)
+			}
+		}
+
+		// Record the migration as the connecting user, who owns the mig
+		// tables, with the search path they are found in
+		for _, statement := range reset {
+			if _, err := tx.ExecContext(ctx, statement); err != nil {
+				tx.Rollback() //nolint:errcheck
+				return false, fmt.Errorf("failed to reset the session of migration %s: %w", migration.ID, err)
+			}
 		}
 
-		// Record the migration
-		if err := database.RecordMigration(e.db, migration.ID, tx); err != nil {
+		if err := e.checkAccess(ctx, tx, migration); err != nil {
 			tx.Rollback() //nolint:errcheck
-			return err
+			return false, err
 		}
 
-		// Record the history with the SQL content
-		if err := database.RecordHistory(e.db, migration.ID, migration.Content, tx); err != nil {
+		if err := e.record(ctx, migration, time.Since(start), tx); err != nil {
 			tx.Rollback() //nolint:errcheck
-			return err
+			return false, err
 		}
 
 		// Commit the transaction
 		if err := tx.Commit(); err != nil {
-			return fmt.Errorf("failed to commit transaction for migration %s: %w", migration.ID, err)
+			return false, fmt.Errorf("failed to commit transaction for migration %s: %w", migration.ID, err)
 		}
 	}
 
-	return nil
+	return true, nil
 }
 
-// ExecuteNextMigration executes the next pending migration
-func (e *Executor) ExecuteNextMigration() (bool, error) {
-	pending := e.GetPendingMigrations()
-	if len(pending) == 0 {
-		return false, nil
+// statementTimeoutSQL returns the statement bounding the statements of a
+// migration transaction to the configured timeout, or "" when there is none
+// or the dialect cannot
+func (e *Executor) statementTimeoutSQL() string {
+	timeouter, ok := e.dialect.(database.StatementTimeouter)
+	if !ok || e.cfg.Migrations.Timeout <= 0 {
+		return ""
 	}
 
-	// Execute the first pending migration
-	if err := e.ExecuteMigration(pending[0]); err != nil {
-		return false, err
+	return timeouter.StatementTimeoutSQL(e.cfg.Migrations.Timeout)
+}
+
+// execer runs statements on a connection pool, a connection or a transaction
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// sessionSQL returns the statements applying the session settings declared
+// by a migration, its role and search path, and those restoring the settings
+// of the connection, failing when the dialect cannot apply one of them. The
+// search path only lasts for the current transaction when local is set.
+func (e *Executor) sessionSQL(migration migrations.Migration, local bool) (set []string, reset []string, err error) {
+	if migration.Role != "" {
+		setter, ok := e.dialect.(database.RoleSetter)
+		if !ok {
+			return nil, nil, fmt.Errorf("%s declares role=%s but roles are not supported by the %s dialect", migration.Filename, migration.Role, e.dialect.Name())
+		}
+		set = append(set, setter.SetRoleSQL(migration.Role))
+		reset = append(reset, setter.ResetRoleSQL())
+	}
+
+	if len(migration.SearchPath) > 0 {
+		setter, ok := e.dialect.(database.SearchPathSetter)
+		if !ok {
+			return nil, nil, fmt.Errorf("%s declares search_path=%s but search paths are not supported by the %s dialect", migration.Filename, strings.Join(migration.SearchPath, ","), e.dialect.Name())
+		}
+		set = append(set, setter.SetSearchPathSQL(migration.SearchPath, local))
+		reset = append(reset, setter.ResetSearchPathSQL())
 	}
 
-	// Refresh the list of applied migrations
-	applied, err := database.GetAppliedMigrations(e.db)
+	return set, reset, nil
+}
+
+// resetSession restores the settings of conn before it returns to the pool,
+// discarding the connection when that fails
+func (e *Executor) resetSession(conn *sql.Conn, reset []string) {
+	ctx := context.Background()
+	for _, statement := range reset {
+		if _, err := conn.ExecContext(ctx, statement); err != nil {
+			e.logger.WarnContext(ctx, "failed to reset the session, closing the connection", slog.String("error", err.Error()))
+			conn.Raw(func(any) error { return driver.ErrBadConn }) //nolint:errcheck
+			return
+		}
+	}
+}
+
+// checkAccess runs the access checks after the statements of a migration,
+// before it is recorded, failing when the application roles lost access
+func (e *Executor) checkAccess(ctx context.Context, q database.Querier, migration migrations.Migration) error {
+	if len(e.cfg.Access.Grants) == 0 && len(e.cfg.Access.Queries) == 0 {
+		return nil
+	}
+
+	problems, err := database.CheckAccess(ctx, q, e.dialect, e.cfg.Access)
 	if err != nil {
-		return true, err
+		return fmt.Errorf("failed to check access after migration %s: %w", migration.ID, err)
 	}
 
-	e.applied = applied
-	return true, nil
+	if len(problems) > 0 {
+		return fmt.Errorf("%w after migration %s: %s", ErrAccessLost, migration.ID, strings.Join(problems, "; "))
+	}
+
+	return nil
 }
 
-// ExecuteAllMigrations executes all pending migrations
-func (e *Executor) ExecuteAllMigrations() (int, error) {
-	count := 0
-	for {
-		executed, err := e.ExecuteNextMigration()
-		if err != nil {
-			return count, err
+// record records an executed migration in mig_versions and its SQL content in
+// mig_history, within tx when it is not nil
+func (e *Executor) record(ctx context.Context, migration migrations.Migration, duration time.Duration, tx *sql.Tx) (err error) {
+	ctx, end := e.startSpan(ctx, SpanRecord, slog.String("migration", migration.ID))
+	defer func() { end(err) }()
+
+	// Record the migration
+	if err := database.RecordMigration(ctx, e.db, e.dialect, migration.ID, migration.Checksum, duration, e.recordedAt(), tx); err != nil {
+		return err
+	}
+
+	// Record the history with the SQL content
+	return database.RecordHistory(ctx, e.db, e.dialect, migration.ID, migration.Content, e.recordedAt(), tx)
+}
+
+// transactional reports whether a migration runs inside a transaction
+func (e *Executor) transactional(migration migrations.Migration) bool {
+	if migration.DisableTx || e.autoDisableTx(migration) > 0 {
+		return false
+	}
+
+	// Without transactions by default, migrations opt in with "-- mig:tx"
+	if !migration.EnableTx && !e.cfg.Migrations.TransactionsByDefault() {
+		return false
+	}
+
+	return e.dialect.SupportsTransactionalDDL()
+}
+
+// autoDisableTx returns the line of the statement that makes a migration run
+// outside of a transaction when migrations.auto_disable_tx is set, or 0
+func (e *Executor) autoDisableTx(migration migrations.Migration) int {
+	if !e.cfg.Migrations.AutoDisableTx {
+		return 0
+	}
+
+	return lint.FindNonTransactional(migration.Content)
+}
+
+// lockTx takes the migration lock for the transaction and reports whether the
+// migration was applied by another runner while waiting for it
+func (e *Executor) lockTx(ctx context.Context, tx *sql.Tx, migration migrations.Migration) (bool, error) {
+	locker, ok := e.dialect.(database.TxLocker)
+	if !ok {
+		return false, nil
+	}
+
+	if err := locker.LockTx(ctx, tx); err != nil {
+		return false, err
+	}
+
+	return database.IsApplied(ctx, tx, e.dialect, migration.ID)
+}
+
+// ExecuteNextMigration executes the next pending migration
+func (e *Executor) ExecuteNextMigration(ctx context.Context) (bool, error) {
+	var executed bool
+	err := e.withLock(ctx, nextPending, func(ctx context.Context) error {
+		if err := e.checkOrder(ctx); err != nil {
+			return err
 		}
 
-		if !executed {
-			break
+		if pending := e.GetPendingMigrations(); len(pending) > 0 {
+			if err := e.preflight(ctx, pending[:1]); err != nil {
+				return err
+			}
+
+			if err := e.backup(ctx); err != nil {
+				return err
+			}
 		}
 
-		count++
+		var err error
+		executed, err = e.executeNext(ctx)
+		return err
+	})
+
+	return executed, err
+}
+
+// ExecuteAllMigrations executes all pending migrations
+func (e *Executor) ExecuteAllMigrations(ctx context.Context) (int, error) {
+	return e.executeAll(ctx, "")
+}
+
+// ExecutePhase executes the pending migrations of a deployment phase,
+// migrations.PhaseExpand or migrations.PhaseContract, in order, stopping
+// before the first pending migration of the other phase
+func (e *Executor) ExecutePhase(ctx context.Context, phase string) (int, error) {
+	if phase != migrations.PhaseExpand && phase != migrations.PhaseContract {
+		return 0, fmt.Errorf("invalid phase %q, expected expand or contract", phase)
 	}
 
-	return count, nil
+	return e.executeAll(ctx, phase)
 }
 
-// Status returns the status of migrations
-func (e *Executor) Status() ([]migrations.Migration, []database.MigrationVersion, error) {
-	// Refresh the list of applied migrations to ensure it's up to date
-	applied, err := database.GetAppliedMigrations(e.db)
-	if err != nil {
-		return nil, nil, err
+// executeAll executes the pending migrations, all of them when phase is
+// empty or else those up to the first one outside of phase
+func (e *Executor) executeAll(ctx context.Context, phase string) (int, error) {
+	selection := allPending
+	if phase != "" {
+		selection = phasePending(phase)
 	}
 
-	e.applied = applied
-	return e.migrations, e.applied, nil
+	count := 0
+	err := e.withLock(ctx, selection, func(ctx context.Context) error {
+		if err := e.checkOrder(ctx); err != nil {
+			return err
+		}
+
+		pending := selection(e.GetPendingMigrations())
+		if phase == "" {
+			e.checkPhases(ctx, pending)
+		}
+
+		if err := e.preflight(ctx, pending); err != nil {
+			return err
+		}
+
+		if len(pending) > 0 {
+			if err := e.backup(ctx); err != nil {
+				return err
+			}
+		}
+
+		for {
+			if phase != "" {
+				next := e.GetPendingMigrations()
+				if len(next) == 0 || !next[0].InPhase(phase) {
+					return nil
+				}
+			}
+
+			executed, err := e.executeNext(ctx)
+			if err != nil {
+				return err
+			}
+
+			if !executed {
+				return nil
+			}
+
+			count++
+		}
+	})
+
+	return count, err
+}
+
+// Migration returns the loaded migration file with the given ID
+func (e *Executor) Migration(id string) (migrations.Migration, error) {
+	files := e.loaded()
+	index := slices.IndexFunc(files, func(m migrations.Migration) bool {
+		return m.ID == id
+	})
+	if index == -1 {
+		return migrations.Migration{}, fmt.Errorf("%w: %s", ErrMigrationNotFound, id)
+	}
+
+	return files[index], nil
+}
+
+// ExecuteByID executes a single pending migration, which must be the next one
+// unless allowOutOfOrder is set
+func (e *Executor) ExecuteByID(ctx context.Context, id string, allowOutOfOrder bool) error {
+	selection := func(pending []migrations.Migration) []migrations.Migration {
+		return slices.DeleteFunc(pending, func(m migrations.Migration) bool { return m.ID != id })
+	}
+
+	return e.withLock(ctx, selection, func(ctx context.Context) error {
+		pending := e.GetPendingMigrations()
+		index := slices.IndexFunc(pending, func(m migrations.Migration) bool {
+			return m.ID == id
+		})
+
+		if index == -1 {
+			if slices.ContainsFunc(e.loaded(), func(m migrations.Migration) bool { return m.ID == id }) {
+				return fmt.Errorf("%w: %s", ErrAlreadyApplied, id)
+			}
+			return fmt.Errorf("%w: %s", ErrMigrationNotFound, id)
+		}
+
+		if index > 0 && !allowOutOfOrder {
+			return fmt.Errorf("migration %s is not the next pending migration, %s must be applied first", id, pending[0].ID)
+		}
+
+		if !allowOutOfOrder {
+			if err := e.checkOrder(ctx); err != nil {
+				return err
+			}
+		}
+
+		if err := e.preflight(ctx, pending[index:index+1]); err != nil {
+			return err
+		}
+
+		if err := e.backup(ctx); err != nil {
+			return err
+		}
+
+		executed, err := e.executeMigration(ctx, pending[index])
+		if err != nil {
+			return e.restore(ctx, pending[index], err)
+		}
+
+		// Refresh the list of applied migrations
+		applied, err := database.GetAppliedMigrations(ctx, e.db)
+		if err != nil {
+			return err
+		}
+		e.setApplied(applied)
+
+		if !executed {
+			return fmt.Errorf("%w: %s", ErrAlreadyApplied, id)
+		}
+
+		return nil
+	})
+}
+
+// checkOrder applies the out-of-order policy to the pending migrations older
+// than the last applied one, the caller must hold the lock
+func (e *Executor) checkOrder(ctx context.Context) error {
+	policy := e.cfg.Migrations.OutOfOrder
+	if policy == "" || policy == config.OutOfOrderAllow {
+		return nil
+	}
+
+	conflicts := e.outOfOrder()
+	if len(conflicts) == 0 {
+		return nil
+	}
+
+	if policy == config.OutOfOrderFail {
+		return fmt.Errorf(`%w: %s; run "mig rebase" to move them after the applied ones, or "mig up -only <id> -allow-out-of-order" to apply one in its current place`,
+			ErrOutOfOrder, strings.Join(conflicts, ", "))
+	}
+
+	e.logger.WarnContext(ctx, "pending migrations older than the last applied one",
+		slog.String("migrations", strings.Join(conflicts, ", ")))
+	return nil
+}
+
+// checkPhases warns about the contract migrations applied in the same run as
+// an expand migration before them, which breaks the application version still
+// running while the new one is deployed
+func (e *Executor) checkPhases(ctx context.Context, pending []migrations.Migration) {
+	var expand string
+	for _, migration := range pending {
+		switch migration.Phase {
+		case migrations.PhaseExpand:
+			expand = migration.ID
+		case migrations.PhaseContract:
+			if expand != "" {
+				e.logger.WarnContext(ctx, "contract migration applied in the same release as an expand migration, apply it after the deployment with the contract phase",
+					slog.String("migration", migration.ID), slog.String("expand", expand))
+			}
+		}
+	}
+}
+
+// outOfOrder describes the pending migrations older than the last applied one
+// as "<pending> precedes applied <version>", naming the first applied
+// migration each one precedes
+func (e *Executor) outOfOrder() []string {
+	e.mu.Lock()
+	versions := make([]string, 0, len(e.applied))
+	for _, applied := range e.applied {
+		versions = append(versions, applied.Version)
+	}
+	e.mu.Unlock()
+	slices.Sort(versions)
+
+	var conflicts []string
+	for _, migration := range e.GetPendingMigrations() {
+		i, _ := slices.BinarySearch(versions, migration.ID)
+		if i < len(versions) {
+			conflicts = append(conflicts, fmt.Sprintf("%s precedes applied %s", migration.ID, versions[i]))
+		}
+	}
+
+	return conflicts
+}
+
+// RebasedMigration is a migration file renamed by Rebase
+type RebasedMigration struct {
+	From string // Previous filename
+	To   string // New filename
+}
+
+// Rebase renames the files of the pending migrations older than the last
+// applied one to timestamps following every migration, from now on, so they
+// apply in their current order after the applied ones
+func (e *Executor) Rebase(ctx context.Context, now time.Time) ([]RebasedMigration, error) {
+	if e.cfg.Migrations.FS != nil {
+		return nil, errors.New("cannot rebase the migrations of a migrations fs.FS")
+	}
+
+	if !e.running.CompareAndSwap(false, true) {
+		return nil, ErrAlreadyRunning
+	}
+	defer e.running.Store(false)
+
+	applied, err := database.GetAppliedMigrations(ctx, e.db)
+	if err != nil {
+		return nil, err
+	}
+	e.setApplied(applied)
+
+	var last string
+	for _, version := range applied {
+		last = max(last, version.Version)
+	}
+
+	// Start after the latest migration file, in case its timestamp is ahead of now
+	next := now.Truncate(time.Second)
+	for _, migration := range e.loaded() {
+		if !next.After(migration.CreatedAt) {
+			next = migration.CreatedAt.Add(time.Second)
+		}
+	}
+
+	directories := append([]string{e.cfg.Migrations.Directory}, e.cfg.Migrations.ExtraDirectories...)
+
+	var rebased []RebasedMigration
+	for _, migration := range e.GetPendingMigrations() {
+		if migration.ID > last {
+			continue
+		}
+
+		filename, err := migrations.RenameMigrationFile(directories, migration, next)
+		if err != nil {
+			return rebased, err
+		}
+		rebased = append(rebased, RebasedMigration{From: migration.Filename, To: filename})
+		next = next.Add(time.Second)
+	}
+
+	// Reload the renamed migrations
+	files, err := migrations.Load(e.cfg.Migrations)
+	if err != nil {
+		return rebased, fmt.Errorf("failed to load migrations: %w", err)
+	}
+
+	e.mu.Lock()
+	e.migrations = files
+	e.mu.Unlock()
+
+	return rebased, nil
+}
+
+// AddMigration writes content as a new migration file named name in the
+// migrations directory, timestamped from now on after every migration so it
+// is the last one, and loads it
+func (e *Executor) AddMigration(name, content string, now time.Time) (migrations.Migration, error) {
+	if e.cfg.Migrations.FS != nil {
+		return migrations.Migration{}, errors.New("cannot add a migration to a migrations fs.FS")
+	}
+
+	e.mu.Lock()
+	at := now.Truncate(time.Second)
+	for _, migration := range e.migrations {
+		if !at.After(migration.CreatedAt) {
+			at = migration.CreatedAt.Add(time.Second)
+		}
+	}
+	e.mu.Unlock()
+
+	filename, err := migrations.WriteMigrationFile(e.cfg.Migrations.Directory, name, content, at)
+	if err != nil {
+		return migrations.Migration{}, err
+	}
+
+	files, err := migrations.Load(e.cfg.Migrations)
+	if err != nil {
+		return migrations.Migration{}, fmt.Errorf("failed to load migrations: %w", err)
+	}
+
+	e.mu.Lock()
+	e.migrations = files
+	e.mu.Unlock()
+
+	index := slices.IndexFunc(files, func(m migrations.Migration) bool {
+		return m.Filename == filename
+	})
+	if index == -1 {
+		return migrations.Migration{}, fmt.Errorf("%w: %s", ErrMigrationNotFound, filename)
+	}
+
+	return files[index], nil
+}
+
+// Archive moves the files of the migrations applied before a date
+// (YYYY-MM-DD) or older than a migration ID to the archive subdirectory of
+// their directory, so they are no longer loaded while Verify still knows
+// about them, and returns their filenames. It refuses to archive a migration
+// that is not applied yet.
+func (e *Executor) Archive(ctx context.Context, before string) ([]string, error) {
+	if e.cfg.Migrations.FS != nil {
+		return nil, errors.New("cannot archive the migrations of a migrations fs.FS")
+	}
+
+	if !e.running.CompareAndSwap(false, true) {
+		return nil, ErrAlreadyRunning
+	}
+	defer e.running.Store(false)
+
+	retired, err := e.archivable(ctx, before)
+	if err != nil {
+		return nil, err
+	}
+
+	directories := append([]string{e.cfg.Migrations.Directory}, e.cfg.Migrations.ExtraDirectories...)
+
+	var archived []string
+	for _, migration := range retired {
+		if err := migrations.ArchiveMigrationFile(directories, migration); err != nil {
+			return archived, err
+		}
+		archived = append(archived, migration.Filename)
+	}
+
+	// Reload the migrations left and the archived ones
+	files, err := migrations.Load(e.cfg.Migrations)
+	if err != nil {
+		return archived, fmt.Errorf("failed to load migrations: %w", err)
+	}
+
+	ids, err := migrations.LoadArchived(e.cfg.Migrations)
+	if err != nil {
+		return archived, fmt.Errorf("failed to load archived migrations: %w", err)
+	}
+
+	e.mu.Lock()
+	e.migrations = files
+	e.archived = ids
+	e.mu.Unlock()
+
+	return archived, nil
+}
+
+// ArchivePlan returns the filenames of the migrations Archive would move,
+// without moving them
+func (e *Executor) ArchivePlan(ctx context.Context, before string) ([]string, error) {
+	retired, err := e.archivable(ctx, before)
+	if err != nil {
+		return nil, err
+	}
+
+	filenames := make([]string, len(retired))
+	for i, migration := range retired {
+		filenames[i] = migration.Filename
+	}
+
+	return filenames, nil
+}
+
+// archivable returns the migrations applied before a date or older than a
+// migration ID, failing when one of them is not applied yet
+func (e *Executor) archivable(ctx context.Context, before string) ([]migrations.Migration, error) {
+	if before == "" {
+		return nil, errors.New("a date or a migration ID to archive before is required")
+	}
+
+	applied, err := database.GetAppliedMigrations(ctx, e.db)
+	if err != nil {
+		return nil, err
+	}
+	e.setApplied(applied)
+
+	appliedAt := make(map[string]time.Time, len(applied))
+	for _, version := range applied {
+		appliedAt[version.Version] = version.AppliedAt
+	}
+
+	retire := func(migration migrations.Migration) bool {
+		return migration.ID < before
+	}
+	if date, err := time.Parse(time.DateOnly, before); err == nil {
+		retire = func(migration migrations.Migration) bool {
+			at, ok := appliedAt[migration.ID]
+			return ok && at.Before(date)
+		}
+	}
+
+	for _, migration := range e.GetPendingMigrations() {
+		if retire(migration) {
+			return nil, fmt.Errorf("cannot archive %s: migration is not applied", migration.ID)
+		}
+	}
+
+	var retired []migrations.Migration
+	for _, migration := range e.loaded() {
+		if retire(migration) {
+			retired = append(retired, migration)
+		}
+	}
+
+	return retired, nil
+}
+
+// preflight checks the migrations about to be applied before running any of
+// them, so a run does not fail halfway on a migration that cannot succeed
+func (e *Executor) preflight(ctx context.Context, pending []migrations.Migration) error {
+	if err := e.checkServerVersion(ctx, pending); err != nil {
+		return err
+	}
+
+	for _, migration := range pending {
+		if _, _, err := e.sessionSQL(migration, false); err != nil {
+			return err
+		}
+	}
+
+	// Behind PgBouncer, runners are serialized by the lock of each migration's
+	// transaction, which a migration without one would run unprotected by
+	if e.cfg.Database.PgBouncer {
+		for _, migration := range pending {
+			if !e.transactional(migration) {
+				return fmt.Errorf("%w: %s, apply it over a direct connection to the database", ErrNoTxInPgBouncer, migration.Filename)
+			}
+		}
+	}
+
+	for _, migration := range pending {
+		if !e.transactional(migration) {
+			continue
+		}
+
+		if line := lint.FindConcurrently(migration.Content); line > 0 {
+			directive := `add "-- disable-tx"`
+			if migration.EnableTx && !e.cfg.Migrations.TransactionsByDefault() {
+				directive = `remove "-- mig:tx"`
+			}
+			return fmt.Errorf("%w: %s:%d, %s to run the migration outside of a transaction", ErrConcurrentInTx, migration.Filename, line, directive)
+		}
+	}
+
+	return e.checkLint(ctx, pending)
+}
+
+// checkServerVersion fails when a pending migration needs a newer
+// PostgreSQL than the server runs, before it fails on a syntax error
+func (e *Executor) checkServerVersion(ctx context.Context, pending []migrations.Migration) error {
+	var required migrations.Migration
+	for _, migration := range pending {
+		if migration.MinPG > required.MinPG {
+			required = migration
+		}
+	}
+
+	if required.MinPG == 0 {
+		return nil
+	}
+
+	versioner, ok := e.dialect.(database.ServerVersioner)
+	if !ok {
+		return fmt.Errorf("%s declares min-pg=%d but the %s dialect is not PostgreSQL", required.Filename, required.MinPG, e.dialect.Name())
+	}
+
+	version, err := versioner.ServerVersion(ctx, e.db)
+	if err != nil {
+		return err
+	}
+
+	if version < required.MinPG {
+		return fmt.Errorf("%w: %s needs PostgreSQL %d or later, the server runs PostgreSQL %d", ErrServerTooOld, required.Filename, required.MinPG, version)
+	}
+
+	return nil
+}
+
+// backup dumps the database before pending migrations are applied when
+// backups are enabled, the caller must hold the lock
+func (e *Executor) backup(ctx context.Context) error {
+	if !e.cfg.Backup.Enabled {
+		return nil
+	}
+
+	now := time.Now()
+	if e.now != nil {
+		now = e.now()
+	}
+
+	path, err := e.cfg.BackupFile(now)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrBackupFailed, err)
+	}
+
+	e.logger.InfoContext(ctx, "backing up the database", slog.String("path", path))
+
+	start := time.Now()
+	if err := database.Backup(ctx, e.dialect, e.cfg.Target, e.cfg.Database, e.cfg.Backup.Command, path); err != nil {
+		return fmt.Errorf("%w: %w", ErrBackupFailed, err)
+	}
+
+	e.logger.InfoContext(ctx, "database backed up", slog.String("path", path), slog.Duration("duration", time.Since(start)))
+	e.backupPath = path
+
+	return nil
+}
+
+// restore restores the backup taken before the run when the failed migration
+// ran outside of a transaction, since its partial changes cannot be rolled
+// back, the caller must hold the lock
+func (e *Executor) restore(ctx context.Context, migration migrations.Migration, err error) error {
+	if e.backupPath == "" || !e.cfg.Backup.RestoreOnFailure || e.transactional(migration) {
+		return err
+	}
+
+	// Restore even when the run was cancelled or timed out
+	ctx = context.WithoutCancel(ctx)
+
+	e.logger.ErrorContext(ctx, "RESTORING THE DATABASE from the backup taken before the run",
+		slog.String("path", e.backupPath),
+		slog.String("failed", migration.ID),
+		slog.Any("undone", e.batch))
+
+	if restoreErr := database.Restore(ctx, e.dialect, e.cfg.Target, e.cfg.Database, e.cfg.Backup.RestoreCommand, e.backupPath); restoreErr != nil {
+		e.logger.ErrorContext(ctx, "RESTORE FAILED, the database is left as the failed migration stopped",
+			slog.String("path", e.backupPath),
+			slog.String("error", restoreErr.Error()))
+		return errors.Join(err, fmt.Errorf("failed to restore the backup %s: %w", e.backupPath, restoreErr))
+	}
+
+	e.logger.ErrorContext(ctx, "DATABASE RESTORED, the migrations of the run are undone",
+		slog.String("path", e.backupPath),
+		slog.String("failed", migration.ID),
+		slog.Any("undone", e.batch))
+	e.batch = nil
+
+	// The backup holds the migrations applied before the run
+	if applied, appliedErr := database.GetAppliedMigrations(ctx, e.db); appliedErr == nil {
+		e.setApplied(applied)
+	}
+
+	return fmt.Errorf("%w; %w from %s", err, ErrRestored, e.backupPath)
+}
+
+// checkLint checks the migrations about to be applied, logging the warnings
+// and failing with ErrUnsafeMigration on errors
+func (e *Executor) checkLint(ctx context.Context, pending []migrations.Migration) error {
+	var errs []string
+	for _, migration := range pending {
+		for _, finding := range e.linter.Check(migration) {
+			if finding.Severity == config.SeverityError {
+				errs = append(errs, finding.String())
+				continue
+			}
+
+			e.logger.WarnContext(ctx, "risky migration statement",
+				slog.String("migration", finding.Migration),
+				slog.Int("line", finding.Line),
+				slog.String("rule", finding.Rule),
+				slog.String("message", finding.Message))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %s", ErrUnsafeMigration, strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// executeNext executes the next pending migration, the caller must hold the lock
+func (e *Executor) executeNext(ctx context.Context) (bool, error) {
+	for {
+		pending := e.GetPendingMigrations()
+		if len(pending) == 0 {
+			return false, nil
+		}
+
+		// Execute the first pending migration
+		executed, err := e.executeMigration(ctx, pending[0])
+		if err != nil {
+			return false, e.restore(ctx, pending[0], err)
+		}
+
+		// Refresh the list of applied migrations
+		applied, err := database.GetAppliedMigrations(ctx, e.db)
+		if err != nil {
+			return executed, err
+		}
+		e.setApplied(applied)
+
+		// Move on to the next one if another runner applied it first
+		if executed {
+			return true, nil
+		}
+	}
+}
+
+// selector picks the pending migrations a run is about to apply
+type selector func(pending []migrations.Migration) []migrations.Migration
+
+// allPending selects every pending migration
+func allPending(pending []migrations.Migration) []migrations.Migration {
+	return pending
+}
+
+// nextPending selects the next pending migration
+func nextPending(pending []migrations.Migration) []migrations.Migration {
+	return pending[:min(len(pending), 1)]
+}
+
+// phasePending selects the pending migrations of phase up to the first one
+// outside of it
+func phasePending(phase string) selector {
+	return func(pending []migrations.Migration) []migrations.Migration {
+		if i := slices.IndexFunc(pending, func(m migrations.Migration) bool { return !m.InPhase(phase) }); i >= 0 {
+			return pending[:i]
+		}
+		return pending
+	}
+}
+
+// withLock runs fn within the run timeout while holding the dialect's
+// migration lock, as a run of the pending migrations selection picks
+func (e *Executor) withLock(ctx context.Context, selection selector, fn func(context.Context) error) error {
+	timeout := e.cfg.Timeouts.Run
+	if timeout <= 0 {
+		return e.lock(ctx, selection, fn)
+	}
+
+	ctx, cancel := context.WithTimeoutCause(ctx, timeout, fmt.Errorf("%w of %s", ErrRunTimeout, timeout))
+	defer cancel()
+
+	err := e.lock(ctx, selection, fn)
+	if err != nil && errors.Is(context.Cause(ctx), ErrRunTimeout) {
+		return fmt.Errorf("%w: %w", context.Cause(ctx), err)
+	}
+
+	return err
+}
+
+// lock runs fn as a migration run while holding the dialect's migration lock
+//
+// The applied migrations are refreshed once the lock is held, so a runner
+// that waited for another one never re-applies what it just did.
+func (e *Executor) lock(ctx context.Context, selection selector, fn func(context.Context) error) error {
+	return e.holdLock(ctx, func(ctx context.Context) error {
+		applied, err := database.GetAppliedMigrations(ctx, e.db)
+		if err != nil {
+			return err
+		}
+		e.setApplied(applied)
+
+		return e.observeRun(ctx, selection, fn)
+	})
+}
+
+// holdLock runs fn while holding the dialect's migration lock
+func (e *Executor) holdLock(ctx context.Context, fn func(context.Context) error) error {
+	// The database lock is per connection, so guard against concurrent calls
+	// on this executor too
+	if !e.running.CompareAndSwap(false, true) {
+		return ErrAlreadyRunning
+	}
+	defer e.running.Store(false)
+
+	// Session locks are not kept behind PgBouncer, migrations lock their
+	// transaction instead
+	if e.cfg.Database.PgBouncer {
+		return fn(ctx)
+	}
+
+	conn, err := e.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get a connection for the migration lock: %w", err)
+	}
+	defer conn.Close() //nolint:errcheck
+
+	e.logger.DebugContext(ctx, "acquiring migration lock")
+	lockCtx, end := e.startSpan(ctx, SpanLock)
+	if e.cfg.Timeouts.Lock > 0 {
+		var cancel context.CancelFunc
+		lockCtx, cancel = context.WithTimeout(lockCtx, e.cfg.Timeouts.Lock)
+		defer cancel()
+	}
+	err = e.dialect.Lock(lockCtx, conn)
+	end(err)
+	if err != nil {
+		if errors.Is(lockCtx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("%w: %w", ErrLockTimeout, err)
+		}
+		return err
+	}
+	e.logger.DebugContext(ctx, "migration lock acquired")
+	defer e.dialect.Unlock(context.Background(), conn) //nolint:errcheck
+
+	return fn(ctx)
+}
+
+// Verify checks that the files of the applied migrations did not change since
+// they were applied and are still on disk, returning an error wrapping
+// ErrChecksumMismatch or ErrMissingMigration otherwise
+func (e *Executor) Verify(ctx context.Context) error {
+	statuses, err := e.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	var modified, missing []string
+	for _, status := range statuses {
+		switch {
+		case status.Missing:
+			missing = append(missing, status.ID)
+		case status.Modified:
+			modified = append(modified, status.ID)
+		}
+	}
+
+	var errs []error
+	if len(modified) > 0 {
+		errs = append(errs, fmt.Errorf("%w: %s", ErrChecksumMismatch, strings.Join(modified, ", ")))
+	}
+	if len(missing) > 0 {
+		errs = append(errs, fmt.Errorf("%w: %s", ErrMissingMigration, strings.Join(missing, ", ")))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Plan returns the pending migrations in the order they would be applied,
+// without executing them
+func (e *Executor) Plan(ctx context.Context) ([]PlannedMigration, error) {
+	// Refresh the list of applied migrations to ensure it's up to date
+	applied, err := database.GetAppliedMigrations(ctx, e.db)
+	if err != nil {
+		return nil, err
+	}
+	e.setApplied(applied)
+
+	pending := e.GetPendingMigrations()
+	plan := make([]PlannedMigration, len(pending))
+	for i, migration := range pending {
+		plan[i] = PlannedMigration{
+			ID:            migration.ID,
+			Name:          migration.Name,
+			Filename:      migration.Filename,
+			Checksum:      migration.Checksum,
+			Transactional: e.transactional(migration),
+			Phase:         migration.Phase,
+		}
+	}
+
+	return plan, nil
+}
+
+// LockImpact is the table lock a statement of a pending migration takes,
+// with the size of the table
+type LockImpact struct {
+	Migration string // ID of the migration
+	Line      int    // Line of the statement in the migration file
+	Statement string // Statement as written in the migration file
+	Table     string // Locked table
+	Mode      string // Lock mode, such as "ACCESS EXCLUSIVE"
+	Risk      string // "high" when reads wait, "medium" when writes wait, "low" otherwise
+	Exists    bool   // Whether the table exists yet, Rows and Bytes are 0 otherwise
+	Rows      int64  // Rows of the table estimated from the planner statistics
+	Bytes     int64  // Size of the table on disk, with its indexes
+}
+
+// AnalyzeLocks returns the table lock each statement of the pending
+// migrations takes and the estimated size of the table, without executing
+// anything, to tell which deploys need a maintenance window
+func (e *Executor) AnalyzeLocks(ctx context.Context) ([]LockImpact, error) {
+	sizer, ok := e.dialect.(database.TableSizer)
+	if !ok {
+		return nil, fmt.Errorf("lock analysis is not supported by the %s dialect", e.dialect.Name())
+	}
+
+	// Refresh the list of applied migrations to ensure it's up to date
+	applied, err := database.GetAppliedMigrations(ctx, e.db)
+	if err != nil {
+		return nil, err
+	}
+	e.setApplied(applied)
+
+	var impacts []LockImpact
+	var tables []string
+	for _, migration := range e.GetPendingMigrations() {
+		for _, lock := range lint.Locks(migration.Content) {
+			impacts = append(impacts, LockImpact{
+				Migration: migration.ID,
+				Line:      lock.Line,
+				Statement: lock.Statement,
+				Table:     lock.Table,
+				Mode:      lock.Mode,
+				Risk:      lint.Risk(lock.Mode),
+			})
+
+			if !slices.Contains(tables, lock.Table) {
+				tables = append(tables, lock.Table)
+			}
+		}
+	}
+
+	sizes, err := sizer.TableSizes(ctx, e.db, tables)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, impact := range impacts {
+		if size, ok := sizes[impact.Table]; ok {
+			impacts[i].Exists, impacts[i].Rows, impacts[i].Bytes = true, size.Rows, size.Bytes
+		}
+	}
+
+	return impacts, nil
+}
+
+// Explain estimates the rows each INSERT, UPDATE and DELETE statement of the
+// pending migrations would touch, without executing them, to catch a full
+// table update before it runs. A statement that cannot be planned yet, such
+// as one on a table created by an earlier pending statement, reports why in
+// its Err instead of failing the whole run.
+func (e *Executor) Explain(ctx context.Context) ([]Estimate, error) {
+	explainer, ok := e.dialect.(database.Explainer)
+	if !ok {
+		return nil, fmt.Errorf("explain is not supported by the %s dialect", e.dialect.Name())
+	}
+
+	// Refresh the list of applied migrations to ensure it's up to date
+	applied, err := database.GetAppliedMigrations(ctx, e.db)
+	if err != nil {
+		return nil, err
+	}
+	e.setApplied(applied)
+
+	var estimates []Estimate
+	for _, migration := range e.GetPendingMigrations() {
+		set, _, err := e.sessionSQL(migration, true)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, statement := range lint.DMLStatements(migration.Content) {
+			estimate := Estimate{Migration: migration.ID, Line: statement.Line, Statement: statement.SQL}
+			estimate.Rows, estimate.Err = e.explain(ctx, explainer, set, statement.SQL)
+			estimates = append(estimates, estimate)
+		}
+	}
+
+	return estimates, nil
+}
+
+// explain estimates the rows of a statement with the role and search path of
+// its migration, inside a transaction rolled back afterwards so the session
+// settings do not outlive it
+func (e *Executor) explain(ctx context.Context, explainer database.Explainer, set []string, statement string) (int64, error) {
+	tx, err := e.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	for _, s := range set {
+		if _, err := tx.ExecContext(ctx, s); err != nil {
+			return 0, fmt.Errorf("failed to apply %q: %w", s, err)
+		}
+	}
+
+	return explainer.EstimateRows(ctx, tx, statement)
+}
+
+// Script writes the SQL that applying the next pending migration, or all of
+// them, would execute: each migration with its transaction wrapper and
+// statement timeout when it runs in a transaction, and the statements of the
+// dialect recording it, without executing anything
+func (e *Executor) Script(ctx context.Context, w io.Writer, all bool) error {
+	// Refresh the list of applied migrations to ensure it's up to date
+	applied, err := database.GetAppliedMigrations(ctx, e.db)
+	if err != nil {
+		return err
+	}
+	e.setApplied(applied)
+
+	pending := e.GetPendingMigrations()
+	if !all && len(pending) > 1 {
+		pending = pending[:1]
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "-- Generated by mig %s for %d pending migration(s)\n", version.Version, len(pending))
+
+	for _, migration := range pending {
+		transactional := e.transactional(migration)
+
+		fmt.Fprintf(&b, "\n-- Migration: %s\n", migration.ID)
+		if timer, ok := e.dialect.(database.ScriptTimer); ok {
+			fmt.Fprintf(&b, "%s;\n", timer.StartTimerSQL())
+		}
+		if transactional {
+			b.WriteString("BEGIN TRANSACTION;\n")
+			if statement := e.statementTimeoutSQL(); statement != "" {
+				fmt.Fprintf(&b, "%s;\n", statement)
+			}
+		}
+
+		set, reset, err := e.sessionSQL(migration, transactional)
+		if err != nil {
+			return err
+		}
+		for _, statement := range set {
+			fmt.Fprintf(&b, "%s;\n", statement)
+		}
+
+		// Terminate the last statement, unless it ends a batch
+		content := strings.TrimSpace(migration.Content)
+		b.WriteString(content)
+		lines := strings.Split(content, "\n")
+		if !strings.HasSuffix(content, ";") && !strings.EqualFold(strings.TrimSpace(lines[len(lines)-1]), "GO") {
+			b.WriteString(";")
+		}
+		b.WriteString("\n")
+
+		for _, statement := range reset {
+			fmt.Fprintf(&b, "%s;\n", statement)
+		}
+
+		fmt.Fprintf(&b, "%s;\n", e.dialect.RecordMigrationSQL(migration.ID, migration.Checksum))
+		fmt.Fprintf(&b, "%s;\n", e.dialect.RecordHistorySQL(migration.ID, migration.Content))
+
+		if transactional {
+			b.WriteString("COMMIT;\n")
+		}
+	}
+
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// DumpSchema returns the schema of the database as normalized DDL
+func (e *Executor) DumpSchema(ctx context.Context) (string, error) {
+	return database.DumpSchema(ctx, e.db, e.dialect)
+}
+
+// History streams the history entries recorded after the given ID, in order
+func (e *Executor) History(ctx context.Context, afterID int64) iter.Seq2[database.HistoryEntry, error] {
+	return database.IterHistory(ctx, e.db, e.dialect, afterID)
+}
+
+// Versions returns the records of mig_versions, in the order they were applied
+func (e *Executor) Versions(ctx context.Context) ([]database.MigrationVersion, error) {
+	return database.GetAppliedMigrations(ctx, e.db)
+}
+
+// Status returns the status of every migration file, in order
+func (e *Executor) Status(ctx context.Context) ([]MigrationStatus, error) {
+	// Refresh the list of applied migrations to ensure it's up to date
+	applied, err := database.GetAppliedMigrations(ctx, e.db)
+	if err != nil {
+		return nil, err
+	}
+	e.setApplied(applied)
+
+	// Create a map of applied migrations for quick lookup
+	appliedMap := make(map[string]database.MigrationVersion, len(applied))
+	for _, version := range applied {
+		appliedMap[version.Version] = version
+	}
+
+	files := e.loaded()
+	statuses := make([]MigrationStatus, len(files), len(files)+len(applied))
+	for i, migration := range files {
+		version, isApplied := appliedMap[migration.ID]
+		delete(appliedMap, migration.ID)
+		statuses[i] = MigrationStatus{
+			ID:          migration.ID,
+			Name:        migration.Name,
+			Filename:    migration.Filename,
+			Applied:     isApplied,
+			AppliedAt:   version.AppliedAt,
+			Checksum:    migration.Checksum,
+			Duration:    version.Duration,
+			AppliedBy:   version.AppliedBy,
+			Description: migration.Description,
+			Modified:    isApplied && version.Checksum != "" && version.Checksum != migration.Checksum,
+		}
+	}
+
+	// The applied migrations left have no file anymore, usually because
+	// history was rewritten, unless they were archived
+	for id := range e.archivedIDs() {
+		delete(appliedMap, id)
+	}
+	if len(appliedMap) > 0 {
+		for _, version := range appliedMap {
+			statuses = append(statuses, MigrationStatus{
+				ID:        version.Version,
+				Applied:   true,
+				AppliedAt: version.AppliedAt,
+				Checksum:  version.Checksum,
+				Duration:  version.Duration,
+				AppliedBy: version.AppliedBy,
+				Missing:   true,
+			})
+		}
+		slices.SortStableFunc(statuses, func(a, b MigrationStatus) int {
+			return strings.Compare(a.ID, b.ID)
+		})
+	}
+
+	return statuses, nil
 }
@@ -1,10 +1,16 @@
 package executor_test
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
+	"encoding/json"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/arthurdotwork/mig/internal/config"
 	"github.com/arthurdotwork/mig/internal/database"
@@ -43,7 +49,7 @@ func setupTestDB(t *testing.T) *sql.DB {
 	t.Helper()
 
 	cfg := testDBConfig(t, "")
-	db, err := database.Connect(cfg)
+	db, err := database.Connect(context.Background(), cfg)
 	require.NoError(t, err)
 
 	// Drop the migration tables and test tables to ensure clean state
@@ -100,7 +106,7 @@ func TestNew(t *testing.T) {
 
 	t.Run("it should create a new executor", func(t *testing.T) {
 		cfg := testDBConfig(t, tempDir)
-		exec, err := executor.New(cfg)
+		exec, err := executor.New(context.Background(), cfg)
 		require.NoError(t, err)
 		require.NotNil(t, exec)
 		defer exec.Close() //nolint:errcheck
@@ -117,7 +123,7 @@ func TestNew(t *testing.T) {
 		cfg := testDBConfig(t, tempDir)
 		cfg.Database.Host = "non-existent-host"
 
-		_, err := executor.New(cfg)
+		_, err := executor.New(context.Background(), cfg)
 		require.Error(t, err)
 		require.Contains(t, err.Error(), "failed to connect to database")
 	})
@@ -125,12 +131,42 @@ func TestNew(t *testing.T) {
 	t.Run("it should return error for invalid migrations directory", func(t *testing.T) {
 		cfg := testDBConfig(t, "/non/existent/directory")
 
-		_, err := executor.New(cfg)
+		_, err := executor.New(context.Background(), cfg)
 		require.Error(t, err)
 		require.Contains(t, err.Error(), "failed to load migrations")
 	})
 }
 
+func TestNewWithDB(t *testing.T) {
+	// Setup
+	db := setupTestDB(t)
+	defer db.Close() //nolint:errcheck
+
+	tempDir := createTempMigrationsDir(t)
+	defer os.RemoveAll(tempDir) //nolint:errcheck
+
+	t.Run("it should run on the given connection and leave it open", func(t *testing.T) {
+		cfg := &config.Config{
+			Migrations: config.MigrationsConfig{
+				Directory: tempDir,
+			},
+		}
+
+		exec, err := executor.NewWithDB(context.Background(), cfg, db)
+		require.NoError(t, err)
+
+		count, err := exec.ExecuteAllMigrations(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, 3, count)
+
+		err = exec.Close()
+		require.NoError(t, err)
+
+		err = db.Ping()
+		require.NoError(t, err)
+	})
+}
+
 func TestExecuteMigration(t *testing.T) {
 	// Setup
 	db := setupTestDB(t)
@@ -142,7 +178,7 @@ func TestExecuteMigration(t *testing.T) {
 	cfg := testDBConfig(t, tempDir)
 
 	t.Run("it should execute a migration with transaction", func(t *testing.T) {
-		exec, err := executor.New(cfg)
+		exec, err := executor.New(context.Background(), cfg)
 		require.NoError(t, err)
 		defer exec.Close() //nolint:errcheck
 
@@ -151,7 +187,7 @@ func TestExecuteMigration(t *testing.T) {
 		require.NotEmpty(t, pending)
 
 		// Execute the migration
-		err = exec.ExecuteMigration(pending[0])
+		err = exec.ExecuteMigration(context.Background(), pending[0])
 		require.NoError(t, err)
 
 		// Verify the migration was recorded in the database
@@ -176,17 +212,17 @@ func TestExecuteMigration(t *testing.T) {
 		setupTestDB(t)
 
 		// First apply the first two migrations to set up the table
-		exec, err := executor.New(cfg)
+		exec, err := executor.New(context.Background(), cfg)
 		require.NoError(t, err)
 		defer exec.Close() //nolint:errcheck
 
 		// The first migration creates the users table
-		executed, err := exec.ExecuteNextMigration()
+		executed, err := exec.ExecuteNextMigration(context.Background())
 		require.NoError(t, err)
 		require.True(t, executed, "First migration should be executed")
 
 		// The second migration adds email column
-		executed, err = exec.ExecuteNextMigration()
+		executed, err = exec.ExecuteNextMigration(context.Background())
 		require.NoError(t, err)
 		require.True(t, executed, "Second migration should be executed")
 
@@ -205,7 +241,7 @@ func TestExecuteMigration(t *testing.T) {
 		require.NotEmpty(t, disableTxMigration.ID, "Should have found a migration with DisableTx=true")
 
 		// Execute the migration without transaction
-		err = exec.ExecuteMigration(disableTxMigration)
+		err = exec.ExecuteMigration(context.Background(), disableTxMigration)
 		require.NoError(t, err)
 
 		// Verify the migration was recorded
@@ -226,7 +262,7 @@ func TestExecuteMigration(t *testing.T) {
 		err := os.WriteFile(invalidMigrationFile, []byte("INVALID SQL;"), 0644)
 		require.NoError(t, err)
 
-		exec, err := executor.New(cfg)
+		exec, err := executor.New(context.Background(), cfg)
 		require.NoError(t, err)
 		defer exec.Close() //nolint:errcheck
 
@@ -242,7 +278,7 @@ func TestExecuteMigration(t *testing.T) {
 		require.NotEmpty(t, invalidMigration.ID, "Invalid migration should be found")
 
 		// Execute the invalid migration
-		err = exec.ExecuteMigration(invalidMigration)
+		err = exec.ExecuteMigration(context.Background(), invalidMigration)
 		require.Error(t, err)
 		require.Contains(t, err.Error(), "failed to execute migration")
 
@@ -268,12 +304,12 @@ func TestExecuteNextMigration(t *testing.T) {
 		// Setup a fresh database state
 		setupTestDB(t)
 
-		exec, err := executor.New(cfg)
+		exec, err := executor.New(context.Background(), cfg)
 		require.NoError(t, err)
 		defer exec.Close() //nolint:errcheck
 
 		// Execute the next migration
-		executed, err := exec.ExecuteNextMigration()
+		executed, err := exec.ExecuteNextMigration(context.Background())
 		require.NoError(t, err)
 		require.True(t, executed, "Should have executed a migration")
 
@@ -284,7 +320,7 @@ func TestExecuteNextMigration(t *testing.T) {
 		require.Equal(t, 1, count, "Only one migration should be applied")
 
 		// Execute the next migration again
-		executed, err = exec.ExecuteNextMigration()
+		executed, err = exec.ExecuteNextMigration(context.Background())
 		require.NoError(t, err)
 		require.True(t, executed, "Should have executed another migration")
 
@@ -299,13 +335,13 @@ func TestExecuteNextMigration(t *testing.T) {
 		setupTestDB(t)
 
 		// Create a new executor and apply all migrations
-		exec, err := executor.New(cfg)
+		exec, err := executor.New(context.Background(), cfg)
 		require.NoError(t, err)
 		defer exec.Close() //nolint:errcheck
 
 		// Apply all migrations one by one to avoid issues
 		for i := 0; i < 3; i++ {
-			executed, err := exec.ExecuteNextMigration()
+			executed, err := exec.ExecuteNextMigration(context.Background())
 			if err != nil {
 				t.Logf("Error on migration %d: %v", i, err)
 			}
@@ -315,7 +351,7 @@ func TestExecuteNextMigration(t *testing.T) {
 		}
 
 		// Try to execute next migration when none are pending
-		executed, err := exec.ExecuteNextMigration()
+		executed, err := exec.ExecuteNextMigration(context.Background())
 		require.NoError(t, err)
 		require.False(t, executed, "Should not have executed any migration")
 	})
@@ -335,12 +371,12 @@ func TestExecuteAllMigrations(t *testing.T) {
 		// Setup a fresh database state
 		setupTestDB(t)
 
-		exec, err := executor.New(cfg)
+		exec, err := executor.New(context.Background(), cfg)
 		require.NoError(t, err)
 		defer exec.Close() //nolint:errcheck
 
 		// Execute all migrations
-		count, err := exec.ExecuteAllMigrations()
+		count, err := exec.ExecuteAllMigrations(context.Background())
 		require.NoError(t, err)
 		require.Equal(t, 3, count, "Should have executed 3 migrations")
 
@@ -351,11 +387,104 @@ func TestExecuteAllMigrations(t *testing.T) {
 		require.Equal(t, 3, dbCount, "All 3 migrations should be applied")
 
 		// Try to execute all migrations again
-		count, err = exec.ExecuteAllMigrations()
+		count, err = exec.ExecuteAllMigrations(context.Background())
 		require.NoError(t, err)
 		require.Equal(t, 0, count, "No additional migrations should be executed")
 	})
 
+	t.Run("it should log each migration to the configured logger", func(t *testing.T) {
+		// Setup a fresh database state
+		setupTestDB(t)
+
+		exec, err := executor.New(context.Background(), cfg)
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		var buf bytes.Buffer
+		exec.SetLogger(slog.New(slog.NewTextHandler(&buf, nil)))
+
+		_, err = exec.ExecuteAllMigrations(context.Background())
+		require.NoError(t, err)
+
+		require.Equal(t, 3, strings.Count(buf.String(), "msg=\"migration applied\""))
+		require.Contains(t, buf.String(), "duration=")
+	})
+
+	t.Run("it should log the outcome of each migration as JSON fields", func(t *testing.T) {
+		// Setup a fresh database state
+		setupTestDB(t)
+
+		exec, err := executor.New(context.Background(), cfg)
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		var buf bytes.Buffer
+		exec.SetLogger(slog.New(slog.NewJSONHandler(&buf, nil)))
+
+		_, err = exec.ExecuteAllMigrations(context.Background())
+		require.NoError(t, err)
+
+		var applied []map[string]any
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var record map[string]any
+			require.NoError(t, json.Unmarshal([]byte(line), &record))
+			if record["msg"] == "migration applied" {
+				applied = append(applied, record)
+			}
+		}
+
+		require.Len(t, applied, 3)
+		require.Equal(t, "2023_01_01_10_00_00_create_users", applied[0]["migration"])
+		require.Equal(t, executor.OutcomeApplied, applied[0]["outcome"])
+		require.Contains(t, applied[0], "duration")
+	})
+
+	t.Run("it should execute all pending migrations in pgbouncer mode", func(t *testing.T) {
+		// Setup a fresh database state
+		setupTestDB(t)
+
+		pgBouncerCfg := testDBConfig(t, tempDir)
+		pgBouncerCfg.Database.PgBouncer = true
+
+		exec, err := executor.New(context.Background(), pgBouncerCfg)
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		count, err := exec.ExecuteAllMigrations(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, 3, count, "Should have executed 3 migrations")
+
+		// A second runner finds nothing left to apply
+		other, err := executor.New(context.Background(), pgBouncerCfg)
+		require.NoError(t, err)
+		defer other.Close() //nolint:errcheck
+
+		count, err = other.ExecuteAllMigrations(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, 0, count)
+	})
+
+	t.Run("it should refuse migrations outside of a transaction in pgbouncer mode", func(t *testing.T) {
+		// Setup a fresh database state
+		setupTestDB(t)
+
+		noTxDir := t.TempDir()
+		createMigrationFile(t, noTxDir, "2023_01_01_10_00_00_create.sql", "CREATE TABLE pgbouncer_test (id int);")
+		createMigrationFile(t, noTxDir, "2023_01_02_10_00_00_index.sql", "-- disable-tx\nCREATE INDEX CONCURRENTLY pgbouncer_test_id_idx ON pgbouncer_test (id);")
+
+		pgBouncerCfg := testDBConfig(t, noTxDir)
+		pgBouncerCfg.Database.PgBouncer = true
+
+		exec, err := executor.New(context.Background(), pgBouncerCfg)
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		_, err = exec.ExecuteAllMigrations(context.Background())
+		require.ErrorIs(t, err, executor.ErrNoTxInPgBouncer)
+		require.Contains(t, err.Error(), "2023_01_02_10_00_00_index.sql")
+		require.Len(t, exec.GetPendingMigrations(), 2)
+	})
+
 	t.Run("it should stop execution on first error", func(t *testing.T) {
 		// Reset the database
 		setupTestDB(t)
@@ -368,15 +497,20 @@ func TestExecuteAllMigrations(t *testing.T) {
 		createMigrationFile(t, newTempDir, "2023_01_01_15_00_00_invalid.sql", "INVALID SQL;")
 
 		newCfg := testDBConfig(t, newTempDir)
-		exec, err := executor.New(newCfg)
+		exec, err := executor.New(context.Background(), newCfg)
 		require.NoError(t, err)
 		defer exec.Close() //nolint:errcheck
 
 		// Execute all migrations - should fail on the invalid one
-		_, err = exec.ExecuteAllMigrations()
+		_, err = exec.ExecuteAllMigrations(context.Background())
 		require.Error(t, err)
 		require.Contains(t, err.Error(), "failed to execute migration")
 
+		var stmtErr *executor.StatementError
+		require.ErrorAs(t, err, &stmtErr)
+		require.Equal(t, "2023_01_01_15_00_00_invalid.sql", stmtErr.Filename)
+		require.Equal(t, 1, stmtErr.Line)
+
 		// We can't be certain how many migrations were executed before the error
 		// since the order depends on the filename timestamps
 		// Just check that not all migrations were applied
@@ -387,7 +521,50 @@ func TestExecuteAllMigrations(t *testing.T) {
 	})
 }
 
-func TestStatus(t *testing.T) {
+func TestMigrationTimeout(t *testing.T) {
+	// Setup
+	db := setupTestDB(t)
+	defer db.Close() //nolint:errcheck
+
+	tempDir, err := os.MkdirTemp("", "mig_executor_timeout_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir) //nolint:errcheck
+
+	createMigrationFile(t, tempDir, "2023_01_01_10_00_00_slow.sql", "SELECT pg_sleep(5);")
+
+	t.Run("it should cancel a migration running longer than the timeout", func(t *testing.T) {
+		cfg := testDBConfig(t, tempDir)
+		cfg.Migrations.Timeout = 200 * time.Millisecond
+
+		exec, err := executor.New(context.Background(), cfg)
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		start := time.Now()
+		_, err = exec.ExecuteNextMigration(context.Background())
+		require.ErrorIs(t, err, executor.ErrMigrationTimeout)
+		require.Less(t, time.Since(start), 5*time.Second)
+		require.Len(t, exec.GetPendingMigrations(), 1)
+	})
+
+	t.Run("it should stop a run exceeding the run timeout", func(t *testing.T) {
+		cfg := testDBConfig(t, tempDir)
+		cfg.Timeouts.Run = 200 * time.Millisecond
+
+		exec, err := executor.New(context.Background(), cfg)
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		start := time.Now()
+		_, err = exec.ExecuteAllMigrations(context.Background())
+		require.ErrorIs(t, err, executor.ErrRunTimeout)
+		require.NotErrorIs(t, err, executor.ErrMigrationTimeout)
+		require.Less(t, time.Since(start), 5*time.Second)
+		require.Len(t, exec.GetPendingMigrations(), 1)
+	})
+}
+
+func TestExecuteByID(t *testing.T) {
 	// Setup
 	db := setupTestDB(t)
 	defer db.Close() //nolint:errcheck
@@ -397,42 +574,1143 @@ func TestStatus(t *testing.T) {
 
 	cfg := testDBConfig(t, tempDir)
 
-	t.Run("it should return status of migrations", func(t *testing.T) {
+	t.Run("it should apply the next pending migration by ID", func(t *testing.T) {
 		// Setup a fresh database state
 		setupTestDB(t)
 
-		exec, err := executor.New(cfg)
+		exec, err := executor.New(context.Background(), cfg)
 		require.NoError(t, err)
 		defer exec.Close() //nolint:errcheck
 
-		// Execute the first migration
-		executed, err := exec.ExecuteNextMigration()
+		err = exec.ExecuteByID(context.Background(), "2023_01_01_10_00_00_create_users", false)
 		require.NoError(t, err)
-		require.True(t, executed)
+		require.Len(t, exec.GetPendingMigrations(), 2)
 
-		// Get status
-		migs, applied, err := exec.Status()
+		err = exec.ExecuteByID(context.Background(), "2023_01_01_10_00_00_create_users", false)
+		require.Error(t, err)
+		require.ErrorIs(t, err, executor.ErrAlreadyApplied)
+	})
+
+	t.Run("it should refuse to skip ahead unless out of order is allowed", func(t *testing.T) {
+		// Setup a fresh database state
+		setupTestDB(t)
+
+		exec, err := executor.New(context.Background(), cfg)
 		require.NoError(t, err)
-		require.Len(t, migs, 3, "Should have 3 migrations total")
-		require.Len(t, applied, 1, "Should have 1 applied migration")
+		defer exec.Close() //nolint:errcheck
 
-		// Verify the correct migration was applied
-		require.Equal(t, migs[0].ID, applied[0].Version)
+		err = exec.ExecuteByID(context.Background(), "2023_01_02_10_00_00_add_email", false)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "not the next pending migration")
 
-		// Execute the remaining migrations one by one
-		executed, err = exec.ExecuteNextMigration()
+		// The users table does not exist yet, so applying out of order fails in the database
+		err = exec.ExecuteByID(context.Background(), "2023_01_02_10_00_00_add_email", true)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "failed to execute migration")
+	})
+
+	t.Run("it should return an error for an unknown migration", func(t *testing.T) {
+		exec, err := executor.New(context.Background(), cfg)
 		require.NoError(t, err)
-		require.True(t, executed, "Second migration should be executed")
+		defer exec.Close() //nolint:errcheck
+
+		err = exec.ExecuteByID(context.Background(), "2023_01_09_10_00_00_missing", false)
+		require.Error(t, err)
+		require.ErrorIs(t, err, executor.ErrMigrationNotFound)
+		require.Contains(t, err.Error(), "2023_01_09_10_00_00_missing")
+	})
+}
+
+func TestOutOfOrderPolicy(t *testing.T) {
+	// Setup
+	db := setupTestDB(t)
+	defer db.Close() //nolint:errcheck
+
+	dir := t.TempDir()
+	createMigrationFile(t, dir, "2023_01_03_10_00_00_third.sql", "SELECT 3;")
+
+	cfg := testDBConfig(t, dir)
+	cfg.Migrations.OutOfOrder = config.OutOfOrderFail
+
+	exec, err := executor.New(context.Background(), cfg)
+	require.NoError(t, err)
+	_, err = exec.ExecuteAllMigrations(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, exec.Close())
 
-		executed, err = exec.ExecuteNextMigration()
+	// A migration merged from another branch, older than the applied one
+	createMigrationFile(t, dir, "2023_01_02_10_00_00_second.sql", "SELECT 2;")
+
+	t.Run("it should refuse to apply an older migration with the fail policy", func(t *testing.T) {
+		exec, err := executor.New(context.Background(), cfg)
 		require.NoError(t, err)
-		require.True(t, executed, "Third migration should be executed")
+		defer exec.Close() //nolint:errcheck
 
-		// Get status again
-		migs, applied, err = exec.Status()
+		_, err = exec.ExecuteAllMigrations(context.Background())
+		require.ErrorIs(t, err, executor.ErrOutOfOrder)
+		require.Contains(t, err.Error(), "2023_01_02_10_00_00_second precedes applied 2023_01_03_10_00_00_third")
+		require.Contains(t, err.Error(), `run "mig rebase"`)
+		require.Len(t, exec.GetPendingMigrations(), 1)
+	})
+
+	t.Run("it should apply an older migration with the warn policy", func(t *testing.T) {
+		cfg := testDBConfig(t, dir)
+		cfg.Migrations.OutOfOrder = config.OutOfOrderWarn
+
+		exec, err := executor.New(context.Background(), cfg)
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		count, err := exec.ExecuteAllMigrations(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, 1, count)
+	})
+
+	t.Run("it should rebase older migrations after the applied ones", func(t *testing.T) {
+		createMigrationFile(t, dir, "2023_01_01_10_00_00_first.sql", "SELECT 1;")
+		createMigrationFile(t, dir, "2023_01_04_10_00_00_fourth.sql", "SELECT 4;")
+
+		exec, err := executor.New(context.Background(), cfg)
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		rebased, err := exec.Rebase(context.Background(), time.Date(2023, 1, 4, 0, 0, 0, 0, time.UTC))
+		require.NoError(t, err)
+		require.Equal(t, []executor.RebasedMigration{
+			{From: "2023_01_01_10_00_00_first.sql", To: "2023_01_04_10_00_01_first.sql"},
+		}, rebased)
+		require.FileExists(t, filepath.Join(dir, "2023_01_04_10_00_01_first.sql"))
+
+		count, err := exec.ExecuteAllMigrations(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, 2, count)
+	})
+}
+
+func TestRole(t *testing.T) {
+	// Setup
+	db := setupTestDB(t)
+	defer db.Close() //nolint:errcheck
+
+	for _, statement := range []string{
+		"DROP TABLE IF EXISTS role_tx, role_no_tx",
+		"DROP ROLE IF EXISTS mig_ddl_owner",
+		"CREATE ROLE mig_ddl_owner",
+		"GRANT CREATE ON SCHEMA public TO mig_ddl_owner",
+	} {
+		_, err := db.Exec(statement)
+		require.NoError(t, err)
+	}
+	defer func() {
+		db.Exec("DROP TABLE IF EXISTS role_tx, role_no_tx")          //nolint:errcheck
+		db.Exec("REVOKE CREATE ON SCHEMA public FROM mig_ddl_owner") //nolint:errcheck
+		db.Exec("DROP ROLE IF EXISTS mig_ddl_owner")                 //nolint:errcheck
+	}()
+
+	dir := t.TempDir()
+	createMigrationFile(t, dir, "2023_01_01_10_00_00_tx.sql", "-- mig:role=mig_ddl_owner\nCREATE TABLE role_tx (id int);")
+	createMigrationFile(t, dir, "2023_01_02_10_00_00_no_tx.sql", "-- mig:role=mig_ddl_owner\n-- disable-tx\nCREATE TABLE role_no_tx (id int);")
+
+	exec, err := executor.New(context.Background(), testDBConfig(t, dir))
+	require.NoError(t, err)
+	defer exec.Close() //nolint:errcheck
+
+	t.Run("it should switch roles in the script", func(t *testing.T) {
+		var script bytes.Buffer
+		require.NoError(t, exec.Script(context.Background(), &script, false))
+		require.Contains(t, script.String(), "SET ROLE \"mig_ddl_owner\";\n-- mig:role=mig_ddl_owner\nCREATE TABLE role_tx (id int);\nRESET ROLE;\n")
+	})
+
+	t.Run("it should create the objects as the role of the migration", func(t *testing.T) {
+		count, err := exec.ExecuteAllMigrations(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, 2, count)
+
+		for _, table := range []string{"role_tx", "role_no_tx"} {
+			var owner string
+			err := db.QueryRow("SELECT tableowner FROM pg_tables WHERE tablename = $1", table).Scan(&owner)
+			require.NoError(t, err)
+			require.Equal(t, "mig_ddl_owner", owner)
+		}
+	})
+}
+
+func TestStatusAppliedBy(t *testing.T) {
+	// Setup
+	db := setupTestDB(t)
+	defer db.Close() //nolint:errcheck
+
+	dir := t.TempDir()
+	createMigrationFile(t, dir, "2023_01_01_10_00_00_first.sql", "-- mig:description Create the first table\nSELECT 1;")
+
+	exec, err := executor.New(context.Background(), testDBConfig(t, dir))
+	require.NoError(t, err)
+	defer exec.Close() //nolint:errcheck
+
+	t.Run("it should report who applied a migration and its description", func(t *testing.T) {
+		_, err := exec.ExecuteAllMigrations(context.Background())
+		require.NoError(t, err)
+
+		var user string
+		require.NoError(t, db.QueryRow("SELECT current_user").Scan(&user))
+
+		statuses, err := exec.Status(context.Background())
+		require.NoError(t, err)
+		require.Len(t, statuses, 1)
+		require.Equal(t, user, statuses[0].AppliedBy)
+		require.Equal(t, "Create the first table", statuses[0].Description)
+	})
+}
+
+func TestExec(t *testing.T) {
+	// Setup
+	db := setupTestDB(t)
+	defer db.Close() //nolint:errcheck
+
+	exec, err := executor.New(context.Background(), testDBConfig(t, t.TempDir()))
+	require.NoError(t, err)
+	defer exec.Close() //nolint:errcheck
+
+	t.Run("it should run ad-hoc SQL and record it in the history", func(t *testing.T) {
+		err := exec.Exec(context.Background(), "hotfix", "CREATE TABLE exec_hotfix (id int);", true)
+		require.NoError(t, err)
+
+		var entries []database.HistoryEntry
+		for entry, err := range exec.History(context.Background(), 0) {
+			require.NoError(t, err)
+			entries = append(entries, entry)
+		}
+		require.Len(t, entries, 1)
+		require.Equal(t, "adhoc:hotfix", entries[0].Version)
+		require.True(t, entries[0].AdHoc())
+
+		require.Empty(t, exec.GetPendingMigrations())
+	})
+
+	t.Run("it should roll back and not record failed ad-hoc SQL", func(t *testing.T) {
+		err := exec.Exec(context.Background(), "broken", "INSERT INTO exec_hotfix VALUES (1); INVALID SQL;", true)
+		require.Error(t, err)
+
+		var count int
+		require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM exec_hotfix").Scan(&count))
+		require.Zero(t, count)
+		require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM mig_history WHERE version = 'adhoc:broken'").Scan(&count))
+		require.Zero(t, count)
+	})
+
+	t.Run("it should refuse ad-hoc SQL outside of a transaction in pgbouncer mode", func(t *testing.T) {
+		cfg := testDBConfig(t, t.TempDir())
+		cfg.Database.PgBouncer = true
+
+		pgBouncer, err := executor.New(context.Background(), cfg)
+		require.NoError(t, err)
+		defer pgBouncer.Close() //nolint:errcheck
+
+		err = pgBouncer.Exec(context.Background(), "index", "CREATE INDEX CONCURRENTLY exec_hotfix_id_idx ON exec_hotfix (id);", false)
+		require.ErrorIs(t, err, executor.ErrNoTxInPgBouncer)
+
+		var count int
+		require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM mig_history WHERE version = 'adhoc:index'").Scan(&count))
+		require.Zero(t, count)
+	})
+}
+
+func TestAddMigration(t *testing.T) {
+	// Setup
+	db := setupTestDB(t)
+	defer db.Close() //nolint:errcheck
+
+	dir := t.TempDir()
+	exec, err := executor.New(context.Background(), testDBConfig(t, dir))
+	require.NoError(t, err)
+	defer exec.Close() //nolint:errcheck
+
+	t.Run("it should write and load the migration after the others", func(t *testing.T) {
+		now := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+		first, err := exec.AddMigration("generated_change", "CREATE TABLE generated_items (id int);", now)
+		require.NoError(t, err)
+		require.Equal(t, "2024_03_01_10_00_00_generated_change", first.ID)
+
+		second, err := exec.AddMigration("generated_change", "CREATE INDEX ON generated_items (id);", now)
+		require.NoError(t, err)
+		require.Equal(t, "2024_03_01_10_00_01_generated_change", second.ID)
+
+		require.FileExists(t, filepath.Join(dir, second.Filename))
+		require.Len(t, exec.GetPendingMigrations(), 2)
+
+		require.NoError(t, exec.ExecuteByID(context.Background(), first.ID, false))
+		require.NoError(t, exec.ExecuteByID(context.Background(), second.ID, false))
+		require.Empty(t, exec.GetPendingMigrations())
+	})
+}
+
+func TestAnalyzeLocks(t *testing.T) {
+	// Setup
+	db := setupTestDB(t)
+	defer db.Close() //nolint:errcheck
+
+	for _, statement := range []string{
+		"DROP TABLE IF EXISTS lock_items, lock_new",
+		"CREATE TABLE lock_items (id int PRIMARY KEY)",
+		"INSERT INTO lock_items SELECT generate_series(1, 500)",
+		"ANALYZE lock_items",
+	} {
+		_, err := db.Exec(statement)
+		require.NoError(t, err)
+	}
+	defer db.Exec("DROP TABLE IF EXISTS lock_items, lock_new") //nolint:errcheck
+
+	dir := t.TempDir()
+	createMigrationFile(t, dir, "2023_01_01_10_00_00_locks.sql", "ALTER TABLE lock_items ADD COLUMN name text;\nCREATE TABLE lock_new (id int);\nCREATE INDEX CONCURRENTLY lock_new_id_idx ON lock_new (id);")
+
+	exec, err := executor.New(context.Background(), testDBConfig(t, dir))
+	require.NoError(t, err)
+	defer exec.Close() //nolint:errcheck
+
+	t.Run("it should report the locks with the size of the tables", func(t *testing.T) {
+		impacts, err := exec.AnalyzeLocks(context.Background())
+		require.NoError(t, err)
+		require.Len(t, impacts, 2)
+
+		require.Equal(t, "lock_items", impacts[0].Table)
+		require.Equal(t, "ACCESS EXCLUSIVE", impacts[0].Mode)
+		require.Equal(t, "high", impacts[0].Risk)
+		require.True(t, impacts[0].Exists)
+		require.EqualValues(t, 500, impacts[0].Rows)
+		require.Positive(t, impacts[0].Bytes)
+
+		require.Equal(t, "lock_new", impacts[1].Table)
+		require.Equal(t, "SHARE UPDATE EXCLUSIVE", impacts[1].Mode)
+		require.Equal(t, "low", impacts[1].Risk)
+		require.False(t, impacts[1].Exists)
+
+		require.Len(t, exec.GetPendingMigrations(), 1)
+	})
+}
+
+func TestExplain(t *testing.T) {
+	// Setup
+	db := setupTestDB(t)
+	defer db.Close() //nolint:errcheck
+
+	for _, statement := range []string{
+		"DROP TABLE IF EXISTS explain_items, explain_new",
+		"CREATE TABLE explain_items (id int PRIMARY KEY, active bool)",
+		"INSERT INTO explain_items SELECT g, false FROM generate_series(1, 1000) g",
+		"ANALYZE explain_items",
+	} {
+		_, err := db.Exec(statement)
+		require.NoError(t, err)
+	}
+	defer db.Exec("DROP TABLE IF EXISTS explain_items, explain_new") //nolint:errcheck
+
+	dir := t.TempDir()
+	createMigrationFile(t, dir, "2023_01_01_10_00_00_backfill.sql", "UPDATE explain_items SET active = true;\nDELETE FROM explain_items WHERE id = 1;")
+	createMigrationFile(t, dir, "2023_01_02_10_00_00_new.sql", "CREATE TABLE explain_new (id int);\nINSERT INTO explain_new VALUES (1);")
+
+	exec, err := executor.New(context.Background(), testDBConfig(t, dir))
+	require.NoError(t, err)
+	defer exec.Close() //nolint:errcheck
+
+	t.Run("it should estimate the rows of the statements without executing them", func(t *testing.T) {
+		estimates, err := exec.Explain(context.Background())
+		require.NoError(t, err)
+		require.Len(t, estimates, 3)
+
+		require.Equal(t, "2023_01_01_10_00_00_backfill", estimates[0].Migration)
+		require.Equal(t, "UPDATE explain_items SET active = true", estimates[0].Statement)
+		require.NoError(t, estimates[0].Err)
+		require.EqualValues(t, 1000, estimates[0].Rows)
+
+		require.Equal(t, 2, estimates[1].Line)
+		require.NoError(t, estimates[1].Err)
+		require.EqualValues(t, 1, estimates[1].Rows)
+
+		require.Equal(t, "2023_01_02_10_00_00_new", estimates[2].Migration)
+		require.ErrorContains(t, estimates[2].Err, "explain_new")
+
+		var active int
+		require.NoError(t, db.QueryRow("SELECT count(*) FROM explain_items WHERE active").Scan(&active))
+		require.Zero(t, active)
+		require.Len(t, exec.GetPendingMigrations(), 2)
+	})
+}
+
+func TestSearchPath(t *testing.T) {
+	// Setup
+	db := setupTestDB(t)
+	defer db.Close() //nolint:errcheck
+
+	_, err := db.Exec("DROP SCHEMA IF EXISTS mig_search_path CASCADE; CREATE SCHEMA mig_search_path")
+	require.NoError(t, err)
+	defer db.Exec("DROP SCHEMA IF EXISTS mig_search_path CASCADE") //nolint:errcheck
+
+	dir := t.TempDir()
+	createMigrationFile(t, dir, "2023_01_01_10_00_00_tx.sql", "-- mig:search_path=mig_search_path\nCREATE TABLE path_tx (id int);")
+	createMigrationFile(t, dir, "2023_01_02_10_00_00_no_tx.sql", "-- mig:search_path=mig_search_path,public\n-- disable-tx\nCREATE TABLE path_no_tx (id int);")
+
+	exec, err := executor.New(context.Background(), testDBConfig(t, dir))
+	require.NoError(t, err)
+	defer exec.Close() //nolint:errcheck
+
+	t.Run("it should set the search path for the transaction in the script", func(t *testing.T) {
+		var script bytes.Buffer
+		require.NoError(t, exec.Script(context.Background(), &script, false))
+		require.Contains(t, script.String(), "BEGIN TRANSACTION;\nSET LOCAL search_path TO \"mig_search_path\";\n")
+		require.Contains(t, script.String(), "RESET search_path;\nINSERT INTO mig_versions")
+	})
+
+	t.Run("it should create the objects in the schemas of the migration", func(t *testing.T) {
+		count, err := exec.ExecuteAllMigrations(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, 2, count)
+
+		var tables int
+		err = db.QueryRow("SELECT COUNT(*) FROM pg_tables WHERE schemaname = 'mig_search_path' AND tablename IN ('path_tx', 'path_no_tx')").Scan(&tables)
+		require.NoError(t, err)
+		require.Equal(t, 2, tables)
+
+		// The migrations are recorded in the mig tables of the connection
+		require.Empty(t, exec.GetPendingMigrations())
+		require.NoError(t, exec.Verify(context.Background()))
+	})
+}
+
+func TestAccessCheck(t *testing.T) {
+	// Setup
+	db := setupTestDB(t)
+	defer db.Close() //nolint:errcheck
+
+	for _, statement := range []string{
+		"DROP TABLE IF EXISTS access_items",
+		"DROP ROLE IF EXISTS mig_app",
+		"CREATE ROLE mig_app",
+		"CREATE TABLE access_items (id int)",
+		"GRANT SELECT, INSERT ON access_items TO mig_app",
+	} {
+		_, err := db.Exec(statement)
+		require.NoError(t, err)
+	}
+	defer func() {
+		db.Exec("DROP TABLE IF EXISTS access_items") //nolint:errcheck
+		db.Exec("DROP ROLE IF EXISTS mig_app")       //nolint:errcheck
+	}()
+
+	dir := t.TempDir()
+	createMigrationFile(t, dir, "2023_01_01_10_00_00_recreate.sql", "DROP TABLE access_items;\nCREATE TABLE access_items (id bigint);")
+
+	cfg := testDBConfig(t, dir)
+	cfg.Access.Grants = []config.GrantConfig{{Role: "mig_app", Privileges: []string{"SELECT", "INSERT"}, Tables: []string{"access_items"}}}
+
+	t.Run("it should roll back a migration revoking the access of a role", func(t *testing.T) {
+		exec, err := executor.New(context.Background(), cfg)
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		_, err = exec.ExecuteAllMigrations(context.Background())
+		require.ErrorIs(t, err, executor.ErrAccessLost)
+		require.Contains(t, err.Error(), "mig_app lacks INSERT on public.access_items; mig_app lacks SELECT on public.access_items")
+		require.Len(t, exec.GetPendingMigrations(), 1)
+
+		var granted bool
+		err = db.QueryRow("SELECT has_table_privilege('mig_app', 'access_items', 'SELECT')").Scan(&granted)
+		require.NoError(t, err)
+		require.True(t, granted)
+	})
+
+	t.Run("it should fail on the rows of an access query", func(t *testing.T) {
+		cfg := testDBConfig(t, dir)
+		cfg.Access.Queries = []string{"SELECT 'access_items has no index' WHERE NOT EXISTS (SELECT 1 FROM pg_indexes WHERE tablename = 'access_items')"}
+
+		exec, err := executor.New(context.Background(), cfg)
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		_, err = exec.ExecuteAllMigrations(context.Background())
+		require.ErrorIs(t, err, executor.ErrAccessLost)
+		require.Contains(t, err.Error(), "after migration 2023_01_01_10_00_00_recreate: access_items has no index")
+	})
+}
+
+func TestMaintenance(t *testing.T) {
+	// Setup
+	db := setupTestDB(t)
+	defer db.Close() //nolint:errcheck
+
+	dir := t.TempDir()
+	createMigrationFile(t, dir, "2023_01_01_10_00_00_create_users.sql", "CREATE TABLE users (id int, name text);")
+	createMigrationFile(t, dir, "2023_01_02_10_00_00_backfill.sql", "-- mig:analyze\n-- mig:after VACUUM nowhere\nINSERT INTO users (id) VALUES (1);\nUPDATE users SET name = 'a' WHERE name IS NULL;")
+
+	cfg := testDBConfig(t, dir)
+	cfg.Migrations.After = []string{"SELECT 1"}
+
+	exec, err := executor.New(context.Background(), cfg)
+	require.NoError(t, err)
+	defer exec.Close() //nolint:errcheck
+
+	var buf bytes.Buffer
+	exec.SetLogger(slog.New(slog.NewTextHandler(&buf, nil)))
+
+	t.Run("it should run the maintenance statements after each migration", func(t *testing.T) {
+		count, err := exec.ExecuteAllMigrations(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, 2, count)
+
+		logs := buf.String()
+		require.Contains(t, logs, `msg="maintenance statement executed" migration=2023_01_02_10_00_00_backfill statement="ANALYZE users"`)
+		require.Equal(t, 2, strings.Count(logs, `statement="SELECT 1"`))
+		require.NotContains(t, logs, `migration=2023_01_01_10_00_00_create_users statement="ANALYZE users"`)
+	})
+
+	t.Run("it should only warn when a maintenance statement fails", func(t *testing.T) {
+		require.Contains(t, buf.String(), `msg="maintenance statement failed" migration=2023_01_02_10_00_00_backfill statement="VACUUM nowhere"`)
+		require.Empty(t, exec.GetPendingMigrations())
+	})
+}
+
+func TestMinServerVersion(t *testing.T) {
+	// Setup
+	db := setupTestDB(t)
+	defer db.Close() //nolint:errcheck
+
+	dir := t.TempDir()
+	createMigrationFile(t, dir, "2023_01_01_10_00_00_first.sql", "-- mig:min-pg=9\nSELECT 1;")
+
+	cfg := testDBConfig(t, dir)
+
+	t.Run("it should apply a migration the server is recent enough for", func(t *testing.T) {
+		exec, err := executor.New(context.Background(), cfg)
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		count, err := exec.ExecuteAllMigrations(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, 1, count)
+	})
+
+	t.Run("it should refuse a migration needing a newer server before applying anything", func(t *testing.T) {
+		createMigrationFile(t, dir, "2023_01_02_10_00_00_second.sql", "SELECT 2;")
+		createMigrationFile(t, dir, "2023_01_03_10_00_00_third.sql", "-- mig:min-pg=999\nSELECT 3;")
+
+		exec, err := executor.New(context.Background(), cfg)
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		_, err = exec.ExecuteAllMigrations(context.Background())
+		require.ErrorIs(t, err, executor.ErrServerTooOld)
+		require.Contains(t, err.Error(), "2023_01_03_10_00_00_third.sql needs PostgreSQL 999 or later")
+		require.Len(t, exec.GetPendingMigrations(), 2)
+	})
+}
+
+func TestExecutePhase(t *testing.T) {
+	// Setup
+	db := setupTestDB(t)
+	defer db.Close() //nolint:errcheck
+
+	dir := t.TempDir()
+	createMigrationFile(t, dir, "2023_01_01_10_00_00_first.sql", "SELECT 1;")
+	createMigrationFile(t, dir, "2023_01_02_10_00_00_second.sql", "-- mig:phase=expand\nSELECT 2;")
+	createMigrationFile(t, dir, "2023_01_03_10_00_00_third.sql", "-- mig:phase=contract\nSELECT 3;")
+	createMigrationFile(t, dir, "2023_01_04_10_00_00_fourth.sql", "-- mig:phase=expand\nSELECT 4;")
+
+	exec, err := executor.New(context.Background(), testDBConfig(t, dir))
+	require.NoError(t, err)
+	defer exec.Close() //nolint:errcheck
+
+	t.Run("it should reject an unknown phase", func(t *testing.T) {
+		_, err := exec.ExecutePhase(context.Background(), "cleanup")
+		require.ErrorContains(t, err, `invalid phase "cleanup"`)
+	})
+
+	t.Run("it should stop before the first contract migration", func(t *testing.T) {
+		count, err := exec.ExecutePhase(context.Background(), migrations.PhaseExpand)
+		require.NoError(t, err)
+		require.Equal(t, 2, count)
+		require.Equal(t, "2023_01_03_10_00_00_third", exec.GetPendingMigrations()[0].ID)
+	})
+
+	t.Run("it should stop before the next expand migration", func(t *testing.T) {
+		count, err := exec.ExecutePhase(context.Background(), migrations.PhaseContract)
+		require.NoError(t, err)
+		require.Equal(t, 1, count)
+		require.Len(t, exec.GetPendingMigrations(), 1)
+	})
+
+	t.Run("it should warn about contract migrations released with an expand one", func(t *testing.T) {
+		setupTestDB(t)
+
+		exec, err := executor.New(context.Background(), testDBConfig(t, dir))
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		var buf bytes.Buffer
+		exec.SetLogger(slog.New(slog.NewTextHandler(&buf, nil)))
+
+		count, err := exec.ExecuteAllMigrations(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, 4, count)
+		require.Contains(t, buf.String(), "contract migration applied in the same release as an expand migration")
+		require.Contains(t, buf.String(), "migration=2023_01_03_10_00_00_third expand=2023_01_02_10_00_00_second")
+	})
+}
+
+func TestBaseline(t *testing.T) {
+	// Setup
+	db := setupTestDB(t)
+	defer db.Close() //nolint:errcheck
+
+	dir := createTempMigrationsDir(t)
+	defer os.RemoveAll(dir) //nolint:errcheck
+
+	// An existing database holding the first two migrations, without mig tables
+	_, err := db.Exec("CREATE TABLE users (id SERIAL PRIMARY KEY, name TEXT, email TEXT)")
+	require.NoError(t, err)
+
+	cfg := testDBConfig(t, dir)
+	cfg.Migrations.BaselineVersion = "2023_01_02_10_00_00_add_email"
+
+	t.Run("it should record the migrations up to the baseline as applied", func(t *testing.T) {
+		exec, err := executor.New(context.Background(), cfg)
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		pending := exec.GetPendingMigrations()
+		require.Len(t, pending, 1)
+		require.Equal(t, "2023_01_03_10_00_00_disable_tx", pending[0].ID)
+
+		count, err := exec.ExecuteAllMigrations(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, 1, count)
+		require.NoError(t, exec.Verify(context.Background()))
+	})
+
+	t.Run("it should leave a database managed by mig alone", func(t *testing.T) {
+		cfg.Migrations.BaselineVersion = "2023_01_03_10_00_00_disable_tx"
+
+		exec, err := executor.New(context.Background(), cfg)
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		var count int
+		err = db.QueryRow("SELECT COUNT(*) FROM mig_versions").Scan(&count)
+		require.NoError(t, err)
+		require.Equal(t, 3, count)
+	})
+}
+
+func TestArchive(t *testing.T) {
+	// Setup
+	db := setupTestDB(t)
+	defer db.Close() //nolint:errcheck
+
+	dir := t.TempDir()
+	createMigrationFile(t, dir, "2023_01_01_10_00_00_first.sql", "SELECT 1;")
+	createMigrationFile(t, dir, "2023_01_02_10_00_00_second.sql", "SELECT 2;")
+
+	cfg := testDBConfig(t, dir)
+
+	exec, err := executor.New(context.Background(), cfg)
+	require.NoError(t, err)
+	defer exec.Close() //nolint:errcheck
+
+	_, err = exec.ExecuteAllMigrations(context.Background())
+	require.NoError(t, err)
+
+	createMigrationFile(t, dir, "2023_01_03_10_00_00_third.sql", "SELECT 3;")
+
+	t.Run("it should refuse to archive a pending migration", func(t *testing.T) {
+		exec, err := executor.New(context.Background(), cfg)
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		_, err = exec.Archive(context.Background(), "2023_01_04")
+		require.ErrorContains(t, err, "cannot archive 2023_01_03_10_00_00_third: migration is not applied")
+		require.NoFileExists(t, filepath.Join(dir, migrations.ArchiveDir, "2023_01_01_10_00_00_first.sql"))
+	})
+
+	t.Run("it should archive the migrations older than an ID without reporting them missing", func(t *testing.T) {
+		exec, err := executor.New(context.Background(), cfg)
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		plan, err := exec.ArchivePlan(context.Background(), "2023_01_02_10_00_00_second")
+		require.NoError(t, err)
+		require.Equal(t, []string{"2023_01_01_10_00_00_first.sql"}, plan)
+		require.NoFileExists(t, filepath.Join(dir, migrations.ArchiveDir, "2023_01_01_10_00_00_first.sql"))
+
+		archived, err := exec.Archive(context.Background(), "2023_01_02_10_00_00_second")
+		require.NoError(t, err)
+		require.Equal(t, []string{"2023_01_01_10_00_00_first.sql"}, archived)
+		require.FileExists(t, filepath.Join(dir, migrations.ArchiveDir, "2023_01_01_10_00_00_first.sql"))
+
+		statuses, err := exec.Status(context.Background())
+		require.NoError(t, err)
+		require.Len(t, statuses, 2)
+		require.Equal(t, "2023_01_02_10_00_00_second", statuses[0].ID)
+		require.NoError(t, exec.Verify(context.Background()))
+
+		// A new executor loads the archived migrations from the directory
+		exec, err = executor.New(context.Background(), cfg)
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+		require.NoError(t, exec.Verify(context.Background()))
+	})
+
+	t.Run("it should archive the migrations applied before a date", func(t *testing.T) {
+		exec, err := executor.New(context.Background(), cfg)
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		archived, err := exec.Archive(context.Background(), time.Now().AddDate(0, 0, 2).Format(time.DateOnly))
+		require.NoError(t, err)
+		require.Equal(t, []string{"2023_01_02_10_00_00_second.sql"}, archived)
+		require.Len(t, exec.GetPendingMigrations(), 1)
+	})
+}
+
+func TestLintBeforeApply(t *testing.T) {
+	// Setup
+	db := setupTestDB(t)
+	defer db.Close() //nolint:errcheck
+
+	dir := t.TempDir()
+	createMigrationFile(t, dir, "2023_01_01_10_00_00_create.sql", "CREATE TABLE lint_test (id int);")
+	createMigrationFile(t, dir, "2023_01_02_10_00_00_drop.sql", "DROP TABLE lint_test;")
+
+	t.Run("it should refuse to apply a migration breaking an error rule", func(t *testing.T) {
+		cfg := testDBConfig(t, dir)
+		cfg.Lint.Rules = map[string]string{"drop_table": config.SeverityError}
+
+		exec, err := executor.New(context.Background(), cfg)
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		_, err = exec.ExecuteAllMigrations(context.Background())
+		require.ErrorIs(t, err, executor.ErrUnsafeMigration)
+		require.Contains(t, err.Error(), "2023_01_02_10_00_00_drop.sql:1: error drop_table")
+		require.Len(t, exec.GetPendingMigrations(), 2)
+	})
+
+	t.Run("it should apply a migration breaking a warning rule", func(t *testing.T) {
+		exec, err := executor.New(context.Background(), testDBConfig(t, dir))
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		count, err := exec.ExecuteAllMigrations(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, 2, count)
+	})
+}
+
+func TestBackupBeforeApply(t *testing.T) {
+	// Setup
+	db := setupTestDB(t)
+	defer db.Close() //nolint:errcheck
+
+	dir := t.TempDir()
+	createMigrationFile(t, dir, "2023_01_01_10_00_00_create.sql", "CREATE TABLE backup_test (id int);")
+
+	backupDir := t.TempDir()
+	newConfig := func(command string) *config.Config {
+		cfg := testDBConfig(t, dir)
+		cfg.Backup = config.BackupConfig{
+			Enabled: true,
+			Path:    filepath.Join(backupDir, "{{.Database}}.dump"),
+			Command: command,
+		}
+		return cfg
+	}
+
+	t.Run("it should not apply migrations when the backup fails", func(t *testing.T) {
+		exec, err := executor.New(context.Background(), newConfig("false"))
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		_, err = exec.ExecuteAllMigrations(context.Background())
+		require.ErrorIs(t, err, executor.ErrBackupFailed)
+		require.Len(t, exec.GetPendingMigrations(), 1)
+	})
+
+	t.Run("it should back up the database before applying migrations", func(t *testing.T) {
+		command := filepath.Join(t.TempDir(), "pg_dump")
+		script := "#!/bin/sh\nfor arg; do case \"$arg\" in --file=*) touch \"${arg#--file=}\";; esac; done\n"
+		require.NoError(t, os.WriteFile(command, []byte(script), 0755))
+
+		exec, err := executor.New(context.Background(), newConfig(command))
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		count, err := exec.ExecuteAllMigrations(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, 1, count)
+		require.FileExists(t, filepath.Join(backupDir, exec.Config().Database.Name+".dump"))
+	})
+}
+
+func TestRestoreOnFailure(t *testing.T) {
+	// Setup
+	db := setupTestDB(t)
+	defer db.Close() //nolint:errcheck
+
+	dir := t.TempDir()
+	createMigrationFile(t, dir, "2023_01_01_10_00_00_create.sql", "CREATE TABLE restore_test (id int);")
+	createMigrationFile(t, dir, "2023_01_02_10_00_00_fail.sql", "-- disable-tx\nINSERT INTO restore_test VALUES (1);\nSELECT 1/0;")
+
+	bin := t.TempDir()
+	restored := filepath.Join(bin, "restored")
+	for name, script := range map[string]string{
+		"pg_dump":    `for arg; do case "$arg" in --file=*) touch "${arg#--file=}";; esac; done`,
+		"pg_restore": `echo "$@" > "` + restored + `"`,
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(bin, name), []byte("#!/bin/sh\n"+script+"\n"), 0755))
+	}
+
+	cfg := testDBConfig(t, dir)
+	cfg.Backup = config.BackupConfig{
+		Enabled:          true,
+		Path:             filepath.Join(t.TempDir(), "app.dump"),
+		Command:          filepath.Join(bin, "pg_dump"),
+		RestoreOnFailure: true,
+		RestoreCommand:   filepath.Join(bin, "pg_restore"),
+	}
+
+	t.Run("it should restore the backup when a migration fails outside of a transaction", func(t *testing.T) {
+		exec, err := executor.New(context.Background(), cfg)
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		_, err = exec.ExecuteAllMigrations(context.Background())
+		require.ErrorIs(t, err, executor.ErrRestored)
+		require.Contains(t, err.Error(), "division by zero")
+
+		args, err := os.ReadFile(restored)
+		require.NoError(t, err)
+		require.Contains(t, string(args), cfg.Backup.Path)
+	})
+}
+
+func TestConcurrentInTx(t *testing.T) {
+	// Setup
+	db := setupTestDB(t)
+	defer db.Close() //nolint:errcheck
+
+	dir := t.TempDir()
+	createMigrationFile(t, dir, "2023_01_01_10_00_00_create.sql", "CREATE TABLE concurrent_test (id int);")
+	createMigrationFile(t, dir, "2023_01_02_10_00_00_index.sql", "-- Index the ids\nCREATE INDEX CONCURRENTLY concurrent_test_id_idx ON concurrent_test (id);")
+
+	t.Run("it should refuse to run CONCURRENTLY inside a transaction before applying anything", func(t *testing.T) {
+		exec, err := executor.New(context.Background(), testDBConfig(t, dir))
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		_, err = exec.ExecuteAllMigrations(context.Background())
+		require.ErrorIs(t, err, executor.ErrConcurrentInTx)
+		require.Contains(t, err.Error(), `2023_01_02_10_00_00_index.sql:2, add "-- disable-tx"`)
+		require.Len(t, exec.GetPendingMigrations(), 2)
+	})
+
+	t.Run("it should plan CONCURRENTLY outside of a transaction with auto_disable_tx", func(t *testing.T) {
+		cfg := testDBConfig(t, dir)
+		cfg.Migrations.AutoDisableTx = true
+
+		exec, err := executor.New(context.Background(), cfg)
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		plan, err := exec.Plan(context.Background())
+		require.NoError(t, err)
+		require.Len(t, plan, 2)
+		require.True(t, plan[0].Transactional)
+		require.False(t, plan[1].Transactional)
+	})
+
+	t.Run("it should run CONCURRENTLY outside of a transaction", func(t *testing.T) {
+		cfg := testDBConfig(t, dir)
+		defaultTx := false
+		cfg.Migrations.DefaultTx = &defaultTx
+
+		exec, err := executor.New(context.Background(), cfg)
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		count, err := exec.ExecuteAllMigrations(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, 2, count)
+	})
+}
+
+func TestPlan(t *testing.T) {
+	// Setup
+	db := setupTestDB(t)
+	defer db.Close() //nolint:errcheck
+
+	tempDir := createTempMigrationsDir(t)
+	defer os.RemoveAll(tempDir) //nolint:errcheck
+
+	cfg := testDBConfig(t, tempDir)
+
+	t.Run("it should return the pending migrations without executing them", func(t *testing.T) {
+		exec, err := executor.New(context.Background(), cfg)
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		executed, err := exec.ExecuteNextMigration(context.Background())
+		require.NoError(t, err)
+		require.True(t, executed)
+
+		plan, err := exec.Plan(context.Background())
+		require.NoError(t, err)
+		require.Len(t, plan, 2)
+		require.Equal(t, "2023_01_02_10_00_00_add_email", plan[0].ID)
+		require.Len(t, plan[0].Checksum, 64)
+		require.True(t, plan[0].Transactional)
+		require.Equal(t, "2023_01_03_10_00_00_disable_tx", plan[1].ID)
+		require.False(t, plan[1].Transactional)
+
+		// Planning again does not change anything
+		again, err := exec.Plan(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, plan, again)
+	})
+
+	t.Run("it should only run opted-in migrations in a transaction when disabled by default", func(t *testing.T) {
+		dir := t.TempDir()
+		createMigrationFile(t, dir, "2023_01_01_10_00_00_plain.sql", "SELECT 1;")
+		createMigrationFile(t, dir, "2023_01_02_10_00_00_opt_in.sql", "-- mig:tx\nSELECT 2;")
+
+		defaultTx := false
+		cfg := testDBConfig(t, dir)
+		cfg.Migrations.DefaultTx = &defaultTx
+
+		exec, err := executor.New(context.Background(), cfg)
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		plan, err := exec.Plan(context.Background())
+		require.NoError(t, err)
+		require.Len(t, plan, 2)
+		require.False(t, plan[0].Transactional)
+		require.True(t, plan[1].Transactional)
+	})
+}
+
+func TestScript(t *testing.T) {
+	// Setup
+	db := setupTestDB(t)
+	defer db.Close() //nolint:errcheck
+
+	tempDir := createTempMigrationsDir(t)
+	defer os.RemoveAll(tempDir) //nolint:errcheck
+
+	cfg := testDBConfig(t, tempDir)
+	cfg.Migrations.Timeout = time.Minute
+
+	t.Run("it should render the pending migrations without executing them", func(t *testing.T) {
+		exec, err := executor.New(context.Background(), cfg)
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		var next bytes.Buffer
+		err = exec.Script(context.Background(), &next, false)
+		require.NoError(t, err)
+		require.Contains(t, next.String(), "-- Migration: 2023_01_01_10_00_00_create_users")
+		require.NotContains(t, next.String(), "2023_01_02_10_00_00_add_email")
+
+		var all bytes.Buffer
+		err = exec.Script(context.Background(), &all, true)
+		require.NoError(t, err)
+
+		script := all.String()
+		require.Contains(t, script, "SELECT set_config('mig.started_at', clock_timestamp()::text, false);\nBEGIN TRANSACTION;\nSET LOCAL statement_timeout = 60000;\nCREATE TABLE users")
+		require.Contains(t, script, "INSERT INTO mig_versions (version, checksum, duration_ms, applied_by) VALUES ('2023_01_03_10_00_00_disable_tx'")
+		require.Equal(t, 2, strings.Count(script, "COMMIT;"), "the disable-tx migration runs outside a transaction")
+
+		// Nothing was applied
+		require.Len(t, exec.GetPendingMigrations(), 3)
+
+		// Running the script applies the migrations and records them
+		_, err = db.Exec(script)
+		require.NoError(t, err)
+
+		statuses, err := exec.Status(context.Background())
+		require.NoError(t, err)
+		for _, status := range statuses {
+			require.True(t, status.Applied)
+			require.False(t, status.Modified)
+			require.NotEmpty(t, status.AppliedBy)
+		}
+	})
+}
+
+func TestConcurrentUse(t *testing.T) {
+	// Setup
+	db := setupTestDB(t)
+	defer db.Close() //nolint:errcheck
+
+	tempDir, err := os.MkdirTemp("", "mig_executor_concurrent_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir) //nolint:errcheck
+
+	createMigrationFile(t, tempDir, "2023_01_01_10_00_00_slow.sql", "SELECT pg_sleep(0.5);")
+
+	cfg := testDBConfig(t, tempDir)
+
+	t.Run("it should reject a second run and keep serving the status", func(t *testing.T) {
+		exec, err := executor.New(context.Background(), cfg)
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		done := make(chan error)
+		go func() {
+			_, err := exec.ExecuteAllMigrations(context.Background())
+			done <- err
+		}()
+
+		// Give the first run time to take the lock
+		time.Sleep(100 * time.Millisecond)
+
+		_, err = exec.ExecuteAllMigrations(context.Background())
+		require.ErrorIs(t, err, executor.ErrAlreadyRunning)
+
+		_, err = exec.Status(context.Background())
+		require.NoError(t, err)
+
+		require.NoError(t, <-done)
+		require.Empty(t, exec.GetPendingMigrations())
+	})
+}
+
+func TestStatus(t *testing.T) {
+	// Setup
+	db := setupTestDB(t)
+	defer db.Close() //nolint:errcheck
+
+	tempDir := createTempMigrationsDir(t)
+	defer os.RemoveAll(tempDir) //nolint:errcheck
+
+	cfg := testDBConfig(t, tempDir)
+
+	t.Run("it should return status of migrations", func(t *testing.T) {
+		// Setup a fresh database state
+		setupTestDB(t)
+
+		exec, err := executor.New(context.Background(), cfg)
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		// Execute the first migration
+		executed, err := exec.ExecuteNextMigration(context.Background())
+		require.NoError(t, err)
+		require.True(t, executed)
+
+		// Get status
+		statuses, err := exec.Status(context.Background())
+		require.NoError(t, err)
+		require.Len(t, statuses, 3, "Should have 3 migrations total")
+
+		// Verify the correct migration was applied
+		require.True(t, statuses[0].Applied)
+		require.False(t, statuses[0].AppliedAt.IsZero())
+		require.Len(t, statuses[0].Checksum, 64)
+		require.False(t, statuses[1].Applied)
+		require.True(t, statuses[1].AppliedAt.IsZero())
+
+		// Execute the remaining migrations one by one
+		executed, err = exec.ExecuteNextMigration(context.Background())
+		require.NoError(t, err)
+		require.True(t, executed, "Second migration should be executed")
+
+		executed, err = exec.ExecuteNextMigration(context.Background())
+		require.NoError(t, err)
+		require.True(t, executed, "Third migration should be executed")
+
+		// Get status again
+		statuses, err = exec.Status(context.Background())
+		require.NoError(t, err)
+		require.Len(t, statuses, 3, "Should still have 3 migrations total")
+		for _, status := range statuses {
+			require.True(t, status.Applied, "All migrations should now be applied")
+		}
+	})
+
+	t.Run("it should record applied migrations at the time of the clock", func(t *testing.T) {
+		setupTestDB(t)
+
+		exec, err := executor.New(context.Background(), cfg)
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		appliedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		exec.SetClock(func() time.Time { return appliedAt })
+
+		_, err = exec.ExecuteAllMigrations(context.Background())
+		require.NoError(t, err)
+
+		statuses, err := exec.Status(context.Background())
+		require.NoError(t, err)
+		for _, status := range statuses {
+			require.True(t, appliedAt.Equal(status.AppliedAt))
+		}
+	})
+
+	t.Run("it should flag applied migrations whose file changed", func(t *testing.T) {
+		setupTestDB(t)
+
+		exec, err := executor.New(context.Background(), cfg)
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		_, err = exec.ExecuteAllMigrations(context.Background())
+		require.NoError(t, err)
+
+		statuses, err := exec.Status(context.Background())
+		require.NoError(t, err)
+		require.False(t, statuses[0].Modified)
+
+		_, err = db.Exec("UPDATE mig_versions SET checksum = 'stale' WHERE version = $1", statuses[0].ID)
+		require.NoError(t, err)
+
+		statuses, err = exec.Status(context.Background())
+		require.NoError(t, err)
+		require.True(t, statuses[0].Modified)
+		require.False(t, statuses[1].Modified)
+
+		err = exec.Verify(context.Background())
+		require.ErrorIs(t, err, executor.ErrChecksumMismatch)
+		require.ErrorIs(t, err, executor.ErrDirtyState)
+		require.Contains(t, err.Error(), statuses[0].ID)
+	})
+
+	t.Run("it should flag applied migrations whose file is missing", func(t *testing.T) {
+		setupTestDB(t)
+
+		exec, err := executor.New(context.Background(), cfg)
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		_, err = exec.ExecuteAllMigrations(context.Background())
+		require.NoError(t, err)
+
+		_, err = db.Exec("INSERT INTO mig_versions (version, checksum) VALUES ('0000_removed', 'abc')")
+		require.NoError(t, err)
+
+		statuses, err := exec.Status(context.Background())
 		require.NoError(t, err)
-		require.Len(t, migs, 3, "Should still have 3 migrations total")
-		require.Len(t, applied, 3, "Should now have 3 applied migrations")
+		require.Equal(t, "0000_removed", statuses[0].ID)
+		require.True(t, statuses[0].Applied)
+		require.True(t, statuses[0].Missing)
+		require.Equal(t, "missing", statuses[0].State())
+		require.Equal(t, "abc", statuses[0].Checksum)
+		require.False(t, statuses[1].Missing)
+		require.Equal(t, "applied", statuses[1].State())
+
+		err = exec.Verify(context.Background())
+		require.ErrorIs(t, err, executor.ErrMissingMigration)
+		require.ErrorIs(t, err, executor.ErrDirtyState)
+		require.NotErrorIs(t, err, executor.ErrChecksumMismatch)
+		require.Contains(t, err.Error(), "0000_removed")
 	})
 
 	t.Run("it should return status with no migrations", func(t *testing.T) {
@@ -446,15 +1724,14 @@ func TestStatus(t *testing.T) {
 		defer cleanDB.Close() //nolint:errcheck
 
 		emptyCfg := testDBConfig(t, emptyDir)
-		exec, err := executor.New(emptyCfg)
+		exec, err := executor.New(context.Background(), emptyCfg)
 		require.NoError(t, err)
 		defer exec.Close() //nolint:errcheck
 
 		// Get status for empty directory
-		migs, applied, err := exec.Status()
+		statuses, err := exec.Status(context.Background())
 		require.NoError(t, err)
-		require.Empty(t, migs, "Should have no migrations")
-		require.Empty(t, applied, "Should have no applied migrations")
+		require.Empty(t, statuses, "Should have no migrations")
 	})
 }
 
@@ -472,7 +1749,7 @@ func TestGetPendingMigrations(t *testing.T) {
 		// Setup a fresh database state
 		setupTestDB(t)
 
-		exec, err := executor.New(cfg)
+		exec, err := executor.New(context.Background(), cfg)
 		require.NoError(t, err)
 		defer exec.Close() //nolint:errcheck
 
@@ -484,12 +1761,12 @@ func TestGetPendingMigrations(t *testing.T) {
 		// Setup a fresh database state
 		setupTestDB(t)
 
-		exec, err := executor.New(cfg)
+		exec, err := executor.New(context.Background(), cfg)
 		require.NoError(t, err)
 		defer exec.Close() //nolint:errcheck
 
 		// Apply the first migration
-		executed, err := exec.ExecuteNextMigration()
+		executed, err := exec.ExecuteNextMigration(context.Background())
 		require.NoError(t, err)
 		require.True(t, executed)
 
@@ -498,7 +1775,7 @@ func TestGetPendingMigrations(t *testing.T) {
 		require.Len(t, pending, 2, "Should have 2 pending migrations")
 
 		// Apply another migration
-		executed, err = exec.ExecuteNextMigration()
+		executed, err = exec.ExecuteNextMigration(context.Background())
 		require.NoError(t, err)
 		require.True(t, executed)
 
@@ -511,13 +1788,13 @@ func TestGetPendingMigrations(t *testing.T) {
 		// Setup a fresh database state
 		setupTestDB(t)
 
-		exec, err := executor.New(cfg)
+		exec, err := executor.New(context.Background(), cfg)
 		require.NoError(t, err)
 		defer exec.Close() //nolint:errcheck
 
 		// Apply each migration individually
 		for i := 0; i < 3; i++ {
-			executed, err := exec.ExecuteNextMigration()
+			executed, err := exec.ExecuteNextMigration(context.Background())
 			require.NoError(t, err)
 			require.True(t, executed, "Migration should be executed")
 		}
@@ -538,7 +1815,7 @@ func TestClose(t *testing.T) {
 	cfg := testDBConfig(t, tempDir)
 
 	t.Run("it should close the database connection", func(t *testing.T) {
-		exec, err := executor.New(cfg)
+		exec, err := executor.New(context.Background(), cfg)
 		require.NoError(t, err)
 
 		// Close the executor
@@ -546,7 +1823,7 @@ func TestClose(t *testing.T) {
 		require.NoError(t, err)
 
 		// Verify that operations fail after close
-		_, err = exec.ExecuteNextMigration()
+		_, err = exec.ExecuteNextMigration(context.Background())
 		require.Error(t, err, "Should fail after connection is closed")
 	})
 }
@@ -0,0 +1,241 @@
+package executor
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/arthurdotwork/mig/internal/config"
+)
+
+// notifyClient posts to the notification endpoints, bounding each request
+var notifyClient = &http.Client{Timeout: 10 * time.Second}
+
+// Notifier is told about the start and outcome of the runs applying
+// migrations, as the configured notification endpoints are
+//
+// Notifiers are called synchronously from the run, a returned error is
+// logged and does not fail the run.
+type Notifier interface {
+	Notify(ctx context.Context, notification Notification) error
+}
+
+// NotifierFunc adapts a function to a Notifier
+type NotifierFunc func(ctx context.Context, notification Notification) error
+
+// Notify calls f
+func (f NotifierFunc) Notify(ctx context.Context, notification Notification) error {
+	return f(ctx, notification)
+}
+
+// Notification is a run posted to the notification endpoints, and the data
+// of their templates
+type Notification struct {
+	// Event is config.NotifyStarted, config.NotifySucceeded or config.NotifyFailed
+	Event    string `json:"event"`
+	Target   string `json:"target,omitempty"`
+	Tenant   string `json:"tenant,omitempty"`
+	Database string `json:"database,omitempty"`
+
+	// Pending lists the migrations the run is about to apply, the next one
+	// for a single migration or those of the phase for a phase run
+	Pending []string `json:"pending,omitempty"`
+
+	// Migrations are the migrations applied by the run, and the one that
+	// failed, once it finished
+	Migrations []NotifiedMigration `json:"migrations,omitempty"`
+
+	// Duration is the time spent on the run once it finished
+	Duration   time.Duration `json:"-"`
+	DurationMs int64         `json:"duration_ms,omitempty"`
+
+	// Error is the reason of a failed run
+	Error string `json:"error,omitempty"`
+}
+
+// NotifiedMigration is a migration of a run, as posted to the notification
+// endpoints
+type NotifiedMigration struct {
+	ID         string        `json:"id"`
+	Duration   time.Duration `json:"-"`
+	DurationMs int64         `json:"duration_ms"`
+	Error      string        `json:"error,omitempty"`
+}
+
+// notifier turns the events of the runs into notifications for the
+// notifiers, runs without pending migrations are left out so every
+// deployment does not post to the channel
+type notifier struct {
+	exec      *Executor
+	notifiers []Notifier
+
+	// pending is false during runs without pending migrations, migrations
+	// collects those of the current run
+	pending    bool
+	migrations []NotifiedMigration
+}
+
+// AddNotifier registers a notifier told about the start and outcome of the
+// runs
+func (e *Executor) AddNotifier(n Notifier) {
+	if e.notifier == nil {
+		e.notifier = &notifier{exec: e}
+		e.AddObserver(e.notifier)
+	}
+
+	e.notifier.notifiers = append(e.notifier.notifiers, n)
+}
+
+// Observe collects the migrations of the run and posts its start and outcome
+func (n *notifier) Observe(ctx context.Context, event Event) {
+	switch event.Type {
+	case EventRunStarted:
+		n.pending, n.migrations = len(event.Selected) > 0, nil
+		if !n.pending {
+			return
+		}
+
+		n.send(ctx, n.notification(config.NotifyStarted, event, func(run *Notification) {
+			run.Pending = event.Selected
+		}))
+
+	case EventMigrationApplied, EventMigrationFailed:
+		migration := NotifiedMigration{ID: event.Migration, Duration: event.Duration, DurationMs: event.Duration.Milliseconds()}
+		if event.Err != nil {
+			migration.Error = event.Err.Error()
+		}
+		n.migrations = append(n.migrations, migration)
+
+	case EventRunFinished:
+		if !n.pending {
+			return
+		}
+
+		outcome := config.NotifySucceeded
+		if event.Err != nil {
+			outcome = config.NotifyFailed
+		}
+		n.send(ctx, n.notification(outcome, event, func(run *Notification) {
+			run.Migrations = n.migrations
+			run.Duration, run.DurationMs = event.Duration, event.Duration.Milliseconds()
+			if event.Err != nil {
+				run.Error = event.Err.Error()
+			}
+		}))
+	}
+}
+
+// notification builds the notification of an event, completed by fill
+func (n *notifier) notification(outcome string, event Event, fill func(*Notification)) Notification {
+	run := Notification{
+		Event:    outcome,
+		Target:   event.Target,
+		Tenant:   event.Tenant,
+		Database: n.exec.Database(),
+	}
+	fill(&run)
+
+	return run
+}
+
+// send passes the notification to the notifiers. A failed notification is
+// logged and does not fail the run, and it is still sent when the run was
+// canceled.
+func (n *notifier) send(ctx context.Context, run Notification) {
+	ctx = context.WithoutCancel(ctx)
+
+	for _, notifier := range n.notifiers {
+		if err := notifier.Notify(ctx, run); err != nil {
+			n.exec.logger.WarnContext(ctx, "failed to send notification", slog.String("event", run.Event), slog.String("error", err.Error()))
+		}
+	}
+}
+
+// endpoint is the Notifier of a configured notification endpoint
+type endpoint struct {
+	cfg config.NotificationConfig
+}
+
+// Notify posts the notification when the endpoint selects its event
+func (e endpoint) Notify(ctx context.Context, run Notification) error {
+	if !e.cfg.Notifies(run.Event) {
+		return nil
+	}
+
+	if e.cfg.Slack != "" {
+		body, err := json.Marshal(map[string]string{"text": slackText(run)})
+		if err != nil {
+			return err
+		}
+		return post(ctx, e.cfg.Slack, e.cfg.Headers, body)
+	}
+
+	body, err := e.cfg.Body(run)
+	if err != nil {
+		return err
+	}
+
+	return post(ctx, e.cfg.URL, e.cfg.Headers, body)
+}
+
+// post sends a body to an endpoint, failing on non-2xx responses
+func post(ctx context.Context, url string, headers map[string]string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create notification request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post notification: %w", err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("notification endpoint returned %s", resp.Status)
+	}
+
+	return nil
+}
+
+// slackText formats a notification as the text of a Slack message
+func slackText(run Notification) string {
+	where := cmp.Or(strings.Trim(run.Target+"/"+run.Tenant, "/"), run.Database, "database")
+
+	var b strings.Builder
+	switch run.Event {
+	case config.NotifyStarted:
+		fmt.Fprintf(&b, ":hourglass_flowing_sand: Applying %d migration(s) to *%s*", len(run.Pending), where)
+		for _, id := range run.Pending {
+			fmt.Fprintf(&b, "\n• `%s`", id)
+		}
+		return b.String()
+
+	case config.NotifySucceeded:
+		fmt.Fprintf(&b, ":white_check_mark: Applied %d migration(s) to *%s* in %s", len(run.Migrations), where, run.Duration.Round(time.Millisecond))
+
+	default:
+		fmt.Fprintf(&b, ":x: Migrating *%s* failed after %s: %s", where, run.Duration.Round(time.Millisecond), run.Error)
+	}
+
+	for _, migration := range run.Migrations {
+		if migration.Error != "" {
+			fmt.Fprintf(&b, "\n• `%s` failed after %s", migration.ID, migration.Duration.Round(time.Millisecond))
+			continue
+		}
+		fmt.Fprintf(&b, "\n• `%s` (%s)", migration.ID, migration.Duration.Round(time.Millisecond))
+	}
+
+	return b.String()
+}
@@ -0,0 +1,58 @@
+package executor
+
+import (
+	"context"
+	"log/slog"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the instrumentation name of the OpenTelemetry tracer of mig
+const TracerName = "github.com/arthurdotwork/mig"
+
+// otelTracer starts the spans of a run with an OpenTelemetry tracer
+type otelTracer struct {
+	tracer trace.Tracer
+}
+
+// NewOTelTracer returns a Tracer creating the spans of the runs through the
+// OpenTelemetry tracer provider
+func NewOTelTracer(provider trace.TracerProvider) Tracer {
+	return otelTracer{tracer: provider.Tracer(TracerName)}
+}
+
+// Start starts an OpenTelemetry span, ended with an error status when the
+// step fails
+func (o otelTracer) Start(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, func(err error)) {
+	ctx, span := o.tracer.Start(ctx, name, trace.WithAttributes(otelAttributes(attrs)...))
+
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+// otelAttributes converts the attributes of a span, keeping their type
+func otelAttributes(attrs []slog.Attr) []attribute.KeyValue {
+	kv := make([]attribute.KeyValue, 0, len(attrs))
+	for _, attr := range attrs {
+		value := attr.Value.Resolve()
+		switch value.Kind() {
+		case slog.KindBool:
+			kv = append(kv, attribute.Bool(attr.Key, value.Bool()))
+		case slog.KindInt64:
+			kv = append(kv, attribute.Int64(attr.Key, value.Int64()))
+		case slog.KindFloat64:
+			kv = append(kv, attribute.Float64(attr.Key, value.Float64()))
+		default:
+			kv = append(kv, attribute.String(attr.Key, value.String()))
+		}
+	}
+
+	return kv
+}
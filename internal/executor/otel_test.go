@@ -0,0 +1,99 @@
+package executor_test
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/arthurdotwork/mig/internal/executor"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// otelProvider is an OpenTelemetry tracer provider keeping the spans started
+// through it
+type otelProvider struct {
+	noop.TracerProvider
+	names []string
+	spans []*otelSpan
+}
+
+func (p *otelProvider) Tracer(name string, _ ...trace.TracerOption) trace.Tracer {
+	p.names = append(p.names, name)
+	return otelTracer{provider: p}
+}
+
+type otelTracer struct {
+	noop.Tracer
+	provider *otelProvider
+}
+
+func (t otelTracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	config := trace.NewSpanStartConfig(opts...)
+	span := &otelSpan{name: name, attrs: config.Attributes()}
+	t.provider.spans = append(t.provider.spans, span)
+
+	return trace.ContextWithSpan(ctx, span), span
+}
+
+type otelSpan struct {
+	noop.Span
+	name   string
+	attrs  []attribute.KeyValue
+	errs   []error
+	status codes.Code
+	ended  bool
+}
+
+func (s *otelSpan) RecordError(err error, _ ...trace.EventOption) {
+	s.errs = append(s.errs, err)
+}
+
+func (s *otelSpan) SetStatus(code codes.Code, _ string) {
+	s.status = code
+}
+
+func (s *otelSpan) End(_ ...trace.SpanEndOption) {
+	s.ended = true
+}
+
+func TestOTelTracer(t *testing.T) {
+	t.Run("it should start the spans through the tracer provider", func(t *testing.T) {
+		provider := &otelProvider{}
+		tracer := executor.NewOTelTracer(provider)
+		require.Equal(t, []string{executor.TracerName}, provider.names)
+
+		ctx, end := tracer.Start(context.Background(), executor.SpanMigration, slog.String("migration", "2023_01_01_10_00_00_create"), slog.Bool("transaction", true), slog.Int("attempt", 2))
+		require.Same(t, provider.spans[0], trace.SpanFromContext(ctx))
+		end(nil)
+
+		require.Len(t, provider.spans, 1)
+		span := provider.spans[0]
+		require.Equal(t, executor.SpanMigration, span.name)
+		require.Equal(t, []attribute.KeyValue{
+			attribute.String("migration", "2023_01_01_10_00_00_create"),
+			attribute.Bool("transaction", true),
+			attribute.Int64("attempt", 2),
+		}, span.attrs)
+		require.True(t, span.ended)
+		require.Equal(t, codes.Unset, span.status)
+		require.Empty(t, span.errs)
+	})
+
+	t.Run("it should record the error of a failed step", func(t *testing.T) {
+		provider := &otelProvider{}
+		tracer := executor.NewOTelTracer(provider)
+
+		_, end := tracer.Start(context.Background(), executor.SpanLock)
+		end(errors.New("lock timeout"))
+
+		span := provider.spans[0]
+		require.True(t, span.ended)
+		require.Equal(t, codes.Error, span.status)
+		require.EqualError(t, span.errs[0], "lock timeout")
+	})
+}
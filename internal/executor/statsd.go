@@ -0,0 +1,129 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/arthurdotwork/mig/internal/config"
+)
+
+// statsDPacketSize bounds the datagrams sent to the agent, below the MTU of
+// most networks
+const statsDPacketSize = 1432
+
+// statsD sends the metrics of the runs to a StatsD agent. The metrics of a
+// run are buffered and flushed when it finishes, so a CLI run has sent them
+// before it exits.
+type statsD struct {
+	exec   *Executor
+	cfg    config.StatsDConfig
+	tags   []string
+	buffer []string
+}
+
+// newStatsD creates the StatsD observer of the configuration, with its tags
+// sorted so the metrics of every run carry them in the same order
+func newStatsD(exec *Executor, cfg config.StatsDConfig) *statsD {
+	var tags []string
+	for name, value := range cfg.Tags {
+		tags = append(tags, name+":"+value)
+	}
+	slices.Sort(tags)
+
+	return &statsD{exec: exec, cfg: cfg, tags: tags}
+}
+
+// Observe buffers the metrics of the events and flushes them at the end of
+// the run
+func (s *statsD) Observe(ctx context.Context, event Event) {
+	tags := slices.Clone(s.tags)
+	if event.Target != "" {
+		tags = append(tags, "target:"+event.Target)
+	}
+	if event.Tenant != "" {
+		tags = append(tags, "tenant:"+event.Tenant)
+	}
+	withOutcome := func(outcome string) []string {
+		return append(slices.Clone(tags), "outcome:"+outcome)
+	}
+
+	switch event.Type {
+	case EventRunStarted:
+		s.buffer = nil
+
+	case EventMigrationApplied:
+		s.add("migrations.applied", "1|c", tags)
+		s.add("migration.duration", timing(event.Duration), withOutcome(OutcomeApplied))
+
+	case EventMigrationFailed:
+		s.add("migrations.failed", "1|c", tags)
+		s.add("migration.duration", timing(event.Duration), withOutcome(OutcomeFailed))
+
+	case EventRunFinished:
+		outcome := "success"
+		if event.Err != nil {
+			outcome = "failure"
+		}
+		s.add("run.duration", timing(event.Duration), withOutcome(outcome))
+		s.add("migrations.pending", fmt.Sprintf("%d|g", event.Pending), tags)
+
+		if err := s.flush(); err != nil {
+			s.exec.logger.WarnContext(ctx, "failed to send statsd metrics", slog.String("error", err.Error()))
+		}
+	}
+}
+
+// add buffers a metric in the DogStatsD format
+func (s *statsD) add(name, value string, tags []string) {
+	metric := s.cfg.Prefix + name + ":" + value
+	if len(tags) > 0 {
+		metric += "|#" + strings.Join(tags, ",")
+	}
+
+	s.buffer = append(s.buffer, metric)
+}
+
+// flush sends the buffered metrics, as few datagrams as their size allows
+func (s *statsD) flush() error {
+	metrics := s.buffer
+	s.buffer = nil
+
+	conn, err := net.Dial("udp", s.cfg.Address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close() //nolint:errcheck
+
+	var packet strings.Builder
+	for _, metric := range metrics {
+		if packet.Len() > 0 && packet.Len()+1+len(metric) > statsDPacketSize {
+			if _, err := conn.Write([]byte(packet.String())); err != nil {
+				return err
+			}
+			packet.Reset()
+		}
+
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(metric)
+	}
+
+	if packet.Len() > 0 {
+		if _, err := conn.Write([]byte(packet.String())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// timing formats a duration as a StatsD timer in milliseconds
+func timing(d time.Duration) string {
+	return fmt.Sprintf("%d|ms", d.Milliseconds())
+}
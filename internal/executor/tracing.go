@@ -0,0 +1,37 @@
+package executor
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Tracer starts a span for each step of a migration run, so the steps show up
+// in the tracing system of the application
+type Tracer interface {
+	// Start starts a span with the given name and attributes, it returns the
+	// context carrying the span and the function ending it with the outcome
+	// of the step
+	Start(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, func(err error))
+}
+
+// Span names, one per traced step
+const (
+	SpanConnect   = "mig.connect"
+	SpanLock      = "mig.lock"
+	SpanMigration = "mig.migration"
+	SpanRecord    = "mig.record"
+)
+
+// SetTracer sets the tracer receiving the spans, nothing is traced by default
+func (e *Executor) SetTracer(tracer Tracer) {
+	e.tracer = tracer
+}
+
+// startSpan starts a span with the configured tracer, if any
+func (e *Executor) startSpan(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, func(err error)) {
+	if e.tracer == nil {
+		return ctx, func(error) {}
+	}
+
+	return e.tracer.Start(ctx, name, attrs...)
+}
@@ -0,0 +1,66 @@
+package executor_test
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/arthurdotwork/mig/internal/executor"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingTracer records the spans it starts and their outcome
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []string
+	errs  []error
+}
+
+func (r *recordingTracer) Start(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, func(err error)) {
+	return ctx, func(err error) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		r.spans = append(r.spans, name)
+		r.errs = append(r.errs, err)
+	}
+}
+
+func TestTracer(t *testing.T) {
+	// Setup
+	db := setupTestDB(t)
+	defer db.Close() //nolint:errcheck
+
+	tempDir, err := os.MkdirTemp("", "mig_executor_tracer_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir) //nolint:errcheck
+
+	createMigrationFile(t, tempDir, "2023_01_01_10_00_00_first.sql", "SELECT 1;")
+	createMigrationFile(t, tempDir, "2023_01_02_10_00_00_broken.sql", "SELECT * FROM missing_table;")
+
+	cfg := testDBConfig(t, tempDir)
+
+	t.Run("it should start a span for each step", func(t *testing.T) {
+		exec, err := executor.New(context.Background(), cfg)
+		require.NoError(t, err)
+		defer exec.Close() //nolint:errcheck
+
+		tracer := &recordingTracer{}
+		exec.SetTracer(tracer)
+
+		_, err = exec.ExecuteAllMigrations(context.Background())
+		require.Error(t, err)
+
+		// Spans are recorded when they end, so children come first
+		require.Equal(t, []string{
+			executor.SpanLock,
+			executor.SpanRecord,
+			executor.SpanMigration,
+			executor.SpanMigration,
+		}, tracer.spans)
+		require.NoError(t, tracer.errs[2])
+		require.Error(t, tracer.errs[3])
+	})
+}
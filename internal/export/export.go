@@ -0,0 +1,182 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/arthurdotwork/mig/internal/database"
+)
+
+// Formats of an export
+const (
+	CSV  = "csv"
+	JSON = "json"
+)
+
+// Kinds of the records of a CSV export
+const (
+	RecordVersion = "version" // A row of mig_versions
+	RecordHistory = "history" // A row of mig_history
+)
+
+// csvHeader are the columns of a CSV export, each row filling those of its kind
+var csvHeader = []string{"record", "id", "version", "time", "checksum", "duration_ms", "applied_by", "command", "truncated"}
+
+// version is a row of mig_versions in a JSON export
+type version struct {
+	ID         int       `json:"id"`
+	Version    string    `json:"version"`
+	AppliedAt  time.Time `json:"applied_at"`
+	Checksum   string    `json:"checksum"`
+	DurationMs int64     `json:"duration_ms"`
+	AppliedBy  string    `json:"applied_by"`
+}
+
+// history is a row of mig_history in a JSON export
+type history struct {
+	ID         int64     `json:"id"`
+	Version    string    `json:"version"`
+	ExecutedAt time.Time `json:"executed_at"`
+	Command    string    `json:"command"`
+	Truncated  bool      `json:"truncated"`
+}
+
+// Write exports the tracking tables in format, CSV or JSON. The history is
+// streamed so large tables are not loaded in memory. Commands longer than
+// maxCommand bytes are truncated and flagged, maxCommand 0 keeps them whole.
+func Write(w io.Writer, format string, versions []database.MigrationVersion, entries iter.Seq2[database.HistoryEntry, error], maxCommand int) error {
+	switch format {
+	case CSV:
+		return writeCSV(w, versions, entries, maxCommand)
+	case JSON:
+		return writeJSON(w, versions, entries, maxCommand)
+	default:
+		return fmt.Errorf("invalid export format %q, expected %s or %s", format, CSV, JSON)
+	}
+}
+
+// writeCSV writes the versions then the history entries as rows of a single
+// table, told apart by their record column
+func writeCSV(w io.Writer, versions []database.MigrationVersion, entries iter.Seq2[database.HistoryEntry, error], maxCommand int) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+
+	for _, v := range versions {
+		if err := cw.Write([]string{
+			RecordVersion,
+			strconv.Itoa(v.ID),
+			v.Version,
+			v.AppliedAt.Format(time.RFC3339Nano),
+			v.Checksum,
+			strconv.FormatInt(v.Duration.Milliseconds(), 10),
+			v.AppliedBy,
+			"",
+			"",
+		}); err != nil {
+			return err
+		}
+	}
+
+	for entry, err := range entries {
+		if err != nil {
+			return err
+		}
+
+		command, truncated := truncate(entry.Command, maxCommand)
+		if err := cw.Write([]string{
+			RecordHistory,
+			strconv.FormatInt(entry.ID, 10),
+			entry.Version,
+			entry.ExecutedAt.Format(time.RFC3339Nano),
+			"",
+			"",
+			"",
+			command,
+			strconv.FormatBool(truncated),
+		}); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeJSON writes an object with the versions and history arrays, encoding
+// the history entries one at a time
+func writeJSON(w io.Writer, versions []database.MigrationVersion, entries iter.Seq2[database.HistoryEntry, error], maxCommand int) error {
+	rows := make([]version, 0, len(versions))
+	for _, v := range versions {
+		rows = append(rows, version{
+			ID:         v.ID,
+			Version:    v.Version,
+			AppliedAt:  v.AppliedAt,
+			Checksum:   v.Checksum,
+			DurationMs: v.Duration.Milliseconds(),
+			AppliedBy:  v.AppliedBy,
+		})
+	}
+
+	encoded, err := json.Marshal(rows)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(w, "{\"versions\":%s,\"history\":[", encoded); err != nil {
+		return err
+	}
+
+	first := true
+	for entry, err := range entries {
+		if err != nil {
+			return err
+		}
+
+		command, truncated := truncate(entry.Command, maxCommand)
+		encoded, err := json.Marshal(history{
+			ID:         entry.ID,
+			Version:    entry.Version,
+			ExecutedAt: entry.ExecutedAt,
+			Command:    command,
+			Truncated:  truncated,
+		})
+		if err != nil {
+			return err
+		}
+
+		if !first {
+			encoded = append([]byte{','}, encoded...)
+		}
+		first = false
+
+		if _, err := w.Write(encoded); err != nil {
+			return err
+		}
+	}
+
+	_, err = io.WriteString(w, "]}\n")
+	return err
+}
+
+// truncate cuts s to at most limit bytes without splitting a character,
+// and reports whether it did
+func truncate(s string, limit int) (string, bool) {
+	if limit <= 0 || len(s) <= limit {
+		return s, false
+	}
+
+	end := limit
+	for end > 0 && !utf8.RuneStart(s[end]) {
+		end--
+	}
+
+	return s[:end], true
+}
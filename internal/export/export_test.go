@@ -0,0 +1,93 @@
+package export_test
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"iter"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arthurdotwork/mig/internal/database"
+	"github.com/arthurdotwork/mig/internal/export"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrite(t *testing.T) {
+	versions := []database.MigrationVersion{
+		{
+			ID:        1,
+			Version:   "2024_03_01_10_00_00_add_orders",
+			AppliedAt: time.Date(2024, 3, 1, 10, 0, 1, 0, time.UTC),
+			Checksum:  "abc123",
+			Duration:  1500 * time.Millisecond,
+			AppliedBy: "deploy",
+		},
+	}
+
+	entries := func(yield func(database.HistoryEntry, error) bool) {
+		yield(database.HistoryEntry{
+			ID:         7,
+			Version:    "2024_03_01_10_00_00_add_orders",
+			Command:    "CREATE TABLE ordérs (id INT);",
+			ExecutedAt: time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC),
+		}, nil)
+	}
+
+	t.Run("it should write the tracking tables as CSV", func(t *testing.T) {
+		var b strings.Builder
+		require.NoError(t, export.Write(&b, export.CSV, versions, entries, 0))
+
+		records, err := csv.NewReader(strings.NewReader(b.String())).ReadAll()
+		require.NoError(t, err)
+		require.Equal(t, [][]string{
+			{"record", "id", "version", "time", "checksum", "duration_ms", "applied_by", "command", "truncated"},
+			{"version", "1", "2024_03_01_10_00_00_add_orders", "2024-03-01T10:00:01Z", "abc123", "1500", "deploy", "", ""},
+			{"history", "7", "2024_03_01_10_00_00_add_orders", "2024-03-01T10:00:00Z", "", "", "", "CREATE TABLE ordérs (id INT);", "false"},
+		}, records)
+	})
+
+	t.Run("it should write the tracking tables as JSON", func(t *testing.T) {
+		var b strings.Builder
+		require.NoError(t, export.Write(&b, export.JSON, versions, entries, 0))
+
+		var exported struct {
+			Versions []map[string]any `json:"versions"`
+			History  []map[string]any `json:"history"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(b.String()), &exported))
+		require.Len(t, exported.Versions, 1)
+		require.Equal(t, "abc123", exported.Versions[0]["checksum"])
+		require.Equal(t, float64(1500), exported.Versions[0]["duration_ms"])
+		require.Len(t, exported.History, 1)
+		require.Equal(t, "CREATE TABLE ordérs (id INT);", exported.History[0]["command"])
+		require.Equal(t, false, exported.History[0]["truncated"])
+	})
+
+	t.Run("it should truncate long commands without splitting a character", func(t *testing.T) {
+		var b strings.Builder
+		require.NoError(t, export.Write(&b, export.JSON, nil, entries, 17))
+
+		var exported struct {
+			History []struct {
+				Command   string `json:"command"`
+				Truncated bool   `json:"truncated"`
+			} `json:"history"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(b.String()), &exported))
+		require.Equal(t, "CREATE TABLE ord", exported.History[0].Command)
+		require.True(t, exported.History[0].Truncated)
+	})
+
+	t.Run("it should write an empty history as an empty array", func(t *testing.T) {
+		var b strings.Builder
+		var empty iter.Seq2[database.HistoryEntry, error] = func(func(database.HistoryEntry, error) bool) {}
+		require.NoError(t, export.Write(&b, export.JSON, nil, empty, 0))
+		require.Equal(t, "{\"versions\":[],\"history\":[]}\n", b.String())
+	})
+
+	t.Run("it should reject an unknown format", func(t *testing.T) {
+		var b strings.Builder
+		require.ErrorContains(t, export.Write(&b, "xml", versions, entries, 0), `invalid export format "xml"`)
+	})
+}
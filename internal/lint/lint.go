@@ -0,0 +1,257 @@
+package lint
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/arthurdotwork/mig/internal/config"
+	"github.com/arthurdotwork/mig/internal/migrations"
+)
+
+// Finding is a risky statement of a migration
+type Finding struct {
+	Migration string // ID of the migration
+	Filename  string // Filename of the migration
+	Line      int    // Line of the statement in the migration file
+	Rule      string // Name of the broken rule
+	Severity  string // config.SeverityError or config.SeverityWarn
+	Message   string // Why the statement is risky
+}
+
+// String formats the finding as "file:line: severity rule: message"
+func (f Finding) String() string {
+	return fmt.Sprintf("%s:%d: %s %s: %s", f.Filename, f.Line, f.Severity, f.Rule, f.Message)
+}
+
+// rule flags the statements matching a risky pattern
+type rule struct {
+	name    string
+	message string
+	match   func(sql string, created map[string]bool) bool
+}
+
+var (
+	createTableStatement = regexp.MustCompile(`^CREATE (?:(?:GLOBAL |LOCAL )?(?:TEMPORARY |TEMP )|UNLOGGED )?TABLE (?:IF NOT EXISTS )?([^ (]+)`)
+	alterTableStatement  = regexp.MustCompile(`^ALTER TABLE (?:IF EXISTS )?(?:ONLY )?([^ ]+) (.*)$`)
+	createIndexStatement = regexp.MustCompile(`^CREATE (?:UNIQUE )?INDEX (CONCURRENTLY )?.*?\bON (?:ONLY )?([^ (]+)`)
+	alterTypeClause      = regexp.MustCompile(`^ALTER (?:COLUMN )?[^ ]+ (?:SET DATA )?TYPE\b`)
+	addConstraintClause  = regexp.MustCompile(`^ADD (?:CONSTRAINT|PRIMARY KEY|UNIQUE|FOREIGN KEY|CHECK|EXCLUDE)\b`)
+	notNull              = regexp.MustCompile(`\bNOT NULL\b`)
+	defaultValue         = regexp.MustCompile(`\b(?:DEFAULT|GENERATED)\b`)
+	where                = regexp.MustCompile(`\bWHERE\b`)
+)
+
+// rules are the checks run on every statement, in the order of the findings
+var rules = []rule{
+	{
+		name:    "drop_table",
+		message: "dropping a table loses its data and breaks the application versions still using it",
+		match: func(sql string, _ map[string]bool) bool {
+			return strings.HasPrefix(sql, "DROP TABLE ")
+		},
+	},
+	{
+		name:    "drop_column",
+		message: "dropping a column breaks the application versions still reading it",
+		match: alterClause(func(clause string) bool {
+			return strings.HasPrefix(clause, "DROP ") && !strings.HasPrefix(clause, "DROP CONSTRAINT ")
+		}),
+	},
+	{
+		name:    "alter_column_type",
+		message: "changing the type of a column rewrites the table under an exclusive lock, which blocks large tables for long",
+		match:   alterClause(alterTypeClause.MatchString),
+	},
+	{
+		name:    "not_null_without_default",
+		message: "adding a NOT NULL column without a default fails on a table with rows",
+		match: alterClause(func(clause string) bool {
+			return strings.HasPrefix(clause, "ADD ") && !addConstraintClause.MatchString(clause) &&
+				notNull.MatchString(clause) && !defaultValue.MatchString(clause)
+		}),
+	},
+	{
+		name:    "create_index_not_concurrently",
+		message: "creating an index without CONCURRENTLY blocks the writes to the table while it is built",
+		match: func(sql string, created map[string]bool) bool {
+			match := createIndexStatement.FindStringSubmatch(sql)
+			return match != nil && match[1] == "" && !created[match[2]]
+		},
+	},
+	{
+		name:    "update_without_where",
+		message: "an UPDATE without WHERE rewrites every row of the table",
+		match: func(sql string, _ map[string]bool) bool {
+			return strings.HasPrefix(sql, "UPDATE ") && !where.MatchString(sql)
+		},
+	},
+}
+
+// alterClause matches the ALTER TABLE statements with an action matching fn,
+// the tables created by the same migration are left alone since they are
+// still empty and unused
+func alterClause(fn func(clause string) bool) func(string, map[string]bool) bool {
+	return func(sql string, created map[string]bool) bool {
+		match := alterTableStatement.FindStringSubmatch(sql)
+		if match == nil || created[match[1]] {
+			return false
+		}
+
+		return slices.ContainsFunc(splitClauses(match[2]), fn)
+	}
+}
+
+// concurrentStatement matches the statements using CONCURRENTLY that
+// PostgreSQL refuses to run inside a transaction
+var concurrentStatement = regexp.MustCompile(`^(?:CREATE (?:UNIQUE )?INDEX|DROP INDEX|REINDEX|ALTER TABLE .* DETACH PARTITION)\b.*\bCONCURRENTLY\b`)
+
+// FindConcurrently returns the line of the first statement of the migration
+// that cannot run inside a transaction because of CONCURRENTLY, or 0
+func FindConcurrently(content string) int {
+	for _, stmt := range splitStatements(content) {
+		if concurrentStatement.MatchString(stmt.sql) {
+			return stmt.line
+		}
+	}
+
+	return 0
+}
+
+// nonTransactionalStatement matches the other statements PostgreSQL refuses
+// to run inside a transaction
+var nonTransactionalStatement = regexp.MustCompile(`^(?:VACUUM|CREATE DATABASE|DROP DATABASE|ALTER SYSTEM|CREATE TABLESPACE|DROP TABLESPACE)\b`)
+
+// FindNonTransactional returns the line of the first statement of the
+// migration that cannot run inside a transaction, such as CONCURRENTLY,
+// VACUUM or CREATE DATABASE, or 0
+func FindNonTransactional(content string) int {
+	for _, stmt := range splitStatements(content) {
+		if concurrentStatement.MatchString(stmt.sql) || nonTransactionalStatement.MatchString(stmt.sql) {
+			return stmt.line
+		}
+	}
+
+	return 0
+}
+
+// writeStatement matches the statements writing to a table, capturing its name
+var writeStatement = regexp.MustCompile(`^(?:INSERT INTO|UPDATE(?: ONLY)?|DELETE FROM(?: ONLY)?|COPY|ALTER TABLE(?: IF EXISTS)?(?: ONLY)?|CREATE (?:UNIQUE )?INDEX .*?\bON(?: ONLY)?) ([^ (]+)`)
+
+// WrittenTables returns the tables the statements of the migration insert
+// into, update, delete from, alter or index, in order of first appearance.
+// Names are lowercased as PostgreSQL folds unquoted identifiers, quoted ones
+// are left out.
+func WrittenTables(content string) []string {
+	var tables []string
+	for _, stmt := range splitStatements(content) {
+		match := writeStatement.FindStringSubmatch(stmt.sql)
+		if match == nil || strings.Contains(match[1], `"`) {
+			continue
+		}
+
+		if table := strings.ToLower(match[1]); !slices.Contains(tables, table) {
+			tables = append(tables, table)
+		}
+	}
+
+	return tables
+}
+
+// dmlStatement matches the statements inserting, updating or deleting rows
+var dmlStatement = regexp.MustCompile(`^(?:INSERT|UPDATE|DELETE)\b`)
+
+// Statement is a statement of a migration file
+type Statement struct {
+	SQL  string // Statement as written in the migration file, without its semicolon
+	Line int    // Line of the statement in the migration file, from 1
+}
+
+// DMLStatements returns the INSERT, UPDATE and DELETE statements of the
+// migration, in order
+func DMLStatements(content string) []Statement {
+	var statements []Statement
+	for _, stmt := range splitStatements(content) {
+		if dmlStatement.MatchString(stmt.sql) {
+			statements = append(statements, Statement{SQL: stmt.raw, Line: stmt.line})
+		}
+	}
+
+	return statements
+}
+
+// Rules returns the names of the rules
+func Rules() []string {
+	names := make([]string, len(rules))
+	for i, rule := range rules {
+		names[i] = rule.name
+	}
+
+	return names
+}
+
+// ignoreDirective waives rules for a migration: "-- mig:lint-ignore drop_column"
+var ignoreDirective = regexp.MustCompile(`--\s*mig:lint-ignore\s+([a-z_]+(?:\s*,\s*[a-z_]+)*)`)
+
+// Linter checks migrations against the rules with the configured severities
+type Linter struct {
+	severities map[string]string
+}
+
+// New creates a linter with the severities of the lint configuration, rules
+// without one are warnings
+func New(cfg config.LintConfig) (*Linter, error) {
+	for name := range cfg.Rules {
+		if !slices.Contains(Rules(), name) {
+			return nil, fmt.Errorf("unknown lint rule %q (available: %s)", name, strings.Join(Rules(), ", "))
+		}
+	}
+
+	return &Linter{severities: cfg.Rules}, nil
+}
+
+// severity returns the severity of the named rule
+func (l *Linter) severity(name string) string {
+	if severity, ok := l.severities[name]; ok {
+		return severity
+	}
+
+	return config.SeverityWarn
+}
+
+// Check returns the findings of a migration, without the rules it ignores
+func (l *Linter) Check(migration migrations.Migration) []Finding {
+	ignored := make(map[string]bool)
+	for _, match := range ignoreDirective.FindAllStringSubmatch(migration.Content, -1) {
+		for _, name := range strings.Split(match[1], ",") {
+			ignored[strings.TrimSpace(name)] = true
+		}
+	}
+
+	var findings []Finding
+	created := make(map[string]bool)
+	for _, stmt := range splitStatements(migration.Content) {
+		if match := createTableStatement.FindStringSubmatch(stmt.sql); match != nil {
+			created[match[1]] = true
+		}
+
+		for _, rule := range rules {
+			severity := l.severity(rule.name)
+			if severity == config.SeverityOff || ignored[rule.name] || !rule.match(stmt.sql, created) {
+				continue
+			}
+
+			findings = append(findings, Finding{
+				Migration: migration.ID,
+				Filename:  migration.Filename,
+				Line:      stmt.line,
+				Rule:      rule.name,
+				Severity:  severity,
+				Message:   rule.message,
+			})
+		}
+	}
+
+	return findings
+}
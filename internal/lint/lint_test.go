@@ -0,0 +1,163 @@
+package lint_test
+
+import (
+	"testing"
+
+	"github.com/arthurdotwork/mig/internal/config"
+	"github.com/arthurdotwork/mig/internal/lint"
+	"github.com/arthurdotwork/mig/internal/migrations"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	linter, err := lint.New(config.LintConfig{})
+	require.NoError(t, err)
+
+	rules := func(content string) []string {
+		var names []string
+		for _, finding := range linter.Check(migrations.Migration{ID: "m", Filename: "m.sql", Content: content}) {
+			names = append(names, finding.Rule)
+		}
+		return names
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		rules   []string
+	}{
+		{"drop table", "DROP TABLE users;", []string{"drop_table"}},
+		{"drop column", "alter table users drop column email;", []string{"drop_column"}},
+		{"drop column without the keyword", "ALTER TABLE users DROP email;", []string{"drop_column"}},
+		{"drop constraint", "ALTER TABLE users DROP CONSTRAINT users_email_key;", nil},
+		{"alter column type", "ALTER TABLE users ALTER COLUMN id TYPE bigint;", []string{"alter_column_type"}},
+		{"set data type", "ALTER TABLE users ALTER id SET DATA TYPE bigint;", []string{"alter_column_type"}},
+		{"add not null without default", "ALTER TABLE users ADD COLUMN age int NOT NULL;", []string{"not_null_without_default"}},
+		{"add not null with default", "ALTER TABLE users ADD COLUMN age int NOT NULL DEFAULT 0;", nil},
+		{"add nullable column", "ALTER TABLE users ADD COLUMN age int;", nil},
+		{"several actions", "ALTER TABLE users ADD COLUMN a int DEFAULT 0, ADD COLUMN b numeric(10, 2) NOT NULL, DROP COLUMN c;", []string{"drop_column", "not_null_without_default"}},
+		{"create index", "CREATE INDEX users_email_idx ON users (email);", []string{"create_index_not_concurrently"}},
+		{"create unique index", "CREATE UNIQUE INDEX users_email_idx ON users (email);", []string{"create_index_not_concurrently"}},
+		{"create index concurrently", "CREATE INDEX CONCURRENTLY users_email_idx ON users (email);", nil},
+		{"update without where", "UPDATE users SET active = true;", []string{"update_without_where"}},
+		{"update with where", "UPDATE users SET active = true WHERE id = 1;", nil},
+		{"keywords in literals and comments", "-- DROP TABLE users;\nINSERT INTO notes VALUES ('DROP TABLE users; UPDATE x SET y = 1');", nil},
+		{"keywords in dollar-quoted bodies", "CREATE FUNCTION f() RETURNS void AS $$ UPDATE users SET a = 1; $$ LANGUAGE sql;", nil},
+		{"table created by the migration", "CREATE TABLE users (id int);\nCREATE INDEX users_id_idx ON users (id);\nALTER TABLE users ADD COLUMN name text NOT NULL;", nil},
+		{"ignored rules", "-- mig:lint-ignore drop_table, drop_column\nDROP TABLE users;\nALTER TABLE orders DROP COLUMN note;", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run("it should check a "+tt.name, func(t *testing.T) {
+			require.Equal(t, tt.rules, rules(tt.content))
+		})
+	}
+
+	t.Run("it should report the line of the statement", func(t *testing.T) {
+		findings := linter.Check(migrations.Migration{ID: "m", Filename: "m.sql", Content: "-- Drop the legacy table\n/* it was\nreplaced */\nSELECT 1;\n\nDROP TABLE legacy;\n"})
+		require.Len(t, findings, 1)
+
+		require.Equal(t, 6, findings[0].Line)
+		require.Equal(t, config.SeverityWarn, findings[0].Severity)
+		require.Equal(t, "m.sql:6: warn drop_table: dropping a table loses its data and breaks the application versions still using it", findings[0].String())
+	})
+}
+
+func TestFindConcurrently(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		line    int
+	}{
+		{"create index concurrently", "SELECT 1;\nCREATE UNIQUE INDEX CONCURRENTLY a ON b (c);", 2},
+		{"drop index concurrently", "DROP INDEX CONCURRENTLY IF EXISTS a;", 1},
+		{"reindex concurrently", "REINDEX INDEX CONCURRENTLY a;", 1},
+		{"detach partition concurrently", "ALTER TABLE a DETACH PARTITION b CONCURRENTLY;", 1},
+		{"create index", "CREATE INDEX a ON b (c);", 0},
+		{"refresh materialized view concurrently", "REFRESH MATERIALIZED VIEW CONCURRENTLY a;", 0},
+		{"concurrently in a comment", "-- CREATE INDEX CONCURRENTLY a ON b (c);\nSELECT 1;", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run("it should find a "+tt.name, func(t *testing.T) {
+			require.Equal(t, tt.line, lint.FindConcurrently(tt.content))
+		})
+	}
+}
+
+func TestFindNonTransactional(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		line    int
+	}{
+		{"create index concurrently", "CREATE INDEX CONCURRENTLY a ON b (c);", 1},
+		{"vacuum", "UPDATE a SET b = 1 WHERE c;\nVACUUM ANALYZE a;", 2},
+		{"create database", "CREATE DATABASE reporting;", 1},
+		{"alter system", "ALTER SYSTEM SET work_mem = '64MB';", 1},
+		{"create table", "CREATE TABLE a (b int);", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run("it should find a "+tt.name, func(t *testing.T) {
+			require.Equal(t, tt.line, lint.FindNonTransactional(tt.content))
+		})
+	}
+}
+
+func TestWrittenTables(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		tables  []string
+	}{
+		{"backfill", "UPDATE users SET active = true WHERE active IS NULL;", []string{"users"}},
+		{"insert", "INSERT INTO audit.events(id, kind) VALUES (1, 'a');", []string{"audit.events"}},
+		{"several statements", "ALTER TABLE Orders ADD COLUMN total int;\nUPDATE orders SET total = 0;\nDELETE FROM ONLY carts WHERE 1 = 1;", []string{"orders", "carts"}},
+		{"index", "CREATE UNIQUE INDEX CONCURRENTLY users_email_idx ON users (email);", []string{"users"}},
+		{"quoted name", `UPDATE "Users" SET a = 1 WHERE b;`, nil},
+		{"read", "SELECT * FROM users;", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run("it should find the tables of a "+tt.name, func(t *testing.T) {
+			require.Equal(t, tt.tables, lint.WrittenTables(tt.content))
+		})
+	}
+}
+
+func TestDMLStatements(t *testing.T) {
+	t.Run("it should return the statements writing rows as written", func(t *testing.T) {
+		content := "-- Backfill the status\nCREATE TABLE a (b int);\nINSERT INTO a VALUES (1);\n\nupdate a\n  set b = 2 -- all rows\n  where b = ';';\nDELETE FROM a WHERE b = 3"
+
+		require.Equal(t, []lint.Statement{
+			{SQL: "INSERT INTO a VALUES (1)", Line: 3},
+			{SQL: "update a\n  set b = 2 -- all rows\n  where b = ';'", Line: 5},
+			{SQL: "DELETE FROM a WHERE b = 3", Line: 8},
+		}, lint.DMLStatements(content))
+	})
+
+	t.Run("it should skip the other statements", func(t *testing.T) {
+		require.Empty(t, lint.DMLStatements("SELECT 1;\nCREATE FUNCTION f() RETURNS void AS $$ UPDATE a SET b = 1; $$ LANGUAGE sql;"))
+	})
+}
+
+func TestNew(t *testing.T) {
+	t.Run("it should apply the configured severities", func(t *testing.T) {
+		linter, err := lint.New(config.LintConfig{Rules: map[string]string{
+			"drop_table":  config.SeverityError,
+			"drop_column": config.SeverityOff,
+		}})
+		require.NoError(t, err)
+
+		findings := linter.Check(migrations.Migration{Content: "DROP TABLE a;\nALTER TABLE b DROP COLUMN c;"})
+		require.Len(t, findings, 1)
+		require.Equal(t, "drop_table", findings[0].Rule)
+		require.Equal(t, config.SeverityError, findings[0].Severity)
+	})
+
+	t.Run("it should reject an unknown rule", func(t *testing.T) {
+		_, err := lint.New(config.LintConfig{Rules: map[string]string{"drop_everything": config.SeverityError}})
+		require.ErrorContains(t, err, `unknown lint rule "drop_everything"`)
+	})
+}
@@ -0,0 +1,183 @@
+package lint
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Table lock modes of PostgreSQL taken by migration statements
+const (
+	LockRowExclusive         = "ROW EXCLUSIVE"
+	LockShareUpdateExclusive = "SHARE UPDATE EXCLUSIVE"
+	LockShare                = "SHARE"
+	LockShareRowExclusive    = "SHARE ROW EXCLUSIVE"
+	LockExclusive            = "EXCLUSIVE"
+	LockAccessExclusive      = "ACCESS EXCLUSIVE"
+)
+
+// lockModes are the lock modes from the weakest to the strongest
+var lockModes = []string{
+	"ACCESS SHARE",
+	"ROW SHARE",
+	LockRowExclusive,
+	LockShareUpdateExclusive,
+	LockShare,
+	LockShareRowExclusive,
+	LockExclusive,
+	LockAccessExclusive,
+}
+
+// Risks of a lock mode
+const (
+	RiskLow    = "low"    // Reads and writes go on
+	RiskMedium = "medium" // Writes to the table wait for the statement
+	RiskHigh   = "high"   // Reads and writes to the table wait for the statement
+)
+
+// Risk returns how much a lock mode disrupts the application: ACCESS
+// EXCLUSIVE blocks even reads, the modes from SHARE up block writes
+func Risk(mode string) string {
+	switch {
+	case mode == LockAccessExclusive:
+		return RiskHigh
+	case slices.Index(lockModes, mode) >= slices.Index(lockModes, LockShare):
+		return RiskMedium
+	default:
+		return RiskLow
+	}
+}
+
+// Lock is the table lock a statement of a migration takes
+type Lock struct {
+	Line      int    // Line of the statement in the migration file
+	Statement string // Statement as written in the migration file
+	Table     string // Locked table, lowercased like WrittenTables
+	Mode      string // Lock mode, such as LockAccessExclusive
+}
+
+var (
+	dropTableStatement         = regexp.MustCompile(`^DROP TABLE (?:IF EXISTS )?([^ ,]+)`)
+	truncateStatement          = regexp.MustCompile(`^TRUNCATE (?:TABLE )?(?:ONLY )?([^ ,]+)`)
+	lockStatement              = regexp.MustCompile(`^LOCK (?:TABLE )?(?:ONLY )?([^ ,]+)(?:.* IN ((?:ACCESS |ROW )?(?:SHARE|EXCLUSIVE)(?: UPDATE EXCLUSIVE| ROW EXCLUSIVE)?) MODE)?`)
+	createTriggerStatement     = regexp.MustCompile(`^CREATE (?:OR REPLACE )?(?:CONSTRAINT )?TRIGGER .*?\bON ([^ ]+)`)
+	reindexTableStatement      = regexp.MustCompile(`^REINDEX (?:\([^)]*\) )?TABLE (CONCURRENTLY )?([^ ]+)`)
+	vacuumStatement            = regexp.MustCompile(`^VACUUM ((?:\([^)]*\) )?(?:(?:FULL|FREEZE|VERBOSE|ANALYZE) )*)([^ (]+)`)
+	clusterStatement           = regexp.MustCompile(`^CLUSTER (?:VERBOSE )?([^ (]+)`)
+	refreshStatement           = regexp.MustCompile(`^REFRESH MATERIALIZED VIEW (CONCURRENTLY )?([^ ]+)`)
+	analyzeStatement           = regexp.MustCompile(`^ANALYZE (?:VERBOSE )?([^ (]+)`)
+	rowWriteStatement          = regexp.MustCompile(`^(?:INSERT INTO|UPDATE(?: ONLY)?|DELETE FROM(?: ONLY)?|MERGE INTO) ([^ (]+)`)
+	copyFromStatement          = regexp.MustCompile(`^COPY ([^ (]+).* FROM\b`)
+	addForeignKeyClause        = regexp.MustCompile(`^ADD (?:CONSTRAINT [^ ]+ )?FOREIGN KEY\b`)
+	shareUpdateExclusiveClause = regexp.MustCompile(`^(?:VALIDATE CONSTRAINT|ALTER (?:COLUMN )?[^ ]+ SET STATISTICS|ATTACH PARTITION|DETACH PARTITION .* CONCURRENTLY|CLUSTER ON|SET WITHOUT CLUSTER)\b`)
+)
+
+// Locks returns the table lock of each statement of the migration taking
+// one, following the lock levels documented by PostgreSQL. An ALTER TABLE
+// takes the strongest lock of its actions. Statements on tables created by
+// the same migration are included since the lock is still taken, and those
+// the classification does not know are left out.
+func Locks(content string) []Lock {
+	var locks []Lock
+	for _, stmt := range splitStatements(content) {
+		table, mode := lockOf(stmt.sql)
+		if mode == "" {
+			continue
+		}
+
+		locks = append(locks, Lock{
+			Line:      stmt.line,
+			Statement: stmt.raw,
+			Table:     strings.ToLower(table),
+			Mode:      mode,
+		})
+	}
+
+	return locks
+}
+
+// vacuumOption are the options of a VACUUM without a table, which the
+// table of vacuumStatement captures
+var vacuumOption = map[string]bool{"FULL": true, "FREEZE": true, "VERBOSE": true, "ANALYZE": true}
+
+// lockOf returns the table a normalized statement locks and the lock mode,
+// or an empty mode when the statement is not classified
+func lockOf(sql string) (string, string) {
+	if match := alterTableStatement.FindStringSubmatch(sql); match != nil {
+		mode := LockShareUpdateExclusive
+		for _, clause := range splitClauses(match[2]) {
+			clauseMode := LockAccessExclusive
+			switch {
+			case shareUpdateExclusiveClause.MatchString(clause):
+				clauseMode = LockShareUpdateExclusive
+			case addForeignKeyClause.MatchString(clause):
+				clauseMode = LockShareRowExclusive
+			}
+			mode = strongest(mode, clauseMode)
+		}
+		return match[1], mode
+	}
+
+	if match := createIndexStatement.FindStringSubmatch(sql); match != nil {
+		if match[1] != "" {
+			return match[2], LockShareUpdateExclusive
+		}
+		return match[2], LockShare
+	}
+
+	if match := lockStatement.FindStringSubmatch(sql); match != nil {
+		if match[2] != "" {
+			return match[1], match[2]
+		}
+		return match[1], LockAccessExclusive
+	}
+
+	if match := reindexTableStatement.FindStringSubmatch(sql); match != nil {
+		if match[1] != "" {
+			return match[2], LockShareUpdateExclusive
+		}
+		return match[2], LockShare
+	}
+
+	if match := vacuumStatement.FindStringSubmatch(sql); match != nil && !vacuumOption[match[2]] {
+		if strings.Contains(match[1], "FULL") {
+			return match[2], LockAccessExclusive
+		}
+		return match[2], LockShareUpdateExclusive
+	}
+
+	if match := refreshStatement.FindStringSubmatch(sql); match != nil {
+		if match[1] != "" {
+			return match[2], LockExclusive
+		}
+		return match[2], LockAccessExclusive
+	}
+
+	for _, classified := range []struct {
+		statement *regexp.Regexp
+		mode      string
+	}{
+		{dropTableStatement, LockAccessExclusive},
+		{truncateStatement, LockAccessExclusive},
+		{clusterStatement, LockAccessExclusive},
+		{createTriggerStatement, LockShareRowExclusive},
+		{analyzeStatement, LockShareUpdateExclusive},
+		{rowWriteStatement, LockRowExclusive},
+		{copyFromStatement, LockRowExclusive},
+	} {
+		if match := classified.statement.FindStringSubmatch(sql); match != nil {
+			return match[1], classified.mode
+		}
+	}
+
+	return "", ""
+}
+
+// strongest returns the stronger of two lock modes
+func strongest(a, b string) string {
+	if slices.Index(lockModes, b) > slices.Index(lockModes, a) {
+		return b
+	}
+
+	return a
+}
@@ -0,0 +1,60 @@
+package lint_test
+
+import (
+	"testing"
+
+	"github.com/arthurdotwork/mig/internal/lint"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocks(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		table   string
+		mode    string
+	}{
+		{"add column", "ALTER TABLE Orders ADD COLUMN total int;", "orders", lint.LockAccessExclusive},
+		{"validate constraint", "ALTER TABLE orders VALIDATE CONSTRAINT orders_total_check;", "orders", lint.LockShareUpdateExclusive},
+		{"foreign key", "ALTER TABLE orders ADD CONSTRAINT orders_user_fk FOREIGN KEY (user_id) REFERENCES users (id) NOT VALID;", "orders", lint.LockShareRowExclusive},
+		{"strongest action", "ALTER TABLE orders SET STATISTICS 100, ALTER COLUMN total SET NOT NULL;", "orders", lint.LockAccessExclusive},
+		{"create index", "CREATE INDEX orders_total_idx ON orders (total);", "orders", lint.LockShare},
+		{"create index concurrently", "CREATE INDEX CONCURRENTLY orders_total_idx ON public.orders (total);", "public.orders", lint.LockShareUpdateExclusive},
+		{"drop table", "DROP TABLE IF EXISTS legacy, other;", "legacy", lint.LockAccessExclusive},
+		{"truncate", "TRUNCATE TABLE carts;", "carts", lint.LockAccessExclusive},
+		{"explicit lock", "LOCK TABLE orders IN SHARE ROW EXCLUSIVE MODE;", "orders", lint.LockShareRowExclusive},
+		{"default lock", "LOCK orders;", "orders", lint.LockAccessExclusive},
+		{"trigger", "CREATE TRIGGER audit AFTER UPDATE ON orders FOR EACH ROW EXECUTE FUNCTION audit();", "orders", lint.LockShareRowExclusive},
+		{"vacuum full", "VACUUM (FULL, ANALYZE) orders;", "orders", lint.LockAccessExclusive},
+		{"vacuum", "VACUUM ANALYZE orders;", "orders", lint.LockShareUpdateExclusive},
+		{"refresh concurrently", "REFRESH MATERIALIZED VIEW CONCURRENTLY totals;", "totals", lint.LockExclusive},
+		{"backfill", "UPDATE orders SET total = 0 WHERE total IS NULL;", "orders", lint.LockRowExclusive},
+	}
+
+	for _, tt := range tests {
+		t.Run("it should classify a "+tt.name, func(t *testing.T) {
+			locks := lint.Locks(tt.content)
+			require.Len(t, locks, 1)
+			require.Equal(t, tt.table, locks[0].Table)
+			require.Equal(t, tt.mode, locks[0].Mode)
+		})
+	}
+
+	t.Run("it should skip the statements taking no table lock", func(t *testing.T) {
+		require.Empty(t, lint.Locks("SELECT 1;\nCREATE TABLE a (b int);\nVACUUM FULL;"))
+	})
+
+	t.Run("it should report the line and statement", func(t *testing.T) {
+		require.Equal(t, []lint.Lock{
+			{Line: 3, Statement: "ALTER TABLE a\n  ADD COLUMN b int", Table: "a", Mode: lint.LockAccessExclusive},
+		}, lint.Locks("CREATE TABLE t (id int);\n\nALTER TABLE a\n  ADD COLUMN b int;"))
+	})
+}
+
+func TestRisk(t *testing.T) {
+	require.Equal(t, lint.RiskHigh, lint.Risk(lint.LockAccessExclusive))
+	require.Equal(t, lint.RiskMedium, lint.Risk(lint.LockShare))
+	require.Equal(t, lint.RiskMedium, lint.Risk(lint.LockExclusive))
+	require.Equal(t, lint.RiskLow, lint.Risk(lint.LockShareUpdateExclusive))
+	require.Equal(t, lint.RiskLow, lint.Risk(lint.LockRowExclusive))
+}
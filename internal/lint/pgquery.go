@@ -0,0 +1,26 @@
+//go:build pg_query
+
+package lint
+
+import (
+	"errors"
+
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+	"github.com/pganalyze/pg_query_go/v6/parser"
+)
+
+func init() {
+	parse = func(content string) (string, int, bool) {
+		_, err := pg_query.Parse(content)
+		if err == nil {
+			return "", 0, false
+		}
+
+		var parseErr *parser.Error
+		if errors.As(err, &parseErr) {
+			return parseErr.Message, parseErr.Cursorpos, true
+		}
+
+		return err.Error(), 0, true
+	}
+}
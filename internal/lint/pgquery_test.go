@@ -0,0 +1,33 @@
+//go:build pg_query
+
+package lint_test
+
+import (
+	"testing"
+
+	"github.com/arthurdotwork/mig/internal/lint"
+	"github.com/arthurdotwork/mig/internal/migrations"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckSyntaxParser(t *testing.T) {
+	check := func(content string) []string {
+		var errs []string
+		for _, syntaxErr := range lint.CheckSyntax(migrations.Migration{ID: "m", Filename: "m.sql", Content: content}) {
+			errs = append(errs, syntaxErr.String())
+		}
+		return errs
+	}
+
+	t.Run("it should accept a migration the parser accepts", func(t *testing.T) {
+		require.Empty(t, check("CREATE TABLE a (b int);\nDO $$ BEGIN PERFORM 1; END $$;"))
+	})
+
+	t.Run("it should report the error of the parser with its position", func(t *testing.T) {
+		require.Equal(t, []string{`m.sql:2:25: syntax error at or near ")"`}, check("SELECT 'é';\nCREATE TABLE a (b int, c);"))
+	})
+
+	t.Run("it should report the lexical errors without parsing", func(t *testing.T) {
+		require.Equal(t, []string{`m.sql:1:1: syntax error at or near "CRATE"`}, check("CRATE TABLE a (b int,);"))
+	})
+}
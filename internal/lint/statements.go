@@ -0,0 +1,130 @@
+package lint
+
+import (
+	"regexp"
+	"strings"
+)
+
+// statement is a SQL statement normalized for matching: comments are removed,
+// string literals are emptied, whitespace is collapsed and keywords are in
+// upper case
+type statement struct {
+	sql  string
+	raw  string // Statement as written in the migration file, without its semicolon
+	line int    // Line of the statement in the migration file, from 1
+}
+
+// dollarTag matches the opening tag of a dollar-quoted string, such as $$ or $body$
+var dollarTag = regexp.MustCompile(`^\$(?:[A-Za-z_][A-Za-z0-9_]*)?\$`)
+
+// splitStatements splits a migration into normalized statements on the
+// semicolons outside of comments, literals and dollar-quoted bodies
+func splitStatements(content string) []statement {
+	var statements []statement
+	var b strings.Builder
+	line, start, begin, i := 1, 0, 0, 0
+
+	flush := func() {
+		if sql := strings.TrimSpace(b.String()); sql != "" {
+			raw := strings.TrimSpace(content[begin:min(i, len(content))])
+			statements = append(statements, statement{sql: strings.ToUpper(sql), raw: raw, line: start})
+		}
+		b.Reset()
+		start = 0
+	}
+
+	// write appends normalized text, the statement starts at its first token
+	write := func(s string) {
+		if start == 0 {
+			start, begin = line, i
+		}
+		b.WriteString(s)
+	}
+
+	space := func() {
+		if b.Len() > 0 && !strings.HasSuffix(b.String(), " ") {
+			b.WriteByte(' ')
+		}
+	}
+
+	for ; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case c == '\n':
+			line++
+			space()
+		case c == ' ' || c == '\t' || c == '\r':
+			space()
+		case strings.HasPrefix(content[i:], "--"):
+			end := strings.IndexByte(content[i:], '\n')
+			if end == -1 {
+				i = len(content)
+			} else {
+				i += end - 1
+			}
+		case strings.HasPrefix(content[i:], "/*"):
+			end := strings.Index(content[i+2:], "*/")
+			if end == -1 {
+				end = len(content) - i - 2
+			}
+			line += strings.Count(content[i:i+2+end], "\n")
+			i += end + 3
+			space()
+		case c == '\'':
+			// '' escapes a quote inside the literal
+			j := i + 1
+			for j < len(content) {
+				if content[j] == '\'' {
+					if j+1 < len(content) && content[j+1] == '\'' {
+						j += 2
+						continue
+					}
+					break
+				}
+				j++
+			}
+			line += strings.Count(content[i:min(j, len(content))], "\n")
+			write("''")
+			i = j
+		case c == '$' && dollarTag.MatchString(content[i:]):
+			tag := dollarTag.FindString(content[i:])
+			end := strings.Index(content[i+len(tag):], tag)
+			if end == -1 {
+				end = len(content) - i - len(tag)
+			}
+			line += strings.Count(content[i:i+len(tag)+end], "\n")
+			write("''")
+			i += len(tag) + end + len(tag) - 1
+		case c == ';':
+			flush()
+		default:
+			write(content[i : i+1])
+		}
+	}
+	flush()
+
+	return statements
+}
+
+// splitClauses splits the actions of an ALTER TABLE statement on the commas
+// outside of parentheses
+func splitClauses(actions string) []string {
+	var clauses []string
+	depth, from := 0, 0
+
+	for i, c := range actions {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				clauses = append(clauses, strings.TrimSpace(actions[from:i]))
+				from = i + 1
+			}
+		}
+	}
+
+	return append(clauses, strings.TrimSpace(actions[from:]))
+}
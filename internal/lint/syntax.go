@@ -0,0 +1,269 @@
+package lint
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/arthurdotwork/mig/internal/migrations"
+)
+
+// SyntaxError is a statement of a migration that PostgreSQL would reject
+type SyntaxError struct {
+	Migration string // ID of the migration
+	Filename  string // Filename of the migration
+	Line      int    // Line of the error in the migration file, from 1
+	Column    int    // Column of the error in its line, from 1
+	Message   string // What is wrong
+}
+
+// String formats the error as "file:line:column: message"
+func (e SyntaxError) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.Filename, e.Line, e.Column, e.Message)
+}
+
+// commands are the keywords a PostgreSQL statement starts with
+var commands = map[string]bool{
+	"ABORT": true, "ALTER": true, "ANALYZE": true, "BEGIN": true, "CALL": true,
+	"CHECKPOINT": true, "CLOSE": true, "CLUSTER": true, "COMMENT": true, "COMMIT": true,
+	"COPY": true, "CREATE": true, "DEALLOCATE": true, "DECLARE": true, "DELETE": true,
+	"DISCARD": true, "DO": true, "DROP": true, "END": true, "EXECUTE": true,
+	"EXPLAIN": true, "FETCH": true, "GRANT": true, "IMPORT": true, "INSERT": true,
+	"LISTEN": true, "LOAD": true, "LOCK": true, "MERGE": true, "MOVE": true,
+	"NOTIFY": true, "PREPARE": true, "REASSIGN": true, "REFRESH": true, "REINDEX": true,
+	"RELEASE": true, "RESET": true, "REVOKE": true, "ROLLBACK": true, "SAVEPOINT": true,
+	"SECURITY": true, "SELECT": true, "SET": true, "SHOW": true, "START": true,
+	"TABLE": true, "TRUNCATE": true, "UNLISTEN": true, "UPDATE": true, "VACUUM": true,
+	"VALUES": true, "WITH": true,
+}
+
+// position is a place in a migration file
+type position struct {
+	line, column int
+}
+
+// parse parses a migration with the PostgreSQL parser and returns its first
+// error and the character of the migration it occurred at, from 1, or 0 when
+// unknown. It is set when mig is built with -tags pg_query.
+var parse func(content string) (message string, cursor int, failed bool)
+
+// CheckSyntax checks the statements of a PostgreSQL migration without a
+// database. A lexical scan reports every unterminated literal, quoted
+// identifier, comment and dollar-quoted body, unbalanced parentheses and
+// statements not starting with a command. When the scan passes and mig is
+// built with -tags pg_query, the migration is then parsed by the parser of
+// PostgreSQL (libpg_query), which reports the first error of the grammar;
+// otherwise a migration it accepts can still fail to apply.
+func CheckSyntax(migration migrations.Migration) []SyntaxError {
+	errs := scanSyntax(migration)
+	if len(errs) > 0 || parse == nil {
+		return errs
+	}
+
+	message, cursor, failed := parse(migration.Content)
+	if !failed {
+		return nil
+	}
+
+	at := cursorPosition(migration.Content, cursor)
+	return []SyntaxError{{
+		Migration: migration.ID,
+		Filename:  migration.Filename,
+		Line:      at.line,
+		Column:    at.column,
+		Message:   message,
+	}}
+}
+
+// cursorPosition returns the position of a character of a migration, from 1,
+// the start of the migration when unknown
+func cursorPosition(content string, cursor int) position {
+	pos := position{line: 1, column: 1}
+	for _, r := range content {
+		if cursor <= 1 {
+			break
+		}
+		if r == '\n' {
+			pos.line, pos.column = pos.line+1, 1
+		} else {
+			pos.column++
+		}
+		cursor--
+	}
+
+	return pos
+}
+
+// scanSyntax runs the lexical checks of CheckSyntax. Scanning stops at the
+// first unterminated token since the rest of the file cannot be told apart
+// from it.
+func scanSyntax(migration migrations.Migration) []SyntaxError {
+	content := migration.Content
+
+	var errs []SyntaxError
+	report := func(at position, format string, args ...any) {
+		errs = append(errs, SyntaxError{
+			Migration: migration.ID,
+			Filename:  migration.Filename,
+			Line:      at.line,
+			Column:    at.column,
+			Message:   fmt.Sprintf(format, args...),
+		})
+	}
+
+	pos := position{line: 1, column: 1}
+	i := 0
+
+	// advance moves past n bytes, keeping track of lines and columns
+	advance := func(n int) {
+		for end := min(i+n, len(content)); i < end; {
+			r, size := utf8.DecodeRuneInString(content[i:])
+			if r == '\n' {
+				pos.line, pos.column = pos.line+1, 1
+			} else {
+				pos.column++
+			}
+			i += size
+		}
+	}
+
+	// closeQuote moves past the quoted token opened at content[i], a doubled
+	// quote escaping a quote, and reports whether it is closed
+	closeQuote := func(quote byte, escapes bool) bool {
+		advance(1)
+		for i < len(content) {
+			switch {
+			case escapes && content[i] == '\\':
+				advance(2)
+			case content[i] == quote && i+1 < len(content) && content[i+1] == quote:
+				advance(2)
+			case content[i] == quote:
+				advance(1)
+				return true
+			default:
+				advance(1)
+			}
+		}
+
+		return false
+	}
+
+	var parens []position
+	started := false
+
+	// endStatement reports the parentheses left open by the statement
+	endStatement := func() {
+		for _, open := range parens {
+			report(open, "unclosed parenthesis")
+		}
+		parens, started = parens[:0], false
+	}
+
+	for i < len(content) {
+		c := content[i]
+		start := pos
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			advance(1)
+
+		case strings.HasPrefix(content[i:], "--"):
+			end := strings.IndexByte(content[i:], '\n')
+			if end == -1 {
+				end = len(content) - i
+			}
+			advance(end)
+
+		case strings.HasPrefix(content[i:], "/*"):
+			// Block comments nest in PostgreSQL
+			depth := 1
+			advance(2)
+			for depth > 0 && i < len(content) {
+				switch {
+				case strings.HasPrefix(content[i:], "/*"):
+					depth++
+					advance(2)
+				case strings.HasPrefix(content[i:], "*/"):
+					depth--
+					advance(2)
+				default:
+					advance(1)
+				}
+			}
+			if depth > 0 {
+				report(start, "unterminated comment")
+				return errs
+			}
+
+		case c == '\'' || c == '"':
+			// E'' literals escape quotes with backslashes
+			escapes := c == '\'' && i > 0 && (content[i-1] == 'E' || content[i-1] == 'e') && (i == 1 || !isIdentifierByte(content[i-2]))
+			if !closeQuote(c, escapes) {
+				if c == '"' {
+					report(start, "unterminated quoted identifier")
+				} else {
+					report(start, "unterminated string literal")
+				}
+				return errs
+			}
+			started = true
+
+		case c == '$' && (i == 0 || !isIdentifierByte(content[i-1])) && dollarTag.MatchString(content[i:]):
+			tag := dollarTag.FindString(content[i:])
+			end := strings.Index(content[i+len(tag):], tag)
+			if end == -1 {
+				report(start, "unterminated dollar-quoted string %s", tag)
+				return errs
+			}
+			advance(len(tag) + end + len(tag))
+			started = true
+
+		case c == ';':
+			endStatement()
+			advance(1)
+
+		case c == '(':
+			parens = append(parens, start)
+			started = true
+			advance(1)
+
+		case c == ')':
+			if len(parens) == 0 {
+				report(start, "syntax error at or near \")\": unmatched parenthesis")
+			} else {
+				parens = parens[:len(parens)-1]
+			}
+			started = true
+			advance(1)
+
+		case isIdentifierByte(c):
+			end := i
+			for end < len(content) && isIdentifierByte(content[end]) {
+				end++
+			}
+			word := content[i:end]
+			if !started && !commands[strings.ToUpper(word)] {
+				report(start, "syntax error at or near %q", word)
+			}
+			started = true
+			advance(end - i)
+
+		default:
+			if !started {
+				_, size := utf8.DecodeRuneInString(content[i:])
+				report(start, "syntax error at or near %q", content[i:i+size])
+			}
+			started = true
+			advance(1)
+		}
+	}
+	endStatement()
+
+	return errs
+}
+
+// isIdentifierByte reports whether c can be part of an unquoted identifier
+// or keyword
+func isIdentifierByte(c byte) bool {
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
@@ -0,0 +1,44 @@
+package lint_test
+
+import (
+	"testing"
+
+	"github.com/arthurdotwork/mig/internal/lint"
+	"github.com/arthurdotwork/mig/internal/migrations"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckSyntax(t *testing.T) {
+	check := func(content string) []string {
+		var errs []string
+		for _, syntaxErr := range lint.CheckSyntax(migrations.Migration{ID: "m", Filename: "m.sql", Content: content}) {
+			errs = append(errs, syntaxErr.String())
+		}
+		return errs
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		errs    []string
+	}{
+		{"valid migration", "-- mig:role=owner\nCREATE TABLE a (b int, c numeric(10, 2));\n/* nested /* comment */ */\nINSERT INTO a VALUES (1, 2.5), (2, 3);", nil},
+		{"function body", "CREATE FUNCTION f() RETURNS void AS $body$ UPDATE a SET b = ')'; $body$ LANGUAGE sql;\nDO $$ BEGIN PERFORM 1; END $$;", nil},
+		{"escaped quotes", "INSERT INTO a VALUES ('it''s', E'it\\'s', \"col\"\"umn\");", nil},
+		{"parenthesized query", "(SELECT 1) UNION (SELECT 2);", nil},
+		{"misspelled command", "CRATE TABLE a (b int);", []string{`m.sql:1:1: syntax error at or near "CRATE"`}},
+		{"unclosed parenthesis", "SELECT 1;\nCREATE TABLE a (\n  b int,\n  c numeric(10, 2;\nSELECT 2;", []string{"m.sql:2:16: unclosed parenthesis", "m.sql:4:12: unclosed parenthesis"}},
+		{"unmatched parenthesis", "SELECT (1));", []string{`m.sql:1:11: syntax error at or near ")": unmatched parenthesis`}},
+		{"unterminated literal", "SELECT 1;\nINSERT INTO a VALUES ('é');\nSELECT 'c", []string{"m.sql:3:8: unterminated string literal"}},
+		{"unterminated identifier", `SELECT "a FROM b;`, []string{"m.sql:1:8: unterminated quoted identifier"}},
+		{"unterminated comment", "SELECT 1;\n/* /* */", []string{"m.sql:2:1: unterminated comment"}},
+		{"unterminated body", "DO $fn$ BEGIN END $$;", []string{"m.sql:1:4: unterminated dollar-quoted string $fn$"}},
+		{"column after a multi-byte character", "INSERT INTO a VALUES ('é');\n  é;", []string{`m.sql:2:3: syntax error at or near "é"`}},
+	}
+
+	for _, tt := range tests {
+		t.Run("it should check a "+tt.name, func(t *testing.T) {
+			require.Equal(t, tt.errs, check(tt.content))
+		})
+	}
+}
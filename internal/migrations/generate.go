@@ -0,0 +1,123 @@
+package migrations
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// generatedTemplate renders the Go file written by Generate
+var generatedTemplate = template.Must(template.New("generated").Parse(`// Code generated by mig gen; DO NOT EDIT.
+
+package {{ .Package }}
+
+// MigrationID identifies a migration file
+type MigrationID string
+
+// Migration is a migration file compiled into the application
+type Migration struct {
+	ID       MigrationID
+	Name     string
+	Checksum string
+	Content  string
+}
+
+// IDs of the migrations, in the order they are applied
+const (
+{{- range .Migrations }}
+	{{ .Const }} MigrationID = {{ printf "%q" .ID }}
+{{- end }}
+)
+
+// Migrations lists the migrations in the order they are applied
+var Migrations = []Migration{
+{{- range .Migrations }}
+	{
+		ID:       {{ .Const }},
+		Name:     {{ printf "%q" .Name }},
+		Checksum: {{ printf "%q" .Checksum }},
+		Content:  {{ .Literal }},
+	},
+{{- end }}
+}
+`))
+
+// generatedMigration is a migration as rendered by generatedTemplate
+type generatedMigration struct {
+	Migration
+	Const   string
+	Literal string
+}
+
+// Generate writes a Go file declaring the migrations of the specified
+// directory as constants, with their checksum and content, in the given package
+func Generate(w io.Writer, directory, packageName string) error {
+	migrations, err := LoadMigrations(directory)
+	if err != nil {
+		return err
+	}
+
+	data := struct {
+		Package    string
+		Migrations []generatedMigration
+	}{Package: packageName}
+
+	for _, migration := range migrations {
+		data.Migrations = append(data.Migrations, generatedMigration{
+			Migration: migration,
+			Const:     constName(migration),
+			Literal:   stringLiteral(migration.Content),
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := generatedTemplate.Execute(&buf, data); err != nil {
+		return fmt.Errorf("failed to render migrations: %w", err)
+	}
+
+	source, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to format generated code: %w", err)
+	}
+
+	_, err = w.Write(source)
+	return err
+}
+
+// constName returns the exported constant name of a migration, the camel-cased
+// name followed by the timestamp: 2023_01_01_10_00_00_create_users becomes
+// CreateUsers20230101100000
+func constName(migration Migration) string {
+	var b strings.Builder
+	for _, word := range strings.Split(migration.Name, "_") {
+		if word == "" {
+			continue
+		}
+		runes := []rune(word)
+		runes[0] = unicode.ToUpper(runes[0])
+		b.WriteString(string(runes))
+	}
+
+	// Names starting with a digit cannot lead an identifier
+	name := b.String()
+	if name == "" || !unicode.IsLetter([]rune(name)[0]) {
+		name = "Migration" + name
+	}
+
+	return name + migration.CreatedAt.Format("20060102150405")
+}
+
+// stringLiteral returns a Go literal of the content, a raw string when possible
+// to keep the SQL readable
+func stringLiteral(content string) string {
+	if strings.Contains(content, "`") || strings.Contains(content, "\r") {
+		return strconv.Quote(content)
+	}
+
+	return "`" + content + "`"
+}
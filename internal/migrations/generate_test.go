@@ -0,0 +1,44 @@
+package migrations_test
+
+import (
+	"bytes"
+	"go/parser"
+	"go/token"
+	"os"
+	"testing"
+
+	"github.com/arthurdotwork/mig/internal/migrations"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerate(t *testing.T) {
+	t.Run("it should declare every migration as a constant", func(t *testing.T) {
+		tempDir := createTempDir(t)
+		defer os.RemoveAll(tempDir) //nolint:errcheck
+
+		createMigrationFile(t, tempDir, "2023_01_01_10_00_00_create_users.sql", "CREATE TABLE users (id INT);")
+		createMigrationFile(t, tempDir, "2023_01_02_10_00_00_quoted.sql", "SELECT `quoted`;")
+
+		var buf bytes.Buffer
+		err := migrations.Generate(&buf, tempDir, "schema")
+		require.NoError(t, err)
+
+		source := buf.String()
+		require.Contains(t, source, "// Code generated by mig gen; DO NOT EDIT.")
+		require.Contains(t, source, "package schema")
+		require.Contains(t, source, `CreateUsers20230101100000 MigrationID = "2023_01_01_10_00_00_create_users"`)
+		require.Contains(t, source, "Content:  `CREATE TABLE users (id INT);`")
+		require.Contains(t, source, `Content:  "SELECT `+"`quoted`"+`;"`)
+		require.Contains(t, source, `Checksum: "`)
+
+		_, err = parser.ParseFile(token.NewFileSet(), "migrations.go", source, 0)
+		require.NoError(t, err)
+	})
+
+	t.Run("it should return an error for a missing directory", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := migrations.Generate(&buf, "/non/existent/directory", "schema")
+		require.Error(t, err)
+		require.Empty(t, buf.String())
+	})
+}
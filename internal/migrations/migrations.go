@@ -1,39 +1,186 @@
 package migrations
 
 import (
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io/fs"
+	"iter"
 	"os"
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/arthurdotwork/mig/internal/config"
 	"github.com/arthurdotwork/mig/internal/database"
 )
 
 // Migration represents a single migration file
 type Migration struct {
-	ID        string    // Unique identifier (filename without extension)
-	Name      string    // Name part of the migration
-	Filename  string    // Full filename
-	Content   string    // SQL content
-	DisableTx bool      // Whether to disable transactions
-	CreatedAt time.Time // Creation time based on the filename
+	ID          string    // Unique identifier (filename without extension)
+	Name        string    // Name part of the migration
+	Filename    string    // Full filename
+	Content     string    // SQL content
+	Checksum    string    // SHA-256 of the content, hex encoded
+	DisableTx   bool      // Whether to disable transactions
+	EnableTx    bool      // Whether to use a transaction when they are disabled by default
+	Phase       string    // PhaseExpand or PhaseContract from "-- mig:phase=", empty when untagged
+	MinPG       int       // Minimum PostgreSQL major version from "-- mig:min-pg=", 0 when unset
+	Analyze     bool      // Whether to ANALYZE the tables it writes to once applied, from "-- mig:analyze"
+	After       []string  // Maintenance statements run once it is applied, from "-- mig:after "
+	Role        string    // Role the statements run as, from "-- mig:role=", empty for the connecting user
+	SearchPath  []string  // Schemas unqualified names resolve in, from "-- mig:search_path="
+	Description string    // What the migration does, from "-- mig:description " lines
+	CreatedAt   time.Time // Creation time based on the filename
 }
 
+// Phases of a zero-downtime deployment: expand migrations are compatible with
+// the application version still running and apply before it is replaced,
+// contract migrations remove what it used and apply once it is gone
+const (
+	PhaseExpand   = "expand"
+	PhaseContract = "contract"
+)
+
+// InPhase reports whether the migration belongs to phase, untagged
+// migrations belonging to the expand phase
+func (m Migration) InPhase(phase string) bool {
+	if m.Phase == "" {
+		return phase == PhaseExpand
+	}
+
+	return m.Phase == phase
+}
+
+// phaseDirective tags a migration with its phase: "-- mig:phase=contract"
+var phaseDirective = regexp.MustCompile(`--\s*mig:phase=(\S*)`)
+
+// afterDirective adds a maintenance statement run once the migration is
+// applied: "-- mig:after VACUUM ANALYZE orders"
+var afterDirective = regexp.MustCompile(`(?m)^--\s*mig:after\s+(.+?)\s*$`)
+
+// descriptionDirective describes a migration for reports, over several lines
+// if needed: "-- mig:description Split the name of the users"
+var descriptionDirective = regexp.MustCompile(`(?m)^--\s*mig:description\s+(.+?)\s*$`)
+
+// roleDirective runs a migration as another role: "-- mig:role=ddl_owner"
+var roleDirective = regexp.MustCompile(`--\s*mig:role=(\S+)`)
+
+// searchPathDirective sets the schemas unqualified names of a migration
+// resolve in: "-- mig:search_path=tenant_template,public"
+var searchPathDirective = regexp.MustCompile(`--\s*mig:search_path=(\S+)`)
+
+// minPGDirective declares the PostgreSQL version a migration needs: "-- mig:min-pg=14"
+var minPGDirective = regexp.MustCompile(`--\s*mig:min-pg=(\S*)`)
+
 // Migration filename pattern: YYYY_MM_DD_HH_MM_SS_name.sql
 var migrationPattern = regexp.MustCompile(`^(\d{4}_\d{2}_\d{2}_\d{2}_\d{2}_\d{2})_([a-zA-Z0-9_]+)\.sql$`)
 
 // LoadMigrations loads all migration files from the specified directory
 func LoadMigrations(directory string) ([]Migration, error) {
+	fsys, err := dirFS(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	return LoadMigrationsFS(fsys)
+}
+
+// Load loads the configured migrations, from the file system or the directory
+// merged with the extra directories
+func Load(cfg config.MigrationsConfig) ([]Migration, error) {
+	var migrations []Migration
+	var err error
+	if cfg.FS != nil {
+		migrations, err = LoadMigrationsFS(cfg.FS)
+	} else {
+		migrations, err = LoadMigrations(cfg.Directory)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cfg.ExtraDirectories) == 0 {
+		return migrations, nil
+	}
+
+	// Add the migrations of the extra directories, such as seeds of an environment
+	sets := [][]Migration{migrations}
+	for _, directory := range cfg.ExtraDirectories {
+		extra, err := LoadMigrations(directory)
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, extra)
+	}
+
+	return Merge(sets...)
+}
+
+// LoadMigrationsFS loads all migration files from the root of fsys, such as
+// an embed.FS sub-tree
+func LoadMigrationsFS(fsys fs.FS) ([]Migration, error) {
+	migrations, err := listMigrations(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range migrations {
+		if err := readMigration(fsys, &migrations[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return migrations, nil
+}
+
+// Iter yields the migrations of the specified directory in order, reading each
+// file only when it is reached, so that only one is held in memory at a time
+func Iter(directory string) iter.Seq2[Migration, error] {
+	return func(yield func(Migration, error) bool) {
+		fsys, err := dirFS(directory)
+		if err != nil {
+			yield(Migration{}, err)
+			return
+		}
+
+		migrations, err := listMigrations(fsys)
+		if err != nil {
+			yield(Migration{}, err)
+			return
+		}
+
+		for _, migration := range migrations {
+			if err := readMigration(fsys, &migration); err != nil {
+				yield(Migration{}, err)
+				return
+			}
+
+			if !yield(migration, nil) {
+				return
+			}
+		}
+	}
+}
+
+// dirFS returns the file system rooted at the specified directory
+func dirFS(directory string) (fs.FS, error) {
 	// Check if the directory exists
 	if _, err := os.Stat(directory); os.IsNotExist(err) {
 		return nil, fmt.Errorf("migrations directory does not exist: %s", directory)
 	}
 
+	return os.DirFS(directory), nil
+}
+
+// listMigrations returns the migration files at the root of fsys in order,
+// without reading their content
+func listMigrations(fsys fs.FS) ([]Migration, error) {
 	// List all .sql files in the directory
-	files, err := os.ReadDir(directory)
+	files, err := fs.ReadDir(fsys, ".")
 	if err != nil {
 		return nil, fmt.Errorf("failed to read migrations directory: %w", err)
 	}
@@ -63,64 +210,111 @@ func LoadMigrations(directory string) ([]Migration, error) {
 			return nil, fmt.Errorf("invalid date format in migration filename %s: %w", file.Name(), err)
 		}
 
-		// Read the file content
-		content, err := os.ReadFile(filepath.Join(directory, file.Name()))
-		if err != nil {
-			return nil, fmt.Errorf("failed to read migration file %s: %w", file.Name(), err)
-		}
-
-		// Check for metadata
-		disableTx := false
-		if strings.Contains(string(content), "-- disable-tx") {
-			disableTx = true
-		}
-
 		// Create the migration
 		migration := Migration{
 			ID:        fmt.Sprintf("%s_%s", dateStr, name),
 			Name:      name,
 			Filename:  file.Name(),
-			Content:   string(content),
-			DisableTx: disableTx,
 			CreatedAt: createdAt,
 		}
 
 		migrations = append(migrations, migration)
 	}
 
-	// Sort migrations by date (and then by name for same date)
+	sortMigrations(migrations)
+
+	return migrations, nil
+}
+
+// sortMigrations sorts migrations by date (and then by name for same date)
+func sortMigrations(migrations []Migration) {
 	sort.Slice(migrations, func(i, j int) bool {
 		if migrations[i].CreatedAt.Equal(migrations[j].CreatedAt) {
 			return migrations[i].ID < migrations[j].ID
 		}
 		return migrations[i].CreatedAt.Before(migrations[j].CreatedAt)
 	})
+}
 
-	return migrations, nil
+// Merge merges sets of migrations loaded from different directories into a
+// single ordered set, failing when two of them share an ID
+func Merge(sets ...[]Migration) ([]Migration, error) {
+	var merged []Migration
+	seen := make(map[string]bool)
+
+	for _, set := range sets {
+		for _, migration := range set {
+			if seen[migration.ID] {
+				return nil, fmt.Errorf("duplicate migration %s in several directories", migration.ID)
+			}
+			seen[migration.ID] = true
+
+			merged = append(merged, migration)
+		}
+	}
+
+	sortMigrations(merged)
+
+	return merged, nil
 }
 
-// CreateMigrationFile creates a new migration file
-func CreateMigrationFile(directory, name string) (string, error) {
-	// Ensure the directory exists
-	if err := os.MkdirAll(directory, 0755); err != nil {
-		return "", fmt.Errorf("failed to create migrations directory: %w", err)
+// readMigration reads the content of a listed migration and the metadata it holds
+func readMigration(fsys fs.FS, migration *Migration) error {
+	content, err := fs.ReadFile(fsys, migration.Filename)
+	if err != nil {
+		return fmt.Errorf("failed to read migration file %s: %w", migration.Filename, err)
 	}
 
-	// Format the current date with time
-	dateStr := time.Now().Format("2006_01_02_15_04_05")
+	migration.Content = string(content)
+	migration.Checksum = fmt.Sprintf("%x", sha256.Sum256(content))
 
-	// Sanitize the name (replace spaces with underscores, remove special characters)
-	sanitizedName := regexp.MustCompile(`[^a-zA-Z0-9_]`).ReplaceAllString(strings.ReplaceAll(name, " ", "_"), "")
+	// Check for metadata
+	migration.DisableTx = strings.Contains(migration.Content, "-- disable-tx")
+	migration.EnableTx = strings.Contains(migration.Content, "-- mig:tx")
 
-	// Generate the filename
-	filename := fmt.Sprintf("%s_%s.sql", dateStr, sanitizedName)
-	filepath := filepath.Join(directory, filename)
+	if match := phaseDirective.FindStringSubmatch(migration.Content); match != nil {
+		if match[1] != PhaseExpand && match[1] != PhaseContract {
+			return fmt.Errorf("invalid phase %q in migration file %s, expected expand or contract", match[1], migration.Filename)
+		}
+		migration.Phase = match[1]
+	}
 
-	// Check if the file already exists
-	if _, err := os.Stat(filepath); err == nil {
-		return "", fmt.Errorf("migration file already exists: %s", filename)
+	migration.Analyze = strings.Contains(migration.Content, "-- mig:analyze")
+	for _, match := range afterDirective.FindAllStringSubmatch(migration.Content, -1) {
+		migration.After = append(migration.After, match[1])
+	}
+
+	var description []string
+	for _, match := range descriptionDirective.FindAllStringSubmatch(migration.Content, -1) {
+		description = append(description, match[1])
+	}
+	migration.Description = strings.Join(description, " ")
+
+	if match := roleDirective.FindStringSubmatch(migration.Content); match != nil {
+		migration.Role = match[1]
 	}
 
+	if match := searchPathDirective.FindStringSubmatch(migration.Content); match != nil {
+		for _, schema := range strings.Split(match[1], ",") {
+			if schema = strings.TrimSpace(schema); schema != "" {
+				migration.SearchPath = append(migration.SearchPath, schema)
+			}
+		}
+	}
+
+	if match := minPGDirective.FindStringSubmatch(migration.Content); match != nil {
+		version, err := strconv.Atoi(match[1])
+		if err != nil || version <= 0 {
+			return fmt.Errorf("invalid min-pg %q in migration file %s, expected a major version such as 14", match[1], migration.Filename)
+		}
+		migration.MinPG = version
+	}
+
+	return nil
+}
+
+// CreateMigrationFile creates a new migration file timestamped with now
+func CreateMigrationFile(directory, name string, now time.Time) (string, error) {
 	// Create the file with a template
 	template := fmt.Sprintf(`-- Migration: %s
 -- Created at: %s
@@ -129,15 +323,137 @@ func CreateMigrationFile(directory, name string) (string, error) {
 -- Add "-- disable-tx" anywhere in this file to disable transaction wrapping.
 
 -- Your SQL goes here
-`, sanitizedName, time.Now().Format("2006-01-02 15:04:05"))
+`, sanitizeName(name), now.Format("2006-01-02 15:04:05"))
+
+	return WriteMigrationFile(directory, name, template, now)
+}
+
+// WriteMigrationFile creates a new migration file of content, such as SQL
+// generated by another tool, timestamped with now
+func WriteMigrationFile(directory, name, content string, now time.Time) (string, error) {
+	// Ensure the directory exists
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return "", fmt.Errorf("failed to create migrations directory: %w", err)
+	}
+
+	// Generate the filename
+	filename := fmt.Sprintf("%s_%s.sql", now.Format("2006_01_02_15_04_05"), sanitizeName(name))
+	filepath := filepath.Join(directory, filename)
 
-	if err := os.WriteFile(filepath, []byte(template), 0644); err != nil {
+	// Check if the file already exists
+	if _, err := os.Stat(filepath); err == nil {
+		return "", fmt.Errorf("migration file already exists: %s", filename)
+	}
+
+	if err := os.WriteFile(filepath, []byte(content), 0644); err != nil {
 		return "", fmt.Errorf("failed to write migration file: %w", err)
 	}
 
 	return filename, nil
 }
 
+// sanitizeName replaces the spaces of a migration name with underscores and
+// removes the special characters
+func sanitizeName(name string) string {
+	return regexp.MustCompile(`[^a-zA-Z0-9_]`).ReplaceAllString(strings.ReplaceAll(name, " ", "_"), "")
+}
+
+// RenameMigrationFile moves a migration file, found in one of the
+// directories, to the timestamp of at while keeping its name, and returns the
+// new filename
+func RenameMigrationFile(directories []string, migration Migration, at time.Time) (string, error) {
+	filename := fmt.Sprintf("%s_%s.sql", at.Format("2006_01_02_15_04_05"), migration.Name)
+
+	for _, directory := range directories {
+		from := filepath.Join(directory, migration.Filename)
+		if _, err := os.Stat(from); err != nil {
+			continue
+		}
+
+		to := filepath.Join(directory, filename)
+		if _, err := os.Stat(to); err == nil {
+			return "", fmt.Errorf("migration file already exists: %s", filename)
+		}
+
+		if err := os.Rename(from, to); err != nil {
+			return "", fmt.Errorf("failed to rename migration file: %w", err)
+		}
+
+		return filename, nil
+	}
+
+	return "", fmt.Errorf("migration file not found: %s", migration.Filename)
+}
+
+// ArchiveDir is the subdirectory of a migrations directory holding the
+// retired migrations, which are not loaded anymore
+const ArchiveDir = "archive"
+
+// LoadArchived returns the IDs of the migrations archived in the configured
+// directories
+func LoadArchived(cfg config.MigrationsConfig) (map[string]bool, error) {
+	var roots []fs.FS
+	if cfg.FS != nil {
+		roots = append(roots, cfg.FS)
+	} else {
+		roots = append(roots, os.DirFS(cfg.Directory))
+	}
+	for _, directory := range cfg.ExtraDirectories {
+		roots = append(roots, os.DirFS(directory))
+	}
+
+	archived := make(map[string]bool)
+	for _, root := range roots {
+		fsys, err := fs.Sub(root, ArchiveDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open archive directory: %w", err)
+		}
+
+		migrations, err := listMigrations(fsys)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, migration := range migrations {
+			archived[migration.ID] = true
+		}
+	}
+
+	return archived, nil
+}
+
+// ArchiveMigrationFile moves a migration file, found in one of the
+// directories, to the archive subdirectory of that directory
+func ArchiveMigrationFile(directories []string, migration Migration) error {
+	for _, directory := range directories {
+		from := filepath.Join(directory, migration.Filename)
+		if _, err := os.Stat(from); err != nil {
+			continue
+		}
+
+		archive := filepath.Join(directory, ArchiveDir)
+		if err := os.MkdirAll(archive, 0o755); err != nil {
+			return fmt.Errorf("failed to create archive directory: %w", err)
+		}
+
+		to := filepath.Join(archive, migration.Filename)
+		if _, err := os.Stat(to); err == nil {
+			return fmt.Errorf("archived migration file already exists: %s", migration.Filename)
+		}
+
+		if err := os.Rename(from, to); err != nil {
+			return fmt.Errorf("failed to archive migration file: %w", err)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("migration file not found: %s", migration.Filename)
+}
+
 // GetPendingMigrations returns migrations that have not been applied yet
 func GetPendingMigrations(allMigrations []Migration, appliedMigrations []database.MigrationVersion) []Migration {
 	// Create a map of applied migrations for quick lookup
@@ -4,8 +4,10 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"testing/fstest"
 	"time"
 
+	"github.com/arthurdotwork/mig/internal/config"
 	"github.com/arthurdotwork/mig/internal/database"
 	"github.com/arthurdotwork/mig/internal/migrations"
 	"github.com/stretchr/testify/require"
@@ -130,11 +132,137 @@ func TestLoadMigrations(t *testing.T) {
 		require.Equal(t, "first", migs[0].Name)
 		require.Equal(t, "2023_01_01_10_00_00_first.sql", migs[0].Filename)
 		require.Equal(t, "SELECT 1;", migs[0].Content)
+		require.Equal(t, "17db4fd369edb9244b9f91d9aeed145c3d04ad8ba6e95d06247f07a63527d11a", migs[0].Checksum)
 		require.False(t, migs[0].DisableTx)
+		require.False(t, migs[0].EnableTx)
 
 		require.True(t, migs[3].DisableTx)
 	})
 
+	t.Run("it should detect the transaction opt-in directive", func(t *testing.T) {
+		tempDir := createTempDir(t)
+		defer os.RemoveAll(tempDir) //nolint:errcheck
+
+		createMigrationFile(t, tempDir, "2023_01_01_10_00_00_first.sql", "-- mig:tx\nSELECT 1;")
+
+		migs, err := migrations.LoadMigrations(tempDir)
+		require.NoError(t, err)
+		require.Len(t, migs, 1)
+
+		require.True(t, migs[0].EnableTx)
+		require.False(t, migs[0].DisableTx)
+	})
+
+	t.Run("it should detect the phase directive", func(t *testing.T) {
+		tempDir := createTempDir(t)
+		defer os.RemoveAll(tempDir) //nolint:errcheck
+
+		createMigrationFile(t, tempDir, "2023_01_01_10_00_00_first.sql", "-- mig:phase=expand\nALTER TABLE users ADD COLUMN email text;")
+		createMigrationFile(t, tempDir, "2023_01_02_10_00_00_second.sql", "-- mig:phase=contract\nALTER TABLE users DROP COLUMN mail;")
+		createMigrationFile(t, tempDir, "2023_01_03_10_00_00_third.sql", "SELECT 3;")
+
+		migs, err := migrations.LoadMigrations(tempDir)
+		require.NoError(t, err)
+		require.Len(t, migs, 3)
+
+		require.Equal(t, migrations.PhaseExpand, migs[0].Phase)
+		require.Equal(t, migrations.PhaseContract, migs[1].Phase)
+		require.Empty(t, migs[2].Phase)
+
+		require.True(t, migs[0].InPhase(migrations.PhaseExpand))
+		require.False(t, migs[1].InPhase(migrations.PhaseExpand))
+		require.True(t, migs[2].InPhase(migrations.PhaseExpand))
+		require.False(t, migs[2].InPhase(migrations.PhaseContract))
+	})
+
+	t.Run("it should return an error for an unknown phase", func(t *testing.T) {
+		tempDir := createTempDir(t)
+		defer os.RemoveAll(tempDir) //nolint:errcheck
+
+		createMigrationFile(t, tempDir, "2023_01_01_10_00_00_first.sql", "-- mig:phase=cleanup\nSELECT 1;")
+
+		_, err := migrations.LoadMigrations(tempDir)
+		require.ErrorContains(t, err, `invalid phase "cleanup" in migration file 2023_01_01_10_00_00_first.sql`)
+	})
+
+	t.Run("it should detect the minimum server version directive", func(t *testing.T) {
+		tempDir := createTempDir(t)
+		defer os.RemoveAll(tempDir) //nolint:errcheck
+
+		createMigrationFile(t, tempDir, "2023_01_01_10_00_00_first.sql", "-- mig:min-pg=15\nMERGE INTO a USING b ON a.id = b.id WHEN MATCHED THEN DELETE;")
+		createMigrationFile(t, tempDir, "2023_01_02_10_00_00_second.sql", "SELECT 2;")
+
+		migs, err := migrations.LoadMigrations(tempDir)
+		require.NoError(t, err)
+		require.Len(t, migs, 2)
+
+		require.Equal(t, 15, migs[0].MinPG)
+		require.Zero(t, migs[1].MinPG)
+	})
+
+	t.Run("it should return an error for an invalid minimum server version", func(t *testing.T) {
+		tempDir := createTempDir(t)
+		defer os.RemoveAll(tempDir) //nolint:errcheck
+
+		createMigrationFile(t, tempDir, "2023_01_01_10_00_00_first.sql", "-- mig:min-pg=fifteen\nSELECT 1;")
+
+		_, err := migrations.LoadMigrations(tempDir)
+		require.ErrorContains(t, err, `invalid min-pg "fifteen" in migration file 2023_01_01_10_00_00_first.sql`)
+	})
+
+	t.Run("it should detect the maintenance directives", func(t *testing.T) {
+		tempDir := createTempDir(t)
+		defer os.RemoveAll(tempDir) //nolint:errcheck
+
+		createMigrationFile(t, tempDir, "2023_01_01_10_00_00_first.sql", "-- mig:analyze\n-- mig:after VACUUM orders\n-- mig:after REINDEX TABLE orders  \nUPDATE orders SET total = 0 WHERE total IS NULL;")
+		createMigrationFile(t, tempDir, "2023_01_02_10_00_00_second.sql", "SELECT 2;")
+
+		migs, err := migrations.LoadMigrations(tempDir)
+		require.NoError(t, err)
+		require.Len(t, migs, 2)
+
+		require.True(t, migs[0].Analyze)
+		require.Equal(t, []string{"VACUUM orders", "REINDEX TABLE orders"}, migs[0].After)
+		require.False(t, migs[1].Analyze)
+		require.Empty(t, migs[1].After)
+	})
+
+	t.Run("it should detect the role directive", func(t *testing.T) {
+		tempDir := createTempDir(t)
+		defer os.RemoveAll(tempDir) //nolint:errcheck
+
+		createMigrationFile(t, tempDir, "2023_01_01_10_00_00_first.sql", "-- mig:role=ddl_owner\nCREATE TABLE a (id int);")
+
+		migs, err := migrations.LoadMigrations(tempDir)
+		require.NoError(t, err)
+		require.Len(t, migs, 1)
+		require.Equal(t, "ddl_owner", migs[0].Role)
+	})
+
+	t.Run("it should detect the search path directive", func(t *testing.T) {
+		tempDir := createTempDir(t)
+		defer os.RemoveAll(tempDir) //nolint:errcheck
+
+		createMigrationFile(t, tempDir, "2023_01_01_10_00_00_first.sql", "-- mig:search_path=tenant_template,public\nCREATE TABLE a (id int);")
+
+		migs, err := migrations.LoadMigrations(tempDir)
+		require.NoError(t, err)
+		require.Len(t, migs, 1)
+		require.Equal(t, []string{"tenant_template", "public"}, migs[0].SearchPath)
+	})
+
+	t.Run("it should detect the description directive", func(t *testing.T) {
+		tempDir := createTempDir(t)
+		defer os.RemoveAll(tempDir) //nolint:errcheck
+
+		createMigrationFile(t, tempDir, "2023_01_01_10_00_00_first.sql", "-- mig:description Split the name of the users\n-- mig:description into first and last names \nALTER TABLE users ADD COLUMN first_name text;")
+
+		migs, err := migrations.LoadMigrations(tempDir)
+		require.NoError(t, err)
+		require.Len(t, migs, 1)
+		require.Equal(t, "Split the name of the users into first and last names", migs[0].Description)
+	})
+
 	t.Run("it should handle migrations with same timestamp", func(t *testing.T) {
 		tempDir := createTempDir(t)
 		defer os.RemoveAll(tempDir) //nolint:errcheck
@@ -151,6 +279,105 @@ func TestLoadMigrations(t *testing.T) {
 	})
 }
 
+func TestLoadMigrationsFS(t *testing.T) {
+	t.Run("it should load migrations from the root of the file system", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"2023_01_02_10_00_00_second.sql": {Data: []byte("SELECT 2;")},
+			"2023_01_01_10_00_00_first.sql":  {Data: []byte("SELECT 1;")},
+			"README.md":                      {Data: []byte("# Migrations")},
+		}
+
+		migrationList, err := migrations.LoadMigrationsFS(fsys)
+		require.NoError(t, err)
+		require.Len(t, migrationList, 2)
+		require.Equal(t, "2023_01_01_10_00_00_first", migrationList[0].ID)
+		require.Equal(t, "SELECT 1;", migrationList[0].Content)
+		require.Equal(t, "2023_01_02_10_00_00_second", migrationList[1].ID)
+	})
+}
+
+func TestMerge(t *testing.T) {
+	common := createTempDir(t)
+	defer os.RemoveAll(common) //nolint:errcheck
+
+	seeds := createTempDir(t)
+	defer os.RemoveAll(seeds) //nolint:errcheck
+
+	createMigrationFile(t, common, "2023_01_01_10_00_00_first.sql", "SELECT 1;")
+	createMigrationFile(t, common, "2023_01_03_10_00_00_third.sql", "SELECT 3;")
+	createMigrationFile(t, seeds, "2023_01_02_10_00_00_seed.sql", "SELECT 2;")
+
+	commonMigs, err := migrations.LoadMigrations(common)
+	require.NoError(t, err)
+
+	seedMigs, err := migrations.LoadMigrations(seeds)
+	require.NoError(t, err)
+
+	t.Run("it should interleave the migrations in order", func(t *testing.T) {
+		merged, err := migrations.Merge(commonMigs, seedMigs)
+		require.NoError(t, err)
+		require.Len(t, merged, 3)
+
+		require.Equal(t, "2023_01_01_10_00_00_first", merged[0].ID)
+		require.Equal(t, "2023_01_02_10_00_00_seed", merged[1].ID)
+		require.Equal(t, "2023_01_03_10_00_00_third", merged[2].ID)
+	})
+
+	t.Run("it should reject a migration present in several directories", func(t *testing.T) {
+		_, err := migrations.Merge(commonMigs, commonMigs)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "duplicate migration 2023_01_01_10_00_00_first")
+	})
+}
+
+func TestIter(t *testing.T) {
+	t.Run("it should yield the migrations in order", func(t *testing.T) {
+		tempDir := createTempDir(t)
+		defer os.RemoveAll(tempDir) //nolint:errcheck
+
+		createMigrationFile(t, tempDir, "2023_01_02_10_00_00_second.sql", "SELECT 2;")
+		createMigrationFile(t, tempDir, "2023_01_01_10_00_00_first.sql", "SELECT 1;")
+
+		var ids []string
+		for migration, err := range migrations.Iter(tempDir) {
+			require.NoError(t, err)
+			require.NotEmpty(t, migration.Content)
+			ids = append(ids, migration.ID)
+		}
+		require.Equal(t, []string{"2023_01_01_10_00_00_first", "2023_01_02_10_00_00_second"}, ids)
+	})
+
+	t.Run("it should read each file only when it is reached", func(t *testing.T) {
+		tempDir := createTempDir(t)
+		defer os.RemoveAll(tempDir) //nolint:errcheck
+
+		createMigrationFile(t, tempDir, "2023_01_01_10_00_00_first.sql", "SELECT 1;")
+		createMigrationFile(t, tempDir, "2023_01_02_10_00_00_second.sql", "SELECT 2;")
+
+		var errs []error
+		for migration, err := range migrations.Iter(tempDir) {
+			errs = append(errs, err)
+			if err == nil {
+				require.Equal(t, "first", migration.Name)
+
+				// The second file is listed but not read yet
+				err = os.Remove(filepath.Join(tempDir, "2023_01_02_10_00_00_second.sql"))
+				require.NoError(t, err)
+			}
+		}
+
+		require.Len(t, errs, 2)
+		require.NoError(t, errs[0])
+		require.ErrorContains(t, errs[1], "failed to read migration file")
+	})
+
+	t.Run("it should yield an error for a missing directory", func(t *testing.T) {
+		for _, err := range migrations.Iter("/non/existent/directory") {
+			require.Error(t, err)
+		}
+	})
+}
+
 func TestCreateMigrationFile(t *testing.T) {
 	t.Parallel()
 
@@ -163,7 +390,7 @@ func TestCreateMigrationFile(t *testing.T) {
 		_, err := os.Stat(migDir)
 		require.True(t, os.IsNotExist(err))
 
-		filename, err := migrations.CreateMigrationFile(migDir, "test_migration")
+		filename, err := migrations.CreateMigrationFile(migDir, "test_migration", time.Now())
 		require.NoError(t, err)
 		require.NotEmpty(t, filename)
 
@@ -178,7 +405,7 @@ func TestCreateMigrationFile(t *testing.T) {
 		now := time.Now()
 		datePrefix := now.Format("2006_01_02")
 
-		filename, err := migrations.CreateMigrationFile(tempDir, "test_migration")
+		filename, err := migrations.CreateMigrationFile(tempDir, "test_migration", time.Now())
 		require.NoError(t, err)
 		require.Contains(t, filename, datePrefix, "Filename should contain current date")
 		require.Contains(t, filename, "test_migration.sql", "Filename should contain migration name")
@@ -191,11 +418,25 @@ func TestCreateMigrationFile(t *testing.T) {
 		require.Contains(t, string(content), "-- Your SQL goes here")
 	})
 
+	t.Run("it should timestamp the file with the given time", func(t *testing.T) {
+		tempDir := createTempDir(t)
+		defer os.RemoveAll(tempDir) //nolint:errcheck
+
+		now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		filename, err := migrations.CreateMigrationFile(tempDir, "fixed", now)
+		require.NoError(t, err)
+		require.Equal(t, "2024_01_02_03_04_05_fixed.sql", filename)
+
+		content, err := os.ReadFile(filepath.Join(tempDir, filename))
+		require.NoError(t, err)
+		require.Contains(t, string(content), "-- Created at: 2024-01-02 03:04:05")
+	})
+
 	t.Run("it should sanitize migration name", func(t *testing.T) {
 		tempDir := createTempDir(t)
 		defer os.RemoveAll(tempDir) //nolint:errcheck
 
-		filename, err := migrations.CreateMigrationFile(tempDir, "test migration with spaces & special @# chars")
+		filename, err := migrations.CreateMigrationFile(tempDir, "test migration with spaces & special @# chars", time.Now())
 		require.NoError(t, err)
 
 		require.Contains(t, filename, "test_migration_with_spaces__special__chars.sql")
@@ -207,15 +448,78 @@ func TestCreateMigrationFile(t *testing.T) {
 		tempDir := createTempDir(t)
 		defer os.RemoveAll(tempDir) //nolint:errcheck
 
-		_, err := migrations.CreateMigrationFile(tempDir, "test")
+		_, err := migrations.CreateMigrationFile(tempDir, "test", time.Now())
 		require.NoError(t, err)
 
-		_, err = migrations.CreateMigrationFile(tempDir, "test")
+		_, err = migrations.CreateMigrationFile(tempDir, "test", time.Now())
 		require.Error(t, err)
 		require.Contains(t, err.Error(), "migration file already exists")
 	})
 }
 
+func TestWriteMigrationFile(t *testing.T) {
+	t.Parallel()
+
+	t.Run("it should write the content to a timestamped file", func(t *testing.T) {
+		tempDir := createTempDir(t)
+		defer os.RemoveAll(tempDir) //nolint:errcheck
+
+		now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		filename, err := migrations.WriteMigrationFile(tempDir, "generated change", "CREATE TABLE orders (id INT);\n", now)
+		require.NoError(t, err)
+		require.Equal(t, "2024_01_02_03_04_05_generated_change.sql", filename)
+
+		content, err := os.ReadFile(filepath.Join(tempDir, filename))
+		require.NoError(t, err)
+		require.Equal(t, "CREATE TABLE orders (id INT);\n", string(content))
+
+		_, err = migrations.WriteMigrationFile(tempDir, "generated change", "SELECT 1;", now)
+		require.ErrorContains(t, err, "migration file already exists")
+	})
+}
+
+func TestArchiveMigrationFile(t *testing.T) {
+	t.Run("it should move the file out of the loaded migrations", func(t *testing.T) {
+		tempDir := createTempDir(t)
+		defer os.RemoveAll(tempDir) //nolint:errcheck
+
+		createMigrationFile(t, tempDir, "2023_01_01_10_00_00_first.sql", "SELECT 1;")
+		createMigrationFile(t, tempDir, "2023_01_02_10_00_00_second.sql", "SELECT 2;")
+
+		loaded, err := migrations.LoadMigrations(tempDir)
+		require.NoError(t, err)
+
+		err = migrations.ArchiveMigrationFile([]string{tempDir}, loaded[0])
+		require.NoError(t, err)
+
+		loaded, err = migrations.LoadMigrations(tempDir)
+		require.NoError(t, err)
+		require.Len(t, loaded, 1)
+		require.Equal(t, "2023_01_02_10_00_00_second", loaded[0].ID)
+
+		archived, err := migrations.LoadArchived(config.MigrationsConfig{Directory: tempDir})
+		require.NoError(t, err)
+		require.Equal(t, map[string]bool{"2023_01_01_10_00_00_first": true}, archived)
+	})
+
+	t.Run("it should list no archived migrations without an archive directory", func(t *testing.T) {
+		tempDir := createTempDir(t)
+		defer os.RemoveAll(tempDir) //nolint:errcheck
+
+		archived, err := migrations.LoadArchived(config.MigrationsConfig{Directory: tempDir})
+		require.NoError(t, err)
+		require.Empty(t, archived)
+	})
+
+	t.Run("it should fail for a file in none of the directories", func(t *testing.T) {
+		tempDir := createTempDir(t)
+		defer os.RemoveAll(tempDir) //nolint:errcheck
+
+		err := migrations.ArchiveMigrationFile([]string{tempDir}, migrations.Migration{Filename: "2023_01_01_10_00_00_first.sql"})
+		require.ErrorContains(t, err, "migration file not found")
+	})
+}
+
 func TestGetPendingMigrations(t *testing.T) {
 	t.Parallel()
 
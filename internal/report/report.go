@@ -0,0 +1,104 @@
+package report
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Formats of a report
+const (
+	Markdown = "markdown"
+	HTML     = "html"
+)
+
+// Entry is an applied migration of a report
+type Entry struct {
+	Version     string        // Migration ID
+	Name        string        // Name part of the migration, empty when its file is missing
+	Description string        // From "-- mig:description", empty when missing
+	AppliedAt   time.Time     // When the migration was applied
+	Duration    time.Duration // How long it took, zero when unknown
+	AppliedBy   string        // Database user that applied it, empty when unknown
+}
+
+// Report is the changelog of the migrations applied to a database
+type Report struct {
+	Title       string
+	GeneratedAt time.Time
+	Entries     []Entry
+}
+
+// funcs format the cells of the templates
+var funcs = map[string]any{
+	"timestamp": func(t time.Time) string {
+		return t.Format("2006-01-02 15:04:05 MST")
+	},
+	"duration": func(d time.Duration) string {
+		if d == 0 {
+			return "-"
+		}
+		return d.String()
+	},
+	"orDash": func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	},
+	// cell escapes the characters ending a Markdown table cell
+	"cell": func(s string) string {
+		return strings.NewReplacer("|", `\|`, "\r", "", "\n", " ").Replace(s)
+	},
+}
+
+var markdownTemplate = template.Must(template.New("markdown").Funcs(funcs).Parse(`# {{cell .Title}}
+
+Generated on {{timestamp .GeneratedAt}}, {{len .Entries}} applied migration(s).
+
+| Version | Name | Description | Applied at | Duration | Applied by |
+| --- | --- | --- | --- | --- | --- |
+{{range .Entries}}| {{cell .Version}} | {{cell (orDash .Name)}} | {{cell (orDash .Description)}} | {{timestamp .AppliedAt}} | {{duration .Duration}} | {{cell (orDash .AppliedBy)}} |
+{{end}}`))
+
+var htmlTemplate = htmltemplate.Must(htmltemplate.New("html").Funcs(funcs).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; vertical-align: top; }
+th { background: #f3f3f3; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>Generated on {{timestamp .GeneratedAt}}, {{len .Entries}} applied migration(s).</p>
+<table>
+<thead>
+<tr><th>Version</th><th>Name</th><th>Description</th><th>Applied at</th><th>Duration</th><th>Applied by</th></tr>
+</thead>
+<tbody>
+{{range .Entries}}<tr><td>{{.Version}}</td><td>{{orDash .Name}}</td><td>{{orDash .Description}}</td><td>{{timestamp .AppliedAt}}</td><td>{{duration .Duration}}</td><td>{{orDash .AppliedBy}}</td></tr>
+{{end}}</tbody>
+</table>
+</body>
+</html>
+`))
+
+// Write renders the report in format, Markdown or HTML
+func Write(w io.Writer, format string, r Report) error {
+	switch format {
+	case Markdown:
+		return markdownTemplate.Execute(w, r)
+	case HTML:
+		return htmlTemplate.Execute(w, r)
+	default:
+		return fmt.Errorf("invalid report format %q, expected %s or %s", format, Markdown, HTML)
+	}
+}
@@ -0,0 +1,53 @@
+package report_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arthurdotwork/mig/internal/report"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrite(t *testing.T) {
+	r := report.Report{
+		Title:       "Migration report",
+		GeneratedAt: time.Date(2024, 3, 2, 9, 0, 0, 0, time.UTC),
+		Entries: []report.Entry{
+			{
+				Version:     "2024_03_01_10_00_00_add_orders",
+				Name:        "add_orders",
+				Description: "Orders | invoices <draft>",
+				AppliedAt:   time.Date(2024, 3, 1, 10, 0, 1, 0, time.UTC),
+				Duration:    1500 * time.Millisecond,
+				AppliedBy:   "deploy",
+			},
+			{
+				Version:   "2024_03_01_11_00_00_legacy",
+				AppliedAt: time.Date(2024, 3, 1, 11, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+
+	t.Run("it should render a Markdown table", func(t *testing.T) {
+		var b strings.Builder
+		require.NoError(t, report.Write(&b, report.Markdown, r))
+
+		require.Contains(t, b.String(), "# Migration report\n\nGenerated on 2024-03-02 09:00:00 UTC, 2 applied migration(s).")
+		require.Contains(t, b.String(), "| 2024_03_01_10_00_00_add_orders | add_orders | Orders \\| invoices <draft> | 2024-03-01 10:00:01 UTC | 1.5s | deploy |\n")
+		require.Contains(t, b.String(), "| 2024_03_01_11_00_00_legacy | - | - | 2024-03-01 11:00:00 UTC | - | - |\n")
+	})
+
+	t.Run("it should render an escaped HTML table", func(t *testing.T) {
+		var b strings.Builder
+		require.NoError(t, report.Write(&b, report.HTML, r))
+
+		require.Contains(t, b.String(), "<title>Migration report</title>")
+		require.Contains(t, b.String(), "<td>Orders | invoices &lt;draft&gt;</td>")
+		require.Contains(t, b.String(), "<td>1.5s</td><td>deploy</td>")
+	})
+
+	t.Run("it should reject an unknown format", func(t *testing.T) {
+		require.ErrorContains(t, report.Write(&strings.Builder{}, "pdf", r), `invalid report format "pdf"`)
+	})
+}
@@ -0,0 +1,129 @@
+package rpc
+
+//go:generate protoc -I ../../proto --go_out=../.. --go_opt=module=github.com/arthurdotwork/mig --go-grpc_out=../.. --go-grpc_opt=module=github.com/arthurdotwork/mig mig/v1/migrator.proto
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/arthurdotwork/mig/internal/executor"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// errUnauthenticated is the status of the calls with a missing or invalid
+// token
+var errUnauthenticated = status.Error(codes.Unauthenticated, "missing or invalid bearer token")
+
+// NewServer creates a gRPC server accepting the calls carrying the token as
+// an "authorization: Bearer" metadata
+func NewServer(token string) *grpc.Server {
+	return grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			if !authorized(ctx, token) {
+				return nil, errUnauthenticated
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv any, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if !authorized(stream.Context(), token) {
+				return errUnauthenticated
+			}
+			return handler(srv, stream)
+		}),
+	)
+}
+
+// authorized reports whether the metadata of a call carry the token
+func authorized(ctx context.Context, token string) bool {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		bearer, ok := strings.CutPrefix(value, "Bearer ")
+		if ok && subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) == 1 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Status returns the status of an error of the library, nil for a nil error
+func Status(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	return status.Error(codeOf(err), err.Error())
+}
+
+// codeOf maps an error of the library to a status code
+func codeOf(err error) codes.Code {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return codes.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return codes.DeadlineExceeded
+	case errors.Is(err, executor.ErrMigrationNotFound):
+		return codes.NotFound
+	case errors.Is(err, executor.ErrAlreadyRunning):
+		return codes.Aborted
+	case errors.Is(err, executor.ErrDirtyState):
+		return codes.FailedPrecondition
+	case errors.Is(err, executor.ErrOutOfOrder):
+		return codes.InvalidArgument
+	default:
+		return codes.Unknown
+	}
+}
+
+// Dial creates a connection to the service at a base URL such as
+// http://mig.internal:8080, over unencrypted HTTP/2 for http URLs and TLS for
+// https ones, sending the token with every call
+func Dial(target, token string) (*grpc.ClientConn, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target %q: %w", target, err)
+	}
+
+	var creds credentials.TransportCredentials
+	switch u.Scheme {
+	case "http":
+		creds = insecure.NewCredentials()
+	case "https":
+		creds = credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	default:
+		return nil, fmt.Errorf("invalid target %q: expected an http or https URL", target)
+	}
+
+	return grpc.NewClient(u.Host,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithPerRPCCredentials(bearer(token)),
+	)
+}
+
+// bearer sends a token as an "authorization: Bearer" metadata
+type bearer string
+
+// GetRequestMetadata returns the metadata of the token
+func (b bearer) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(b)}, nil
+}
+
+// RequireTransportSecurity allows the token over unencrypted HTTP/2, the way
+// mig serve is reached inside a cluster
+func (b bearer) RequireTransportSecurity() bool {
+	return false
+}
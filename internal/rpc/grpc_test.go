@@ -0,0 +1,203 @@
+package rpc_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/arthurdotwork/mig/internal/executor"
+	"github.com/arthurdotwork/mig/internal/rpc"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// migrator is a fake of the service, applying two migrations before failing
+// as if another run was in progress
+type migrator struct {
+	rpc.UnimplementedMigratorServer
+}
+
+func (migrator) Plan(context.Context, *rpc.PlanRequest) (*rpc.PlanResponse, error) {
+	return rpc.NewPlanResponse([]executor.PlannedMigration{{ID: "2024_03_01_10_00_00_add_orders"}}), nil
+}
+
+func (migrator) Verify(context.Context, *rpc.VerifyRequest) (*rpc.VerifyResponse, error) {
+	return nil, rpc.Status(fmt.Errorf("%w: 2024_03_01_10_00_00_add_orders", executor.ErrChecksumMismatch))
+}
+
+func (migrator) Apply(_ *rpc.ApplyRequest, stream grpc.ServerStreamingServer[rpc.ApplyEvent]) error {
+	for _, id := range []string{"2024_03_01_10_00_00_add_orders", "2024_03_02_10_00_00_index_orders"} {
+		if err := stream.Send(rpc.NewApplyEvent(executor.Event{Type: executor.EventMigrationApplied, Migration: id})); err != nil {
+			return err
+		}
+	}
+	return rpc.Status(executor.ErrAlreadyRunning)
+}
+
+// serve serves the fake service and returns its URL
+func serve(t *testing.T, token string) string {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := rpc.NewServer(token)
+	rpc.RegisterMigratorServer(server, migrator{})
+	go server.Serve(lis) //nolint:errcheck
+	t.Cleanup(server.Stop)
+
+	return "http://" + lis.Addr().String()
+}
+
+// dial connects a client of the service
+func dial(t *testing.T, target, token string) rpc.MigratorClient {
+	t.Helper()
+
+	conn, err := rpc.Dial(target, token)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() }) //nolint:errcheck
+
+	return rpc.NewMigratorClient(conn)
+}
+
+func TestMessages(t *testing.T) {
+	t.Run("it should round-trip the plan", func(t *testing.T) {
+		plan := []executor.PlannedMigration{
+			{ID: "2024_03_01_10_00_00_add_orders", Name: "add_orders", Filename: "2024_03_01_10_00_00_add_orders.sql", Checksum: "abc", Transactional: true},
+			{ID: "2024_03_02_10_00_00_index_orders", Phase: "expand"},
+		}
+
+		require.Equal(t, plan, rpc.PlanOf(rpc.NewPlanResponse(plan)))
+	})
+
+	t.Run("it should round-trip the status with its times", func(t *testing.T) {
+		statuses := []executor.MigrationStatus{
+			{
+				ID:          "2024_03_01_10_00_00_add_orders",
+				Applied:     true,
+				AppliedAt:   time.Date(2024, 3, 1, 10, 0, 1, 500, time.UTC),
+				Duration:    1500 * time.Millisecond,
+				AppliedBy:   "deploy",
+				Description: "Add the orders table",
+				Modified:    true,
+			},
+			{ID: "2024_03_02_10_00_00_index_orders"},
+		}
+
+		require.Equal(t, statuses, rpc.StatusOf(rpc.NewStatusResponse(statuses)))
+	})
+
+	t.Run("it should round-trip the events", func(t *testing.T) {
+		event := rpc.EventOf(rpc.NewApplyEvent(executor.Event{
+			Type:      executor.EventMigrationFailed,
+			Target:    "production",
+			Migration: "2024_03_01_10_00_00_add_orders",
+			Duration:  42 * time.Millisecond,
+			Err:       errors.New("syntax error"),
+		}))
+		require.Equal(t, executor.EventMigrationFailed, event.Type)
+		require.Equal(t, "production", event.Target)
+		require.Equal(t, 42*time.Millisecond, event.Duration)
+		require.EqualError(t, event.Err, "syntax error")
+
+		result := rpc.NewResultEvent(3)
+		require.Equal(t, rpc.EventResult, rpc.EventOf(result).Type)
+		require.EqualValues(t, 3, result.GetApplied())
+	})
+}
+
+func TestStatus(t *testing.T) {
+	t.Run("it should map the errors of the library to status codes", func(t *testing.T) {
+		require.NoError(t, rpc.Status(nil))
+		require.Equal(t, codes.Aborted, status.Code(rpc.Status(executor.ErrAlreadyRunning)))
+		require.Equal(t, codes.NotFound, status.Code(rpc.Status(fmt.Errorf("apply: %w", executor.ErrMigrationNotFound))))
+		require.Equal(t, codes.FailedPrecondition, status.Code(rpc.Status(executor.ErrChecksumMismatch)))
+		require.Equal(t, codes.InvalidArgument, status.Code(rpc.Status(executor.ErrOutOfOrder)))
+		require.Equal(t, codes.Canceled, status.Code(rpc.Status(context.Canceled)))
+		require.Equal(t, codes.Unknown, status.Code(rpc.Status(errors.New("syntax error"))))
+	})
+
+	t.Run("it should keep the code of a status error", func(t *testing.T) {
+		err := status.Error(codes.InvalidArgument, "id and phase are mutually exclusive")
+		require.Equal(t, err, rpc.Status(err))
+	})
+}
+
+func TestServer(t *testing.T) {
+	url := serve(t, "secret")
+	client := dial(t, url, "secret")
+	ctx := context.Background()
+
+	t.Run("it should answer a unary call", func(t *testing.T) {
+		resp, err := client.Plan(ctx, &rpc.PlanRequest{})
+		require.NoError(t, err)
+
+		plan := rpc.PlanOf(resp)
+		require.Len(t, plan, 1)
+		require.Equal(t, "2024_03_01_10_00_00_add_orders", plan[0].ID)
+	})
+
+	t.Run("it should send the status of a failed call", func(t *testing.T) {
+		_, err := client.Verify(ctx, &rpc.VerifyRequest{})
+		require.Equal(t, codes.FailedPrecondition, status.Code(err))
+		require.Contains(t, status.Convert(err).Message(), "2024_03_01_10_00_00_add_orders")
+
+		_, err = client.Status(ctx, &rpc.StatusRequest{})
+		require.Equal(t, codes.Unimplemented, status.Code(err))
+	})
+
+	t.Run("it should stream the events before the status", func(t *testing.T) {
+		stream, err := client.Apply(ctx, &rpc.ApplyRequest{})
+		require.NoError(t, err)
+
+		var ids []string
+		for {
+			var event *rpc.ApplyEvent
+			if event, err = stream.Recv(); err != nil {
+				break
+			}
+			ids = append(ids, event.GetMigration())
+		}
+		require.NotErrorIs(t, err, io.EOF)
+		require.Equal(t, codes.Aborted, status.Code(err))
+		require.Equal(t, []string{"2024_03_01_10_00_00_add_orders", "2024_03_02_10_00_00_index_orders"}, ids)
+	})
+
+	t.Run("it should reject the calls with an invalid token", func(t *testing.T) {
+		_, err := dial(t, url, "wrong").Plan(ctx, &rpc.PlanRequest{})
+		require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+		stream, err := dial(t, url, "wrong").Apply(ctx, &rpc.ApplyRequest{})
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		require.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("it should be served by an http.Server over unencrypted HTTP/2", func(t *testing.T) {
+		server := rpc.NewServer("secret")
+		rpc.RegisterMigratorServer(server, migrator{})
+
+		ts := httptest.NewUnstartedServer(server)
+		ts.Config.Protocols = new(http.Protocols)
+		ts.Config.Protocols.SetUnencryptedHTTP2(true)
+		ts.Start()
+		t.Cleanup(ts.Close)
+
+		resp, err := dial(t, ts.URL, "secret").Plan(ctx, &rpc.PlanRequest{})
+		require.NoError(t, err)
+		require.Len(t, resp.GetMigrations(), 1)
+	})
+
+	t.Run("it should only dial http and https URLs", func(t *testing.T) {
+		_, err := rpc.Dial("mig.internal:8080", "secret")
+		require.Error(t, err)
+	})
+}
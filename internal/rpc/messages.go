@@ -0,0 +1,155 @@
+package rpc
+
+import (
+	"errors"
+	"time"
+
+	"github.com/arthurdotwork/mig/internal/executor"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// EventResult is the type of the last event streamed by Apply, carrying the
+// number of migrations applied
+const EventResult executor.EventType = "result"
+
+// NewApplyEvent converts an event of a run
+func NewApplyEvent(event executor.Event) *ApplyEvent {
+	msg := &ApplyEvent{
+		Type:      string(event.Type),
+		Target:    event.Target,
+		Tenant:    event.Tenant,
+		Migration: event.Migration,
+		Duration:  newDuration(event.Duration),
+		Pending:   int32(event.Pending),
+	}
+	if event.Err != nil {
+		msg.Error = event.Err.Error()
+	}
+
+	return msg
+}
+
+// NewResultEvent returns the result event ending Apply
+func NewResultEvent(applied int) *ApplyEvent {
+	return &ApplyEvent{Type: string(EventResult), Applied: int32(applied)}
+}
+
+// EventOf converts an ApplyEvent back to an event of a run, Applied is only
+// set by the result event
+func EventOf(msg *ApplyEvent) executor.Event {
+	event := executor.Event{
+		Type:      executor.EventType(msg.GetType()),
+		Target:    msg.GetTarget(),
+		Tenant:    msg.GetTenant(),
+		Migration: msg.GetMigration(),
+		Duration:  msg.GetDuration().AsDuration(),
+		Pending:   int(msg.GetPending()),
+	}
+	if msg.GetError() != "" {
+		event.Err = errors.New(msg.GetError())
+	}
+
+	return event
+}
+
+// NewPlanResponse converts the pending migrations of a plan
+func NewPlanResponse(plan []executor.PlannedMigration) *PlanResponse {
+	resp := &PlanResponse{Migrations: make([]*PlannedMigration, 0, len(plan))}
+	for _, planned := range plan {
+		resp.Migrations = append(resp.Migrations, &PlannedMigration{
+			Id:            planned.ID,
+			Name:          planned.Name,
+			Filename:      planned.Filename,
+			Checksum:      planned.Checksum,
+			Transactional: planned.Transactional,
+			Phase:         planned.Phase,
+		})
+	}
+
+	return resp
+}
+
+// PlanOf converts a PlanResponse back to the pending migrations of a plan
+func PlanOf(resp *PlanResponse) []executor.PlannedMigration {
+	plan := make([]executor.PlannedMigration, 0, len(resp.GetMigrations()))
+	for _, planned := range resp.GetMigrations() {
+		plan = append(plan, executor.PlannedMigration{
+			ID:            planned.GetId(),
+			Name:          planned.GetName(),
+			Filename:      planned.GetFilename(),
+			Checksum:      planned.GetChecksum(),
+			Transactional: planned.GetTransactional(),
+			Phase:         planned.GetPhase(),
+		})
+	}
+
+	return plan
+}
+
+// NewStatusResponse converts the status of the migrations
+func NewStatusResponse(statuses []executor.MigrationStatus) *StatusResponse {
+	resp := &StatusResponse{Migrations: make([]*MigrationStatus, 0, len(statuses))}
+	for _, status := range statuses {
+		resp.Migrations = append(resp.Migrations, &MigrationStatus{
+			Id:          status.ID,
+			Name:        status.Name,
+			Filename:    status.Filename,
+			Applied:     status.Applied,
+			AppliedAt:   newTimestamp(status.AppliedAt),
+			Checksum:    status.Checksum,
+			Duration:    newDuration(status.Duration),
+			AppliedBy:   status.AppliedBy,
+			Description: status.Description,
+			Modified:    status.Modified,
+			Missing:     status.Missing,
+		})
+	}
+
+	return resp
+}
+
+// StatusOf converts a StatusResponse back to the status of the migrations
+func StatusOf(resp *StatusResponse) []executor.MigrationStatus {
+	statuses := make([]executor.MigrationStatus, 0, len(resp.GetMigrations()))
+	for _, status := range resp.GetMigrations() {
+		var appliedAt time.Time
+		if status.GetAppliedAt() != nil {
+			appliedAt = status.GetAppliedAt().AsTime()
+		}
+
+		statuses = append(statuses, executor.MigrationStatus{
+			ID:          status.GetId(),
+			Name:        status.GetName(),
+			Filename:    status.GetFilename(),
+			Applied:     status.GetApplied(),
+			AppliedAt:   appliedAt,
+			Checksum:    status.GetChecksum(),
+			Duration:    status.GetDuration().AsDuration(),
+			AppliedBy:   status.GetAppliedBy(),
+			Description: status.GetDescription(),
+			Modified:    status.GetModified(),
+			Missing:     status.GetMissing(),
+		})
+	}
+
+	return statuses
+}
+
+// newDuration converts a duration, leaving a zero one unset
+func newDuration(d time.Duration) *durationpb.Duration {
+	if d == 0 {
+		return nil
+	}
+
+	return durationpb.New(d)
+}
+
+// newTimestamp converts a time, leaving a zero one unset
+func newTimestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+
+	return timestamppb.New(t)
+}
@@ -0,0 +1,257 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: mig/v1/migrator.proto
+
+// The gRPC API served by `mig serve`, mirroring the Plan, Apply, Status and
+// Verify methods of the Go library. Calls are authenticated by an
+// "authorization: Bearer <token>" metadata.
+
+package rpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Migrator_Plan_FullMethodName   = "/mig.v1.Migrator/Plan"
+	Migrator_Apply_FullMethodName  = "/mig.v1.Migrator/Apply"
+	Migrator_Status_FullMethodName = "/mig.v1.Migrator/Status"
+	Migrator_Verify_FullMethodName = "/mig.v1.Migrator/Verify"
+)
+
+// MigratorClient is the client API for Migrator service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type MigratorClient interface {
+	// Plan lists the pending migrations in the order they would be applied
+	Plan(ctx context.Context, in *PlanRequest, opts ...grpc.CallOption) (*PlanResponse, error)
+	// Apply applies the pending migrations, streaming the events of the run and
+	// ending with a "result" event. It takes the migration lock, runs already
+	// in progress fail with ABORTED.
+	Apply(ctx context.Context, in *ApplyRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ApplyEvent], error)
+	// Status lists the migrations with their state
+	Status(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*StatusResponse, error)
+	// Verify fails with FAILED_PRECONDITION when the files of applied
+	// migrations changed or are missing
+	Verify(ctx context.Context, in *VerifyRequest, opts ...grpc.CallOption) (*VerifyResponse, error)
+}
+
+type migratorClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMigratorClient(cc grpc.ClientConnInterface) MigratorClient {
+	return &migratorClient{cc}
+}
+
+func (c *migratorClient) Plan(ctx context.Context, in *PlanRequest, opts ...grpc.CallOption) (*PlanResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PlanResponse)
+	err := c.cc.Invoke(ctx, Migrator_Plan_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *migratorClient) Apply(ctx context.Context, in *ApplyRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ApplyEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Migrator_ServiceDesc.Streams[0], Migrator_Apply_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ApplyRequest, ApplyEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Migrator_ApplyClient = grpc.ServerStreamingClient[ApplyEvent]
+
+func (c *migratorClient) Status(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*StatusResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StatusResponse)
+	err := c.cc.Invoke(ctx, Migrator_Status_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *migratorClient) Verify(ctx context.Context, in *VerifyRequest, opts ...grpc.CallOption) (*VerifyResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(VerifyResponse)
+	err := c.cc.Invoke(ctx, Migrator_Verify_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// MigratorServer is the server API for Migrator service.
+// All implementations must embed UnimplementedMigratorServer
+// for forward compatibility.
+type MigratorServer interface {
+	// Plan lists the pending migrations in the order they would be applied
+	Plan(context.Context, *PlanRequest) (*PlanResponse, error)
+	// Apply applies the pending migrations, streaming the events of the run and
+	// ending with a "result" event. It takes the migration lock, runs already
+	// in progress fail with ABORTED.
+	Apply(*ApplyRequest, grpc.ServerStreamingServer[ApplyEvent]) error
+	// Status lists the migrations with their state
+	Status(context.Context, *StatusRequest) (*StatusResponse, error)
+	// Verify fails with FAILED_PRECONDITION when the files of applied
+	// migrations changed or are missing
+	Verify(context.Context, *VerifyRequest) (*VerifyResponse, error)
+	mustEmbedUnimplementedMigratorServer()
+}
+
+// UnimplementedMigratorServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedMigratorServer struct{}
+
+func (UnimplementedMigratorServer) Plan(context.Context, *PlanRequest) (*PlanResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Plan not implemented")
+}
+func (UnimplementedMigratorServer) Apply(*ApplyRequest, grpc.ServerStreamingServer[ApplyEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method Apply not implemented")
+}
+func (UnimplementedMigratorServer) Status(context.Context, *StatusRequest) (*StatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Status not implemented")
+}
+func (UnimplementedMigratorServer) Verify(context.Context, *VerifyRequest) (*VerifyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Verify not implemented")
+}
+func (UnimplementedMigratorServer) mustEmbedUnimplementedMigratorServer() {}
+func (UnimplementedMigratorServer) testEmbeddedByValue()                  {}
+
+// UnsafeMigratorServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MigratorServer will
+// result in compilation errors.
+type UnsafeMigratorServer interface {
+	mustEmbedUnimplementedMigratorServer()
+}
+
+func RegisterMigratorServer(s grpc.ServiceRegistrar, srv MigratorServer) {
+	// If the following call pancis, it indicates UnimplementedMigratorServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Migrator_ServiceDesc, srv)
+}
+
+func _Migrator_Plan_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PlanRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MigratorServer).Plan(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Migrator_Plan_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MigratorServer).Plan(ctx, req.(*PlanRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Migrator_Apply_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ApplyRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MigratorServer).Apply(m, &grpc.GenericServerStream[ApplyRequest, ApplyEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Migrator_ApplyServer = grpc.ServerStreamingServer[ApplyEvent]
+
+func _Migrator_Status_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MigratorServer).Status(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Migrator_Status_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MigratorServer).Status(ctx, req.(*StatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Migrator_Verify_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VerifyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MigratorServer).Verify(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Migrator_Verify_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MigratorServer).Verify(ctx, req.(*VerifyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Migrator_ServiceDesc is the grpc.ServiceDesc for Migrator service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Migrator_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "mig.v1.Migrator",
+	HandlerType: (*MigratorServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Plan",
+			Handler:    _Migrator_Plan_Handler,
+		},
+		{
+			MethodName: "Status",
+			Handler:    _Migrator_Status_Handler,
+		},
+		{
+			MethodName: "Verify",
+			Handler:    _Migrator_Verify_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Apply",
+			Handler:       _Migrator_Apply_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "mig/v1/migrator.proto",
+}
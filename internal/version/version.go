@@ -0,0 +1,4 @@
+package version
+
+// Version is the version of the migrator
+const Version = "0.1.0"
@@ -0,0 +1,173 @@
+package mig
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// durationBuckets are the upper bounds, in seconds, of the migration duration
+// histogram
+var durationBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, 3600}
+
+// Descriptions of the metrics, for Describe and Collect
+var (
+	appliedDesc  = prometheus.NewDesc("migrations_applied_total", "Number of migrations applied.", nil, nil)
+	failuresDesc = prometheus.NewDesc("migration_failures_total", "Number of migrations that failed.", nil, nil)
+	durationDesc = prometheus.NewDesc("migration_duration_seconds", "Time spent applying a migration.", nil, nil)
+	pendingDesc  = prometheus.NewDesc("pending_migrations", "Number of pending migrations after the last run.", nil, nil)
+)
+
+// Metrics collects Prometheus metrics about the migrations applied by the
+// Migrators it is passed to with WithMetrics:
+//
+//   - migrations_applied_total, a counter of applied migrations
+//   - migration_failures_total, a counter of failed migrations
+//   - migration_duration_seconds, a histogram of migration durations
+//   - pending_migrations, a gauge of the migrations left after the last run
+//
+// Metrics is a prometheus.Collector, to be registered with the registry of
+// the application, and an http.Handler serving them in the Prometheus text
+// format for applications without client_golang. Push sends them to a
+// Pushgateway for short-lived jobs.
+type Metrics struct {
+	mu       sync.Mutex
+	applied  uint64
+	failures uint64
+	buckets  []uint64
+	sum      float64
+	count    uint64
+	pending  int
+}
+
+// NewMetrics creates an empty set of metrics
+func NewMetrics() *Metrics {
+	return &Metrics{
+		buckets: make([]uint64, len(durationBuckets)),
+	}
+}
+
+// Observe records the outcome of the migrations and runs
+func (m *Metrics) Observe(_ context.Context, event Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch event.Type {
+	case EventMigrationApplied:
+		m.applied++
+		m.observeDuration(event.Duration.Seconds())
+	case EventMigrationFailed:
+		m.failures++
+		m.observeDuration(event.Duration.Seconds())
+	case EventRunFinished:
+		m.pending = event.Pending
+	}
+}
+
+// observeDuration adds a migration duration to the histogram
+func (m *Metrics) observeDuration(seconds float64) {
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			m.buckets[i]++
+		}
+	}
+	m.sum += seconds
+	m.count++
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+	writeMetric(&b, "migrations_applied_total", "counter", "Number of migrations applied.")
+	fmt.Fprintf(&b, "migrations_applied_total %d\n", m.applied)
+
+	writeMetric(&b, "migration_failures_total", "counter", "Number of migrations that failed.")
+	fmt.Fprintf(&b, "migration_failures_total %d\n", m.failures)
+
+	writeMetric(&b, "migration_duration_seconds", "histogram", "Time spent applying a migration.")
+	for i, bound := range durationBuckets {
+		fmt.Fprintf(&b, "migration_duration_seconds_bucket{le=%q} %d\n", strconv.FormatFloat(bound, 'g', -1, 64), m.buckets[i])
+	}
+	fmt.Fprintf(&b, "migration_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.count)
+	fmt.Fprintf(&b, "migration_duration_seconds_sum %s\n", strconv.FormatFloat(m.sum, 'g', -1, 64))
+	fmt.Fprintf(&b, "migration_duration_seconds_count %d\n", m.count)
+
+	writeMetric(&b, "pending_migrations", "gauge", "Number of pending migrations after the last run.")
+	fmt.Fprintf(&b, "pending_migrations %d\n", m.pending)
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// writeMetric writes the HELP and TYPE lines of a metric
+func writeMetric(b *strings.Builder, name, kind, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// Describe sends the descriptions of the metrics, for prometheus.Collector
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	ch <- appliedDesc
+	ch <- failuresDesc
+	ch <- durationDesc
+	ch <- pendingDesc
+}
+
+// Collect sends the current values of the metrics, for prometheus.Collector
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	buckets := make(map[float64]uint64, len(durationBuckets))
+	for i, bound := range durationBuckets {
+		buckets[bound] = m.buckets[i]
+	}
+
+	ch <- prometheus.MustNewConstMetric(appliedDesc, prometheus.CounterValue, float64(m.applied))
+	ch <- prometheus.MustNewConstMetric(failuresDesc, prometheus.CounterValue, float64(m.failures))
+	ch <- prometheus.MustNewConstHistogram(durationDesc, m.count, m.sum, buckets)
+	ch <- prometheus.MustNewConstMetric(pendingDesc, prometheus.GaugeValue, float64(m.pending))
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w) //nolint:errcheck
+}
+
+// Push replaces the metrics of the given job on the Pushgateway at url
+func (m *Metrics) Push(ctx context.Context, pushURL, job string) error {
+	var body bytes.Buffer
+	if _, err := m.WriteTo(&body); err != nil {
+		return err
+	}
+
+	endpoint := strings.TrimRight(pushURL, "/") + "/metrics/job/" + url.PathEscape(job)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint, &body)
+	if err != nil {
+		return fmt.Errorf("failed to create pushgateway request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to push metrics: %w", err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("failed to push metrics: pushgateway responded %s", resp.Status)
+	}
+
+	return nil
+}
@@ -1,17 +1,32 @@
 package mig
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"iter"
+	"log/slog"
 	"os"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/arthurdotwork/mig/internal/config"
+	"github.com/arthurdotwork/mig/internal/database"
 	"github.com/arthurdotwork/mig/internal/executor"
+	"github.com/arthurdotwork/mig/internal/export"
+	"github.com/arthurdotwork/mig/internal/lint"
 	"github.com/arthurdotwork/mig/internal/migrations"
+	"github.com/arthurdotwork/mig/internal/report"
+	"github.com/arthurdotwork/mig/internal/version"
 )
 
 const (
 	// Version is the version of the migrator
-	Version = "0.1.0"
+	Version = version.Version
 
 	// DefaultConfigFilename is the default name of the configuration file
 	DefaultConfigFilename = "mig.yaml"
@@ -20,37 +35,734 @@ const (
 	DefaultMigrationsDir = config.DefaultMigrationsDir
 )
 
+// Errors returned by a Migrator, wrapped with the migration they relate to;
+// compare them with errors.Is
+var (
+	// ErrAlreadyRunning is returned when migrations are applied while another
+	// call on the same Migrator is still applying them
+	ErrAlreadyRunning = executor.ErrAlreadyRunning
+
+	// ErrMigrationNotFound is returned by MigrateUpByID for an unknown ID
+	ErrMigrationNotFound = executor.ErrMigrationNotFound
+
+	// ErrAlreadyApplied is returned by MigrateUpByID for an applied migration
+	ErrAlreadyApplied = executor.ErrAlreadyApplied
+
+	// ErrMigrationTimeout is returned when a migration runs longer than the
+	// per-migration timeout, see WithPerMigrationTimeout
+	ErrMigrationTimeout = executor.ErrMigrationTimeout
+
+	// ErrLockTimeout is returned when the context deadline or timeouts.lock
+	// expires while waiting for the migration lock held by another process
+	ErrLockTimeout = executor.ErrLockTimeout
+
+	// ErrRunTimeout is returned when a run exceeds timeouts.run, from waiting
+	// for the migration lock to applying the last migration
+	ErrRunTimeout = executor.ErrRunTimeout
+
+	// ErrUnsafeMigration is returned when a migration about to be applied
+	// breaks a lint rule configured with the error severity, see Lint
+	ErrUnsafeMigration = executor.ErrUnsafeMigration
+
+	// ErrConcurrentInTx is returned before applying a migration that uses
+	// CONCURRENTLY inside a transaction, it needs "-- disable-tx"
+	ErrConcurrentInTx = executor.ErrConcurrentInTx
+
+	// ErrNoTxInPgBouncer is returned before applying a migration that runs
+	// outside of a transaction in pgbouncer mode, which no lock protects
+	ErrNoTxInPgBouncer = executor.ErrNoTxInPgBouncer
+
+	// ErrServerTooOld is returned before applying a migration declaring
+	// "-- mig:min-pg=" a newer PostgreSQL version than the server runs
+	ErrServerTooOld = executor.ErrServerTooOld
+
+	// ErrAccessLost is returned when the access checks fail after the
+	// statements of a migration: the migration is rolled back when it runs
+	// inside a transaction, and left unrecorded otherwise
+	ErrAccessLost = executor.ErrAccessLost
+
+	// ErrBackupFailed is returned when backup.enabled is set and the backup
+	// taken before applying migrations fails, nothing is applied then
+	ErrBackupFailed = executor.ErrBackupFailed
+
+	// ErrRestored is returned alongside the error of a migration that failed
+	// outside of a transaction when backup.restore_on_failure restored the
+	// database from the backup taken before the run
+	ErrRestored = executor.ErrRestored
+
+	// ErrOutOfOrder is returned when a pending migration is older than the
+	// last applied one and migrations.out_of_order is "fail"
+	ErrOutOfOrder = executor.ErrOutOfOrder
+
+	// ErrDirtyState is returned when the applied migrations no longer match
+	// the migration files
+	ErrDirtyState = executor.ErrDirtyState
+
+	// ErrChecksumMismatch is returned by Verify when the file of an applied
+	// migration changed since it was applied, it wraps ErrDirtyState
+	ErrChecksumMismatch = executor.ErrChecksumMismatch
+
+	// ErrMissingMigration is returned by Verify when the file of an applied
+	// migration was renamed or deleted, it wraps ErrDirtyState
+	ErrMissingMigration = executor.ErrMissingMigration
+)
+
+// StatementError is returned when a statement of a migration fails, with the
+// line of the statement in the migration file
+type StatementError = executor.StatementError
+
 // Migrator is the main struct for migration management
+//
+// A Migrator is safe for concurrent use: Status, Plan and History can be
+// called while migrations are applied, and applying migrations while another
+// call on the same Migrator is already doing so returns ErrAlreadyRunning.
+// Other processes applying migrations to the same database wait for the
+// database lock instead.
 type Migrator struct {
 	executor *executor.Executor
+	clock    Clock
+}
+
+// MigrationStatus represents a migration's current status, as returned by Status
+type MigrationStatus = executor.MigrationStatus
+
+// Migration is a migration file with its content, as yielded by Iter
+type Migration = migrations.Migration
+
+// HistoryEntry is a recorded migration execution, as yielded by History
+type HistoryEntry = database.HistoryEntry
+
+// AdHocPrefix starts the version of the history entries recorded by Exec,
+// see HistoryEntry.AdHoc
+const AdHocPrefix = database.AdHocPrefix
+
+// PlannedMigration is a pending migration as it would be applied, as returned by Plan
+type PlannedMigration = executor.PlannedMigration
+
+// ApplyRequest selects the migrations applied through the APIs of mig serve,
+// every pending one when empty
+type ApplyRequest struct {
+	ID              string `json:"id,omitempty"`                 // Applies a single pending migration
+	AllowOutOfOrder bool   `json:"allow_out_of_order,omitempty"` // Lets ID skip earlier pending migrations
+	Phase           string `json:"phase,omitempty"`              // Applies the pending migrations of a deployment phase
 }
 
-// MigrationStatus represents a migration's current status
-type MigrationStatus struct {
-	ID        string // Migration ID
-	Name      string // Migration Name
-	Filename  string // Migration Filename
-	Applied   bool   // Whether the migration has been applied
-	AppliedAt string // When the migration was applied (empty if not applied)
+// Estimate is the number of rows a statement of a pending migration is
+// expected to touch, as returned by Explain
+type Estimate = executor.Estimate
+
+// LockImpact is the table lock a statement of a pending migration takes, as
+// returned by AnalyzeLocks
+type LockImpact = executor.LockImpact
+
+// Risks of a LockImpact
+const (
+	LockRiskHigh   = lint.RiskHigh
+	LockRiskMedium = lint.RiskMedium
+	LockRiskLow    = lint.RiskLow
+)
+
+// RebasedMigration is a migration file renamed by Rebase
+type RebasedMigration = executor.RebasedMigration
+
+// Event reports the progress of a migration run to an Observer
+type Event = executor.Event
+
+// EventType identifies what an Event reports
+type EventType = executor.EventType
+
+// Event types, in the order they occur during a run
+const (
+	EventRunStarted       = executor.EventRunStarted
+	EventMigrationApplied = executor.EventMigrationApplied
+	EventMigrationFailed  = executor.EventMigrationFailed
+	EventRunFinished      = executor.EventRunFinished
+)
+
+// Observer is notified of the progress of migration runs, see WithObserver
+type Observer = executor.Observer
+
+// Notifier is told about the start and outcome of the runs applying
+// migrations, see WithNotifier
+type Notifier = executor.Notifier
+
+// NotifierFunc adapts a function to a Notifier
+type NotifierFunc = executor.NotifierFunc
+
+// Notification is a run passed to a Notifier
+type Notification = executor.Notification
+
+// NotifiedMigration is a migration of the run of a Notification
+type NotifiedMigration = executor.NotifiedMigration
+
+// Events of a Notification
+const (
+	NotifyStarted   = config.NotifyStarted
+	NotifySucceeded = config.NotifySucceeded
+	NotifyFailed    = config.NotifyFailed
+)
+
+// Tracer starts the spans of a migration run, see WithTracer. It is small
+// enough to be implemented on top of any tracing library, such as an
+// OpenTelemetry trace.Tracer.
+type Tracer = executor.Tracer
+
+// LintFinding is a risky statement of a migration, as returned by Lint
+type LintFinding = lint.Finding
+
+// SyntaxError is a statement of a migration PostgreSQL would reject, as
+// returned by Validate
+type SyntaxError = lint.SyntaxError
+
+// Severities of a LintFinding
+const (
+	LintSeverityError = config.SeverityError
+	LintSeverityWarn  = config.SeverityWarn
+)
+
+// SchemaChange is a difference between a schema snapshot and the live
+// schema, as returned by DiffSchema
+type SchemaChange = database.SchemaChange
+
+// Changes of a SchemaChange
+const (
+	ChangeMissing    = database.ChangeMissing
+	ChangeUnexpected = database.ChangeUnexpected
+	ChangeChanged    = database.ChangeChanged
+)
+
+// Deployment phases of a migration tagged "-- mig:phase=expand" or
+// "-- mig:phase=contract", see MigrateUpPhase
+const (
+	PhaseExpand   = migrations.PhaseExpand
+	PhaseContract = migrations.PhaseContract
+)
+
+// LoggingConfig is the logging section of the configuration file, as returned
+// by Logging
+type LoggingConfig = config.LoggingConfig
+
+// AuditConfig is the audit section of the configuration file, as returned by
+// Audit
+type AuditConfig = config.AuditConfig
+
+// ConfigCache holds the configuration files read by the functions given it
+// with WithConfigCache
+type ConfigCache = config.Cache
+
+// NewConfigCache returns an empty configuration cache, to share between the
+// calls of one operation rather than for the lifetime of a program, as the
+// files changed since they were read are not seen
+func NewConfigCache() *ConfigCache {
+	return config.NewCache()
 }
 
+// Formats of Report
+const (
+	ReportMarkdown = report.Markdown
+	ReportHTML     = report.HTML
+)
+
+// Formats of Export
+const (
+	ExportCSV  = export.CSV
+	ExportJSON = export.JSON
+)
+
+// Log formats of LoggingConfig
+const (
+	LogFormatText = config.LogFormatText
+	LogFormatJSON = config.LogFormatJSON
+)
+
+// ScriptScope selects the migrations rendered by Script
+type ScriptScope int
+
+const (
+	// UpNext renders the next pending migration, as MigrateUp applies it
+	UpNext ScriptScope = iota
+
+	// UpAll renders every pending migration, as MigrateUpAll applies them
+	UpAll
+)
+
 // New creates a new Migrator instance
-func New(configPath string) (*Migrator, error) {
+func New(configPath string, opts ...Option) (*Migrator, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
 	// Load the configuration
-	cfg, err := config.Load(configPath)
+	cfg, err := loadConfig(configPath, o)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := promptPassword(cfg, o); err != nil {
+		return nil, err
+	}
+
 	// Create the executor
-	exec, err := executor.New(cfg)
+	ctx, end := context.Background(), func(error) {}
+	if o.tracer != nil {
+		ctx, end = o.tracer.Start(ctx, executor.SpanConnect, slog.String("driver", cfg.Database.Driver))
+	}
+	exec, err := executor.New(ctx, cfg)
+	end(err)
+	if err != nil {
+		return nil, err
+	}
+
+	return newMigrator(exec, o), nil
+}
+
+// NewWithDB creates a new Migrator on a connection pool managed by the caller
+//
+// The connection settings of the configuration do not apply: use WithDriver
+// for databases other than PostgreSQL and WithMigrationsDir to locate the
+// migrations. Close leaves the pool open. The pool must allow at least two
+// connections, one of them holding the migration lock.
+func NewWithDB(db *sql.DB, opts ...Option) (*Migrator, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	cfg, err := config.ForDB(o.overrides...)
+	if err != nil {
+		return nil, err
+	}
+
+	exec, err := executor.NewWithDB(context.Background(), cfg, db)
 	if err != nil {
 		return nil, err
 	}
 
+	return newMigrator(exec, o), nil
+}
+
+// newMigrator wraps an executor with the options that apply to it
+func newMigrator(exec *executor.Executor, o *options) *Migrator {
+	if o.logger != nil {
+		exec.SetLogger(o.logger)
+	}
+
+	if o.tracer != nil {
+		exec.SetTracer(o.tracer)
+	}
+
+	for _, observer := range o.observers {
+		exec.AddObserver(observer)
+	}
+
+	for _, notifier := range o.notifiers {
+		exec.AddNotifier(notifier)
+	}
+
+	clock := o.clock
+	if clock != nil {
+		exec.SetClock(clock.Now)
+	} else {
+		clock = systemClock{}
+	}
+
 	return &Migrator{
 		executor: exec,
-	}, nil
+		clock:    clock,
+	}
+}
+
+// Summary reports the outcome of AutoMigrate
+type Summary struct {
+	// Applied is the number of migrations applied by the call
+	Applied int
+
+	// Current is the ID of the latest applied migration, empty when none is
+	Current string
+
+	// Duration is the time spent applying the migrations
+	Duration time.Duration
+}
+
+// AutoMigrate applies the pending migrations found at the root of fsys to db,
+// for services that migrate their schema on startup:
+//
+//	//go:embed migrations/*.sql
+//	var migrationsFS embed.FS
+//
+//	sub, _ := fs.Sub(migrationsFS, "migrations")
+//	summary, err := mig.AutoMigrate(ctx, db, sub)
+//
+// Instances starting together wait for each other on the migration lock, so
+// each migration is applied once. Options are those of NewWithDB.
+func AutoMigrate(ctx context.Context, db *sql.DB, fsys fs.FS, opts ...Option) (*Summary, error) {
+	m, err := NewWithDB(db, append(opts, WithMigrationsFS(fsys))...)
+	if err != nil {
+		return nil, err
+	}
+	defer m.Close() //nolint:errcheck
+
+	start := time.Now()
+	applied, err := m.MigrateUpAllContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &Summary{
+		Applied:  applied,
+		Duration: time.Since(start),
+	}
+
+	status, err := m.StatusContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, migration := range status {
+		if migration.Applied {
+			summary.Current = migration.ID
+		}
+	}
+
+	return summary, nil
+}
+
+// promptPassword asks for the password when no source provides one
+func promptPassword(cfg *config.Config, o *options) error {
+	if o.passwordPrompt == nil || cfg.Database.HasPassword() || cfg.Database.IsSocket() {
+		return nil
+	}
+
+	password, err := o.passwordPrompt()
+	if err != nil {
+		return err
+	}
+	cfg.Database.Password = password
+
+	return nil
+}
+
+// FindConfig looks for the configuration file name in the working directory,
+// then in its parents up to the root of the repository, so a project can be
+// migrated from any of its subdirectories. Pass the directory of the file to
+// WithBaseDir to resolve its relative paths next to it.
+func FindConfig(name string) (string, error) {
+	return config.Find(".", name)
+}
+
+// ValidateConfig loads the configuration of the selected target and checks
+// it, without connecting to the database
+func ValidateConfig(configPath string, opts ...Option) error {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	cfg, err := loadConfig(configPath, o)
+	if err != nil {
+		return err
+	}
+
+	_, err = lint.New(cfg.Lint)
+	return err
+}
+
+// Lint checks every migration of the selected target against the safety
+// rules, such as dropping a column or creating an index without
+// CONCURRENTLY, without connecting to the database. The severity of each
+// finding comes from lint.rules in the configuration file, the same check
+// runs on the pending migrations before they are applied.
+func Lint(configPath string, opts ...Option) ([]LintFinding, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	cfg, err := loadConfig(configPath, o)
+	if err != nil {
+		return nil, err
+	}
+
+	linter, err := lint.New(cfg.Lint)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := migrations.Load(cfg.Migrations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load migrations: %w", err)
+	}
+
+	var findings []LintFinding
+	for _, migration := range files {
+		findings = append(findings, linter.Check(migration)...)
+	}
+
+	return findings, nil
+}
+
+// Validate checks the syntax of every migration of the selected target
+// without connecting to the database, reporting unterminated literals,
+// comments and dollar-quoted bodies, unbalanced parentheses and statements
+// not starting with a command, with their line and column. It is a lexical
+// check rather than a full parse of the PostgreSQL grammar, so a migration
+// it accepts can still fail to apply. Only PostgreSQL supports it.
+func Validate(configPath string, opts ...Option) ([]SyntaxError, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	cfg, err := loadConfig(configPath, o)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Database.Driver != "postgres" && cfg.Database.Driver != "pgx" {
+		return nil, fmt.Errorf("syntax validation is not supported by the %s driver", cfg.Database.Driver)
+	}
+
+	files, err := migrations.Load(cfg.Migrations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load migrations: %w", err)
+	}
+
+	var errs []SyntaxError
+	for _, migration := range files {
+		errs = append(errs, lint.CheckSyntax(migration)...)
+	}
+
+	return errs, nil
+}
+
+// loadConfig loads the configuration of the selected target, warning about
+// the unknown keys tolerated by WithLenientConfig
+func loadConfig(configPath string, o *options) (*config.Config, error) {
+	cfg, err := config.LoadTarget(configPath, o.target, o.overrides...)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.logger != nil {
+		for _, key := range cfg.UnknownKeys {
+			o.logger.Warn("unknown config key", slog.String("key", key))
+		}
+	}
+
+	return cfg, nil
+}
+
+// Targets returns the names of the targets defined in the configuration file
+func Targets(configPath string, opts ...Option) ([]string, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return config.Targets(configPath, o.overrides...)
+}
+
+// Logging returns the logging settings of the configuration file, for
+// programs setting up their logger like the CLI does. The defaults are
+// returned when the file does not exist.
+func Logging(configPath string, opts ...Option) (LoggingConfig, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return config.LoadLogging(configPath, o.overrides...)
+}
+
+// Audit returns the audit settings of the configuration file, for programs
+// keeping a trail of their commands like the CLI does. The defaults are
+// returned when the file does not exist.
+func Audit(configPath string, opts ...Option) (AuditConfig, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return config.LoadAudit(configPath, o.overrides...)
+}
+
+// Protected reports whether the selected target sets environment.protected,
+// for programs asking for a confirmation before migrating it like the CLI does
+func Protected(configPath string, opts ...Option) (bool, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	cfg, err := loadConfig(configPath, o)
+	if err != nil {
+		return false, err
+	}
+
+	return cfg.Environment.Protected, nil
+}
+
+// SchemaFile returns the migrations.schema_file of the selected target, empty
+// when the schema is not dumped after migrating
+func SchemaFile(configPath string, opts ...Option) (string, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	cfg, err := loadConfig(configPath, o)
+	if err != nil {
+		return "", err
+	}
+
+	return cfg.Migrations.SchemaFile, nil
+}
+
+// MigrationsDirs returns the absolute paths of the migrations.directory of
+// the selected target followed by its migrations.extra_directories
+func MigrationsDirs(configPath string, opts ...Option) ([]string, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	cfg, err := loadConfig(configPath, o)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]string{cfg.Migrations.Directory}, cfg.Migrations.ExtraDirectories...), nil
+}
+
+// Tenants returns the tenant schemas of the selected target, listed in the
+// configuration file or discovered in the database, or nil when the target
+// is not multi-tenant
+func Tenants(configPath string, opts ...Option) ([]string, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	cfg, err := loadConfig(configPath, o)
+	if err != nil {
+		return nil, err
+	}
+
+	if !cfg.Tenants.Enabled() {
+		return nil, nil
+	}
+
+	if err := promptPassword(cfg, o); err != nil {
+		return nil, err
+	}
+
+	return database.ListTenants(context.Background(), cfg)
+}
+
+// Shadow rehearses the pending migrations of the configured database on a
+// shadow database created on the same server, as a copy of it when
+// fromTemplate is set or empty otherwise, and drops the shadow afterwards.
+// It returns the number of migrations applied to the shadow, an error means
+// applying them to the real database would likely fail too.
+//
+// PostgreSQL refuses to copy a database other sessions are connected to, so
+// copies suit databases that are not in use. Only PostgreSQL is supported.
+func Shadow(ctx context.Context, configPath string, fromTemplate bool, opts ...Option) (applied int, err error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	cfg, err := loadConfig(configPath, o)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := promptPassword(cfg, o); err != nil {
+		return 0, err
+	}
+
+	dialect, err := database.GetDialect(cfg.Database.Driver)
+	if err != nil {
+		return 0, err
+	}
+
+	shadowDB, drop, err := database.CreateShadow(ctx, dialect, cfg.Target, cfg.Database, fromTemplate)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if dropErr := drop(context.WithoutCancel(ctx)); dropErr != nil {
+			err = errors.Join(err, dropErr)
+		}
+	}()
+
+	// The shadow is thrown away, so it is neither backed up nor restored
+	shadowCfg := *cfg
+	shadowCfg.Database = shadowDB
+	shadowCfg.Backup = config.BackupConfig{}
+
+	exec, err := executor.New(ctx, &shadowCfg)
+	if err != nil {
+		return 0, err
+	}
+	defer exec.Close() //nolint:errcheck
+
+	if o.logger != nil {
+		exec.SetLogger(o.logger.With(slog.String("shadow", shadowDB.Name)))
+	}
+
+	return exec.ExecuteAllMigrations(ctx)
+}
+
+// DumpSchema returns the schema of db as normalized DDL (types, tables,
+// constraints, indexes and views, without the mig tables) in a deterministic
+// order, so snapshots only change when the schema does. Only PostgreSQL is
+// supported; use WithDriver for pgx.
+func DumpSchema(ctx context.Context, db *sql.DB, opts ...Option) (string, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	cfg, err := config.ForDB(o.overrides...)
+	if err != nil {
+		return "", err
+	}
+
+	dialect, err := database.GetDialect(cfg.Database.Driver)
+	if err != nil {
+		return "", err
+	}
+
+	return database.DumpSchema(ctx, db, dialect)
+}
+
+// DiffSchema compares a schema snapshot to the live schema, both dumped by
+// DumpSchema, and returns the tables, columns, constraints, indexes, types and
+// views that differ, so changes made outside of migrations are discovered
+func DiffSchema(want, got string) []SchemaChange {
+	return database.DiffSchema(want, got)
+}
+
+// Iter yields the migrations of a directory in the order they are applied,
+// reading each file only when it is reached. Iteration stops when the context
+// is cancelled.
+func Iter(ctx context.Context, directory string) iter.Seq2[Migration, error] {
+	return func(yield func(Migration, error) bool) {
+		for migration, err := range migrations.Iter(directory) {
+			if err == nil {
+				if err = ctx.Err(); err != nil {
+					migration = Migration{}
+				}
+			}
+
+			if !yield(migration, err) || err != nil {
+				return
+			}
+		}
+	}
 }
 
 // Initialize sets up the migration environment
@@ -71,7 +783,7 @@ func Initialize(configPath, migrationsDir string) error {
 		fmt.Printf("Created migrations directory: %s\n", migrationsDir)
 
 		// Create a sample migration
-		filename, err := migrations.CreateMigrationFile(migrationsDir, "init")
+		filename, err := migrations.CreateMigrationFile(migrationsDir, "init", time.Now())
 		if err != nil {
 			return err
 		}
@@ -81,51 +793,283 @@ func Initialize(configPath, migrationsDir string) error {
 	return nil
 }
 
+// Generate writes a Go file declaring the migrations of a directory as typed
+// constants with their checksum and content, in the given package
+func Generate(w io.Writer, directory, packageName string) error {
+	return migrations.Generate(w, directory, packageName)
+}
+
 // CreateMigration creates a new migration file
 func (m *Migrator) CreateMigration(name string) (string, error) {
-	return migrations.CreateMigrationFile(m.executor.Config().Migrations.Directory, name)
+	if m.executor.Config().Migrations.FS != nil {
+		return "", errors.New("cannot create a migration in a migrations fs.FS")
+	}
+
+	return migrations.CreateMigrationFile(m.executor.Config().Migrations.Directory, name, m.clock.Now())
 }
 
 // MigrateUp applies the next pending migration
 func (m *Migrator) MigrateUp() (bool, error) {
-	return m.executor.ExecuteNextMigration()
+	return m.MigrateUpContext(context.Background())
+}
+
+// MigrateUpContext applies the next pending migration, the context cancels
+// the migration and the wait for the migration lock
+func (m *Migrator) MigrateUpContext(ctx context.Context) (bool, error) {
+	return m.executor.ExecuteNextMigration(ctx)
 }
 
 // MigrateUpAll applies all pending migrations
 func (m *Migrator) MigrateUpAll() (int, error) {
-	return m.executor.ExecuteAllMigrations()
+	return m.MigrateUpAllContext(context.Background())
+}
+
+// MigrateUpAllContext applies all pending migrations, the context cancels the
+// running migration and the wait for the migration lock
+func (m *Migrator) MigrateUpAllContext(ctx context.Context) (int, error) {
+	return m.executor.ExecuteAllMigrations(ctx)
+}
+
+// MigrateUpPhase applies the pending migrations of a deployment phase,
+// PhaseExpand before the new application version is deployed and
+// PhaseContract once the previous one is gone, stopping before the first
+// pending migration of the other phase. Untagged migrations belong to the
+// expand phase.
+func (m *Migrator) MigrateUpPhase(ctx context.Context, phase string) (int, error) {
+	return m.executor.ExecutePhase(ctx, phase)
+}
+
+// MigrateUpByID applies a single pending migration, which must be the next one
+// unless allowOutOfOrder is set
+func (m *Migrator) MigrateUpByID(ctx context.Context, id string, allowOutOfOrder bool) error {
+	return m.executor.ExecuteByID(ctx, id, allowOutOfOrder)
+}
+
+// ApplySQL writes content, such as SQL generated by another tool, as a new
+// migration file named name and applies it, recording it like any other
+// migration, and returns its filename. It refuses when migrations are
+// pending, which would have to be applied first. The file is kept when the
+// migration fails, to be fixed or deleted.
+func (m *Migrator) ApplySQL(ctx context.Context, name, content string) (string, error) {
+	if m.executor.Config().Migrations.FS != nil {
+		return "", errors.New("cannot create a migration in a migrations fs.FS")
+	}
+
+	if strings.TrimSpace(content) == "" {
+		return "", errors.New("no statement to apply")
+	}
+
+	pending, err := m.executor.Plan(ctx)
+	if err != nil {
+		return "", err
+	}
+	if len(pending) > 0 {
+		return "", fmt.Errorf("%d migration(s) pending, apply them first: %s is next", len(pending), pending[0].ID)
+	}
+
+	migration, err := m.executor.AddMigration(name, content, m.clock.Now())
+	if err != nil {
+		return "", err
+	}
+
+	return migration.Filename, m.executor.ExecuteByID(ctx, migration.ID, false)
+}
+
+// Exec runs ad-hoc SQL, such as an emergency hotfix, while holding the
+// migration lock, and records it in the history under the version
+// AdHocPrefix + name, so operators leave the same trail as the migrations.
+// It runs in a transaction unless transactional is false, and does not
+// change the applied migrations.
+func (m *Migrator) Exec(ctx context.Context, name, content string, transactional bool) error {
+	return m.executor.Exec(ctx, name, content, transactional)
+}
+
+// Migration returns the migration file with the given ID, with its content,
+// or ErrMigrationNotFound
+func (m *Migrator) Migration(id string) (Migration, error) {
+	return m.executor.Migration(id)
+}
+
+// Rebase renames the files of the pending migrations older than the last
+// applied one, which migrations.out_of_order: fail refuses, to new timestamps
+// so they apply in their current order after the applied ones. Run it on the
+// branch that brought them, the renamed files are part of the change.
+func (m *Migrator) Rebase(ctx context.Context) ([]RebasedMigration, error) {
+	return m.executor.Rebase(ctx, m.clock.Now())
+}
+
+// Archive moves the files of the migrations applied before a date
+// (YYYY-MM-DD), or older than a migration ID, to an archive/ subdirectory of
+// their migrations directory and returns their filenames. Archived migrations
+// are no longer loaded, nor reported missing by Verify. Fresh databases cannot
+// replay them, so keep a schema snapshot to create those.
+func (m *Migrator) Archive(ctx context.Context, before string) ([]string, error) {
+	return m.executor.Archive(ctx, before)
+}
+
+// ArchivePlan returns the filenames of the migrations Archive would move,
+// without moving them
+func (m *Migrator) ArchivePlan(ctx context.Context, before string) ([]string, error) {
+	return m.executor.ArchivePlan(ctx, before)
+}
+
+// Verify checks that the files of the applied migrations did not change since
+// they were applied and are still on disk, returning an error wrapping
+// ErrChecksumMismatch or ErrMissingMigration otherwise
+func (m *Migrator) Verify(ctx context.Context) error {
+	return m.executor.Verify(ctx)
+}
+
+// Plan returns the pending migrations in the order MigrateUpAll would apply
+// them, without executing anything
+func (m *Migrator) Plan(ctx context.Context) ([]PlannedMigration, error) {
+	return m.executor.Plan(ctx)
+}
+
+// AnalyzeLocks classifies each statement of the pending migrations by the
+// table lock it takes, ACCESS EXCLUSIVE blocking reads and the modes from
+// SHARE up blocking writes, with the size of the table estimated from
+// pg_class, so deploys needing a maintenance window stand out. Nothing is
+// executed. Only PostgreSQL supports it.
+func (m *Migrator) AnalyzeLocks(ctx context.Context) ([]LockImpact, error) {
+	return m.executor.AnalyzeLocks(ctx)
+}
+
+// Explain runs EXPLAIN, without ANALYZE, on the INSERT, UPDATE and DELETE
+// statements of the pending migrations and returns their estimated rows, so
+// an accidental full table update shows up before it runs. Nothing is
+// executed. Only PostgreSQL supports it.
+func (m *Migrator) Explain(ctx context.Context) ([]Estimate, error) {
+	return m.executor.Explain(ctx)
+}
+
+// Script returns the SQL that applying the pending migrations would execute,
+// with the transaction wrappers, statement timeouts and the statements
+// recording each migration, so it can be reviewed or run by a DBA. Nothing is
+// executed; running the script marks the migrations as applied.
+func (m *Migrator) Script(ctx context.Context, scope ScriptScope) (string, error) {
+	var b strings.Builder
+	if err := m.executor.Script(ctx, &b, scope == UpAll); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+// DumpSchema returns the schema of the database as normalized DDL, see the
+// package-level DumpSchema
+func (m *Migrator) DumpSchema(ctx context.Context) (string, error) {
+	return m.executor.DumpSchema(ctx)
+}
+
+// History streams the recorded migration executions with an ID greater than
+// afterID (0 for the whole history), in order
+func (m *Migrator) History(ctx context.Context, afterID int64) iter.Seq2[HistoryEntry, error] {
+	return m.executor.History(ctx, afterID)
+}
+
+// HistoryPage returns at most limit history entries with an ID greater than
+// afterID; pass the ID of the last entry to get the next page
+func (m *Migrator) HistoryPage(ctx context.Context, afterID int64, limit int) ([]HistoryEntry, error) {
+	var page []HistoryEntry
+	if limit <= 0 {
+		return page, nil
+	}
+
+	for entry, err := range m.History(ctx, afterID) {
+		if err != nil {
+			return nil, err
+		}
+
+		page = append(page, entry)
+		if len(page) == limit {
+			break
+		}
+	}
+
+	return page, nil
 }
 
 // Status returns the status of migrations
 func (m *Migrator) Status() ([]MigrationStatus, error) {
-	migrations, applied, err := m.executor.Status()
+	return m.StatusContext(context.Background())
+}
+
+// Report renders a changelog of the applied migrations, in the order they
+// were applied, with their description from "-- mig:description", when and
+// by which database user they were applied and how long they took, in
+// ReportMarkdown or ReportHTML for release notes and audits. A non-zero since
+// keeps the migrations applied from then on.
+func (m *Migrator) Report(ctx context.Context, format string, since time.Time) (string, error) {
+	statuses, err := m.executor.Status(ctx)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	// Create a map of applied migrations for quick lookup
-	appliedMap := make(map[string]string)
-	for _, m := range applied {
-		appliedMap[m.Version] = m.AppliedAt.Format("2006-01-02 15:04:05")
+	title := "Migration report"
+	if cfg := m.executor.Config(); cfg.Target != "" || cfg.Tenant != "" {
+		title += " (" + strings.Trim(cfg.Target+"/"+cfg.Tenant, "/") + ")"
 	}
 
-	// Convert to MigrationStatus
-	statuses := make([]MigrationStatus, len(migrations))
-	for i, m := range migrations {
-		appliedAt, isApplied := appliedMap[m.ID]
-		statuses[i] = MigrationStatus{
-			ID:        m.ID,
-			Name:      m.Name,
-			Filename:  m.Filename,
-			Applied:   isApplied,
-			AppliedAt: appliedAt,
+	r := report.Report{Title: title, GeneratedAt: time.Now()}
+	for _, status := range statuses {
+		if !status.Applied || status.AppliedAt.Before(since) {
+			continue
 		}
+
+		r.Entries = append(r.Entries, report.Entry{
+			Version:     status.ID,
+			Name:        status.Name,
+			Description: status.Description,
+			AppliedAt:   status.AppliedAt,
+			Duration:    status.Duration,
+			AppliedBy:   status.AppliedBy,
+		})
 	}
+	slices.SortStableFunc(r.Entries, func(a, b report.Entry) int {
+		return a.AppliedAt.Compare(b.AppliedAt)
+	})
+
+	var b strings.Builder
+	if err := report.Write(&b, format, r); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+// Export writes the mig_versions and mig_history tables to w in ExportCSV or
+// ExportJSON, with their checksums and executed SQL, for compliance archives.
+// Commands longer than maxCommand bytes are truncated, 0 keeps them whole.
+// The history is streamed rather than loaded in memory.
+func (m *Migrator) Export(ctx context.Context, w io.Writer, format string, maxCommand int) error {
+	versions, err := m.executor.Versions(ctx)
+	if err != nil {
+		return err
+	}
+
+	return export.Write(w, format, versions, m.executor.History(ctx, 0), maxCommand)
+}
+
+// StatusContext returns the status of migrations
+func (m *Migrator) StatusContext(ctx context.Context) ([]MigrationStatus, error) {
+	return m.executor.Status(ctx)
+}
+
+// Database returns the name of the database the migrator applies migrations
+// to, empty when it is not configured
+func (m *Migrator) Database() string {
+	return m.executor.Database()
+}
 
-	return statuses, nil
+// DB returns the connection pool of the migrator, connected with the
+// settings and credentials of the configuration. It is closed with the
+// migrator.
+func (m *Migrator) DB() *sql.DB {
+	return m.executor.DB()
 }
 
-// Close closes the database connection
+// Close closes the database connection, unless it was passed to NewWithDB
 func (m *Migrator) Close() error {
 	return m.executor.Close()
 }
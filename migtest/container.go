@@ -0,0 +1,94 @@
+package migtest
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+)
+
+const (
+	// EnvImage is the environment variable holding the PostgreSQL image of the
+	// container started when no server is configured, DefaultImage otherwise
+	EnvImage = "MIGTEST_IMAGE"
+
+	// DefaultImage is the PostgreSQL image of the container started when no
+	// server is configured
+	DefaultImage = "postgres:17-alpine"
+
+	// containerPassword is the password of the postgres user of the container
+	containerPassword = "migtest"
+)
+
+// errNoDocker is returned when no server is configured and there is no
+// Docker daemon to start one
+var errNoDocker = errors.New("docker is not available")
+
+// container is the PostgreSQL server started for the test binary, shared by
+// the tests so it only starts once
+var container struct {
+	once sync.Once
+	url  string
+	err  error
+}
+
+// serverURL returns the URL of the PostgreSQL container, starting it on the
+// first call
+func serverURL() (string, error) {
+	container.once.Do(func() {
+		container.url, container.err = startContainer(context.Background())
+	})
+
+	return container.url, container.err
+}
+
+// startContainer runs a PostgreSQL container with testcontainers and waits
+// until it accepts connections
+//
+// There is no hook to stop it at the end of the tests, so its removal is left
+// to the reaper container of testcontainers (Ryuk), which removes it once the
+// test binary is gone, even when it is killed.
+func startContainer(ctx context.Context) (string, error) {
+	if err := checkDocker(ctx); err != nil {
+		return "", err
+	}
+
+	image := cmp.Or(os.Getenv(EnvImage), DefaultImage)
+	server, err := postgres.Run(ctx, image,
+		postgres.WithDatabase("postgres"),
+		postgres.WithPassword(containerPassword),
+		postgres.BasicWaitStrategies(),
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to start the %s container: %w", image, err)
+	}
+
+	return server.ConnectionString(ctx, "sslmode=disable")
+}
+
+// checkDocker returns errNoDocker when there is no Docker daemon to start
+// containers with, which testcontainers reports by panicking
+func checkDocker(ctx context.Context) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", errNoDocker, r)
+		}
+	}()
+
+	provider, err := testcontainers.NewDockerProvider()
+	if err != nil {
+		return fmt.Errorf("%w: %w", errNoDocker, err)
+	}
+	defer provider.Close() //nolint:errcheck
+
+	if err := provider.Health(ctx); err != nil {
+		return fmt.Errorf("%w: %w", errNoDocker, err)
+	}
+
+	return nil
+}
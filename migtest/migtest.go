@@ -0,0 +1,248 @@
+// Package migtest provides disposable PostgreSQL databases with migrations
+// applied, for tests of applications using mig.
+//
+// Each call to New creates a database with a unique name on the server given
+// by the MIGTEST_DATABASE_URL environment variable (or WithURL), applies the
+// migrations and drops the database when the test ends. Without a configured
+// server, a PostgreSQL container is started with testcontainers for the test
+// binary and removed once it exits, tests are skipped when there is no Docker
+// daemon.
+//
+// Migrations are forward-only in mig, so there is no down migration to check:
+// AssertMigrationsApply checks that applying them again is a no-op instead.
+//
+// AssertSchema compares the resulting schema to a golden file committed with
+// the migrations.
+package migtest
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/arthurdotwork/mig"
+)
+
+const (
+	// EnvURL is the environment variable holding the URL of the PostgreSQL
+	// server the test databases are created on
+	EnvURL = "MIGTEST_DATABASE_URL"
+
+	// EnvUpdate is the environment variable that makes AssertSchema rewrite
+	// the golden files instead of comparing them, when set to a true value
+	EnvUpdate = "MIGTEST_UPDATE"
+)
+
+// Option configures the test database
+type Option func(*options)
+
+// options holds the settings applied by Option functions
+type options struct {
+	url  string
+	opts []mig.Option
+}
+
+// WithURL creates the test databases on the server at the given URL instead
+// of MIGTEST_DATABASE_URL. The database of the URL is only used to create and
+// drop the test databases.
+func WithURL(url string) Option {
+	return func(o *options) {
+		o.url = url
+	}
+}
+
+// WithMigratorOptions passes options to the Migrator applying the migrations,
+// such as mig.WithLogger
+func WithMigratorOptions(opts ...mig.Option) Option {
+	return func(o *options) {
+		o.opts = append(o.opts, opts...)
+	}
+}
+
+// New creates a database with the migrations found at the root of fsys
+// applied, and drops it when the test ends
+func New(t testing.TB, fsys fs.FS, opts ...Option) *sql.DB {
+	t.Helper()
+
+	db, _ := setup(t, fsys, opts...)
+	return db
+}
+
+// AssertMigrationsApply checks that the migrations found at the root of fsys
+// apply cleanly to an empty database, and that applying them again is a no-op
+func AssertMigrationsApply(t testing.TB, fsys fs.FS, opts ...Option) {
+	t.Helper()
+
+	db, o := setup(t, fsys, opts...)
+
+	summary, err := mig.AutoMigrate(context.Background(), db, fsys, o.opts...)
+	if err != nil {
+		t.Fatalf("migrations do not apply again cleanly: %v", err)
+	}
+
+	if summary.Applied != 0 {
+		t.Fatalf("applying the migrations again applied %d migration(s), want 0", summary.Applied)
+	}
+}
+
+// AssertSchema compares the schema of db, as dumped by mig.DumpSchema, to the
+// golden file, failing the test when they differ. Run the tests with
+// MIGTEST_UPDATE=1 to write the golden file from the current schema, then
+// commit it: schema changes show up in code review and unexpected drift fails
+// the tests.
+func AssertSchema(t testing.TB, db *sql.DB, golden string) {
+	t.Helper()
+
+	schema, err := mig.DumpSchema(context.Background(), db)
+	if err != nil {
+		t.Fatalf("failed to dump the schema: %v", err)
+	}
+
+	if update, _ := strconv.ParseBool(os.Getenv(EnvUpdate)); update {
+		if err := os.MkdirAll(filepath.Dir(golden), 0755); err != nil {
+			t.Fatalf("failed to create the golden file directory: %v", err)
+		}
+		if err := os.WriteFile(golden, []byte(schema), 0644); err != nil {
+			t.Fatalf("failed to write the golden file: %v", err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(golden)
+	if os.IsNotExist(err) {
+		t.Fatalf("golden file %s does not exist, run the tests with %s=1 to create it", golden, EnvUpdate)
+	}
+	if err != nil {
+		t.Fatalf("failed to read the golden file: %v", err)
+	}
+
+	if string(want) != schema {
+		t.Fatalf("schema does not match %s, run the tests with %s=1 to update it if the change is expected\n%s", golden, EnvUpdate, diff(string(want), schema))
+	}
+}
+
+// diff lists the lines missing from got and the unexpected ones
+func diff(want, got string) string {
+	wantLines := strings.Split(want, "\n")
+	gotLines := strings.Split(got, "\n")
+
+	var b strings.Builder
+	for _, line := range wantLines {
+		if !slices.Contains(gotLines, line) {
+			fmt.Fprintf(&b, "- %s\n", line)
+		}
+	}
+	for _, line := range gotLines {
+		if !slices.Contains(wantLines, line) {
+			fmt.Fprintf(&b, "+ %s\n", line)
+		}
+	}
+
+	return b.String()
+}
+
+// setup creates the test database and applies the migrations
+func setup(t testing.TB, fsys fs.FS, opts ...Option) (*sql.DB, *options) {
+	t.Helper()
+
+	o := &options{url: os.Getenv(EnvURL)}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if o.url == "" {
+		url, err := serverURL()
+		if errors.Is(err, errNoDocker) {
+			t.Skipf("%s is not set and no PostgreSQL container can be started: %v", EnvURL, err)
+		}
+		if err != nil {
+			t.Fatalf("failed to start a PostgreSQL container: %v", err)
+		}
+		o.url = url
+	}
+
+	ctx := context.Background()
+
+	admin, err := sql.Open("postgres", o.url)
+	if err != nil {
+		t.Fatalf("failed to open the server connection: %v", err)
+	}
+	defer admin.Close() //nolint:errcheck
+
+	name, err := databaseName()
+	if err != nil {
+		t.Fatalf("failed to generate a database name: %v", err)
+	}
+
+	if _, err := admin.ExecContext(ctx, "CREATE DATABASE "+name); err != nil {
+		t.Fatalf("failed to create the test database: %v", err)
+	}
+
+	t.Cleanup(func() {
+		admin, err := sql.Open("postgres", o.url)
+		if err != nil {
+			t.Errorf("failed to open the server connection: %v", err)
+			return
+		}
+		defer admin.Close() //nolint:errcheck
+
+		if _, err := admin.ExecContext(context.Background(), "DROP DATABASE IF EXISTS "+name+" WITH (FORCE)"); err != nil {
+			t.Errorf("failed to drop the test database %s: %v", name, err)
+		}
+	})
+
+	dbURL, err := withDatabase(o.url, name)
+	if err != nil {
+		t.Fatalf("failed to build the test database url: %v", err)
+	}
+
+	db, err := sql.Open("postgres", dbURL)
+	if err != nil {
+		t.Fatalf("failed to open the test database: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Close() //nolint:errcheck
+	})
+
+	if _, err := mig.AutoMigrate(ctx, db, fsys, o.opts...); err != nil {
+		t.Fatalf("failed to apply the migrations: %v", err)
+	}
+
+	return db, o
+}
+
+// databaseName returns a unique name for a test database
+func databaseName() (string, error) {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+
+	return "migtest_" + hex.EncodeToString(suffix), nil
+}
+
+// withDatabase returns the server URL pointing to the given database
+func withDatabase(serverURL, name string) (string, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.HasPrefix(u.Scheme, "postgres") {
+		return "", fmt.Errorf("unsupported scheme %q, migtest requires a postgres:// url", u.Scheme)
+	}
+
+	u.Path = "/" + name
+	return u.String(), nil
+}
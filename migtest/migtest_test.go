@@ -0,0 +1,68 @@
+package migtest_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/arthurdotwork/mig/migtest"
+	"github.com/stretchr/testify/require"
+)
+
+var testMigrations = fstest.MapFS{
+	"2023_01_01_10_00_00_create_users.sql": {Data: []byte("CREATE TABLE users (id SERIAL PRIMARY KEY, name TEXT);")},
+	"2023_01_02_10_00_00_add_email.sql":    {Data: []byte("ALTER TABLE users ADD COLUMN email TEXT;")},
+}
+
+func TestNew(t *testing.T) {
+	t.Run("it should return a database with the migrations applied", func(t *testing.T) {
+		db := migtest.New(t, testMigrations)
+
+		_, err := db.Exec("INSERT INTO users (name, email) VALUES ('Ada', 'ada@example.com')")
+		require.NoError(t, err)
+
+		var count int
+		err = db.QueryRow("SELECT COUNT(*) FROM mig_versions").Scan(&count)
+		require.NoError(t, err)
+		require.Equal(t, 2, count)
+	})
+
+	t.Run("it should isolate each test database", func(t *testing.T) {
+		first := migtest.New(t, testMigrations)
+		second := migtest.New(t, testMigrations)
+
+		_, err := first.Exec("INSERT INTO users (name) VALUES ('Ada')")
+		require.NoError(t, err)
+
+		var count int
+		err = second.QueryRow("SELECT COUNT(*) FROM users").Scan(&count)
+		require.NoError(t, err)
+		require.Zero(t, count)
+	})
+}
+
+func TestAssertSchema(t *testing.T) {
+	t.Run("it should write then match the golden file", func(t *testing.T) {
+		db := migtest.New(t, testMigrations)
+		golden := filepath.Join(t.TempDir(), "schema.sql")
+
+		t.Setenv(migtest.EnvUpdate, "1")
+		migtest.AssertSchema(t, db, golden)
+
+		content, err := os.ReadFile(golden)
+		require.NoError(t, err)
+		require.Contains(t, string(content), "CREATE TABLE public.users (")
+		require.Contains(t, string(content), "    email text")
+		require.NotContains(t, string(content), "mig_versions")
+
+		t.Setenv(migtest.EnvUpdate, "")
+		migtest.AssertSchema(t, db, golden)
+	})
+}
+
+func TestAssertMigrationsApply(t *testing.T) {
+	t.Run("it should pass for migrations that apply cleanly", func(t *testing.T) {
+		migtest.AssertMigrationsApply(t, testMigrations)
+	})
+}
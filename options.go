@@ -0,0 +1,300 @@
+package mig
+
+import (
+	"io/fs"
+	"log/slog"
+	"time"
+
+	"github.com/arthurdotwork/mig/internal/config"
+	"github.com/arthurdotwork/mig/internal/executor"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Option configures a Migrator
+type Option func(*options)
+
+// options holds the settings applied by Option functions
+type options struct {
+	target         string
+	overrides      []config.Override
+	passwordPrompt func() (string, error)
+	logger         *slog.Logger
+	clock          Clock
+	tracer         Tracer
+	observers      []Observer
+	notifiers      []Notifier
+}
+
+// Clock tells the time, see WithClock
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the Clock returning the current time
+type systemClock struct{}
+
+// Now returns the current time
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// WithTarget selects a named target from the configuration file instead of
+// the default one
+func WithTarget(name string) Option {
+	return func(o *options) {
+		o.target = name
+	}
+}
+
+// WithTenant runs migrations in the given tenant schema by setting the
+// connection search_path
+func WithTenant(schema string) Option {
+	return func(o *options) {
+		o.overrides = append(o.overrides, func(cfg *config.Config) {
+			cfg.Tenant = schema
+		})
+	}
+}
+
+// WithDriver selects the dialect of a connection passed to NewWithDB, it
+// defaults to postgres
+func WithDriver(name string) Option {
+	return func(o *options) {
+		o.overrides = append(o.overrides, func(cfg *config.Config) {
+			cfg.Database.Driver = name
+		})
+	}
+}
+
+// WithMigrationsDir reads the migrations from the given directory instead of
+// the configured one
+func WithMigrationsDir(dir string) Option {
+	return func(o *options) {
+		o.overrides = append(o.overrides, func(cfg *config.Config) {
+			cfg.Migrations.Directory = dir
+		})
+	}
+}
+
+// WithMigrationsFS reads the migrations from the root of fsys instead of a
+// directory, for migrations embedded in the binary with embed.FS. Use fs.Sub
+// when they live in a sub-directory of the embedded tree.
+func WithMigrationsFS(fsys fs.FS) Option {
+	return func(o *options) {
+		o.overrides = append(o.overrides, func(cfg *config.Config) {
+			cfg.Migrations.FS = fsys
+		})
+	}
+}
+
+// WithPerMigrationTimeout cancels a migration that runs longer than d, both
+// in the application and on the database server, and fails with
+// ErrMigrationTimeout. It takes precedence over migrations.timeout in the
+// configuration file.
+func WithPerMigrationTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.overrides = append(o.overrides, func(cfg *config.Config) {
+			cfg.Migrations.Timeout = d
+		})
+	}
+}
+
+// WithDefaultTx selects whether migrations run inside a transaction unless
+// they opt out with "-- disable-tx" (true, the default), or outside of one
+// unless they opt in with "-- mig:tx" (false). It takes precedence over
+// migrations.default_tx in the configuration file.
+func WithDefaultTx(enabled bool) Option {
+	return func(o *options) {
+		o.overrides = append(o.overrides, func(cfg *config.Config) {
+			cfg.Migrations.DefaultTx = &enabled
+		})
+	}
+}
+
+// WithAutoDisableTx runs the migrations holding statements that cannot run
+// inside a transaction, such as CREATE INDEX CONCURRENTLY, VACUUM or CREATE
+// DATABASE, outside of one without "-- disable-tx", logging the decision. It
+// takes precedence over migrations.auto_disable_tx in the configuration file.
+func WithAutoDisableTx(enabled bool) Option {
+	return func(o *options) {
+		o.overrides = append(o.overrides, func(cfg *config.Config) {
+			cfg.Migrations.AutoDisableTx = enabled
+		})
+	}
+}
+
+// WithBaselineVersion adopts an existing database: when it holds tables but
+// no mig_versions table yet, the migrations up to version are recorded as
+// applied without running them. It takes precedence over
+// migrations.baseline_version in the configuration file.
+func WithBaselineVersion(version string) Option {
+	return func(o *options) {
+		o.overrides = append(o.overrides, func(cfg *config.Config) {
+			cfg.Migrations.BaselineVersion = version
+		})
+	}
+}
+
+// WithLogger routes the migration progress (start, outcome and duration of
+// each migration) to the given logger, nothing is logged by default
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithClock takes the time from clock when timestamping created migration
+// files and recording applied migrations, so tests and code generation get
+// stable filenames and applied_at values. By default files use the current
+// time and the database records its own.
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+// WithTracer starts spans for the connection, the wait for the migration lock,
+// each migration and the recording of each migration with the given Tracer
+func WithTracer(tracer Tracer) Option {
+	return func(o *options) {
+		o.tracer = tracer
+	}
+}
+
+// WithTracerProvider starts the spans of WithTracer through an OpenTelemetry
+// tracer provider, as the tracer named after the module, with the attributes
+// of each step and the error of the failed ones
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return WithTracer(executor.NewOTelTracer(provider))
+}
+
+// WithMetrics records the outcome of the migrations in metrics, which can be
+// shared by several Migrators
+func WithMetrics(metrics *Metrics) Option {
+	return func(o *options) {
+		o.observers = append(o.observers, metrics)
+	}
+}
+
+// WithObserver notifies the observer of the progress of the runs, along with
+// the metrics of WithMetrics
+func WithObserver(observer Observer) Option {
+	return func(o *options) {
+		o.observers = append(o.observers, observer)
+	}
+}
+
+// WithNotifier tells the notifier about the start and outcome of the runs
+// applying migrations, along with the notifications of the configuration file,
+// e.g. to page on-call when a run fails
+func WithNotifier(notifier Notifier) Option {
+	return func(o *options) {
+		o.notifiers = append(o.notifiers, notifier)
+	}
+}
+
+// WithDatabaseURL connects with the given URL, taking precedence over the
+// configuration file and the DATABASE_URL environment variable
+func WithDatabaseURL(url string) Option {
+	return func(o *options) {
+		o.overrides = append(o.overrides, func(cfg *config.Config) {
+			cfg.Database.URL = url
+		})
+	}
+}
+
+// WithHost connects to the given host, taking precedence over the
+// configuration file, the DATABASE_HOST environment variable and the host of
+// the database URL
+func WithHost(host string) Option {
+	return func(o *options) {
+		o.overrides = append(o.overrides, func(cfg *config.Config) {
+			cfg.Database.SetHost(host)
+		})
+	}
+}
+
+// WithPort connects to the given port, taking precedence over the
+// configuration file, the DATABASE_PORT environment variable and the port of
+// the database URL
+func WithPort(port int) Option {
+	return func(o *options) {
+		o.overrides = append(o.overrides, func(cfg *config.Config) {
+			cfg.Database.SetPort(port)
+		})
+	}
+}
+
+// WithDatabaseName connects to the named database, taking precedence over the
+// configuration file, the DATABASE_NAME environment variable and the database
+// of the database URL
+func WithDatabaseName(name string) Option {
+	return func(o *options) {
+		o.overrides = append(o.overrides, func(cfg *config.Config) {
+			cfg.Database.SetName(name)
+		})
+	}
+}
+
+// WithUser connects as the given user, taking precedence over the
+// configuration file, the DATABASE_USER environment variable and the user of
+// the database URL
+func WithUser(user string) Option {
+	return func(o *options) {
+		o.overrides = append(o.overrides, func(cfg *config.Config) {
+			cfg.Database.SetUser(user)
+		})
+	}
+}
+
+// WithLenientConfig tolerates keys of the configuration file that match no
+// setting, such as typos, which fail loading by default. They are reported
+// to the logger of WithLogger instead.
+func WithLenientConfig() Option {
+	return func(o *options) {
+		o.overrides = append(o.overrides, func(cfg *config.Config) {
+			cfg.Lenient = true
+		})
+	}
+}
+
+// WithBaseDir resolves the relative migrations directories and schema file
+// of the configuration in dir instead of the working directory, such as the
+// directory of a configuration file returned by FindConfig
+func WithBaseDir(dir string) Option {
+	return func(o *options) {
+		o.overrides = append(o.overrides, func(cfg *config.Config) {
+			cfg.BaseDir = dir
+		})
+	}
+}
+
+// WithAgeKeyFile decrypts SOPS-encrypted configuration files with the age
+// identity at path, instead of SOPS_AGE_KEY_FILE or the sops default
+func WithAgeKeyFile(path string) Option {
+	return func(o *options) {
+		o.overrides = append(o.overrides, func(cfg *config.Config) {
+			cfg.AgeKeyFile = path
+		})
+	}
+}
+
+// WithConfigCache shares the configuration files read by the functions given
+// the same cache, so that a program calling several of them, as the CLI does
+// with Protected, Tenants and New, reads and decrypts each file once
+func WithConfigCache(cache *ConfigCache) Option {
+	return func(o *options) {
+		o.overrides = append(o.overrides, func(cfg *config.Config) {
+			cfg.Cache = cache
+		})
+	}
+}
+
+// WithPasswordPrompt asks for the database password with the given function
+// when none is configured. Unix socket connections are left alone, since
+// they usually rely on peer authentication.
+func WithPasswordPrompt(prompt func() (string, error)) Option {
+	return func(o *options) {
+		o.passwordPrompt = prompt
+	}
+}